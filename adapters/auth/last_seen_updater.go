@@ -87,9 +87,7 @@ func (u *LastSeenUpdater) flush() error {
 					return errors.Wrap(err, "could not get the user")
 				}
 
-				if cache.LastSeen.After(u.LastSeen) {
-					u.LastSeen = cache.LastSeen
-				}
+				u.UpdateLastSeen(cache.LastSeen)
 
 				for token, t := range cache.Sessions {
 					for i := range u.Sessions {
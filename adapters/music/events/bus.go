@@ -0,0 +1,143 @@
+// Package events delivers the events published by the library and the stores
+// to the subscribed clients.
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/eggplant/logging"
+)
+
+// subscriberBuffer is the number of events which can wait for a subscriber.
+// Subscribers which fall behind further than that are disconnected.
+const subscriberBuffer = 100
+
+// Bus sends the published events to all subscribers. Publishing never blocks.
+// Only the latest progress event waits for each subscriber as the progress
+// events replace each other. Slow subscribers are disconnected instead of
+// missing other events so that the clients reconnect and load the library
+// again.
+type Bus struct {
+	subscribers map[*subscriber]struct{}
+	mutex       sync.Mutex
+	log         logging.Logger
+}
+
+type subscriber struct {
+	ch     chan music.Event
+	signal chan struct{}
+
+	// Fields below are protected by the mutex of the bus.
+	queue        []music.Event
+	progress     *music.Event
+	disconnected bool
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[*subscriber]struct{}),
+		log:         logging.New("events.Bus"),
+	}
+}
+
+// Publish sends the event to all subscribers.
+func (b *Bus) Publish(event music.Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for s := range b.subscribers {
+		if event.Type == music.EventTypeScanProgress {
+			s.progress = &event
+		} else if len(s.queue) < subscriberBuffer {
+			s.queue = append(s.queue, event)
+		} else {
+			b.log.Debug("disconnecting a slow subscriber", "type", event.Type)
+			s.disconnected = true
+			delete(b.subscribers, s)
+		}
+
+		select {
+		case s.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel on which the events are sent until the context
+// is cancelled or the subscriber falls too far behind. The channel is closed
+// afterwards.
+func (b *Bus) Subscribe(ctx context.Context) <-chan music.Event {
+	s := &subscriber{
+		ch:     make(chan music.Event),
+		signal: make(chan struct{}, 1),
+	}
+
+	b.mutex.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mutex.Unlock()
+
+	go b.deliver(ctx, s)
+
+	return s.ch
+}
+
+func (b *Bus) deliver(ctx context.Context, s *subscriber) {
+	defer close(s.ch)
+	defer b.unsubscribe(s)
+
+	for {
+		event, ok, disconnected := b.next(s)
+		if disconnected {
+			return
+		}
+
+		if !ok {
+			select {
+			case <-s.signal:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case s.ch <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// next returns the next event which should be sent to the subscriber. The
+// progress event is sent after the other events.
+func (b *Bus) next(s *subscriber) (music.Event, bool, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if s.disconnected {
+		return music.Event{}, false, true
+	}
+
+	if len(s.queue) > 0 {
+		event := s.queue[0]
+		s.queue = s.queue[1:]
+		return event, true, false
+	}
+
+	if s.progress != nil {
+		event := *s.progress
+		s.progress = nil
+		return event, true, false
+	}
+
+	return music.Event{}, false, false
+}
+
+func (b *Bus) unsubscribe(s *subscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.subscribers, s)
+}
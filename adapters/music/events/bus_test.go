@@ -0,0 +1,99 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/events"
+	"github.com/boreq/eggplant/application/music"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	b := events.NewBus()
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	ch1 := b.Subscribe(ctx1)
+	ch2 := b.Subscribe(ctx2)
+
+	event := music.Event{Type: music.EventTypeLibraryChanged}
+	b.Publish(event)
+
+	require.Equal(t, event, <-ch1)
+	require.Equal(t, event, <-ch2)
+
+	cancel1()
+	_, ok := <-ch1
+	require.False(t, ok)
+
+	b.Publish(event)
+	require.Equal(t, event, <-ch2)
+}
+
+func TestBusKeepsOnlyTheLatestProgressEvent(t *testing.T) {
+	b := events.NewBus()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := b.Subscribe(ctx)
+
+	b.Publish(music.Event{Type: music.EventTypeLibraryChanged})
+	for i := 0; i < 1000; i++ {
+		b.Publish(music.Event{
+			Type:     music.EventTypeScanProgress,
+			Progress: &music.ScanProgress{PendingMetadata: 1000 - i},
+		})
+	}
+	b.Publish(music.Event{Type: music.EventTypeLibraryChanged})
+
+	require.Equal(t, music.EventTypeLibraryChanged, receive(t, ch).Type)
+	require.Equal(t, music.EventTypeLibraryChanged, receive(t, ch).Type)
+
+	progress := receive(t, ch)
+	require.Equal(t, music.EventTypeScanProgress, progress.Type)
+	require.Equal(t, 1, progress.Progress.PendingMetadata)
+}
+
+func TestBusDisconnectsSlowSubscribers(t *testing.T) {
+	b := events.NewBus()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := b.Subscribe(ctx)
+
+	for i := 0; i < 1000; i++ {
+		b.Publish(music.Event{Type: music.EventTypeLibraryChanged})
+	}
+
+	received := 0
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				require.Less(t, received, 1000)
+				return
+			}
+			received++
+		case <-time.After(5 * time.Second):
+			t.Fatal("subscriber wasn't disconnected")
+		}
+	}
+}
+
+func receive(t *testing.T, ch <-chan music.Event) music.Event {
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("event wasn't received")
+		return music.Event{}
+	}
+}
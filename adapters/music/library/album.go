@@ -0,0 +1,32 @@
+package library
+
+import (
+	"github.com/boreq/eggplant/application/music"
+)
+
+func (l *Library) newBasicAlbum(path []music.AlbumId, album album) music.BasicAlbum {
+	return music.BasicAlbum{
+		Path:      path,
+		Title:     album.title,
+		Thumbnail: l.newThumbnail(album),
+	}
+}
+
+// newThumbnail returns the thumbnail of the album or nil if the album doesn't
+// have one. The cover art embedded in the first track is used only if the
+// track turned out to contain it.
+func (l *Library) newThumbnail(album album) *music.Thumbnail {
+	if album.thumbnailId == "" {
+		return nil
+	}
+
+	if album.thumbnailEmbedded {
+		if metadata, _ := l.trackStore.GetMetadata(album.thumbnailId.String()); metadata.Picture == "" {
+			return nil
+		}
+	}
+
+	return &music.Thumbnail{
+		FileId: album.thumbnailId,
+	}
+}
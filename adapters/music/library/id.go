@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
+	"github.com/boreq/eggplant/adapters/music/scanner"
 	"github.com/boreq/eggplant/application/music"
 	"github.com/boreq/errors"
 )
@@ -27,7 +29,7 @@ func (idGenerator) AlbumId(parents []music.AlbumId, title string) (music.AlbumId
 	return music.AlbumId(h), nil
 }
 
-func (idGenerator) TrackId(parents []music.AlbumId, title string) (music.TrackId, error) {
+func (idGenerator) TrackId(parents []music.AlbumId, title string, track scanner.Track) (music.TrackId, error) {
 	h, err := shortHash(parentsAsString(parents) + title)
 	if err != nil {
 		return "", errors.Wrap(err, "hashing failed")
@@ -36,11 +38,10 @@ func (idGenerator) TrackId(parents []music.AlbumId, title string) (music.TrackId
 }
 
 func (idGenerator) FileId(path string) (music.FileId, error) {
-	fileInfo, err := os.Stat(path)
+	s, err := fileIdString(path)
 	if err != nil {
-		return "", errors.Wrap(err, "os stat failed")
+		return "", errors.Wrap(err, "could not create a file id string")
 	}
-	s := fmt.Sprintf("%s-%d-%d", path, fileInfo.Size(), fileInfo.ModTime().Unix())
 	h, err := longHash(s)
 	if err != nil {
 		return "", errors.Wrap(err, "hashing failed")
@@ -48,6 +49,31 @@ func (idGenerator) FileId(path string) (music.FileId, error) {
 	return music.FileId(h), nil
 }
 
+func (idGenerator) FileRangeId(path string, start, end time.Duration) (music.FileId, error) {
+	s, err := fileIdString(path)
+	if err != nil {
+		return "", errors.Wrap(err, "could not create a file id string")
+	}
+	s = fmt.Sprintf("%s-%d-%d", s, start, end)
+	h, err := longHash(s)
+	if err != nil {
+		return "", errors.Wrap(err, "hashing failed")
+	}
+	return music.FileId(h), nil
+}
+
+// Retain does nothing as the ids are derived from the paths alone.
+func (idGenerator) Retain(paths []string) {
+}
+
+func fileIdString(path string) (string, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return "", errors.Wrap(err, "os stat failed")
+	}
+	return fmt.Sprintf("%s-%d-%d", path, fileInfo.Size(), fileInfo.ModTime().Unix()), nil
+}
+
 func parentsAsString(parents []music.AlbumId) string {
 	var s string
 	for _, parent := range parents {
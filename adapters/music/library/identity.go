@@ -0,0 +1,403 @@
+package library
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/scanner"
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/eggplant/logging"
+	"github.com/boreq/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// fingerprintChunkSize specifies how many bytes are read from the
+	// beginning and from the end of each file to compute its fingerprint.
+	// Both ends are used so that changes to the tags which are usually
+	// stored at one of the ends of the file are detected.
+	fingerprintChunkSize = 1024 * 1024
+
+	// flushIdentitiesEvery specifies how often the changed identities are
+	// persisted. The identities are written in batches as writing them
+	// one by one during the initial scan would take too long.
+	flushIdentitiesEvery = 5 * time.Second
+)
+
+// FileIdentity is assigned to a file when it is seen for the first time and
+// follows the file when it is modified or moved.
+type FileIdentity struct {
+	// Identity is the fingerprint of the file from the moment it was
+	// seen for the first time.
+	Identity string `json:"identity"`
+
+	// Fingerprint, Size and ModTime describe the current contents of the
+	// file. Fingerprint is recomputed only when the size or the
+	// modification time change.
+	Fingerprint string `json:"fingerprint"`
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"modTime"`
+}
+
+// IdentityRepository persists the identities keyed by file paths.
+type IdentityRepository interface {
+	Load() (map[string]FileIdentity, error)
+	Save(changed map[string]FileIdentity, removed []string) error
+}
+
+// ContentIdGenerator generates the file and track ids based on the contents
+// of the files instead of their paths. This way the tracks keep their ids when
+// they are moved or renamed. The tracks also keep their ids when they are
+// modified eg. retagged as long as they aren't moved at the same time.
+type ContentIdGenerator struct {
+	repository IdentityRepository
+
+	byPath        map[string]FileIdentity
+	byFingerprint map[string][]string // values are paths
+	byIdentity    map[string]string   // values are paths
+	changed       map[string]FileIdentity
+	removed       map[string]struct{}
+
+	mutex sync.Mutex
+	log   logging.Logger
+}
+
+// NewContentIdGenerator loads the identities and persists the changes in the
+// background until the context is cancelled.
+func NewContentIdGenerator(ctx context.Context, repository IdentityRepository) (*ContentIdGenerator, error) {
+	identities, err := repository.Load()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load the identities")
+	}
+
+	g := &ContentIdGenerator{
+		repository:    repository,
+		byPath:        make(map[string]FileIdentity),
+		byFingerprint: make(map[string][]string),
+		byIdentity:    make(map[string]string),
+		changed:       make(map[string]FileIdentity),
+		removed:       make(map[string]struct{}),
+		log:           logging.New("library.contentIdGenerator"),
+	}
+
+	for path, identity := range identities {
+		g.set(path, identity)
+	}
+	g.changed = make(map[string]FileIdentity)
+
+	go g.run(ctx)
+
+	return g, nil
+}
+
+func (g *ContentIdGenerator) AlbumId(parents []music.AlbumId, title string) (music.AlbumId, error) {
+	return idGenerator{}.AlbumId(parents, title)
+}
+
+func (g *ContentIdGenerator) TrackId(parents []music.AlbumId, title string, track scanner.Track) (music.TrackId, error) {
+	identity, err := g.identity(track.Path)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get the identity")
+	}
+
+	s := identity.Identity
+	if track.Cue != nil {
+		s = fmt.Sprintf("%s-%d", s, track.Cue.Number)
+	}
+
+	h, err := shortHash(s)
+	if err != nil {
+		return "", errors.Wrap(err, "hashing failed")
+	}
+	return music.TrackId(h), nil
+}
+
+func (g *ContentIdGenerator) FileId(path string) (music.FileId, error) {
+	identity, err := g.identity(path)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get the identity")
+	}
+	return music.FileId(identity.Fingerprint), nil
+}
+
+func (g *ContentIdGenerator) FileRangeId(path string, start, end time.Duration) (music.FileId, error) {
+	identity, err := g.identity(path)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get the identity")
+	}
+	h, err := longHash(fmt.Sprintf("%s-%d-%d", identity.Fingerprint, start, end))
+	if err != nil {
+		return "", errors.Wrap(err, "hashing failed")
+	}
+	return music.FileId(h), nil
+}
+
+// Retain forgets the identities of the files which are not present in the
+// provided list. The identities of the moved files are taken over when the new
+// paths are seen so the files which are no longer present in the library
+// after a full scan are gone for good.
+func (g *ContentIdGenerator) Retain(paths []string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	existingPaths := make(map[string]struct{})
+	for _, path := range paths {
+		existingPaths[path] = struct{}{}
+	}
+
+	for path := range g.byPath {
+		if _, ok := existingPaths[path]; !ok {
+			g.remove(path)
+		}
+	}
+}
+
+// identity returns the identity of the file. A file which wasn't seen before
+// takes over the identity of a file which had the same contents but no
+// longer exists.
+func (g *ContentIdGenerator) identity(path string) (FileIdentity, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return FileIdentity{}, errors.Wrap(err, "os stat failed")
+	}
+
+	identity, known := g.byPath[path]
+	if known && identity.Size == fileInfo.Size() && identity.ModTime == fileInfo.ModTime().UnixNano() {
+		return identity, nil
+	}
+
+	fingerprint, err := fingerprint(path, fileInfo.Size())
+	if err != nil {
+		return FileIdentity{}, errors.Wrap(err, "could not compute the fingerprint")
+	}
+
+	if !known {
+		newIdentity, err := g.newIdentity(path, fingerprint)
+		if err != nil {
+			return FileIdentity{}, errors.Wrap(err, "could not create a new identity")
+		}
+		identity.Identity = newIdentity
+	}
+
+	identity.Fingerprint = fingerprint
+	identity.Size = fileInfo.Size()
+	identity.ModTime = fileInfo.ModTime().UnixNano()
+	g.set(path, identity)
+	return identity, nil
+}
+
+// newIdentity returns the identity of a moved file or creates a new one. Files
+// with identical contents receive different identities.
+func (g *ContentIdGenerator) newIdentity(path string, fingerprint string) (string, error) {
+	if movedFrom, ok := g.findMovedFile(fingerprint); ok {
+		identity := g.byPath[movedFrom].Identity
+		g.remove(movedFrom)
+		return identity, nil
+	}
+
+	if _, ok := g.byIdentity[fingerprint]; !ok {
+		return fingerprint, nil
+	}
+
+	return longHash(fingerprint + "-" + path)
+}
+
+// findMovedFile returns a path of a file which had the specified
+// fingerprint but no longer exists.
+func (g *ContentIdGenerator) findMovedFile(fingerprint string) (string, bool) {
+	for _, path := range g.byFingerprint[fingerprint] {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func (g *ContentIdGenerator) set(path string, identity FileIdentity) {
+	if previous, ok := g.byPath[path]; ok {
+		g.removeFingerprint(previous.Fingerprint, path)
+	}
+
+	g.byPath[path] = identity
+	g.byIdentity[identity.Identity] = path
+	g.byFingerprint[identity.Fingerprint] = append(g.byFingerprint[identity.Fingerprint], path)
+	g.changed[path] = identity
+	delete(g.removed, path)
+}
+
+func (g *ContentIdGenerator) remove(path string) {
+	if previous, ok := g.byPath[path]; ok {
+		g.removeFingerprint(previous.Fingerprint, path)
+		delete(g.byIdentity, previous.Identity)
+	}
+
+	delete(g.byPath, path)
+	delete(g.changed, path)
+	g.removed[path] = struct{}{}
+}
+
+func (g *ContentIdGenerator) removeFingerprint(fingerprint string, path string) {
+	paths := g.byFingerprint[fingerprint]
+	for i := range paths {
+		if paths[i] == path {
+			paths = append(paths[:i], paths[i+1:]...)
+			break
+		}
+	}
+
+	if len(paths) == 0 {
+		delete(g.byFingerprint, fingerprint)
+	} else {
+		g.byFingerprint[fingerprint] = paths
+	}
+}
+
+func (g *ContentIdGenerator) run(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(flushIdentitiesEvery):
+			if err := g.flush(); err != nil {
+				g.log.Error("could not persist the identities", "err", err)
+			}
+		case <-ctx.Done():
+			if err := g.flush(); err != nil {
+				g.log.Error("could not persist the identities", "err", err)
+			}
+			return
+		}
+	}
+}
+
+func (g *ContentIdGenerator) flush() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if len(g.changed) == 0 && len(g.removed) == 0 {
+		return nil
+	}
+
+	var removed []string
+	for path := range g.removed {
+		removed = append(removed, path)
+	}
+
+	if err := g.repository.Save(g.changed, removed); err != nil {
+		return errors.Wrap(err, "could not save the identities")
+	}
+
+	g.changed = make(map[string]FileIdentity)
+	g.removed = make(map[string]struct{})
+	return nil
+}
+
+// fingerprint hashes the size of the file together with its beginning and its
+// end.
+func fingerprint(path string, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err, "could not open the file")
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%d-", size)
+
+	if _, err := io.CopyN(hasher, f, fingerprintChunkSize); err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "could not read the beginning of the file")
+	}
+
+	if size > fingerprintChunkSize {
+		offset := size - fingerprintChunkSize
+		if offset < fingerprintChunkSize {
+			offset = fingerprintChunkSize
+		}
+
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return "", errors.Wrap(err, "seek failed")
+		}
+
+		if _, err := io.Copy(hasher, f); err != nil {
+			return "", errors.Wrap(err, "could not read the end of the file")
+		}
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+type BoltIdentityRepository struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+func NewBoltIdentityRepository(db *bolt.DB) (*BoltIdentityRepository, error) {
+	bucket := []byte("identities")
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "could not create a bucket")
+	}
+
+	return &BoltIdentityRepository{
+		db:     db,
+		bucket: bucket,
+	}, nil
+}
+
+// Load returns all identities keyed by file paths.
+func (r *BoltIdentityRepository) Load() (map[string]FileIdentity, error) {
+	identities := make(map[string]FileIdentity)
+
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(r.bucket).ForEach(func(k, v []byte) error {
+			var identity FileIdentity
+			if err := json.Unmarshal(v, &identity); err != nil {
+				return errors.Wrap(err, "json unmarshal failed")
+			}
+			identities[string(k)] = identity
+			return nil
+		})
+	}); err != nil {
+		return nil, errors.Wrap(err, "transaction failed")
+	}
+
+	return identities, nil
+}
+
+// Save inserts or overwrites the changed identities and removes the
+// identities of the removed paths.
+func (r *BoltIdentityRepository) Save(changed map[string]FileIdentity, removed []string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(r.bucket)
+
+		for path, identity := range changed {
+			j, err := json.Marshal(identity)
+			if err != nil {
+				return errors.Wrap(err, "marshaling to json failed")
+			}
+
+			if err := b.Put([]byte(path), j); err != nil {
+				return errors.Wrap(err, "put failed")
+			}
+		}
+
+		for _, path := range removed {
+			if err := b.Delete([]byte(path)); err != nil {
+				return errors.Wrap(err, "delete failed")
+			}
+		}
+
+		return nil
+	})
+}
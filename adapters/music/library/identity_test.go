@@ -0,0 +1,303 @@
+package library_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/library"
+	"github.com/boreq/eggplant/adapters/music/scanner"
+	"github.com/boreq/eggplant/internal/fixture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentIdGeneratorPreservesIdsOfMovedFiles(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	g, err := library.NewContentIdGenerator(ctx, mockIdentityRepository{})
+	require.NoError(t, err)
+
+	oldPath := filepath.Join(dir, "old.flac")
+	newPath := filepath.Join(dir, "new.flac")
+	writeFile(t, oldPath, "contents")
+
+	oldTrackId, err := g.TrackId(nil, "old", scanner.Track{Path: oldPath})
+	require.NoError(t, err)
+
+	oldFileId, err := g.FileId(oldPath)
+	require.NoError(t, err)
+
+	err = os.Rename(oldPath, newPath)
+	require.NoError(t, err)
+
+	newTrackId, err := g.TrackId(nil, "new", scanner.Track{Path: newPath})
+	require.NoError(t, err)
+
+	newFileId, err := g.FileId(newPath)
+	require.NoError(t, err)
+
+	require.Equal(t, oldTrackId, newTrackId)
+	require.Equal(t, oldFileId, newFileId)
+}
+
+func TestContentIdGeneratorPreservesIdsOfModifiedFiles(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	g, err := library.NewContentIdGenerator(ctx, mockIdentityRepository{})
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "track.flac")
+	writeFile(t, path, "contents")
+
+	oldTrackId, err := g.TrackId(nil, "old", scanner.Track{Path: path})
+	require.NoError(t, err)
+
+	oldFileId, err := g.FileId(path)
+	require.NoError(t, err)
+
+	writeFile(t, path, "retagged contents")
+
+	newTrackId, err := g.TrackId(nil, "new", scanner.Track{Path: path})
+	require.NoError(t, err)
+
+	newFileId, err := g.FileId(path)
+	require.NoError(t, err)
+
+	require.Equal(t, oldTrackId, newTrackId)
+	require.NotEqual(t, oldFileId, newFileId, "converted file has to be refreshed")
+}
+
+func TestContentIdGeneratorGeneratesDifferentIdsForCopies(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	g, err := library.NewContentIdGenerator(ctx, mockIdentityRepository{})
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "track.flac")
+	copyPath := filepath.Join(dir, "copy.flac")
+	writeFile(t, path, "contents")
+	writeFile(t, copyPath, "contents")
+
+	trackId, err := g.TrackId(nil, "track", scanner.Track{Path: path})
+	require.NoError(t, err)
+
+	copyTrackId, err := g.TrackId(nil, "track", scanner.Track{Path: copyPath})
+	require.NoError(t, err)
+
+	require.NotEqual(t, trackId, copyTrackId)
+}
+
+func TestContentIdGeneratorGeneratesDifferentIdsForCueTracks(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	g, err := library.NewContentIdGenerator(ctx, mockIdentityRepository{})
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "album.flac")
+	writeFile(t, path, "contents")
+
+	firstTrackId, err := g.TrackId(nil, "first", scanner.Track{Path: path, Cue: &scanner.CueTrack{Number: 1}})
+	require.NoError(t, err)
+
+	secondTrackId, err := g.TrackId(nil, "second", scanner.Track{Path: path, Cue: &scanner.CueTrack{Number: 2, Start: time.Minute}})
+	require.NoError(t, err)
+
+	require.NotEqual(t, firstTrackId, secondTrackId)
+}
+
+func TestContentIdGeneratorPersistsIdentities(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	db, dbCleanup := fixture.Bolt(t)
+	defer dbCleanup()
+
+	r, err := library.NewBoltIdentityRepository(db)
+	require.NoError(t, err)
+
+	sr := newSavingIdentityRepository(r)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	g, err := library.NewContentIdGenerator(ctx, sr)
+	require.NoError(t, err)
+
+	oldPath := filepath.Join(dir, "old.flac")
+	newPath := filepath.Join(dir, "new.flac")
+	writeFile(t, oldPath, "contents")
+
+	oldTrackId, err := g.TrackId(nil, "old", scanner.Track{Path: oldPath})
+	require.NoError(t, err)
+
+	cancel()
+	sr.waitForSave(t)
+
+	identities, err := r.Load()
+	require.NoError(t, err)
+	require.Contains(t, identities, oldPath)
+
+	err = os.Rename(oldPath, newPath)
+	require.NoError(t, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	g, err = library.NewContentIdGenerator(ctx, r)
+	require.NoError(t, err)
+
+	newTrackId, err := g.TrackId(nil, "new", scanner.Track{Path: newPath})
+	require.NoError(t, err)
+
+	require.Equal(t, oldTrackId, newTrackId)
+}
+
+func TestContentIdGeneratorRetainsIdentities(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	db, dbCleanup := fixture.Bolt(t)
+	defer dbCleanup()
+
+	r, err := library.NewBoltIdentityRepository(db)
+	require.NoError(t, err)
+
+	sr := newSavingIdentityRepository(r)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	g, err := library.NewContentIdGenerator(ctx, sr)
+	require.NoError(t, err)
+
+	keptPath := filepath.Join(dir, "kept.flac")
+	removedPath := filepath.Join(dir, "removed.flac")
+	writeFile(t, keptPath, "kept")
+	writeFile(t, removedPath, "removed")
+
+	_, err = g.FileId(keptPath)
+	require.NoError(t, err)
+
+	_, err = g.FileId(removedPath)
+	require.NoError(t, err)
+
+	g.Retain([]string{keptPath})
+
+	cancel()
+	sr.waitForSave(t)
+
+	identities, err := r.Load()
+	require.NoError(t, err)
+	require.Contains(t, identities, keptPath)
+	require.NotContains(t, identities, removedPath)
+}
+
+func TestBoltIdentityRepository(t *testing.T) {
+	db, cleanup := fixture.Bolt(t)
+	defer cleanup()
+
+	r, err := library.NewBoltIdentityRepository(db)
+	require.NoError(t, err)
+
+	identities, err := r.Load()
+	require.NoError(t, err)
+	require.Empty(t, identities)
+
+	saved := map[string]library.FileIdentity{
+		"path1": {
+			Identity:    "identity1",
+			Fingerprint: "fingerprint1",
+			Size:        1,
+			ModTime:     2,
+		},
+		"path2": {
+			Identity:    "identity2",
+			Fingerprint: "fingerprint2",
+			Size:        3,
+			ModTime:     4,
+		},
+	}
+
+	err = r.Save(saved, nil)
+	require.NoError(t, err)
+
+	err = r.Save(nil, []string{"path2"})
+	require.NoError(t, err)
+
+	identities, err = r.Load()
+	require.NoError(t, err)
+	require.Equal(t,
+		map[string]library.FileIdentity{
+			"path1": saved["path1"],
+		},
+		identities,
+	)
+}
+
+type mockIdentityRepository struct {
+}
+
+func (m mockIdentityRepository) Load() (map[string]library.FileIdentity, error) {
+	return nil, nil
+}
+
+func (m mockIdentityRepository) Save(changed map[string]library.FileIdentity, removed []string) error {
+	return nil
+}
+
+// savingIdentityRepository notifies the tests when the identities are saved.
+type savingIdentityRepository struct {
+	library.IdentityRepository
+	saved chan struct{}
+}
+
+func newSavingIdentityRepository(r library.IdentityRepository) *savingIdentityRepository {
+	return &savingIdentityRepository{
+		IdentityRepository: r,
+		saved:              make(chan struct{}, 10),
+	}
+}
+
+func (r *savingIdentityRepository) Save(changed map[string]library.FileIdentity, removed []string) error {
+	err := r.IdentityRepository.Save(changed, removed)
+	r.saved <- struct{}{}
+	return err
+}
+
+func (r *savingIdentityRepository) waitForSave(t *testing.T) {
+	select {
+	case <-r.saved:
+	case <-time.After(5 * time.Second):
+		t.Fatal("identities weren't saved")
+	}
+}
+
+func tempDir(t *testing.T) (string, fixture.CleanupFunc) {
+	dir, err := ioutil.TempDir("", "eggplant_test")
+	require.NoError(t, err)
+
+	return dir, func() {
+		err := os.RemoveAll(dir)
+		require.NoError(t, err)
+	}
+}
+
+func writeFile(t *testing.T, path string, contents string) {
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	require.NoError(t, err)
+}
@@ -3,16 +3,21 @@
 package library
 
 import (
+	"fmt"
+	"math"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 
 	"github.com/boreq/eggplant/adapters/music/scanner"
 	"github.com/boreq/eggplant/adapters/music/store"
 	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/eggplant/application/queries"
 	"github.com/boreq/eggplant/logging"
 	"github.com/boreq/errors"
 )
@@ -21,73 +26,240 @@ const rootAlbumTitle = "Eggplant"
 
 type TrackStore interface {
 	SetItems(items []store.Item)
-	GetDuration(id string) time.Duration
+	AddItems(items []store.Item)
+	RemoveItems(ids []string)
+
+	// GetMetadata returns false if the metadata of the track wasn't
+	// extracted yet. It must return immediately.
+	GetMetadata(id string) (store.Metadata, bool)
+
+	GetEmbeddedLyrics(id string) (store.Lyrics, bool, error)
+
+	// OnProbed registers a handler which is called after the metadata of a
+	// track is extracted in the background.
+	OnProbed(handler func(id string))
 }
 
 type ThumbnailStore interface {
 	SetItems(items []store.Item)
+	AddItems(items []store.Item)
+	RemoveItems(ids []string)
 }
 
 type AccessLoader interface {
 	Load(file string) (music.Access, error)
 }
 
+type Scanner interface {
+	Start() (<-chan scanner.Update, error)
+}
+
+type SnapshotRepository interface {
+	Load() (*Snapshot, error)
+	Save(snapshot Snapshot) error
+}
+
+type PlayCountRepository interface {
+	// Load returns the play counts of all tracks.
+	Load() (map[music.FileId]int, error)
+
+	// Save overwrites the play count of the specified track.
+	Save(id music.FileId, count int) error
+}
+
+// EventPublisher notifies the clients about the changes of the library.
+type EventPublisher interface {
+	Publish(event music.Event)
+}
+
 type IdGenerator interface {
 	AlbumId(parents []music.AlbumId, title string) (music.AlbumId, error)
-	TrackId(parents []music.AlbumId, title string) (music.TrackId, error)
+
+	// TrackId receives the scanned track as the ids don't have to be
+	// based on the titles.
+	TrackId(parents []music.AlbumId, title string, track scanner.Track) (music.TrackId, error)
+
 	FileId(path string) (music.FileId, error)
+
+	// FileRangeId is used for the tracks which occupy only a part of a
+	// file.
+	FileRangeId(path string, start, end time.Duration) (music.FileId, error)
+
+	// Retain forgets all files which are not present in the provided
+	// list. It is called after the entire library is scanned.
+	Retain(paths []string)
+}
+
+// Root is a scanned directory.
+type Root struct {
+	// Title is the title of the top-level album which contains the
+	// scanned directory. If the library consists of a single root then
+	// the title can be left empty. The scanned directory becomes the root
+	// album in that case.
+	Title string
+
+	Scanner Scanner
+
+	// DefaultAccess applies to the albums of this root which aren't
+	// covered by access files. If it is nil then the albums are private.
+	DefaultAccess *music.Access
+}
+
+type root struct {
+	Root
+
+	// ids is a path to the album which contains the scanned directory.
+	ids []music.AlbumId
+}
+
+// path returns a path to the album which has the specified path in the
+// scanned directory.
+func (r root) path(path []string) []string {
+	if r.Title == "" {
+		return path
+	}
+	return append([]string{r.Title}, path...)
+}
+
+func validateRoots(roots []Root) error {
+	if len(roots) == 0 {
+		return errors.New("at least one root is required")
+	}
+
+	if len(roots) == 1 {
+		return nil
+	}
+
+	titles := make(map[string]struct{})
+	for _, root := range roots {
+		if root.Title == "" {
+			return errors.New("titles are required when multiple roots are used")
+		}
+
+		if _, ok := titles[root.Title]; ok {
+			return fmt.Errorf("duplicate title '%s'", root.Title)
+		}
+		titles[root.Title] = struct{}{}
+	}
+
+	return nil
 }
 
 // Library receives scanner updates, dispatches them to appropriate stores and
 // builds a navigable representation of the music collection.
 type Library struct {
-	trackStore     TrackStore
-	thumbnailStore ThumbnailStore
-	accessLoader   AccessLoader
-	idGenerator    IdGenerator
-	root           *album
-	mutex          sync.Mutex
-	log            logging.Logger
+	trackStore         TrackStore
+	thumbnailStore     ThumbnailStore
+	accessLoader       AccessLoader
+	idGenerator        IdGenerator
+	snapshotRepository SnapshotRepository
+	plays              *playCounts
+	events             EventPublisher
+	roots              []root
+	tree               atomic.Value // *tree
+	updateMutex        sync.Mutex   // serializes the updates of the tree
+	probed             map[music.FileId]struct{}
+	probedMutex        sync.Mutex
+	probedSignal       chan struct{}
+	log                logging.Logger
 }
 
-// New creates a library which receives updates from the specified channel.
+// New creates a library which receives updates from the scanners of the
+// specified roots. If a previously saved snapshot of the library is available
+// then the library is restored from it and the scanners are started in the
+// background. Otherwise this function waits for the scanners to start.
 func New(
-	ch <-chan scanner.Album,
+	roots []Root,
 	trackStore TrackStore,
 	thumbnailStore ThumbnailStore,
 	accessLoader AccessLoader,
 	idGenerator IdGenerator,
+	snapshotRepository SnapshotRepository,
+	playCountRepository PlayCountRepository,
+	events EventPublisher,
 ) (*Library, error) {
+	if err := validateRoots(roots); err != nil {
+		return nil, errors.Wrap(err, "invalid roots")
+	}
+
+	plays, err := newPlayCounts(playCountRepository)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the play counts")
+	}
+
 	l := &Library{
-		trackStore:     trackStore,
-		thumbnailStore: thumbnailStore,
-		accessLoader:   accessLoader,
-		idGenerator:    idGenerator,
-		root:           newAlbum(rootAlbumTitle),
-		log:            logging.New("library"),
-	}
-	go l.receiveUpdates(ch)
-	return l, nil
+		trackStore:         trackStore,
+		thumbnailStore:     thumbnailStore,
+		accessLoader:       accessLoader,
+		idGenerator:        idGenerator,
+		snapshotRepository: snapshotRepository,
+		plays:              plays,
+		events:             events,
+		probed:             make(map[music.FileId]struct{}),
+		probedSignal:       make(chan struct{}, 1),
+		log:                logging.New("library"),
+	}
 
+	for _, r := range roots {
+		var ids []music.AlbumId
+		if r.Title != "" {
+			id, err := idGenerator.AlbumId(nil, r.Title)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not create a root album id")
+			}
+			ids = append(ids, id)
+		}
+		l.roots = append(l.roots, root{Root: r, ids: ids})
+	}
+
+	l.tree.Store(l.newTree(newAlbum(rootAlbumTitle), make([][]scanner.Problem, len(roots))))
+
+	trackStore.OnProbed(l.onTrackProbed)
+	go l.reindexProbedTracks()
+
+	restored, err := l.restore()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not restore the snapshot")
+	}
+
+	if restored {
+		for i := range l.roots {
+			go func(i int) {
+				ch, err := l.roots[i].Scanner.Start()
+				if err != nil {
+					l.log.Error("could not start the scanner", "root", l.roots[i].Title, "err", err)
+					return
+				}
+				l.receiveUpdates(i, ch)
+			}(i)
+		}
+		return l, nil
+	}
+
+	for i := range l.roots {
+		ch, err := l.roots[i].Scanner.Start()
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not start the scanner of root '%s'", l.roots[i].Title)
+		}
+		go l.receiveUpdates(i, ch)
+	}
+	return l, nil
 }
 
 // Browse lists the specified album. Provide a zero-length slice to list the
-// root album.
-func (l *Library) Browse(ids []music.AlbumId, publicOnly bool) (music.Album, error) {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
+// root album. The listing selects the order of the albums and tracks
+// contained in the album and which of them are returned.
+func (l *Library) Browse(ids []music.AlbumId, publicOnly bool, listing music.Listing) (music.Album, error) {
+	t := l.getTree()
 
-	album, err := l.getAlbum(ids)
+	album, err := t.getAlbum(ids)
 	if err != nil {
 		return music.Album{}, errors.Wrap(err, "failed to get an album")
 	}
 
-	access, err := l.getAccess(ids)
-	if err != nil {
-		return music.Album{}, errors.Wrap(err, "failed to get access")
-	}
+	access := album.resolvedAccess
 
-	parents, err := l.getParents(ids)
+	parents, err := t.getParents(ids)
 	if err != nil {
 		return music.Album{}, errors.Wrap(err, "failed to get parents")
 	}
@@ -102,182 +274,745 @@ func (l *Library) Browse(ids []music.AlbumId, publicOnly bool) (music.Album, err
 		listed.Id = ids[len(ids)-1]
 	}
 
-	if album.thumbnailId != "" {
-		listed.Thumbnail = &music.Thumbnail{
-			FileId: album.thumbnailId,
+	listed.Thumbnail = l.newThumbnail(*album)
+
+	var albums []listedAlbum
+	for id, album := range album.albums {
+		if canAccess(album.resolvedAccess, publicOnly) {
+			albums = append(albums, listedAlbum{id: id, album: album})
 		}
 	}
+	l.sortListedAlbums(t, albums, listing)
 
-	for id, album := range album.albums {
-		access, err := l.getAccess(append(ids, id))
-		if err != nil {
-			return music.Album{}, errors.Wrap(err, "failed to get access")
+	var tracks []listedTrack
+	if canAccess(access, publicOnly) {
+		info := l.albumInfo(album)
+		for id, track := range album.tracks {
+			tracks = append(tracks, listedTrack{
+				id:         id,
+				track:      track,
+				musicTrack: l.toMusicTrack(id, track, info),
+			})
 		}
+		l.sortListedTracks(tracks, listing)
+	}
 
-		if !canAccess(access, publicOnly) {
-			continue
+	listed.TotalAlbums = len(albums)
+	listed.TotalTracks = len(tracks)
+
+	start, end, next := listingBounds(albums, tracks, listing)
+	listed.NextCursor = next.String()
+
+	for i := start; i < end && i < len(albums); i++ {
+		listed.Albums = append(listed.Albums, music.Album{
+			Id:        albums[i].id,
+			Title:     albums[i].album.title,
+			Thumbnail: l.newThumbnail(*albums[i].album),
+			Access:    albums[i].album.resolvedAccess,
+		})
+	}
+
+	for i := start; i < end; i++ {
+		if i >= len(albums) {
+			listed.Tracks = append(listed.Tracks, tracks[i-len(albums)].musicTrack)
 		}
+	}
+
+	return listed, nil
+}
 
-		d := music.Album{
-			Id:     id,
-			Title:  album.title,
-			Access: access,
+// RecordPlay increases the play count of the track. The tree is replaced so
+// that the play is included in the stats of the albums containing the track.
+func (l *Library) RecordPlay(id music.FileId) error {
+	l.updateMutex.Lock()
+	defer l.updateMutex.Unlock()
+
+	t := l.getTree()
+
+	v, ok := t.tracks[id]
+	if !ok {
+		return music.ErrNotFound
+	}
+
+	if err := l.plays.increment(id); err != nil {
+		return errors.Wrap(err, "could not increment the play count")
+	}
+
+	l.tree.Store(t.withPlay(v))
+	return nil
+}
+
+// Search returns the albums and the tracks which match the query sorted by
+// their relevance. The albums and the tracks are paginated separately.
+func (l *Library) Search(query music.Query, publicOnly bool, page music.Page) (music.SearchResult, error) {
+	t := l.getTree()
+
+	var albums, tracks []searchDocument
+	for _, hit := range l.evaluateQuery(t.search, query) {
+		document := t.search.documents[hit.document]
+		if !canAccess(document.album.resolvedAccess, publicOnly) {
+			continue
 		}
-		if album.thumbnailId != "" {
-			d.Thumbnail = &music.Thumbnail{
-				FileId: album.thumbnailId,
-			}
+
+		if document.isTrack() {
+			tracks = append(tracks, document)
+		} else {
+			albums = append(albums, document)
 		}
-		listed.Albums = append(listed.Albums, d)
 	}
-	sortAlbums(listed.Albums)
 
-	if canAccess(access, publicOnly) {
-		for id, track := range album.tracks {
-			t := music.Track{
-				Id:       id,
-				FileId:   track.fileId,
-				Title:    track.title,
-				Duration: l.trackStore.GetDuration(track.fileId.String()).Seconds(),
-			}
-			listed.Tracks = append(listed.Tracks, t)
+	result := music.SearchResult{
+		TotalAlbums: len(albums),
+		TotalTracks: len(tracks),
+	}
+
+	start, end := pageBounds(len(albums), page)
+	for _, document := range albums[start:end] {
+		result.Albums = append(
+			result.Albums,
+			l.newBasicAlbum(document.path, *document.album),
+		)
+	}
+
+	start, end = pageBounds(len(tracks), page)
+	for _, document := range tracks[start:end] {
+		result.Tracks = append(
+			result.Tracks,
+			l.toSearchResultTrack(
+				l.newBasicAlbum(document.path, *document.album),
+				document.trackId,
+				document.track,
+				l.albumInfo(document.album),
+			),
+		)
+	}
+
+	return result, nil
+}
+
+// pageBounds returns the bounds of the page in a list of the specified
+// length.
+func pageBounds(length int, page music.Page) (int, int) {
+	start := page.Offset()
+	if start > length {
+		start = length
+	}
+
+	end := start + page.Limit()
+	if end > length {
+		end = length
+	}
+
+	return start, end
+}
+
+// Lyrics returns the lyrics of the track with the specified file id. The
+// lyrics files take precedence over the lyrics embedded in the track. The
+// lyrics embedded in the files described by cue sheets are ignored as they
+// describe the entire file.
+func (l *Library) Lyrics(id music.FileId) (music.Lyrics, error) {
+	t, _, ok := l.getTree().findTrack(id)
+	if !ok {
+		return music.Lyrics{}, music.ErrNotFound
+	}
+
+	if t.lyrics != "" {
+		lyrics, err := store.ReadLyricsFile(t.lyrics)
+		if err != nil {
+			return music.Lyrics{}, errors.Wrap(err, "could not read the lyrics file")
 		}
-		SortTracks(listed.Tracks)
+		return toMusicLyrics(lyrics), nil
 	}
 
-	return listed, nil
+	if t.cue != nil {
+		return music.Lyrics{}, music.ErrNotFound
+	}
+
+	lyrics, ok, err := l.trackStore.GetEmbeddedLyrics(id.String())
+	if err != nil {
+		return music.Lyrics{}, errors.Wrap(err, "could not get the embedded lyrics")
+	}
+
+	if !ok {
+		return music.Lyrics{}, music.ErrNotFound
+	}
+
+	return toMusicLyrics(lyrics), nil
 }
 
-const maxSearchItems = 10
+// Gain returns the gain which should be applied to the track with the
+// specified file id to normalize its loudness. False is returned if the
+// gain isn't known or the normalization is disabled. The track gain is used
+// if the album gain isn't known. Positive gains are limited using the peaks
+// so that the tracks don't clip.
+func (l *Library) Gain(id music.FileId, normalization music.Normalization) (float64, bool, error) {
+	t, a, ok := l.getTree().findTrack(id)
+	if !ok {
+		return 0, false, music.ErrNotFound
+	}
 
-func (l *Library) Search(query string, publicOnly bool) (music.SearchResult, error) {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
+	if normalization == music.NormalizationAlbum {
+		if gain := l.albumGain(a); gain != nil {
+			return limitGain(*gain, l.albumPeak(a)), true, nil
+		}
+	}
 
-	var result music.SearchResult
+	if normalization == music.NormalizationTrack || normalization == music.NormalizationAlbum {
+		if metadata, _ := l.trackStore.GetMetadata(t.fileId.String()); metadata.TrackGain != nil {
+			return limitGain(*metadata.TrackGain, metadata.TrackPeak), true, nil
+		}
+	}
 
-	if err := l.walk(
-		func(parent *music.BasicAlbum, id music.AlbumId, v album) error {
-			if len(result.Albums) > maxSearchItems {
-				return nil
-			}
+	return 0, false, nil
+}
 
-			if !containsStringCaseInsensitive(v.title, query) {
-				return nil
-			}
+// NextTracks returns the file ids of at most limit tracks which follow the
+// track with the specified file id in the order in which the tracks appear on
+// its album.
+func (l *Library) NextTracks(id music.FileId, limit int) ([]music.FileId, error) {
+	_, a, ok := l.getTree().findTrack(id)
+	if !ok {
+		return nil, music.ErrNotFound
+	}
 
-			var path []music.AlbumId
-			if parent != nil {
-				path = append(path, parent.Path...)
-			}
-			path = append(path, id)
+	info := l.albumInfo(a)
+	var tracks []music.Track
+	for trackId, t := range a.tracks {
+		tracks = append(tracks, l.toMusicTrack(trackId, t, info))
+	}
+	SortTracks(tracks)
 
-			result.Albums = append(
-				result.Albums,
-				newBasicAlbum(path, v),
-			)
+	var result []music.FileId
+	for i, t := range tracks {
+		if t.FileId != id {
+			continue
+		}
 
-			return nil
-		},
-		func(parents music.BasicAlbum, id music.TrackId, v track) error {
-			if len(result.Tracks) > maxSearchItems {
-				return nil
+		for _, next := range tracks[i+1:] {
+			if len(result) >= limit {
+				break
 			}
+			result = append(result, next.FileId)
+		}
+		break
+	}
 
-			if !containsStringCaseInsensitive(v.title, query) {
-				return nil
-			}
+	return result, nil
+}
 
-			result.Tracks = append(
-				result.Tracks,
-				l.toSearchResultTrack(parents, id, v),
-			)
-			return nil
-		},
-		publicOnly,
-	); err != nil {
-		return music.SearchResult{}, errors.Wrap(err, "walk failed")
+// CanAccessTrack checks if the album containing the track with the
+// specified file id can be accessed.
+func (l *Library) CanAccessTrack(id music.FileId, publicOnly bool) (bool, error) {
+	_, a, ok := l.getTree().findTrack(id)
+	if !ok {
+		return false, music.ErrNotFound
 	}
+	return canAccess(a.resolvedAccess, publicOnly), nil
+}
 
-	return result, nil
+func hasLyrics(t track, metadata store.Metadata) bool {
+	return t.lyrics != "" || (t.cue == nil && metadata.Lyrics)
 }
 
-func (l *Library) toSearchResultTrack(album music.BasicAlbum, id music.TrackId, v track) music.SearchResultTrack {
+func toMusicLyrics(lyrics store.Lyrics) music.Lyrics {
+	result := music.Lyrics{
+		Text: lyrics.Text,
+	}
+
+	for _, line := range lyrics.Lines {
+		result.Lines = append(result.Lines, music.LyricsLine{
+			Time: line.Time.Seconds(),
+			Text: line.Text,
+		})
+	}
+
+	return result
+}
+
+// ScanProblems returns the paths which were skipped during the last scan.
+func (l *Library) ScanProblems() []queries.ScanProblem {
+	var problems []queries.ScanProblem
+	for _, rootProblems := range l.getTree().problems {
+		for _, problem := range rootProblems {
+			problems = append(problems, queries.ScanProblem{
+				Path:   problem.Path,
+				Reason: problem.Reason,
+			})
+		}
+	}
+	return problems
+}
+
+func (l *Library) toSearchResultTrack(album music.BasicAlbum, id music.TrackId, v track, info albumInfo) music.SearchResultTrack {
 	return music.SearchResultTrack{
-		Track: music.Track{
-			Id:       id,
-			FileId:   v.fileId,
-			Title:    v.title,
-			Duration: l.trackStore.GetDuration(v.fileId.String()).Seconds(),
-		},
+		Track: l.toMusicTrack(id, v, info),
 		Album: album,
 	}
 }
 
-func (l *Library) getParents(ids []music.AlbumId) ([]music.Album, error) {
-	parents := make([]music.Album, 0)
-	for i := 0; i < len(ids); i++ {
-		parentIds := ids[:i+1]
-		dir, err := l.getAlbum(parentIds)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to get a parent album")
+// albumInfo describes the album which contains the converted tracks.
+type albumInfo struct {
+	picture string
+	gain    *float64
+}
+
+func (l *Library) albumInfo(a *album) albumInfo {
+	return albumInfo{
+		picture: l.albumPicture(a),
+		gain:    l.albumGain(a),
+	}
+}
+
+// albumPicture returns the hash of the cover art embedded in the first track
+// of the album. This cover art is used as the thumbnail of the album if the
+// album doesn't have a thumbnail file.
+func (l *Library) albumPicture(a *album) string {
+	first, ok := firstTrack(a)
+	if !ok {
+		return ""
+	}
+	metadata, _ := l.trackStore.GetMetadata(first.fileId.String())
+	return metadata.Picture
+}
+
+// albumGain returns the album gain stored in the tags of the tracks. If the
+// tracks aren't tagged then the album gain is computed from the loudness of
+// the tracks once the loudness of all of them is known.
+func (l *Library) albumGain(a *album) *float64 {
+	var loudness []weightedLoudness
+	for _, t := range a.tracks {
+		metadata, _ := l.trackStore.GetMetadata(t.fileId.String())
+		if metadata.AlbumGain != nil {
+			return metadata.AlbumGain
+		}
+
+		if metadata.TrackGain != nil {
+			loudness = append(loudness, weightedLoudness{
+				Loudness: store.GainToLoudness(*metadata.TrackGain),
+				Weight:   metadata.Duration.Seconds(),
+			})
+		}
+	}
+
+	if len(loudness) == 0 || len(loudness) != len(a.tracks) {
+		return nil
+	}
+
+	gain := store.LoudnessToGain(averageLoudness(loudness))
+	return &gain
+}
+
+// albumPeak returns the album peak stored in the tags of the tracks. If the
+// tracks aren't tagged then the album peak is the highest track peak once
+// the peaks of all tracks are known.
+func (l *Library) albumPeak(a *album) *float64 {
+	var peak *float64
+	known := true
+	for _, t := range a.tracks {
+		metadata, _ := l.trackStore.GetMetadata(t.fileId.String())
+		if metadata.AlbumPeak != nil {
+			return metadata.AlbumPeak
 		}
-		parent := music.Album{
-			Id:    parentIds[len(parentIds)-1],
-			Title: dir.title,
+
+		if metadata.TrackPeak == nil {
+			known = false
+			continue
 		}
-		parents = append(parents, parent)
 
+		if peak == nil || *metadata.TrackPeak > *peak {
+			peak = metadata.TrackPeak
+		}
+	}
+
+	if !known {
+		return nil
+	}
+	return peak
+}
+
+// limitGain lowers the positive gain so that the peak doesn't exceed the
+// full scale after the gain is applied. The gain is returned unchanged if the
+// peak isn't known.
+func limitGain(gain float64, peak *float64) float64 {
+	if gain <= 0 || peak == nil || *peak <= 0 {
+		return gain
+	}
+	headroom := math.Max(-20*math.Log10(*peak), 0)
+	return math.Min(gain, headroom)
+}
+
+type weightedLoudness struct {
+	Loudness float64
+	Weight   float64
+}
+
+// averageLoudness averages the energy of the tracks weighted by their
+// durations as the album should be as loud as the tracks played one after
+// another.
+func averageLoudness(loudness []weightedLoudness) float64 {
+	var energy, weights float64
+	for _, l := range loudness {
+		weight := l.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		energy += weight * math.Pow(10, l.Loudness/10)
+		weights += weight
+	}
+	return 10 * math.Log10(energy/weights)
+}
+
+// toMusicTrack converts the track. The cover art embedded in the track is
+// exposed only if it differs from the one embedded in the first track of the
+// album.
+func (l *Library) toMusicTrack(id music.TrackId, v track, info albumInfo) music.Track {
+	metadata, probed := l.trackStore.GetMetadata(v.fileId.String())
+
+	title := v.title
+	if metadata.Title != "" {
+		title = metadata.Title
+	}
+
+	// the tags embedded in a file described by a cue sheet describe the
+	// entire album
+	if v.cue != nil {
+		title = v.title
+		metadata.TrackNumber = v.cue.Number
+		if v.cue.Performer != "" {
+			metadata.Artist = v.cue.Performer
+		}
+	}
+
+	if metadata.DiscNumber == 0 {
+		metadata.DiscNumber = v.disc
+	}
+
+	var thumbnail *music.Thumbnail
+	if metadata.Picture != "" && metadata.Picture != info.picture {
+		thumbnail = &music.Thumbnail{
+			FileId: v.fileId,
+		}
+	}
+
+	return music.Track{
+		Id:          id,
+		FileId:      v.fileId,
+		Title:       title,
+		Thumbnail:   thumbnail,
+		Pending:     !probed,
+		Lyrics:      hasLyrics(v, metadata),
+		Duration:    metadata.Duration.Seconds(),
+		TrackGain:   metadata.TrackGain,
+		AlbumGain:   info.gain,
+		Artist:      metadata.Artist,
+		AlbumArtist: metadata.AlbumArtist,
+		Album:       metadata.Album,
+		TrackNumber: metadata.TrackNumber,
+		DiscNumber:  metadata.DiscNumber,
+		Year:        metadata.Year,
+		Genre:       metadata.Genre,
 	}
-	return parents, nil
 }
 
 var defaultAccess = music.Access{
 	Public: false,
 }
 
-func (l *Library) getAccess(ids []music.AlbumId) (music.Access, error) {
-	for i := len(ids); i >= 0; i-- {
-		parentIds := ids[:i]
-		album, err := l.getAlbum(parentIds)
-		if err != nil {
-			return music.Access{}, errors.Wrap(err, "failed to get a parent album")
+// rootDefaultAccess returns the default access of the root which is located
+// at the specified path or nil.
+func (l *Library) rootDefaultAccess(ids []music.AlbumId) *music.Access {
+	for _, root := range l.roots {
+		if root.DefaultAccess != nil && equalAlbumIds(root.ids, ids) {
+			return root.DefaultAccess
 		}
-		if album.access != nil {
-			return *album.access, nil
+	}
+	return nil
+}
+
+func equalAlbumIds(a, b []music.AlbumId) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
 	}
-	return defaultAccess, nil
+	return true
 }
 
-func (l *Library) receiveUpdates(ch <-chan scanner.Album) {
-	for album := range ch {
-		if err := l.handleUpdate(album); err != nil {
+func (l *Library) receiveUpdates(rootIndex int, ch <-chan scanner.Update) {
+	for update := range ch {
+		if err := l.handleUpdate(rootIndex, update); err != nil {
 			l.log.Error("could not handle a scanner update", "err", err)
 		}
 	}
 }
 
-func (l *Library) handleUpdate(album scanner.Album) error {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
+// getTree returns the current state of the library. The returned tree must not
+// be modified.
+func (l *Library) getTree() *tree {
+	return l.tree.Load().(*tree)
+}
+
+// reindexInterval limits how often the search index is updated while the
+// tracks are being probed.
+const reindexInterval = time.Second
+
+func (l *Library) onTrackProbed(id string) {
+	l.probedMutex.Lock()
+	defer l.probedMutex.Unlock()
+
+	l.probed[music.FileId(id)] = struct{}{}
+
+	select {
+	case l.probedSignal <- struct{}{}:
+	default:
+	}
+}
+
+// reindexProbedTracks indexes the tracks again after they are probed as their
+// tags weren't known when they were indexed. The tracks probed in quick
+// succession are indexed together.
+func (l *Library) reindexProbedTracks() {
+	for range l.probedSignal {
+		l.probedMutex.Lock()
+		var ids []music.FileId
+		for id := range l.probed {
+			ids = append(ids, id)
+		}
+		l.probed = make(map[music.FileId]struct{})
+		l.probedMutex.Unlock()
+
+		l.updateMutex.Lock()
+		l.tree.Store(l.reindexTracks(l.getTree(), ids))
+		l.updateMutex.Unlock()
+
+		<-time.After(reindexInterval)
+	}
+}
+
+func (l *Library) restore() (bool, error) {
+	l.updateMutex.Lock()
+	defer l.updateMutex.Unlock()
+
+	snapshot, err := l.snapshotRepository.Load()
+	if err != nil {
+		return false, errors.Wrap(err, "could not load the snapshot")
+	}
+
+	if snapshot == nil {
+		return false, nil
+	}
 
-	// update the track list
-	l.root = newAlbum(rootAlbumTitle)
-	if err := l.mergeAlbum(nil, l.root, album); err != nil {
-		return errors.Wrap(err, "merge album failed")
+	root := fromSnapshotAlbum(snapshot.Root)
+	if err := l.updateStores(root); err != nil {
+		return false, errors.Wrap(err, "could not update the stores")
 	}
 
+	l.tree.Store(l.newTree(root, l.getTree().problems))
+	return true, nil
+}
+
+// handleUpdate builds a new tree which replaces the current one. Readers
+// continue to use the previous tree until the new one is ready.
+func (l *Library) handleUpdate(rootIndex int, update scanner.Update) error {
+	l.updateMutex.Lock()
+	defer l.updateMutex.Unlock()
+
+	current := l.getTree()
+	path := l.roots[rootIndex].path(update.Path)
+
+	ids, err := l.albumIds(path)
+	if err != nil {
+		return errors.Wrap(err, "could not create the album ids")
+	}
+
+	var root *album
+	if len(path) == 0 {
+		r, err := l.replaceRoot(update.Album)
+		if err != nil {
+			return errors.Wrap(err, "could not replace the root album")
+		}
+		root = r
+	} else {
+		r, err := l.replaceAlbum(current.root, path, update.Album)
+		if err != nil {
+			return errors.Wrap(err, "could not replace an album")
+		}
+		root = r
+	}
+
+	problems := make([][]scanner.Problem, len(current.problems))
+	copy(problems, current.problems)
+	problems[rootIndex] = update.Problems
+
+	if len(path) == 0 {
+		l.tree.Store(l.newTree(root, problems))
+	} else {
+		l.tree.Store(l.updateTree(current, root, ids, problems))
+	}
+
+	// the files which disappeared during a full scan weren't moved
+	if len(update.Path) == 0 {
+		var paths []string
+		getFilePaths(&paths, root)
+		l.idGenerator.Retain(paths)
+	}
+
+	l.events.Publish(music.Event{
+		Type:   music.EventTypeLibraryChanged,
+		Albums: [][]music.AlbumId{ids},
+	})
+
+	if err := l.snapshotRepository.Save(newSnapshot(root)); err != nil {
+		return errors.Wrap(err, "could not save the snapshot")
+	}
+
+	return nil
+}
+
+// albumIds returns the ids of the albums located on the specified path.
+func (l *Library) albumIds(path []string) ([]music.AlbumId, error) {
+	ids := make([]music.AlbumId, 0, len(path))
+	for _, title := range path {
+		id, err := l.idGenerator.AlbumId(ids, title)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create an album id")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (l *Library) replaceRoot(scannerAlbum *scanner.Album) (*album, error) {
+	root := newAlbum(rootAlbumTitle)
+	if scannerAlbum != nil {
+		if err := l.mergeAlbum(nil, root, *scannerAlbum); err != nil {
+			return nil, errors.Wrap(err, "merge album failed")
+		}
+	}
+
+	if err := l.updateStores(root); err != nil {
+		return nil, errors.Wrap(err, "could not update the stores")
+	}
+
+	return root, nil
+}
+
+// replaceAlbum returns a copy of the root album in which a single album and
+// its children are replaced. The rest of the tree is left intact. The album
+// is removed if the scanner album is nil.
+func (l *Library) replaceAlbum(currentRoot *album, path []string, scannerAlbum *scanner.Album) (*album, error) {
+	ids, err := l.albumIds(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the album ids")
+	}
+
+	parentIds := ids[:len(ids)-1]
+	id := ids[len(ids)-1]
+
+	var replacement *album
+	if scannerAlbum != nil {
+		replacement = newAlbum(path[len(path)-1])
+		if err := l.mergeAlbum(ids, replacement, *scannerAlbum); err != nil {
+			return nil, errors.Wrap(err, "merge album failed")
+		}
+	}
+
+	// copy or create the parent albums, the rest of the tree is shared with
+	// the current root
+	root := cloneAlbum(currentRoot)
+	parent := root
+	for i, parentId := range parentIds {
+		child, ok := parent.albums[parentId]
+		if ok {
+			child = cloneAlbum(child)
+		} else {
+			child = newAlbum(path[i])
+		}
+		parent.albums[parentId] = child
+		parent = child
+	}
+
+	var oldTracks, oldThumbnails []store.Item
+	if previous, ok := parent.albums[id]; ok {
+		if err := l.getTracks(&oldTracks, previous); err != nil {
+			return nil, errors.Wrap(err, "preparing tracks failed")
+		}
+		if err := l.getThumbnails(&oldThumbnails, previous); err != nil {
+			return nil, errors.Wrap(err, "preparing thumbnails failed")
+		}
+		delete(parent.albums, id)
+	}
+
+	var newTracks, newThumbnails []store.Item
+	if replacement != nil {
+		if err := l.getTracks(&newTracks, replacement); err != nil {
+			return nil, errors.Wrap(err, "preparing tracks failed")
+		}
+		if err := l.getThumbnails(&newThumbnails, replacement); err != nil {
+			return nil, errors.Wrap(err, "preparing thumbnails failed")
+		}
+		parent.albums[id] = replacement
+	}
+
+	removeEmptyAlbums(root, ids)
+
+	l.trackStore.RemoveItems(removedItemIds(oldTracks, newTracks))
+	l.trackStore.AddItems(newTracks)
+	l.thumbnailStore.RemoveItems(removedItemIds(oldThumbnails, newThumbnails))
+	l.thumbnailStore.AddItems(newThumbnails)
+
+	return root, nil
+}
+
+// removeEmptyAlbums removes the albums located on the specified path if they
+// no longer contain any tracks or albums.
+func removeEmptyAlbums(root *album, ids []music.AlbumId) {
+	for i := len(ids); i > 0; i-- {
+		parent, err := getAlbum(root, ids[:i-1])
+		if err != nil {
+			continue
+		}
+
+		album, ok := parent.albums[ids[i-1]]
+		if !ok {
+			continue
+		}
+
+		if len(album.albums) == 0 && len(album.tracks) == 0 {
+			delete(parent.albums, ids[i-1])
+		}
+	}
+}
+
+func removedItemIds(oldItems, newItems []store.Item) []string {
+	newIds := make(map[string]struct{})
+	for _, item := range newItems {
+		newIds[item.Id] = struct{}{}
+	}
+
+	var removed []string
+	for _, item := range oldItems {
+		if _, ok := newIds[item.Id]; !ok {
+			removed = append(removed, item.Id)
+		}
+	}
+	return removed
+}
+
+func (l *Library) updateStores(root *album) error {
 	// inform track store which files are available for conversion
 	var tracks []store.Item
-	if err := l.getTracks(&tracks, l.root); err != nil {
+	if err := l.getTracks(&tracks, root); err != nil {
 		return errors.Wrap(err, "preparing tracks failed")
 	}
 	l.trackStore.SetItems(tracks)
 
 	// inform thumbnail store which files are available for conversion
 	var thumbnails []store.Item
-	if err := l.getThumbnails(&thumbnails, l.root); err != nil {
+	if err := l.getThumbnails(&thumbnails, root); err != nil {
 		return errors.Wrap(err, "preparing thumbnails failed")
 	}
 	l.thumbnailStore.SetItems(thumbnails)
@@ -311,6 +1046,16 @@ func (l *Library) mergeAlbum(parents []music.AlbumId, target *album, album scann
 		target.tracks[id] = track
 	}
 
+	// the cover art embedded in the first track is used if the album doesn't
+	// have a thumbnail file
+	if target.thumbnailId == "" {
+		if first, ok := firstTrack(target); ok {
+			target.thumbnailPath = first.path
+			target.thumbnailId = first.fileId
+			target.thumbnailEmbedded = true
+		}
+	}
+
 	for title, scannerAlbum := range album.Albums {
 		id, album, err := l.toAlbum(parents, title, *scannerAlbum)
 		if err != nil {
@@ -330,8 +1075,19 @@ func (l *Library) mergeAlbum(parents []music.AlbumId, target *album, album scann
 func (l *Library) getThumbnails(thumbnails *[]store.Item, current *album) error {
 	if current.thumbnailPath != "" {
 		thumbnail := store.Item{
-			Id:   current.thumbnailId.String(),
-			Path: current.thumbnailPath,
+			Id:       current.thumbnailId.String(),
+			Path:     current.thumbnailPath,
+			Embedded: current.thumbnailEmbedded,
+		}
+		*thumbnails = append(*thumbnails, thumbnail)
+	}
+
+	// the cover art embedded in the tracks can be displayed as well
+	for _, track := range current.tracks {
+		thumbnail := store.Item{
+			Id:       track.fileId.String(),
+			Path:     track.path,
+			Embedded: true,
 		}
 		*thumbnails = append(*thumbnails, thumbnail)
 	}
@@ -347,11 +1103,15 @@ func (l *Library) getThumbnails(thumbnails *[]store.Item, current *album) error
 
 func (l *Library) getTracks(tracks *[]store.Item, current *album) error {
 	for _, track := range current.tracks {
-		track := store.Item{
+		item := store.Item{
 			Id:   track.fileId.String(),
 			Path: track.path,
 		}
-		*tracks = append(*tracks, track)
+		if track.cue != nil {
+			item.Start = track.cue.Start
+			item.End = track.cue.End
+		}
+		*tracks = append(*tracks, item)
 	}
 
 	for _, child := range current.albums {
@@ -363,37 +1123,72 @@ func (l *Library) getTracks(tracks *[]store.Item, current *album) error {
 	return nil
 }
 
-func (l *Library) getAlbum(ids []music.AlbumId) (*album, error) {
-	var current *album = l.root
-	for _, id := range ids {
-		child, ok := current.albums[id]
-		if !ok {
-			return nil, errors.Wrapf(music.ErrNotFound, "album '%s' not found", id)
-		}
-		current = child
+// getFilePaths lists the paths of the files for which the file ids were
+// generated.
+func getFilePaths(paths *[]string, current *album) {
+	if current.thumbnailPath != "" {
+		*paths = append(*paths, current.thumbnailPath)
+	}
+
+	for _, track := range current.tracks {
+		*paths = append(*paths, track.path)
+	}
+
+	for _, child := range current.albums {
+		getFilePaths(paths, child)
 	}
-	return current, nil
 }
 
-func (l *Library) newTrack(title string, path string) (track, error) {
-	fileId, err := l.idGenerator.FileId(path)
+func (l *Library) newTrack(title string, scannerTrack scanner.Track) (track, error) {
+	fileId, err := l.newFileId(scannerTrack)
 	if err != nil {
 		return track{}, errors.Wrap(err, "could not create a file id")
 	}
 	t := track{
-		title:  title,
-		path:   path,
-		fileId: fileId,
+		title:   title,
+		path:    scannerTrack.Path,
+		fileId:  fileId,
+		cue:     scannerTrack.Cue,
+		disc:    scannerTrack.Disc,
+		lyrics:  scannerTrack.Lyrics,
+		added:   l.addedTime(fileId),
+		modTime: modTime(scannerTrack.Path),
 	}
 	return t, nil
 }
 
+// addedTime returns the time at which the track was first seen. The time is
+// preserved as long as the file id of the track doesn't change.
+func (l *Library) addedTime(fileId music.FileId) time.Time {
+	if previous, _, ok := l.getTree().findTrack(fileId); ok && !previous.added.IsZero() {
+		return previous.added
+	}
+	return time.Now()
+}
+
+// modTime returns the modification time of the file or zero time if it can't
+// be determined.
+func modTime(path string) time.Time {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fileInfo.ModTime()
+}
+
+func (l *Library) newFileId(scannerTrack scanner.Track) (music.FileId, error) {
+	if scannerTrack.Cue != nil {
+		return l.idGenerator.FileRangeId(scannerTrack.Path, scannerTrack.Cue.Start, scannerTrack.Cue.End)
+	}
+	return l.idGenerator.FileId(scannerTrack.Path)
+}
+
 func (l *Library) toTrack(parents []music.AlbumId, title string, scannerTrack scanner.Track) (music.TrackId, track, error) {
-	id, err := l.idGenerator.TrackId(parents, title)
+	id, err := l.idGenerator.TrackId(parents, title, scannerTrack)
 	if err != nil {
 		return "", track{}, errors.Wrap(err, "could not create a track id")
 	}
-	t, err := l.newTrack(title, scannerTrack.Path)
+	t, err := l.newTrack(title, scannerTrack)
 	if err != nil {
 		return "", track{}, errors.Wrap(err, "could not create a track")
 	}
@@ -420,15 +1215,51 @@ type track struct {
 	title  string
 	path   string
 	fileId music.FileId
+
+	// cue is set if the track occupies only a part of the file.
+	cue *scanner.CueTrack
+
+	// disc is set if the track was located in a disc directory.
+	disc int
+
+	// lyrics is a path of a lyrics file.
+	lyrics string
+
+	// added is the time at which the track was first seen. It is zero if
+	// it isn't known.
+	added time.Time
+
+	// modTime is the modification time of the file. It is zero if it
+	// isn't known.
+	modTime time.Time
 }
 
 type album struct {
 	title         string
 	thumbnailPath string
 	thumbnailId   music.FileId
-	access        *music.Access
-	albums        map[music.AlbumId]*album
-	tracks        map[music.TrackId]track
+
+	// thumbnailEmbedded is set if the album doesn't have a thumbnail file
+	// and the cover art embedded in its first track is used instead. In
+	// that case the thumbnail points to the first track.
+	thumbnailEmbedded bool
+
+	// access is loaded from the access file of the album.
+	access *music.Access
+
+	// resolvedAccess applies to the album taking the access of its parents
+	// into account. It is set when a tree is created.
+	resolvedAccess music.Access
+
+	// added is the earliest time at which one of the tracks of the album or
+	// its children was first seen. modTime is the latest modification time
+	// of those tracks. They are set when a tree is created and are zero if
+	// they aren't known.
+	added   time.Time
+	modTime time.Time
+
+	albums map[music.AlbumId]*album
+	tracks map[music.TrackId]track
 }
 
 func newAlbum(title string) *album {
@@ -439,17 +1270,52 @@ func newAlbum(title string) *album {
 	}
 }
 
-func sortAlbums(albums []music.Album) {
-	sort.Slice(albums,
-		func(i, j int) bool {
-			return albums[i].Title < albums[j].Title
-		},
-	)
+// firstTrack returns the track which most likely opens the album. The track
+// numbers stored in the tags aren't used as the files may not have been
+// probed yet.
+func firstTrack(a *album) (track, bool) {
+	var first track
+	var found bool
+	for _, t := range a.tracks {
+		if !found || isTrackBefore(t, first) {
+			first = t
+			found = true
+		}
+	}
+	return first, found
+}
+
+func isTrackBefore(a, b track) bool {
+	if a.disc != b.disc {
+		return a.disc < b.disc
+	}
+
+	if a.cue != nil && b.cue != nil && a.path == b.path {
+		return a.cue.Start < b.cue.Start
+	}
+
+	return a.title < b.title
 }
 
 func SortTracks(tracks []music.Track) {
 	sort.Slice(tracks,
 		func(i, j int) bool {
+			if tracks[i].DiscNumber != tracks[j].DiscNumber {
+				return tracks[i].DiscNumber < tracks[j].DiscNumber
+			}
+
+			// the tracks without track numbers are placed after the
+			// numbered ones so that the order doesn't depend on the input
+			numberedI := tracks[i].TrackNumber > 0
+			numberedJ := tracks[j].TrackNumber > 0
+			if numberedI != numberedJ {
+				return numberedI
+			}
+
+			if tracks[i].TrackNumber != tracks[j].TrackNumber {
+				return tracks[i].TrackNumber < tracks[j].TrackNumber
+			}
+
 			fieldsI := strings.Fields(tracks[i].Title)
 			fieldsJ := strings.Fields(tracks[j].Title)
 
@@ -468,11 +1334,7 @@ func SortTracks(tracks []music.Track) {
 				}
 			}
 
-			return tracks[i].Title < tracks[j].Title
+			return compareNatural(tracks[i].Title, tracks[j].Title) < 0
 		},
 	)
 }
-
-func containsStringCaseInsensitive(s, substr string) bool {
-	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
-}
@@ -2,6 +2,9 @@ package library_test
 
 import (
 	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,6 +12,8 @@ import (
 	"github.com/boreq/eggplant/adapters/music/scanner"
 	"github.com/boreq/eggplant/adapters/music/store"
 	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/eggplant/application/queries"
+	"github.com/boreq/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -17,8 +22,49 @@ type mockTrackStore struct{}
 func (mockTrackStore) SetItems(items []store.Item) {
 }
 
-func (mockTrackStore) GetDuration(id string) time.Duration {
-	return 0
+func (mockTrackStore) AddItems(items []store.Item) {
+}
+
+func (mockTrackStore) RemoveItems(ids []string) {
+}
+
+func (mockTrackStore) GetMetadata(id string) (store.Metadata, bool) {
+	return store.Metadata{}, true
+}
+
+func (mockTrackStore) GetEmbeddedLyrics(id string) (store.Lyrics, bool, error) {
+	return store.Lyrics{}, false, nil
+}
+
+func (mockTrackStore) OnProbed(handler func(id string)) {
+}
+
+type recordingTrackStore struct {
+	mockTrackStore
+	items []store.Item
+}
+
+func (r *recordingTrackStore) SetItems(items []store.Item) {
+	r.items = items
+}
+
+type metadataTrackStore struct {
+	mockTrackStore
+	metadata map[string]store.Metadata
+	lyrics   map[string]store.Lyrics
+	pending  map[string]bool
+}
+
+func (s metadataTrackStore) GetMetadata(id string) (store.Metadata, bool) {
+	if s.pending[id] {
+		return store.Metadata{}, false
+	}
+	return s.metadata[id], true
+}
+
+func (s metadataTrackStore) GetEmbeddedLyrics(id string) (store.Lyrics, bool, error) {
+	lyrics, ok := s.lyrics[id]
+	return lyrics, ok, nil
 }
 
 type mockThumbnailStore struct{}
@@ -26,6 +72,30 @@ type mockThumbnailStore struct{}
 func (mockThumbnailStore) SetItems(items []store.Item) {
 }
 
+func (mockThumbnailStore) AddItems(items []store.Item) {
+}
+
+func (mockThumbnailStore) RemoveItems(ids []string) {
+}
+
+type recordingThumbnailStore struct {
+	added   []string
+	removed []string
+}
+
+func (s *recordingThumbnailStore) SetItems(items []store.Item) {
+}
+
+func (s *recordingThumbnailStore) AddItems(items []store.Item) {
+	for _, item := range items {
+		s.added = append(s.added, item.Id)
+	}
+}
+
+func (s *recordingThumbnailStore) RemoveItems(ids []string) {
+	s.removed = append(s.removed, ids...)
+}
+
 type mockAccessLoader struct {
 	m map[string]music.Access
 }
@@ -38,13 +108,127 @@ func (l mockAccessLoader) Load(file string) (music.Access, error) {
 	return access, nil
 }
 
+type mockScanner struct {
+	ch chan scanner.Update
+}
+
+func (s mockScanner) Start() (<-chan scanner.Update, error) {
+	return s.ch, nil
+}
+
+func newRoots(s library.Scanner) []library.Root {
+	return []library.Root{
+		{
+			Scanner: s,
+		},
+	}
+}
+
+type mockSnapshotRepository struct {
+	snapshot *library.Snapshot
+
+	// saved receives a value after each save if it isn't nil
+	saved chan struct{}
+
+	mutex sync.Mutex
+}
+
+func (r *mockSnapshotRepository) Load() (*library.Snapshot, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.snapshot, nil
+}
+
+func (r *mockSnapshotRepository) Save(snapshot library.Snapshot) error {
+	r.mutex.Lock()
+	r.snapshot = &snapshot
+	r.mutex.Unlock()
+
+	if r.saved != nil {
+		select {
+		case r.saved <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+type mockPlayCountRepository struct {
+	counts map[music.FileId]int
+}
+
+func (r *mockPlayCountRepository) Load() (map[music.FileId]int, error) {
+	counts := make(map[music.FileId]int)
+	for id, count := range r.counts {
+		counts[id] = count
+	}
+	return counts, nil
+}
+
+func (r *mockPlayCountRepository) Save(id music.FileId, count int) error {
+	if r.counts == nil {
+		r.counts = make(map[music.FileId]int)
+	}
+	r.counts[id] = count
+	return nil
+}
+
+type mockEventPublisher struct {
+	events  []music.Event
+	changed chan struct{}
+	mutex   sync.Mutex
+}
+
+func (p *mockEventPublisher) Publish(event music.Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.events = append(p.events, event)
+
+	if event.Type == music.EventTypeLibraryChanged {
+		select {
+		case p.libraryChanged() <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// waitForLibraryChanged waits for the next libraryChanged event which is
+// published once the library handles an update.
+func (p *mockEventPublisher) waitForLibraryChanged(t *testing.T) {
+	p.mutex.Lock()
+	changed := p.libraryChanged()
+	p.mutex.Unlock()
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("library wasn't changed")
+	}
+}
+
+func (p *mockEventPublisher) libraryChanged() chan struct{} {
+	if p.changed == nil {
+		p.changed = make(chan struct{}, 100)
+	}
+	return p.changed
+}
+
+func (p *mockEventPublisher) Events() []music.Event {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]music.Event(nil), p.events...)
+}
+
 type mockIdGenerator struct{}
 
 func (mockIdGenerator) AlbumId(parents []music.AlbumId, title string) (music.AlbumId, error) {
 	return music.AlbumId(title), nil
 }
 
-func (mockIdGenerator) TrackId(parents []music.AlbumId, title string) (music.TrackId, error) {
+func (mockIdGenerator) TrackId(parents []music.AlbumId, title string, track scanner.Track) (music.TrackId, error) {
 	return music.TrackId(title), nil
 }
 
@@ -52,6 +236,13 @@ func (mockIdGenerator) FileId(path string) (music.FileId, error) {
 	return music.FileId(path), nil
 }
 
+func (mockIdGenerator) FileRangeId(path string, start, end time.Duration) (music.FileId, error) {
+	return music.FileId(fmt.Sprintf("%s-%s-%s", path, start, end)), nil
+}
+
+func (mockIdGenerator) Retain(paths []string) {
+}
+
 func TestLibrary(t *testing.T) {
 	testCases := []struct {
 		Name string
@@ -142,6 +333,8 @@ func TestLibrary(t *testing.T) {
 						FileId: "t1_path",
 					},
 				},
+				TotalAlbums: 2,
+				TotalTracks: 1,
 			},
 			ExpectedError: nil,
 		},
@@ -220,6 +413,8 @@ func TestLibrary(t *testing.T) {
 						FileId: "a1t1_path",
 					},
 				},
+				TotalAlbums: 2,
+				TotalTracks: 1,
 			},
 			ExpectedError: nil,
 		},
@@ -269,7 +464,8 @@ func TestLibrary(t *testing.T) {
 						},
 					},
 				},
-				Tracks: nil,
+				Tracks:      nil,
+				TotalAlbums: 1,
 			},
 			ExpectedError: nil,
 		},
@@ -326,6 +522,8 @@ func TestLibrary(t *testing.T) {
 						FileId: "t1_path",
 					},
 				},
+				TotalAlbums: 1,
+				TotalTracks: 1,
 			},
 			ExpectedError: nil,
 		},
@@ -372,6 +570,7 @@ func TestLibrary(t *testing.T) {
 						},
 					},
 				},
+				TotalAlbums: 1,
 			},
 			ExpectedError: nil,
 		},
@@ -446,6 +645,8 @@ func TestLibrary(t *testing.T) {
 						FileId: "a1t1_path",
 					},
 				},
+				TotalAlbums: 1,
+				TotalTracks: 1,
 			},
 			ExpectedError: nil,
 		},
@@ -453,23 +654,25 @@ func TestLibrary(t *testing.T) {
 
 	for _, testCase := range testCases {
 		t.Run(testCase.Name, func(t *testing.T) {
-			ch := make(chan scanner.Album)
+			ch := make(chan scanner.Update)
+			events := &mockEventPublisher{}
 			trs := mockTrackStore{}
 			ths := mockThumbnailStore{}
 			al := mockAccessLoader{
 				m: testCase.Access,
 			}
 			ig := mockIdGenerator{}
+			sr := &mockSnapshotRepository{}
 
-			library, err := library.New(ch, trs, ths, al, ig)
+			library, err := library.New(newRoots(mockScanner{ch}), trs, ths, al, ig, sr, &mockPlayCountRepository{}, events)
 			require.NoError(t, err)
 
 			if testCase.Album != nil {
-				ch <- *testCase.Album
-				<-time.After(time.Second) // rc
+				ch <- scanner.Update{Album: testCase.Album}
+				events.waitForLibraryChanged(t)
 			}
 
-			album, err := library.Browse(testCase.Ids, testCase.PublicOnly)
+			album, err := library.Browse(testCase.Ids, testCase.PublicOnly, music.Listing{})
 			if testCase.ExpectedError == nil {
 				require.NoError(t, err)
 				require.Equal(t, testCase.ExpectedAlbum, &album)
@@ -480,6 +683,639 @@ func TestLibrary(t *testing.T) {
 	}
 }
 
+func TestLibraryCanAccessTrack(t *testing.T) {
+	al := mockAccessLoader{
+		m: map[string]music.Access{
+			"public": {
+				Public: true,
+			},
+		},
+	}
+
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	l, err := library.New(newRoots(mockScanner{ch}), mockTrackStore{}, mockThumbnailStore{}, al, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"public": {
+					AccessFile: "public",
+					Tracks: map[string]scanner.Track{
+						"t": {Path: "public_path"},
+					},
+				},
+				"private": {
+					Tracks: map[string]scanner.Track{
+						"t": {Path: "private_path"},
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	ok, err := l.CanAccessTrack("public_path", true)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = l.CanAccessTrack("private_path", true)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = l.CanAccessTrack("private_path", false)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = l.CanAccessTrack("missing_path", false)
+	require.ErrorIs(t, err, music.ErrNotFound)
+}
+
+func TestLibraryNextTracks(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	l, err := library.New(newRoots(mockScanner{ch}), mockTrackStore{}, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"album": {
+					Tracks: map[string]scanner.Track{
+						"c": {Path: "c_path"},
+						"a": {Path: "a_path"},
+						"d": {Path: "d_path"},
+						"b": {Path: "b_path"},
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	ids, err := l.NextTracks("a_path", 2)
+	require.NoError(t, err)
+	require.Equal(t, []music.FileId{"b_path", "c_path"}, ids)
+
+	ids, err = l.NextTracks("c_path", 2)
+	require.NoError(t, err)
+	require.Equal(t, []music.FileId{"d_path"}, ids)
+
+	ids, err = l.NextTracks("d_path", 2)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	_, err = l.NextTracks("missing_path", 2)
+	require.ErrorIs(t, err, music.ErrNotFound)
+}
+
+func TestLibraryIsRestoredFromSnapshot(t *testing.T) {
+	al := mockAccessLoader{
+		m: map[string]music.Access{
+			"public": {
+				Public: true,
+			},
+		},
+	}
+	sr := &mockSnapshotRepository{saved: make(chan struct{}, 1)}
+
+	ch := make(chan scanner.Update)
+	l, err := library.New(newRoots(mockScanner{ch}), mockTrackStore{}, mockThumbnailStore{}, al, mockIdGenerator{}, sr, &mockPlayCountRepository{}, &mockEventPublisher{})
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {
+					Thumbnail:  "a1_thumbnail",
+					AccessFile: "public",
+					Tracks: map[string]scanner.Track{
+						"a1t1": {
+							Path: "a1t1_path",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	select {
+	case <-sr.saved:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the snapshot wasn't saved")
+	}
+
+	// stops the first library from receiving updates
+	close(ch)
+
+	expected, err := l.Browse([]music.AlbumId{"a1"}, true, music.Listing{})
+	require.NoError(t, err)
+
+	snapshot, err := sr.Load()
+	require.NoError(t, err)
+	require.NotNil(t, snapshot)
+
+	restored, err := library.New(newRoots(mockScanner{make(chan scanner.Update)}), mockTrackStore{}, mockThumbnailStore{}, al, mockIdGenerator{}, sr, &mockPlayCountRepository{}, &mockEventPublisher{})
+	require.NoError(t, err)
+
+	album, err := restored.Browse([]music.AlbumId{"a1"}, true, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, expected, album)
+}
+
+func TestLibraryAppliesPartialUpdates(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	ths := &recordingThumbnailStore{}
+
+	l, err := library.New(newRoots(mockScanner{ch}), mockTrackStore{}, ths, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {
+					Thumbnail: "a1_thumbnail",
+					Tracks: map[string]scanner.Track{
+						"a1t1": {
+							Path: "a1t1_path",
+						},
+					},
+				},
+				"a2": {
+					Thumbnail: "a2_thumbnail",
+					Tracks: map[string]scanner.Track{
+						"a2t1": {
+							Path: "a2t1_path",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	ch <- scanner.Update{
+		Path:  []string{"a2"},
+		Album: nil,
+	}
+
+	ch <- scanner.Update{
+		Path: []string{"a3", "a3a1"},
+		Album: &scanner.Album{
+			Thumbnail: "a3a1_thumbnail",
+			Tracks: map[string]scanner.Track{
+				"a3a1t1": {
+					Path: "a3a1t1_path",
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+	events.waitForLibraryChanged(t)
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, []music.Album{
+		{
+			Id:    "a1",
+			Title: "a1",
+			Thumbnail: &music.Thumbnail{
+				FileId: "a1_thumbnail",
+			},
+		},
+		{
+			Id:    "a3",
+			Title: "a3",
+		},
+	}, album.Albums)
+
+	album, err = l.Browse([]music.AlbumId{"a3", "a3a1"}, false, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, []music.Track{
+		{
+			Id:     "a3a1t1",
+			FileId: "a3a1t1_path",
+			Title:  "a3a1t1",
+		},
+	}, album.Tracks)
+
+	require.Equal(t, []string{"a2_thumbnail", "a2t1_path"}, ths.removed)
+	require.Equal(t, []string{"a3a1_thumbnail", "a3a1t1_path"}, ths.added)
+
+	ch <- scanner.Update{
+		Path:  []string{"a3", "a3a1"},
+		Album: nil,
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err = l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Len(t, album.Albums, 1)
+
+	_, err = l.Browse([]music.AlbumId{"a3"}, false, music.Listing{})
+	require.ErrorIs(t, err, music.ErrNotFound)
+}
+
+func TestLibraryMergesMultipleRoots(t *testing.T) {
+	musicCh := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	audiobooksCh := make(chan scanner.Update)
+
+	roots := []library.Root{
+		{
+			Title:   "Music",
+			Scanner: mockScanner{musicCh},
+		},
+		{
+			Title:   "Audiobooks",
+			Scanner: mockScanner{audiobooksCh},
+			DefaultAccess: &music.Access{
+				Public: true,
+			},
+		},
+	}
+
+	al := mockAccessLoader{
+		m: map[string]music.Access{
+			"no-public": {
+				Public: false,
+			},
+		},
+	}
+
+	l, err := library.New(roots, mockTrackStore{}, mockThumbnailStore{}, al, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	musicCh <- scanner.Update{
+		Album: &scanner.Album{
+			Tracks: map[string]scanner.Track{
+				"t1": {
+					Path: "t1_path",
+				},
+			},
+		},
+	}
+
+	audiobooksCh <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {
+					Tracks: map[string]scanner.Track{
+						"a1t1": {
+							Path: "a1t1_path",
+						},
+					},
+				},
+				"a2": {
+					AccessFile: "no-public",
+					Tracks: map[string]scanner.Track{
+						"a2t1": {
+							Path: "a2t1_path",
+						},
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, []music.Album{
+		{
+			Id:    "Audiobooks",
+			Title: "Audiobooks",
+			Access: music.Access{
+				Public: true,
+			},
+		},
+		{
+			Id:    "Music",
+			Title: "Music",
+		},
+	}, album.Albums)
+	require.Empty(t, album.Tracks)
+
+	album, err = l.Browse([]music.AlbumId{"Audiobooks"}, true, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, []music.Album{
+		{
+			Id:    "a1",
+			Title: "a1",
+			Access: music.Access{
+				Public: true,
+			},
+		},
+	}, album.Albums)
+
+	musicCh <- scanner.Update{
+		Path:  []string{},
+		Album: &scanner.Album{},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err = l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Len(t, album.Albums, 1)
+	require.Equal(t, music.AlbumId("Audiobooks"), album.Albums[0].Id)
+}
+
+func TestLibraryRequiresUniqueRootTitles(t *testing.T) {
+	roots := []library.Root{
+		{
+			Title:   "Music",
+			Scanner: mockScanner{make(chan scanner.Update)},
+		},
+		{
+			Title:   "Music",
+			Scanner: mockScanner{make(chan scanner.Update)},
+		},
+	}
+
+	_, err := library.New(roots, mockTrackStore{}, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, &mockEventPublisher{})
+	require.EqualError(t, err, "invalid roots: duplicate title 'Music'")
+}
+
+func TestLibraryReportsScanProblems(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+
+	l, err := library.New(newRoots(mockScanner{ch}), mockTrackStore{}, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{},
+		Problems: []scanner.Problem{
+			{
+				Path:   "a/b.mp3",
+				Reason: "some reason",
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	require.Equal(t, []queries.ScanProblem{
+		{
+			Path:   "a/b.mp3",
+			Reason: "some reason",
+		},
+	}, l.ScanProblems())
+
+	ch <- scanner.Update{
+		Path:  []string{"a"},
+		Album: nil,
+	}
+	events.waitForLibraryChanged(t)
+
+	require.Empty(t, l.ScanProblems())
+}
+
+func TestLibraryCreatesCueTracks(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	ts := &recordingTrackStore{}
+
+	l, err := library.New(newRoots(mockScanner{ch}), ts, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Tracks: map[string]scanner.Track{
+				"First": {
+					Path: "album.flac",
+					Cue: &scanner.CueTrack{
+						Number:    1,
+						Title:     "First",
+						Performer: "Artist",
+						Start:     0,
+						End:       time.Minute,
+					},
+				},
+				"Second": {
+					Path: "album.flac",
+					Cue: &scanner.CueTrack{
+						Number: 2,
+						Title:  "Second",
+						Start:  time.Minute,
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, []music.Track{
+		{
+			Id:          "First",
+			FileId:      "album.flac-0s-1m0s",
+			Title:       "First",
+			Artist:      "Artist",
+			TrackNumber: 1,
+		},
+		{
+			Id:          "Second",
+			FileId:      "album.flac-1m0s-0s",
+			Title:       "Second",
+			TrackNumber: 2,
+		},
+	}, album.Tracks)
+
+	require.ElementsMatch(t, []store.Item{
+		{
+			Id:    "album.flac-0s-1m0s",
+			Path:  "album.flac",
+			Start: 0,
+			End:   time.Minute,
+		},
+		{
+			Id:    "album.flac-1m0s-0s",
+			Path:  "album.flac",
+			Start: time.Minute,
+		},
+	}, ts.items)
+}
+
+func TestLibraryReturnsLyrics(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	lyricsFile := filepath.Join(dir, "a.lrc")
+	writeFile(t, lyricsFile, "[00:01.00]First line\n[00:02.50]Second line\n")
+
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	ts := metadataTrackStore{
+		metadata: map[string]store.Metadata{
+			"b_path": {Lyrics: true},
+		},
+		lyrics: map[string]store.Lyrics{
+			"b_path": {Text: "Embedded"},
+		},
+	}
+
+	l, err := library.New(newRoots(mockScanner{ch}), ts, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Tracks: map[string]scanner.Track{
+				"a": {
+					Path:   "a_path",
+					Lyrics: lyricsFile,
+				},
+				"b": {
+					Path: "b_path",
+				},
+				"c": {
+					Path: "c_path",
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, []music.Track{
+		{
+			Id:     "a",
+			FileId: "a_path",
+			Title:  "a",
+			Lyrics: true,
+		},
+		{
+			Id:     "b",
+			FileId: "b_path",
+			Title:  "b",
+			Lyrics: true,
+		},
+		{
+			Id:     "c",
+			FileId: "c_path",
+			Title:  "c",
+		},
+	}, album.Tracks)
+
+	lyrics, err := l.Lyrics("a_path")
+	require.NoError(t, err)
+	require.Equal(t, music.Lyrics{
+		Lines: []music.LyricsLine{
+			{Time: 1, Text: "First line"},
+			{Time: 2.5, Text: "Second line"},
+		},
+	}, lyrics)
+
+	lyrics, err = l.Lyrics("b_path")
+	require.NoError(t, err)
+	require.Equal(t, music.Lyrics{Text: "Embedded"}, lyrics)
+
+	_, err = l.Lyrics("c_path")
+	require.True(t, errors.Is(err, music.ErrNotFound))
+
+	_, err = l.Lyrics("unknown")
+	require.True(t, errors.Is(err, music.ErrNotFound))
+}
+
+func TestLibraryUsesEmbeddedCoverArt(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	ts := metadataTrackStore{
+		metadata: map[string]store.Metadata{
+			"a1t1_path": {Picture: "picture1"},
+			"a1t2_path": {Picture: "picture1"},
+			"a1t3_path": {Picture: "picture2"},
+			"a2t2_path": {Picture: "picture3"},
+		},
+	}
+
+	l, err := library.New(newRoots(mockScanner{ch}), ts, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {
+					Tracks: map[string]scanner.Track{
+						"a1t1": {Path: "a1t1_path"},
+						"a1t2": {Path: "a1t2_path"},
+						"a1t3": {Path: "a1t3_path"},
+					},
+				},
+				"a2": {
+					Tracks: map[string]scanner.Track{
+						"a2t1": {Path: "a2t1_path"},
+						"a2t2": {Path: "a2t2_path"},
+					},
+				},
+				"a3": {
+					Thumbnail: "a3_thumbnail",
+					Tracks: map[string]scanner.Track{
+						"a3t1": {Path: "a3t1_path"},
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, []music.Album{
+		{
+			Id:    "a1",
+			Title: "a1",
+			Thumbnail: &music.Thumbnail{
+				FileId: "a1t1_path",
+			},
+		},
+		{
+			Id:    "a2",
+			Title: "a2",
+		},
+		{
+			Id:    "a3",
+			Title: "a3",
+			Thumbnail: &music.Thumbnail{
+				FileId: "a3_thumbnail",
+			},
+		},
+	}, album.Albums)
+
+	album, err = l.Browse([]music.AlbumId{"a1"}, false, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, &music.Thumbnail{FileId: "a1t1_path"}, album.Thumbnail)
+	require.Equal(t, []music.Track{
+		{
+			Id:     "a1t1",
+			FileId: "a1t1_path",
+			Title:  "a1t1",
+		},
+		{
+			Id:     "a1t2",
+			FileId: "a1t2_path",
+			Title:  "a1t2",
+		},
+		{
+			Id:     "a1t3",
+			FileId: "a1t3_path",
+			Title:  "a1t3",
+			Thumbnail: &music.Thumbnail{
+				FileId: "a1t3_path",
+			},
+		},
+	}, album.Tracks)
+
+	album, err = l.Browse([]music.AlbumId{"a2"}, false, music.Listing{})
+	require.NoError(t, err)
+	require.Nil(t, album.Thumbnail)
+	require.Equal(t, &music.Thumbnail{FileId: "a2t2_path"}, album.Tracks[1].Thumbnail)
+}
+
 func TestSearch(t *testing.T) {
 	testCases := []struct {
 		Name string
@@ -555,6 +1391,8 @@ func TestSearch(t *testing.T) {
 						},
 					},
 				},
+				TotalAlbums: 1,
+				TotalTracks: 1,
 			},
 			ExpectedError: nil,
 		},
@@ -562,25 +1400,28 @@ func TestSearch(t *testing.T) {
 
 	for _, testCase := range testCases {
 		t.Run(testCase.Name, func(t *testing.T) {
-			ch := make(chan scanner.Album)
+			ch := make(chan scanner.Update)
+			events := &mockEventPublisher{}
 			trs := mockTrackStore{}
 			ths := mockThumbnailStore{}
 			al := mockAccessLoader{
 				m: testCase.Access,
 			}
 			ig := mockIdGenerator{}
+			sr := &mockSnapshotRepository{}
 
-			library, err := library.New(ch, trs, ths, al, ig)
+			library, err := library.New(newRoots(mockScanner{ch}), trs, ths, al, ig, sr, &mockPlayCountRepository{}, events)
 			require.NoError(t, err)
 
 			if testCase.Album != nil {
-				ch <- *testCase.Album
-				<-time.After(time.Second) // rc
+				ch <- scanner.Update{Album: testCase.Album}
+				events.waitForLibraryChanged(t)
 			}
 
 			result, err := library.Search(
-				testCase.Query,
+				music.MustNewQuery(testCase.Query),
 				testCase.PublicOnly,
+				music.MustNewPage(0, 10),
 			)
 			if testCase.ExpectedError == nil {
 				require.NoError(t, err)
@@ -763,3 +1604,343 @@ func TestSortTracks(t *testing.T) {
 		})
 	}
 }
+
+func TestSortTracksUsesTrackNumbers(t *testing.T) {
+	input := []music.Track{
+		{Title: "1 a", DiscNumber: 2, TrackNumber: 1},
+		{Title: "2 b", DiscNumber: 1, TrackNumber: 2},
+		{Title: "3 c", DiscNumber: 1, TrackNumber: 1},
+	}
+
+	output := []music.Track{
+		{Title: "3 c", DiscNumber: 1, TrackNumber: 1},
+		{Title: "2 b", DiscNumber: 1, TrackNumber: 2},
+		{Title: "1 a", DiscNumber: 2, TrackNumber: 1},
+	}
+
+	library.SortTracks(input)
+	require.Equal(t, output, input)
+}
+
+func TestSortTracksPlacesUnnumberedTracksLast(t *testing.T) {
+	output := []music.Track{
+		{Title: "c", TrackNumber: 1},
+		{Title: "a", TrackNumber: 2},
+		{Title: "b", TrackNumber: 3},
+		{Title: "a"},
+		{Title: "d"},
+	}
+
+	for i := 0; i < 10; i++ {
+		input := make([]music.Track, len(output))
+		copy(input, output)
+		rand.Shuffle(len(input), func(i, j int) {
+			input[i], input[j] = input[j], input[i]
+		})
+
+		library.SortTracks(input)
+		require.Equal(t, output, input)
+	}
+}
+
+func TestSortTracksUsesDiscNumbers(t *testing.T) {
+	input := []music.Track{
+		{Title: "01 a", DiscNumber: 2},
+		{Title: "02 b", DiscNumber: 1},
+		{Title: "01 c", DiscNumber: 1},
+	}
+
+	output := []music.Track{
+		{Title: "01 c", DiscNumber: 1},
+		{Title: "02 b", DiscNumber: 1},
+		{Title: "01 a", DiscNumber: 2},
+	}
+
+	library.SortTracks(input)
+	require.Equal(t, output, input)
+}
+
+func TestLibraryReturnsGain(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	ts := metadataTrackStore{
+		metadata: map[string]store.Metadata{
+			"a1t1_path": {Duration: time.Minute, TrackGain: float64Ptr(-2)},
+			"a1t2_path": {Duration: time.Minute, TrackGain: float64Ptr(-8)},
+			"a2t1_path": {TrackGain: float64Ptr(-2), AlbumGain: float64Ptr(-4)},
+			"a2t2_path": {},
+			"a3t1_path": {TrackGain: float64Ptr(-2)},
+			"a3t2_path": {},
+			"a4t1_path": {TrackGain: float64Ptr(6), TrackPeak: float64Ptr(0.6), AlbumGain: float64Ptr(4), AlbumPeak: float64Ptr(0.8)},
+			"a4t2_path": {TrackGain: float64Ptr(3)},
+		},
+	}
+
+	l, err := library.New(newRoots(mockScanner{ch}), ts, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {
+					Tracks: map[string]scanner.Track{
+						"a1t1": {Path: "a1t1_path"},
+						"a1t2": {Path: "a1t2_path"},
+					},
+				},
+				"a2": {
+					Tracks: map[string]scanner.Track{
+						"a2t1": {Path: "a2t1_path"},
+						"a2t2": {Path: "a2t2_path"},
+					},
+				},
+				"a3": {
+					Tracks: map[string]scanner.Track{
+						"a3t1": {Path: "a3t1_path"},
+						"a3t2": {Path: "a3t2_path"},
+					},
+				},
+				"a4": {
+					Tracks: map[string]scanner.Track{
+						"a4t1": {Path: "a4t1_path"},
+						"a4t2": {Path: "a4t2_path"},
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	// energy average of -16 LUFS and -10 LUFS
+	measuredAlbumGain := -5.963
+
+	testCases := []struct {
+		Name          string
+		FileId        music.FileId
+		Normalization music.Normalization
+		ExpectedGain  float64
+		ExpectedOk    bool
+	}{
+		{
+			Name:          "none",
+			FileId:        "a1t1_path",
+			Normalization: music.NormalizationNone,
+			ExpectedOk:    false,
+		},
+		{
+			Name:          "track",
+			FileId:        "a1t1_path",
+			Normalization: music.NormalizationTrack,
+			ExpectedGain:  -2,
+			ExpectedOk:    true,
+		},
+		{
+			Name:          "measured_album",
+			FileId:        "a1t1_path",
+			Normalization: music.NormalizationAlbum,
+			ExpectedGain:  measuredAlbumGain,
+			ExpectedOk:    true,
+		},
+		{
+			Name:          "tagged_album",
+			FileId:        "a2t2_path",
+			Normalization: music.NormalizationAlbum,
+			ExpectedGain:  -4,
+			ExpectedOk:    true,
+		},
+		{
+			Name:          "album_falls_back_to_track",
+			FileId:        "a3t1_path",
+			Normalization: music.NormalizationAlbum,
+			ExpectedGain:  -2,
+			ExpectedOk:    true,
+		},
+		{
+			Name:          "unknown",
+			FileId:        "a3t2_path",
+			Normalization: music.NormalizationAlbum,
+			ExpectedOk:    false,
+		},
+		{
+			Name:          "track_limited_by_peak",
+			FileId:        "a4t1_path",
+			Normalization: music.NormalizationTrack,
+			ExpectedGain:  4.437,
+			ExpectedOk:    true,
+		},
+		{
+			Name:          "album_limited_by_peak",
+			FileId:        "a4t1_path",
+			Normalization: music.NormalizationAlbum,
+			ExpectedGain:  1.938,
+			ExpectedOk:    true,
+		},
+		{
+			Name:          "unknown_peak",
+			FileId:        "a4t2_path",
+			Normalization: music.NormalizationTrack,
+			ExpectedGain:  3,
+			ExpectedOk:    true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			gain, ok, err := l.Gain(testCase.FileId, testCase.Normalization)
+			require.NoError(t, err)
+			require.Equal(t, testCase.ExpectedOk, ok)
+			require.InDelta(t, testCase.ExpectedGain, gain, 0.001)
+		})
+	}
+
+	_, _, err = l.Gain("nonexistent", music.NormalizationTrack)
+	require.True(t, errors.Is(err, music.ErrNotFound))
+
+	album, err := l.Browse([]music.AlbumId{"a1"}, false, music.Listing{})
+	require.NoError(t, err)
+	require.Len(t, album.Tracks, 2)
+	for _, track := range album.Tracks {
+		require.NotNil(t, track.TrackGain)
+		require.NotNil(t, track.AlbumGain)
+		require.InDelta(t, measuredAlbumGain, *track.AlbumGain, 0.001)
+	}
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func TestLibraryMarksPendingTracks(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	ts := metadataTrackStore{
+		metadata: map[string]store.Metadata{
+			"a_path": {Title: "Title", Duration: time.Minute},
+		},
+		pending: map[string]bool{
+			"b_path": true,
+		},
+	}
+
+	l, err := library.New(newRoots(mockScanner{ch}), ts, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Tracks: map[string]scanner.Track{
+				"a": {Path: "a_path"},
+				"b": {Path: "b_path"},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, []music.Track{
+		{
+			Id:      "b",
+			FileId:  "b_path",
+			Title:   "b",
+			Pending: true,
+		},
+		{
+			Id:       "a",
+			FileId:   "a_path",
+			Title:    "Title",
+			Duration: 60,
+		},
+	}, album.Tracks)
+}
+
+func TestLibraryBrowseDoesNotWaitForUpdates(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	ts := &blockingTrackStore{
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	l, err := library.New(newRoots(mockScanner{ch}), ts, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Tracks: map[string]scanner.Track{
+				"t1": {Path: "t1_path"},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	// the update blocks in the track store
+	ch <- scanner.Update{
+		Path: []string{"a"},
+		Album: &scanner.Album{
+			Tracks: map[string]scanner.Track{
+				"t2": {Path: "t2_path"},
+			},
+		},
+	}
+	<-ts.blocked
+
+	album, err := l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Empty(t, album.Albums)
+	require.Len(t, album.Tracks, 1)
+
+	close(ts.release)
+	events.waitForLibraryChanged(t)
+
+	album, err = l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Len(t, album.Albums, 1)
+	require.Len(t, album.Tracks, 1)
+}
+
+type blockingTrackStore struct {
+	mockTrackStore
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingTrackStore) AddItems(items []store.Item) {
+	close(s.blocked)
+	<-s.release
+}
+
+func TestLibraryPublishesChanges(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+
+	_, err := library.New(newRoots(mockScanner{ch}), mockTrackStore{}, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {Tracks: map[string]scanner.Track{"t": {Path: "a1t"}}},
+			},
+		},
+	}
+
+	ch <- scanner.Update{
+		Path: []string{"a2", "a2a1"},
+		Album: &scanner.Album{
+			Tracks: map[string]scanner.Track{"t": {Path: "a2a1t"}},
+		},
+	}
+	events.waitForLibraryChanged(t)
+	events.waitForLibraryChanged(t)
+
+	require.Equal(t, []music.Event{
+		{
+			Type:   music.EventTypeLibraryChanged,
+			Albums: [][]music.AlbumId{{}},
+		},
+		{
+			Type:   music.EventTypeLibraryChanged,
+			Albums: [][]music.AlbumId{{"a2", "a2a1"}},
+		},
+	}, events.Events())
+}
@@ -0,0 +1,304 @@
+package library
+
+import (
+	"sort"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/boreq/eggplant/application/music"
+)
+
+// sortValue is the value of the sort key of an album or a track. Values
+// which aren't known are always listed last.
+type sortValue struct {
+	value int64
+	known bool
+}
+
+func knownValue(v int64) sortValue {
+	return sortValue{value: v, known: v != 0}
+}
+
+type listedAlbum struct {
+	id    music.AlbumId
+	album *album
+	value sortValue
+}
+
+type listedTrack struct {
+	id         music.TrackId
+	track      track
+	musicTrack music.Track
+	value      sortValue
+}
+
+// sortListedAlbums sorts the albums by their names unless a different sort
+// key is specified. Albums which have the same value of the sort key are
+// sorted by their names.
+func (l *Library) sortListedAlbums(t *tree, albums []listedAlbum, listing music.Listing) {
+	for i := range albums {
+		albums[i].value = albumSortValue(t, albums[i].album, listing.Sort())
+	}
+
+	descending := listing.Descending()
+	byName := listing.Sort() == music.SortKeyDefault || listing.Sort() == music.SortKeyName
+
+	sort.SliceStable(albums, func(i, j int) bool {
+		if !byName {
+			if c := compareSortValues(albums[i].value, albums[j].value, descending); c != 0 {
+				return c < 0
+			}
+		}
+
+		c := compareNatural(albums[i].album.title, albums[j].album.title)
+		if byName && descending {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+
+		return albums[i].id < albums[j].id
+	})
+}
+
+// albumSortValue returns the sort value of the album using the stats
+// aggregated when the tree was created.
+func albumSortValue(t *tree, a *album, key music.SortKey) sortValue {
+	switch key {
+	case music.SortKeyAdded:
+		return knownValue(unixNano(a.added))
+	case music.SortKeyModified:
+		return knownValue(unixNano(a.modTime))
+	case music.SortKeyYear:
+		return knownValue(int64(t.stats[a].year))
+	case music.SortKeyPlays:
+		return sortValue{value: int64(t.stats[a].plays), known: true}
+	default:
+		return sortValue{}
+	}
+}
+
+// sortListedTracks sorts the tracks in the order in which they appear on the
+// album unless a different sort key is specified. Tracks which have the same
+// value of the sort key retain that order.
+func (l *Library) sortListedTracks(tracks []listedTrack, listing music.Listing) {
+	musicTracks := make([]music.Track, len(tracks))
+	byId := make(map[music.TrackId]listedTrack)
+	for i, t := range tracks {
+		musicTracks[i] = t.musicTrack
+		byId[t.id] = t
+	}
+	SortTracks(musicTracks)
+
+	for i, musicTrack := range musicTracks {
+		tracks[i] = byId[musicTrack.Id]
+		tracks[i].value = l.trackSortValue(tracks[i].track, musicTrack, listing.Sort())
+	}
+
+	descending := listing.Descending()
+
+	switch listing.Sort() {
+	case music.SortKeyDefault:
+		if descending {
+			for i, j := 0, len(tracks)-1; i < j; i, j = i+1, j-1 {
+				tracks[i], tracks[j] = tracks[j], tracks[i]
+			}
+		}
+	case music.SortKeyName:
+		sort.SliceStable(tracks, func(i, j int) bool {
+			c := compareNatural(tracks[i].musicTrack.Title, tracks[j].musicTrack.Title)
+			if descending {
+				c = -c
+			}
+			return c < 0
+		})
+	default:
+		sort.SliceStable(tracks, func(i, j int) bool {
+			return compareSortValues(tracks[i].value, tracks[j].value, descending) < 0
+		})
+	}
+}
+
+func (l *Library) trackSortValue(t track, musicTrack music.Track, key music.SortKey) sortValue {
+	switch key {
+	case music.SortKeyAdded:
+		return knownValue(unixNano(t.added))
+	case music.SortKeyModified:
+		return knownValue(unixNano(t.modTime))
+	case music.SortKeyYear:
+		return knownValue(int64(musicTrack.Year))
+	case music.SortKeyPlays:
+		return sortValue{value: int64(l.plays.get(t.fileId)), known: true}
+	default:
+		return sortValue{}
+	}
+}
+
+// listingBounds returns the bounds of the listed page in a list of albums
+// followed by tracks. The cursor of the next page is returned if there are
+// more items after the page.
+func listingBounds(albums []listedAlbum, tracks []listedTrack, listing music.Listing) (int, int, music.Cursor) {
+	total := len(albums) + len(tracks)
+
+	start := listingStart(albums, tracks, listing.After())
+	if start > total {
+		start = total
+	}
+
+	end := total
+	if listing.Limit() > 0 && start+listing.Limit() < total {
+		end = start + listing.Limit()
+	}
+
+	var next music.Cursor
+	if end < total && end > 0 {
+		last := end - 1
+		if last < len(albums) {
+			next = music.NewAlbumCursor(albums[last].id, last)
+		} else {
+			next = music.NewTrackCursor(tracks[last-len(albums)].id, last)
+		}
+	}
+
+	return start, end, next
+}
+
+// listingStart returns the index of the item which follows the item pointed
+// to by the cursor. If that item no longer exists then the listing continues
+// at its former index.
+func listingStart(albums []listedAlbum, tracks []listedTrack, after music.Cursor) int {
+	if after.IsZero() {
+		return 0
+	}
+
+	for i, a := range albums {
+		if after.PointsTo(false, a.id.String()) {
+			return i + 1
+		}
+	}
+
+	for i, t := range tracks {
+		if after.PointsTo(true, t.id.String()) {
+			return len(albums) + i + 1
+		}
+	}
+
+	return after.Index()
+}
+
+// unixNano returns zero for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// compareSortValues returns a negative number if a should be listed before
+// b, a positive number if b should be listed before a and zero if the values
+// are equal.
+func compareSortValues(a, b sortValue, descending bool) int {
+	if a.known != b.known {
+		if a.known {
+			return -1
+		}
+		return 1
+	}
+
+	if a.value == b.value {
+		return 0
+	}
+
+	c := 1
+	if a.value < b.value {
+		c = -1
+	}
+
+	if descending {
+		c = -c
+	}
+	return c
+}
+
+// compareNatural compares the strings ignoring case and diacritics. Numbers
+// are compared by their values so that "Track 2" comes before "Track 10".
+func compareNatural(a, b string) int {
+	a = foldString(a)
+	b = foldString(b)
+
+	for a != "" && b != "" {
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+
+		if isDigit(ra) && isDigit(rb) {
+			numberA, restA := splitNumber(a)
+			numberB, restB := splitNumber(b)
+			if c := compareNumbers(numberA, numberB); c != 0 {
+				return c
+			}
+			a, b = restA, restB
+			continue
+		}
+
+		if ra != rb {
+			if ra < rb {
+				return -1
+			}
+			return 1
+		}
+
+		a, b = a[sizeA:], b[sizeB:]
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+func isDigit(r rune) bool {
+	return r < utf8.RuneSelf && unicode.IsDigit(r)
+}
+
+func splitNumber(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(rune(s[i])) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareNumbers compares numbers of any length written in decimal.
+func compareNumbers(a, b string) int {
+	a = trimLeadingZeros(a)
+	b = trimLeadingZeros(b)
+
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func trimLeadingZeros(s string) string {
+	for len(s) > 1 && s[0] == '0' {
+		s = s[1:]
+	}
+	return s
+}
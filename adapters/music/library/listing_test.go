@@ -0,0 +1,352 @@
+package library_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/library"
+	"github.com/boreq/eggplant/adapters/music/scanner"
+	"github.com/boreq/eggplant/adapters/music/store"
+	"github.com/boreq/eggplant/application/music"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrowseSortsByNaturalNames(t *testing.T) {
+	l, ch, events := newListingLibrary(t, mockTrackStore{})
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"Album 10": {Tracks: map[string]scanner.Track{"t": {Path: "a10"}}},
+				"album 2":  {Tracks: map[string]scanner.Track{"t": {Path: "a2"}}},
+				"Álbum 1":  {Tracks: map[string]scanner.Track{"t": {Path: "a1"}}},
+			},
+			Tracks: map[string]scanner.Track{
+				"Track 10":  {Path: "t10"},
+				"Track 9":   {Path: "t9"},
+				"track 09b": {Path: "t9b"},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Álbum 1", "album 2", "Album 10"}, albumTitles(album))
+	require.Equal(t, []string{"Track 9", "track 09b", "Track 10"}, trackTitles(album))
+
+	album, err = l.Browse(nil, false, music.MustNewListing(music.SortKeyName, music.SortDescending, music.Cursor{}, 0))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Album 10", "album 2", "Álbum 1"}, albumTitles(album))
+	require.Equal(t, []string{"Track 10", "track 09b", "Track 9"}, trackTitles(album))
+}
+
+func TestBrowseSortsByYear(t *testing.T) {
+	ts := metadataTrackStore{
+		metadata: map[string]store.Metadata{
+			"a1t": {Year: 1999},
+			"a2t": {Year: 1969},
+			"t1":  {Year: 2005},
+			"t2":  {Year: 1980},
+		},
+	}
+	l, ch, events := newListingLibrary(t, ts)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {Tracks: map[string]scanner.Track{"t": {Path: "a1t"}}},
+				"a2": {Tracks: map[string]scanner.Track{"t": {Path: "a2t"}}},
+				"a3": {Tracks: map[string]scanner.Track{"t": {Path: "a3t"}}},
+			},
+			Tracks: map[string]scanner.Track{
+				"t1": {Path: "t1"},
+				"t2": {Path: "t2"},
+				"t3": {Path: "t3"},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.MustNewListing(music.SortKeyYear, music.SortAscending, music.Cursor{}, 0))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a2", "a1", "a3"}, albumTitles(album))
+	require.Equal(t, []string{"t2", "t1", "t3"}, trackTitles(album))
+
+	album, err = l.Browse(nil, false, music.MustNewListing(music.SortKeyYear, music.SortDescending, music.Cursor{}, 0))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a1", "a2", "a3"}, albumTitles(album))
+	require.Equal(t, []string{"t1", "t2", "t3"}, trackTitles(album))
+}
+
+func TestBrowseSortsByPlays(t *testing.T) {
+	l, ch, events := newListingLibrary(t, mockTrackStore{})
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {Tracks: map[string]scanner.Track{"t": {Path: "a1t"}}},
+				"a2": {Tracks: map[string]scanner.Track{"t": {Path: "a2t"}}},
+			},
+			Tracks: map[string]scanner.Track{
+				"t1": {Path: "t1"},
+				"t2": {Path: "t2"},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	require.NoError(t, l.RecordPlay("a2t"))
+	require.NoError(t, l.RecordPlay("t2"))
+	require.NoError(t, l.RecordPlay("t2"))
+	require.ErrorIs(t, l.RecordPlay("unknown"), music.ErrNotFound)
+
+	album, err := l.Browse(nil, false, music.MustNewListing(music.SortKeyPlays, music.SortDescending, music.Cursor{}, 0))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a2", "a1"}, albumTitles(album))
+	require.Equal(t, []string{"t2", "t1"}, trackTitles(album))
+}
+
+func TestBrowseSortsNestedAlbumsAfterUpdates(t *testing.T) {
+	ts := &probingTrackStore{
+		metadata: map[string]store.Metadata{
+			"a1t": {Year: 1999},
+			"a1u": {Year: 1950},
+		},
+	}
+	l, ch, events := newListingLibrary(t, ts)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {Tracks: map[string]scanner.Track{"t": {Path: "a1t"}}},
+				"a2": {
+					Albums: map[string]*scanner.Album{
+						"CD1": {Tracks: map[string]scanner.Track{"t": {Path: "a2t"}}},
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	byYear := music.MustNewListing(music.SortKeyYear, music.SortAscending, music.Cursor{}, 0)
+	byPlays := music.MustNewListing(music.SortKeyPlays, music.SortDescending, music.Cursor{}, 0)
+
+	album, err := l.Browse(nil, false, byYear)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a1", "a2"}, albumTitles(album))
+
+	ts.probe("a2t", store.Metadata{Year: 1969})
+
+	require.Eventually(t, func() bool {
+		album, err := l.Browse(nil, false, byYear)
+		require.NoError(t, err)
+		return album.Albums[0].Title == "a2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, l.RecordPlay("a1t"))
+
+	album, err = l.Browse(nil, false, byPlays)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a1", "a2"}, albumTitles(album))
+
+	require.NoError(t, l.RecordPlay("a2t"))
+	require.NoError(t, l.RecordPlay("a2t"))
+
+	album, err = l.Browse(nil, false, byPlays)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a2", "a1"}, albumTitles(album))
+
+	ch <- scanner.Update{
+		Path: []string{"a1"},
+		Album: &scanner.Album{
+			Tracks: map[string]scanner.Track{
+				"t": {Path: "a1t"},
+				"u": {Path: "a1u"},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err = l.Browse(nil, false, byYear)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a1", "a2"}, albumTitles(album))
+
+	album, err = l.Browse(nil, false, byPlays)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a2", "a1"}, albumTitles(album))
+}
+
+func TestBrowseSortsByAddedTime(t *testing.T) {
+	l, ch, events := newListingLibrary(t, mockTrackStore{})
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"b": {Tracks: map[string]scanner.Track{"t": {Path: "bt"}}},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a": {Tracks: map[string]scanner.Track{"t": {Path: "at"}}},
+				"b": {Tracks: map[string]scanner.Track{"t": {Path: "bt"}}},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.MustNewListing(music.SortKeyAdded, music.SortDescending, music.Cursor{}, 0))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, albumTitles(album))
+
+	album, err = l.Browse(nil, false, music.MustNewListing(music.SortKeyAdded, music.SortAscending, music.Cursor{}, 0))
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "a"}, albumTitles(album))
+}
+
+func TestBrowseSortsByModificationTime(t *testing.T) {
+	dir := t.TempDir()
+
+	newFile := func(name string, modTime time.Time) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, nil, 0600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+		return path
+	}
+
+	now := time.Now()
+	old := newFile("old", now.Add(-time.Hour))
+	recent := newFile("recent", now)
+
+	l, ch, events := newListingLibrary(t, mockTrackStore{})
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a": {Tracks: map[string]scanner.Track{"t": {Path: old}}},
+				"b": {Tracks: map[string]scanner.Track{"t": {Path: recent}}},
+				"c": {Tracks: map[string]scanner.Track{"t": {Path: filepath.Join(dir, "missing")}}},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.MustNewListing(music.SortKeyModified, music.SortDescending, music.Cursor{}, 0))
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "a", "c"}, albumTitles(album))
+}
+
+func TestBrowsePaginatesUsingCursors(t *testing.T) {
+	l, ch, events := newListingLibrary(t, mockTrackStore{})
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {Tracks: map[string]scanner.Track{"t": {Path: "a1t"}}},
+				"a2": {Tracks: map[string]scanner.Track{"t": {Path: "a2t"}}},
+				"a3": {Tracks: map[string]scanner.Track{"t": {Path: "a3t"}}},
+			},
+			Tracks: map[string]scanner.Track{
+				"t1": {Path: "t1"},
+				"t2": {Path: "t2"},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	var albums, tracks []string
+	var cursor music.Cursor
+	pages := 0
+	for {
+		album, err := l.Browse(nil, false, music.MustNewListing(music.SortKeyDefault, music.SortAscending, cursor, 2))
+		require.NoError(t, err)
+		require.Equal(t, 3, album.TotalAlbums)
+		require.Equal(t, 2, album.TotalTracks)
+
+		albums = append(albums, albumTitles(album)...)
+		tracks = append(tracks, trackTitles(album)...)
+		pages++
+
+		if album.NextCursor == "" {
+			break
+		}
+
+		cursor, err = music.NewCursor(album.NextCursor)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 3, pages)
+	require.Equal(t, []string{"a1", "a2", "a3"}, albums)
+	require.Equal(t, []string{"t1", "t2"}, tracks)
+}
+
+func TestBrowseCursorSurvivesRemovedItems(t *testing.T) {
+	l, ch, events := newListingLibrary(t, mockTrackStore{})
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a1": {Tracks: map[string]scanner.Track{"t": {Path: "a1t"}}},
+				"a2": {Tracks: map[string]scanner.Track{"t": {Path: "a2t"}}},
+				"a3": {Tracks: map[string]scanner.Track{"t": {Path: "a3t"}}},
+				"a4": {Tracks: map[string]scanner.Track{"t": {Path: "a4t"}}},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err := l.Browse(nil, false, music.MustNewListing(music.SortKeyDefault, music.SortAscending, music.Cursor{}, 2))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a1", "a2"}, albumTitles(album))
+
+	cursor, err := music.NewCursor(album.NextCursor)
+	require.NoError(t, err)
+
+	// the first album was removed so the cursor still points to a2
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"a2": {Tracks: map[string]scanner.Track{"t": {Path: "a2t"}}},
+				"a3": {Tracks: map[string]scanner.Track{"t": {Path: "a3t"}}},
+				"a4": {Tracks: map[string]scanner.Track{"t": {Path: "a4t"}}},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	album, err = l.Browse(nil, false, music.MustNewListing(music.SortKeyDefault, music.SortAscending, cursor, 2))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a3", "a4"}, albumTitles(album))
+	require.Empty(t, album.NextCursor)
+}
+
+func newListingLibrary(t *testing.T, trackStore library.TrackStore) (*library.Library, chan scanner.Update, *mockEventPublisher) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	l, err := library.New(newRoots(mockScanner{ch}), trackStore, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+	return l, ch, events
+}
+
+func albumTitles(album music.Album) []string {
+	var titles []string
+	for _, a := range album.Albums {
+		titles = append(titles, a.Title)
+	}
+	return titles
+}
+
+func trackTitles(album music.Album) []string {
+	var titles []string
+	for _, t := range album.Tracks {
+		titles = append(titles, t.Title)
+	}
+	return titles
+}
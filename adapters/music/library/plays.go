@@ -0,0 +1,97 @@
+package library
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// playCounts holds the number of times each track was played. The counts are
+// loaded once and then kept in memory as they are needed to sort the tracks.
+type playCounts struct {
+	counts     map[music.FileId]int
+	repository PlayCountRepository
+	mutex      sync.Mutex
+}
+
+func newPlayCounts(repository PlayCountRepository) (*playCounts, error) {
+	counts, err := repository.Load()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load the play counts")
+	}
+
+	return &playCounts{
+		counts:     counts,
+		repository: repository,
+	}, nil
+}
+
+func (p *playCounts) get(id music.FileId) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.counts[id]
+}
+
+func (p *playCounts) increment(id music.FileId) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.repository.Save(id, p.counts[id]+1); err != nil {
+		return errors.Wrap(err, "could not save the play count")
+	}
+
+	p.counts[id]++
+	return nil
+}
+
+type BoltPlayCountRepository struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+func NewBoltPlayCountRepository(db *bolt.DB) (*BoltPlayCountRepository, error) {
+	bucket := []byte("plays")
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "could not create a bucket")
+	}
+
+	return &BoltPlayCountRepository{
+		db:     db,
+		bucket: bucket,
+	}, nil
+}
+
+// Load returns the play counts of all tracks keyed by their file ids.
+func (r *BoltPlayCountRepository) Load() (map[music.FileId]int, error) {
+	counts := make(map[music.FileId]int)
+
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(r.bucket).ForEach(func(k, v []byte) error {
+			count, err := strconv.Atoi(string(v))
+			if err != nil {
+				return errors.Wrap(err, "invalid play count")
+			}
+			counts[music.FileId(k)] = count
+			return nil
+		})
+	}); err != nil {
+		return nil, errors.Wrap(err, "transaction failed")
+	}
+
+	return counts, nil
+}
+
+// Save overwrites the play count of the specified track.
+func (r *BoltPlayCountRepository) Save(id music.FileId, count int) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(r.bucket).Put([]byte(id), []byte(strconv.Itoa(count)))
+	})
+}
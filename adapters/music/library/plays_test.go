@@ -0,0 +1,30 @@
+package library_test
+
+import (
+	"testing"
+
+	"github.com/boreq/eggplant/adapters/music/library"
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/eggplant/internal/fixture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltPlayCountRepository(t *testing.T) {
+	db, cleanup := fixture.Bolt(t)
+	defer cleanup()
+
+	r, err := library.NewBoltPlayCountRepository(db)
+	require.NoError(t, err)
+
+	counts, err := r.Load()
+	require.NoError(t, err)
+	require.Empty(t, counts)
+
+	require.NoError(t, r.Save("a", 1))
+	require.NoError(t, r.Save("b", 3))
+	require.NoError(t, r.Save("a", 2))
+
+	counts, err = r.Load()
+	require.NoError(t, err)
+	require.Equal(t, map[music.FileId]int{"a": 2, "b": 3}, counts)
+}
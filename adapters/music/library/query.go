@@ -0,0 +1,341 @@
+package library
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boreq/eggplant/adapters/music/store"
+	"github.com/boreq/eggplant/application/music"
+)
+
+// evaluateQuery returns the documents matching the query sorted by their
+// relevance. The words which aren't quoted, negated or limited to a field are
+// found using the index and may contain typos. The other terms filter the
+// found documents and have to match exactly or as a prefix. If the query
+// contains only the filters then the documents which can match them are found
+// using the index if possible.
+func (l *Library) evaluateQuery(index *searchIndex, query music.Query) []searchHit {
+	var words []string
+	var filters []music.QueryTerm
+	for _, term := range query.Terms() {
+		if term.Field == music.QueryFieldAny && !term.Phrase && !term.Negated {
+			words = append(words, term.Text)
+		} else {
+			filters = append(filters, term)
+		}
+	}
+
+	var hits []searchHit
+	if len(words) > 0 {
+		hits = index.search(strings.Join(words, " "))
+	} else if candidates, ok := index.filterCandidates(filters); ok {
+		hits = candidates
+	} else {
+		hits = index.all()
+	}
+
+	if len(filters) == 0 {
+		return hits
+	}
+
+	var result []searchHit
+	for _, hit := range hits {
+		if index.documents[hit.document].values.matchesAll(filters) {
+			result = append(result, hit)
+		}
+	}
+	return result
+}
+
+// filterCandidates returns the documents which contain the terms of the
+// filters that can be looked up in the index sorted by their titles. The
+// documents still have to be matched against the filters. False is returned
+// if none of the filters can be looked up in the index.
+func (index *searchIndex) filterCandidates(filters []music.QueryTerm) ([]searchHit, bool) {
+	var candidates map[int]struct{}
+	for _, term := range filters {
+		if term.Negated {
+			continue
+		}
+
+		documents, ok := index.termCandidates(term)
+		if !ok {
+			continue
+		}
+
+		if candidates == nil {
+			candidates = documents
+			continue
+		}
+
+		for document := range candidates {
+			if _, ok := documents[document]; !ok {
+				delete(candidates, document)
+			}
+		}
+	}
+
+	if candidates == nil {
+		return nil, false
+	}
+
+	hits := make([]searchHit, 0, len(candidates))
+	for document := range candidates {
+		if !index.isRemoved(document) {
+			hits = append(hits, searchHit{document: document})
+		}
+	}
+	index.sortHits(hits)
+	return hits, true
+}
+
+// termCandidates returns the documents which contain the first word of the
+// term. The titles, artists and albums are looked up in the postings and the
+// genres, formats and years in the field index.
+func (index *searchIndex) termCandidates(term music.QueryTerm) (map[int]struct{}, bool) {
+	documents := make(map[int]struct{})
+
+	if term.Field == music.QueryFieldYear {
+		for key, keyDocuments := range index.fields {
+			value, ok := fieldKeyValue(music.QueryFieldYear, key)
+			if !ok {
+				continue
+			}
+
+			year, err := strconv.Atoi(value)
+			if err != nil || !matchesComparisons(float64(year), term.Comparisons) {
+				continue
+			}
+
+			addDocuments(documents, keyDocuments)
+		}
+		return documents, true
+	}
+
+	if term.Field.IsNumeric() {
+		return nil, false
+	}
+
+	words := tokenize(term.Text)
+	if len(words) == 0 {
+		return documents, true
+	}
+
+	// the last word of the text may be a prefix
+	prefix := !term.Phrase && len(words) == 1
+
+	switch term.Field {
+	case music.QueryFieldAny, music.QueryFieldTitle, music.QueryFieldArtist, music.QueryFieldAlbum:
+		for i := sort.SearchStrings(index.terms, words[0]); i < len(index.terms); i++ {
+			t := index.terms[i]
+			if t != words[0] && !(prefix && strings.HasPrefix(t, words[0])) {
+				break
+			}
+
+			for _, posting := range index.postings[t] {
+				documents[posting.document] = struct{}{}
+			}
+		}
+		return documents, true
+	case music.QueryFieldGenre, music.QueryFieldFormat:
+		for key, keyDocuments := range index.fields {
+			value, ok := fieldKeyValue(term.Field, key)
+			if ok && (value == words[0] || prefix && strings.HasPrefix(value, words[0])) {
+				addDocuments(documents, keyDocuments)
+			}
+		}
+		return documents, true
+	default:
+		return nil, false
+	}
+}
+
+func addDocuments(documents map[int]struct{}, add []int) {
+	for _, document := range add {
+		documents[document] = struct{}{}
+	}
+}
+
+// fieldKey returns the key of the value of the field in the field index.
+func fieldKey(field music.QueryField, value string) string {
+	return string(field) + ":" + value
+}
+
+// fieldKeyValue returns the value of the field stored under the key in the
+// field index. False is returned if the key belongs to a different field.
+func fieldKeyValue(field music.QueryField, key string) (string, bool) {
+	prefix := string(field) + ":"
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(key, prefix), true
+}
+
+// documentValues holds the tokenized values of the fields of a document which
+// can be used in a query. They are created when the document is indexed. The
+// values of the numeric fields are zero if they aren't known.
+type documentValues struct {
+	title  [][]string
+	artist [][]string
+	album  [][]string
+	genre  [][]string
+	path   [][]string
+	format [][]string
+
+	year     float64
+	duration float64
+}
+
+// newDocumentValues returns the values of the fields of the document. The
+// albums have only a title, an album and an artist which is the title of the
+// parent album as the albums are usually stored in the directories named
+// after the artists.
+func newDocumentValues(document searchDocument, metadata store.Metadata) documentValues {
+	var values documentValues
+
+	if !document.isTrack() {
+		values.title = addValue(values.title, document.album.title)
+		values.album = addValue(values.album, document.album.title)
+		values.artist = addValue(values.artist, document.parentTitle)
+		return values
+	}
+
+	t := document.track
+
+	values.title = addValue(values.title, t.title)
+	if t.cue == nil {
+		values.title = addValue(values.title, metadata.Title)
+	}
+	values.artist = addValue(values.artist, metadata.Artist)
+	values.artist = addValue(values.artist, metadata.AlbumArtist)
+	if t.cue != nil {
+		values.artist = addValue(values.artist, t.cue.Performer)
+	}
+	values.album = addValue(values.album, metadata.Album)
+	if len(document.path) > 0 {
+		values.album = addValue(values.album, document.album.title)
+	}
+	values.genre = addValue(values.genre, metadata.Genre)
+	values.path = addValue(values.path, t.path)
+	values.format = addValue(values.format, strings.TrimPrefix(filepath.Ext(t.path), "."))
+
+	values.year = float64(metadata.Year)
+	values.duration = metadata.Duration.Seconds()
+
+	return values
+}
+
+func addValue(values [][]string, value string) [][]string {
+	if value == "" {
+		return values
+	}
+	return append(values, tokenize(value))
+}
+
+func (v documentValues) text(field music.QueryField) [][]string {
+	switch field {
+	case music.QueryFieldTitle:
+		return v.title
+	case music.QueryFieldArtist:
+		return v.artist
+	case music.QueryFieldAlbum:
+		return v.album
+	case music.QueryFieldGenre:
+		return v.genre
+	case music.QueryFieldPath:
+		return v.path
+	case music.QueryFieldFormat:
+		return v.format
+	default:
+		return nil
+	}
+}
+
+func (v documentValues) number(field music.QueryField) (float64, bool) {
+	switch field {
+	case music.QueryFieldYear:
+		return v.year, v.year != 0
+	case music.QueryFieldDuration:
+		return v.duration, v.duration != 0
+	default:
+		return 0, false
+	}
+}
+
+func (v documentValues) matchesAll(terms []music.QueryTerm) bool {
+	for _, term := range terms {
+		if v.matches(term) == term.Negated {
+			return false
+		}
+	}
+	return true
+}
+
+func (v documentValues) matches(term music.QueryTerm) bool {
+	if term.Field.IsNumeric() {
+		value, ok := v.number(term.Field)
+		if !ok {
+			return false
+		}
+		return matchesComparisons(value, term.Comparisons)
+	}
+
+	fields := []music.QueryField{term.Field}
+	if term.Field == music.QueryFieldAny {
+		fields = []music.QueryField{
+			music.QueryFieldTitle,
+			music.QueryFieldArtist,
+			music.QueryFieldAlbum,
+		}
+	}
+
+	words := tokenize(term.Text)
+	for _, field := range fields {
+		for _, terms := range v.text(field) {
+			if containsWords(terms, words, !term.Phrase) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesComparisons(value float64, comparisons []music.QueryComparison) bool {
+	for _, comparison := range comparisons {
+		if !comparison.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsWords returns true if the terms contain the words in the same
+// order next to each other. If prefix is set then the last word may also be
+// a prefix of a term.
+func containsWords(terms, words []string, prefix bool) bool {
+	if len(words) == 0 {
+		return false
+	}
+
+	for i := 0; i+len(words) <= len(terms); i++ {
+		if matchWordsAt(terms[i:], words, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchWordsAt(terms, words []string, prefix bool) bool {
+	for j, word := range words {
+		if terms[j] == word {
+			continue
+		}
+		if prefix && j == len(words)-1 && strings.HasPrefix(terms[j], word) {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package library
+
+import (
+	"sort"
+	"time"
+
+	"github.com/boreq/eggplant/application/music"
+)
+
+// recentAlbum is an album which contains tracks. The albums are listed in the
+// order in which they were added to the library.
+type recentAlbum struct {
+	path  []music.AlbumId
+	album *album
+}
+
+// newRecentAlbums returns the albums which contain tracks starting with the
+// most recently added ones. The time at which an album was added is the time
+// at which the first of its tracks was seen.
+func newRecentAlbums(root *album) []recentAlbum {
+	var albums []recentAlbum
+	collectRecentAlbums(&albums, nil, root)
+	sortRecentAlbums(albums)
+	return albums
+}
+
+// updateRecentAlbums replaces the albums which changed while updating the
+// tree without sorting all albums again.
+func updateRecentAlbums(previous []recentAlbum, before, after []changedAlbum) []recentAlbum {
+	removed := make(map[*album]struct{}, len(before))
+	for _, changed := range before {
+		removed[changed.album] = struct{}{}
+	}
+
+	var added []recentAlbum
+	for _, changed := range after {
+		if len(changed.path) > 0 && len(changed.album.tracks) > 0 {
+			added = append(added, recentAlbum{
+				path:  changed.path,
+				album: changed.album,
+			})
+		}
+	}
+	sortRecentAlbums(added)
+
+	albums := make([]recentAlbum, 0, len(previous)+len(added))
+	for _, v := range previous {
+		if _, ok := removed[v.album]; ok {
+			continue
+		}
+
+		for len(added) > 0 && lessRecentAlbum(added[0], v) {
+			albums = append(albums, added[0])
+			added = added[1:]
+		}
+		albums = append(albums, v)
+	}
+	return append(albums, added...)
+}
+
+func sortRecentAlbums(albums []recentAlbum) {
+	sort.SliceStable(albums, func(i, j int) bool {
+		return lessRecentAlbum(albums[i], albums[j])
+	})
+}
+
+func lessRecentAlbum(a, b recentAlbum) bool {
+	if c := compareSortValues(knownValue(unixNano(a.album.added)), knownValue(unixNano(b.album.added)), true); c != 0 {
+		return c < 0
+	}
+	if c := compareNatural(a.album.title, b.album.title); c != 0 {
+		return c < 0
+	}
+	return joinIds(a.path) < joinIds(b.path)
+}
+
+func collectRecentAlbums(albums *[]recentAlbum, path []music.AlbumId, a *album) {
+	if len(path) > 0 && len(a.tracks) > 0 {
+		*albums = append(*albums, recentAlbum{
+			path:  path,
+			album: a,
+		})
+	}
+
+	for id, child := range a.albums {
+		childPath := make([]music.AlbumId, len(path), len(path)+1)
+		copy(childPath, path)
+		collectRecentAlbums(albums, append(childPath, id), child)
+	}
+}
+
+func joinIds(ids []music.AlbumId) string {
+	var s string
+	for _, id := range ids {
+		s += "/" + id.String()
+	}
+	return s
+}
+
+// RecentlyAdded lists the albums which contain tracks starting with the most
+// recently added ones. The albums added after the specified time are marked
+// as new unless the time is zero.
+func (l *Library) RecentlyAdded(publicOnly bool, since time.Time, page music.Page) (music.RecentAlbums, error) {
+	t := l.getTree()
+
+	var albums []recentAlbum
+	for _, v := range t.recent {
+		if canAccess(v.album.resolvedAccess, publicOnly) {
+			albums = append(albums, v)
+		}
+	}
+
+	result := music.RecentAlbums{
+		Total: len(albums),
+	}
+
+	for _, v := range albums {
+		if isAddedSince(v.album, since) {
+			result.TotalNew++
+		}
+	}
+
+	start, end := pageBounds(len(albums), page)
+	for _, v := range albums[start:end] {
+		result.Albums = append(result.Albums, music.RecentAlbum{
+			Album: l.newBasicAlbum(v.path, *v.album),
+			Added: v.album.added,
+			New:   isAddedSince(v.album, since),
+		})
+	}
+
+	return result, nil
+}
+
+func isAddedSince(a *album, since time.Time) bool {
+	return !since.IsZero() && a.added.After(since)
+}
@@ -0,0 +1,89 @@
+package library_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/scanner"
+	"github.com/boreq/eggplant/application/music"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecentlyAdded(t *testing.T) {
+	l, ch, events := newListingLibrary(t, mockTrackStore{})
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"b": {Tracks: map[string]scanner.Track{"t": {Path: "bt"}}},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"artist": {
+					Albums: map[string]*scanner.Album{
+						"a": {Tracks: map[string]scanner.Track{"t": {Path: "at"}}},
+					},
+				},
+				"b": {Tracks: map[string]scanner.Track{"t": {Path: "bt"}}},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	since := time.Now()
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"artist": {
+					Albums: map[string]*scanner.Album{
+						"a": {Tracks: map[string]scanner.Track{"t": {Path: "at"}}},
+						"c": {Tracks: map[string]scanner.Track{"t": {Path: "ct"}}},
+					},
+				},
+				"b": {
+					Tracks: map[string]scanner.Track{
+						"t":  {Path: "bt"},
+						"t2": {Path: "bt2"},
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	result, err := l.RecentlyAdded(false, since, music.MustNewPage(0, 10))
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Total)
+	require.Equal(t, 1, result.TotalNew)
+	require.Equal(t, []string{"c", "a", "b"}, recentAlbumTitles(result))
+	require.Equal(t, []music.AlbumId{"artist", "c"}, result.Albums[0].Album.Path)
+	require.True(t, result.Albums[0].New)
+	require.False(t, result.Albums[1].New)
+	require.False(t, result.Albums[2].New)
+	require.True(t, result.Albums[0].Added.After(since))
+
+	result, err = l.RecentlyAdded(false, time.Time{}, music.MustNewPage(1, 1))
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Total)
+	require.Equal(t, 0, result.TotalNew)
+	require.Equal(t, []string{"a"}, recentAlbumTitles(result))
+
+	result, err = l.RecentlyAdded(true, since, music.MustNewPage(0, 10))
+	require.NoError(t, err)
+	require.Equal(t, 0, result.Total)
+	require.Empty(t, result.Albums)
+}
+
+func recentAlbumTitles(result music.RecentAlbums) []string {
+	var titles []string
+	for _, album := range result.Albums {
+		titles = append(titles, album.Album.Title)
+	}
+	return titles
+}
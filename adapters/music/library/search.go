@@ -0,0 +1,562 @@
+package library
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/boreq/eggplant/adapters/music/store"
+	"github.com/boreq/eggplant/application/music"
+)
+
+type searchField int
+
+const (
+	searchFieldTitle searchField = iota
+	searchFieldArtist
+	searchFieldAlbum
+)
+
+// searchFieldWeights make the matches in the titles more relevant than the
+// matches in the names of the artists or the titles of the albums which
+// contain the tracks.
+var searchFieldWeights = map[searchField]float64{
+	searchFieldTitle:  3,
+	searchFieldArtist: 2,
+	searchFieldAlbum:  1,
+}
+
+const (
+	searchExactMatch  = 1
+	searchPrefixMatch = 0.75
+	searchFuzzyMatch  = 0.5
+
+	// searchExactTitleBonus is added to the score of the documents which
+	// titles are equal to the query.
+	searchExactTitleBonus = 5
+)
+
+type searchDocument struct {
+	title string // joined terms of the title
+
+	// path points to the album itself or to the album which contains the
+	// track.
+	path  []music.AlbumId
+	album *album
+
+	// parentTitle is the title of the parent of an album.
+	parentTitle string
+
+	// trackId is set if the document is a track.
+	trackId music.TrackId
+	track   track
+
+	// values are matched against the terms of the queries which are
+	// limited to fields.
+	values documentValues
+}
+
+func (d searchDocument) isTrack() bool {
+	return d.trackId != ""
+}
+
+type searchPosting struct {
+	document int
+	field    searchField
+}
+
+// searchIndex is an inverted index of the titles of the albums and tracks.
+// The index is a part of the tree so it is immutable. Updates of the tree
+// create a copy of the index in which the documents of the changed albums are
+// marked as removed and indexed again. The tags are indexed if they are known
+// when the albums are indexed so the albums are indexed again after their
+// tracks are probed.
+type searchIndex struct {
+	documents []searchDocument
+	postings  map[string][]searchPosting // key is a folded term
+	terms     []string                   // sorted keys of postings
+
+	// fields lists the documents by the folded terms of the genres and
+	// the formats and by the years so that the queries which contain only
+	// the filters don't have to look at every document. The keys are
+	// created using fieldKey.
+	fields map[string][]int
+
+	// albums lists the documents created for each album and its tracks.
+	albums map[*album][]int
+
+	// removed marks the documents which belong to the albums which are no
+	// longer a part of the tree.
+	removed map[int]struct{}
+}
+
+func (l *Library) newSearchIndex(root *album) *searchIndex {
+	index := &searchIndex{
+		postings: make(map[string][]searchPosting),
+		fields:   make(map[string][]int),
+		albums:   make(map[*album][]int),
+		removed:  make(map[int]struct{}),
+	}
+	l.indexAlbum(index, nil, root, "")
+
+	for term := range index.postings {
+		index.terms = append(index.terms, term)
+	}
+	sort.Strings(index.terms)
+
+	return index
+}
+
+// updateSearchIndex creates a copy of the index in which the albums that
+// changed are indexed again. The index is rebuilt once most of its documents
+// are removed.
+func (l *Library) updateSearchIndex(previous *searchIndex, root *album, before, after []changedAlbum) *searchIndex {
+	index := &searchIndex{
+		documents: make([]searchDocument, len(previous.documents)),
+		postings:  make(map[string][]searchPosting),
+		fields:    make(map[string][]int),
+		albums:    make(map[*album][]int, len(previous.albums)),
+		removed:   make(map[int]struct{}, len(previous.removed)),
+	}
+
+	copy(index.documents, previous.documents)
+	for a, documents := range previous.albums {
+		index.albums[a] = documents
+	}
+	for document := range previous.removed {
+		index.removed[document] = struct{}{}
+	}
+
+	for _, changed := range before {
+		for _, document := range index.albums[changed.album] {
+			index.removed[document] = struct{}{}
+		}
+		delete(index.albums, changed.album)
+	}
+
+	if len(index.removed) > len(index.documents)/2 {
+		return l.newSearchIndex(root)
+	}
+
+	for _, changed := range after {
+		l.indexAlbumDocuments(index, changed.path, changed.album, changed.parentTitle)
+	}
+
+	var newTerms []string
+	for term, postings := range index.postings {
+		previousPostings, ok := previous.postings[term]
+		if !ok {
+			newTerms = append(newTerms, term)
+		}
+		index.postings[term] = append(previousPostings[:len(previousPostings):len(previousPostings)], postings...)
+	}
+	for term, postings := range previous.postings {
+		if _, ok := index.postings[term]; !ok {
+			index.postings[term] = postings
+		}
+	}
+
+	sort.Strings(newTerms)
+	index.terms = mergeSortedStrings(previous.terms, newTerms)
+
+	for key, documents := range index.fields {
+		previousDocuments := previous.fields[key]
+		index.fields[key] = append(previousDocuments[:len(previousDocuments):len(previousDocuments)], documents...)
+	}
+	for key, documents := range previous.fields {
+		if _, ok := index.fields[key]; !ok {
+			index.fields[key] = documents
+		}
+	}
+
+	return index
+}
+
+func mergeSortedStrings(a, b []string) []string {
+	result := make([]string, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0] < b[0] {
+			result = append(result, a[0])
+			a = a[1:]
+		} else {
+			result = append(result, b[0])
+			b = b[1:]
+		}
+	}
+	result = append(result, a...)
+	return append(result, b...)
+}
+
+// indexAlbum indexes the album and its children. The albums are also indexed
+// using the titles of their parents as the albums are usually stored in the
+// directories named after the artists.
+func (l *Library) indexAlbum(index *searchIndex, path []music.AlbumId, a *album, parentTitle string) {
+	l.indexAlbumDocuments(index, path, a, parentTitle)
+
+	for id, child := range a.albums {
+		childPath := make([]music.AlbumId, len(path), len(path)+1)
+		copy(childPath, path)
+		l.indexAlbum(index, append(childPath, id), child, titleIfNotRoot(path, a))
+	}
+}
+
+// indexAlbumDocuments indexes the album and its tracks without looking at its
+// children.
+func (l *Library) indexAlbumDocuments(index *searchIndex, path []music.AlbumId, a *album, parentTitle string) {
+	if len(path) > 0 {
+		document := index.add(searchDocument{
+			title:       joinTerms(a.title),
+			path:        path,
+			album:       a,
+			parentTitle: parentTitle,
+		}, store.Metadata{})
+		index.addField(document, searchFieldTitle, a.title)
+		index.addField(document, searchFieldAlbum, parentTitle)
+	}
+
+	for id, t := range a.tracks {
+		metadata, _ := l.trackStore.GetMetadata(t.fileId.String())
+
+		document := index.add(searchDocument{
+			title:   joinTerms(t.title),
+			path:    path,
+			album:   a,
+			trackId: id,
+			track:   t,
+		}, metadata)
+		index.addField(document, searchFieldTitle, t.title)
+		if t.cue == nil {
+			index.addField(document, searchFieldTitle, metadata.Title)
+		}
+		index.addField(document, searchFieldArtist, metadata.Artist)
+		index.addField(document, searchFieldArtist, metadata.AlbumArtist)
+		if t.cue != nil {
+			index.addField(document, searchFieldArtist, t.cue.Performer)
+		}
+		index.addField(document, searchFieldAlbum, metadata.Album)
+		if len(path) > 0 {
+			index.addField(document, searchFieldAlbum, a.title)
+		}
+	}
+}
+
+func titleIfNotRoot(path []music.AlbumId, a *album) string {
+	if len(path) == 0 {
+		return ""
+	}
+	return a.title
+}
+
+// add adds the document to the index. The values of the fields of the
+// document which can be used in the queries are created using the metadata.
+func (index *searchIndex) add(document searchDocument, metadata store.Metadata) int {
+	document.values = newDocumentValues(document, metadata)
+
+	index.documents = append(index.documents, document)
+	id := len(index.documents) - 1
+	index.albums[document.album] = append(index.albums[document.album], id)
+
+	for _, terms := range document.values.genre {
+		index.addFieldTerms(id, music.QueryFieldGenre, terms)
+	}
+	for _, terms := range document.values.format {
+		index.addFieldTerms(id, music.QueryFieldFormat, terms)
+	}
+	if document.values.year != 0 {
+		index.addFieldTerms(id, music.QueryFieldYear, []string{strconv.Itoa(int(document.values.year))})
+	}
+
+	return id
+}
+
+func (index *searchIndex) addFieldTerms(document int, field music.QueryField, terms []string) {
+	for _, term := range terms {
+		key := fieldKey(field, term)
+		documents := index.fields[key]
+		if len(documents) > 0 && documents[len(documents)-1] == document {
+			continue
+		}
+		index.fields[key] = append(documents, document)
+	}
+}
+
+func (index *searchIndex) addField(document int, field searchField, s string) {
+	for _, term := range tokenize(s) {
+		postings := index.postings[term]
+		posting := searchPosting{document: document, field: field}
+		if len(postings) > 0 && postings[len(postings)-1] == posting {
+			continue
+		}
+		index.postings[term] = append(postings, posting)
+	}
+}
+
+type searchHit struct {
+	document int
+	score    float64
+}
+
+// search returns the matching documents sorted by their relevance. Each
+// token of the query has to match one of the terms of the document exactly,
+// as a prefix or with a typo.
+func (index *searchIndex) search(query string) []searchHit {
+	tokens := tokenize(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var scores map[int]float64
+	for _, token := range tokens {
+		tokenScores := index.matchToken(token)
+
+		if scores == nil {
+			scores = tokenScores
+			continue
+		}
+
+		for document := range scores {
+			score, ok := tokenScores[document]
+			if !ok {
+				delete(scores, document)
+				continue
+			}
+			scores[document] += score
+		}
+	}
+
+	foldedQuery := strings.Join(tokens, " ")
+
+	var hits []searchHit
+	for document, score := range scores {
+		if index.documents[document].title == foldedQuery {
+			score += searchExactTitleBonus
+		}
+		hits = append(hits, searchHit{document: document, score: score})
+	}
+
+	index.sortHits(hits)
+	return hits
+}
+
+// all returns all documents sorted by their titles.
+func (index *searchIndex) all() []searchHit {
+	hits := make([]searchHit, 0, len(index.documents)-len(index.removed))
+	for i := range index.documents {
+		if !index.isRemoved(i) {
+			hits = append(hits, searchHit{document: i})
+		}
+	}
+	index.sortHits(hits)
+	return hits
+}
+
+func (index *searchIndex) isRemoved(document int) bool {
+	_, ok := index.removed[document]
+	return ok
+}
+
+func (index *searchIndex) sortHits(hits []searchHit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+
+		a := index.documents[hits[i].document]
+		b := index.documents[hits[j].document]
+		if a.title != b.title {
+			return a.title < b.title
+		}
+		return hits[i].document < hits[j].document
+	})
+}
+
+// matchToken returns the best score of each document which contains a term
+// matching the token.
+func (index *searchIndex) matchToken(token string) map[int]float64 {
+	scores := make(map[int]float64)
+
+	add := func(term string, quality float64) {
+		for _, posting := range index.postings[term] {
+			if index.isRemoved(posting.document) {
+				continue
+			}
+			score := quality * searchFieldWeights[posting.field]
+			if score > scores[posting.document] {
+				scores[posting.document] = score
+			}
+		}
+	}
+
+	add(token, searchExactMatch)
+
+	for i := sort.SearchStrings(index.terms, token); i < len(index.terms); i++ {
+		term := index.terms[i]
+		if !strings.HasPrefix(term, token) {
+			break
+		}
+		if term != token {
+			add(term, searchPrefixMatch)
+		}
+	}
+
+	if maxDistance := maxTypos(token); maxDistance > 0 {
+		for _, term := range index.terms {
+			if term == token || strings.HasPrefix(term, token) {
+				continue
+			}
+
+			if editDistance(token, term, maxDistance) <= maxDistance {
+				add(term, searchFuzzyMatch)
+			}
+		}
+	}
+
+	return scores
+}
+
+// maxTypos returns the number of typos which are tolerated in a token. Short
+// tokens must match exactly as otherwise they would match too many terms.
+func maxTypos(token string) int {
+	n := len([]rune(token))
+	switch {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// editDistance returns the optimal string alignment distance between the
+// strings which is the number of insertions, deletions, substitutions and
+// transpositions of adjacent characters needed to turn one string into the
+// other. The computation is abandoned once it is known that the distance
+// exceeds the specified maximum.
+func editDistance(a, b string, max int) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	if abs(len(ra)-len(rb)) > max {
+		return max + 1
+	}
+
+	prevPrev := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		rowMin := current[0]
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(prev[j]+1, current[j-1]+1, prev[j-1]+cost)
+
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				current[j] = min(current[j], prevPrev[j-2]+1)
+			}
+
+			if current[j] < rowMin {
+				rowMin = current[j]
+			}
+		}
+
+		if rowMin > max {
+			return max + 1
+		}
+
+		prevPrev, prev, current = prev, current, prevPrev
+	}
+
+	return prev[len(rb)]
+}
+
+func min(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// tokenize splits the string into folded terms. Apostrophes are removed so
+// that "don't" matches "dont".
+func tokenize(s string) []string {
+	s = strings.NewReplacer("'", "", "’", "").Replace(s)
+	return strings.FieldsFunc(foldString(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func joinTerms(s string) string {
+	return strings.Join(tokenize(s), " ")
+}
+
+// foldString converts the string to lower case and removes the diacritics
+// so that "Björk" matches "bjork".
+func foldString(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if folded, ok := foldings[r]; ok {
+			b.WriteString(folded)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+var foldings = newFoldings(map[string]string{
+	"a":  "àáâãäåāăąǎ",
+	"c":  "çćĉċč",
+	"d":  "ďđð",
+	"e":  "èéêëēĕėęě",
+	"g":  "ĝğġģ",
+	"h":  "ĥħ",
+	"i":  "ìíîïĩīĭįıǐ",
+	"j":  "ĵ",
+	"k":  "ķ",
+	"l":  "ĺļľŀł",
+	"n":  "ñńņňŉ",
+	"o":  "òóôõöøōŏőǒ",
+	"r":  "ŕŗř",
+	"s":  "śŝşšș",
+	"t":  "ţťŧț",
+	"u":  "ùúûüũūŭůűųǔ",
+	"w":  "ŵ",
+	"y":  "ýÿŷ",
+	"z":  "źżž",
+	"ae": "æ",
+	"oe": "œ",
+	"ss": "ß",
+	"th": "þ",
+})
+
+func newFoldings(m map[string]string) map[rune]string {
+	foldings := make(map[rune]string)
+	for folded, runes := range m {
+		for _, r := range runes {
+			foldings[r] = folded
+		}
+	}
+	return foldings
+}
@@ -0,0 +1,385 @@
+package library_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/library"
+	"github.com/boreq/eggplant/adapters/music/scanner"
+	"github.com/boreq/eggplant/adapters/music/store"
+	"github.com/boreq/eggplant/application/music"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchMatching(t *testing.T) {
+	l := newSearchLibrary(t)
+
+	testCases := []struct {
+		Name           string
+		Query          string
+		ExpectedAlbums []string
+		ExpectedTracks []string
+	}{
+		{
+			Name:           "case_insensitive",
+			Query:          "BEATLES",
+			ExpectedAlbums: []string{"The Beatles", "Abbey Road"},
+			ExpectedTracks: []string{"Come Together", "Something"},
+		},
+		{
+			Name:           "diacritics",
+			Query:          "bjork",
+			ExpectedAlbums: []string{"Björk", "Homogenic"},
+			ExpectedTracks: []string{"Jóga"},
+		},
+		{
+			Name:           "diacritics_in_query",
+			Query:          "jóga",
+			ExpectedTracks: []string{"Jóga"},
+		},
+		{
+			Name:           "prefix",
+			Query:          "toget",
+			ExpectedTracks: []string{"Come Together"},
+		},
+		{
+			Name:           "typo",
+			Query:          "beatels",
+			ExpectedAlbums: []string{"The Beatles", "Abbey Road"},
+			ExpectedTracks: []string{"Come Together", "Something"},
+		},
+		{
+			Name:           "all_tokens_must_match",
+			Query:          "beatles something",
+			ExpectedTracks: []string{"Something"},
+		},
+		{
+			Name:           "apostrophes",
+			Query:          "dont",
+			ExpectedTracks: []string{"Don't Let Me Down"},
+		},
+		{
+			Name:           "phrase",
+			Query:          `"come together"`,
+			ExpectedTracks: []string{"Come Together"},
+		},
+		{
+			Name:  "phrase_requires_adjacent_words",
+			Query: `"together come"`,
+		},
+		{
+			Name:           "negation",
+			Query:          "beatles -something",
+			ExpectedAlbums: []string{"The Beatles", "Abbey Road"},
+			ExpectedTracks: []string{"Come Together"},
+		},
+		{
+			Name:           "artist",
+			Query:          "artist:bjor",
+			ExpectedAlbums: []string{"Homogenic"},
+			ExpectedTracks: []string{"Jóga"},
+		},
+		{
+			Name:           "artist_phrase",
+			Query:          `artist:"the beatles"`,
+			ExpectedAlbums: []string{"Abbey Road"},
+			ExpectedTracks: []string{"Come Together", "Something"},
+		},
+		{
+			Name:           "album",
+			Query:          "album:abbey",
+			ExpectedAlbums: []string{"Abbey Road"},
+			ExpectedTracks: []string{"Come Together", "Something"},
+		},
+		{
+			Name:           "title",
+			Query:          "title:something",
+			ExpectedTracks: []string{"Something"},
+		},
+		{
+			Name:           "year_range",
+			Query:          "year:1990..1999",
+			ExpectedTracks: []string{"Jóga"},
+		},
+		{
+			Name:           "year",
+			Query:          "year:1969",
+			ExpectedTracks: []string{"Come Together", "Something"},
+		},
+		{
+			Name:           "genre",
+			Query:          "genre:electronic",
+			ExpectedTracks: []string{"Jóga"},
+		},
+		{
+			Name:           "genre_prefix",
+			Query:          "genre:elec",
+			ExpectedTracks: []string{"Jóga"},
+		},
+		{
+			Name:           "format_and_year",
+			Query:          "format:flac year:1969",
+			ExpectedTracks: []string{"Something"},
+		},
+		{
+			Name:           "filter_and_negation",
+			Query:          "artist:beatles -format:flac",
+			ExpectedAlbums: []string{"Abbey Road"},
+			ExpectedTracks: []string{"Come Together"},
+		},
+		{
+			Name:           "path",
+			Query:          "path:singles",
+			ExpectedTracks: []string{"Don't Let Me Down", "XY"},
+		},
+		{
+			Name:           "format",
+			Query:          "format:flac",
+			ExpectedTracks: []string{"Jóga", "Something"},
+		},
+		{
+			Name:           "duration",
+			Query:          "duration:>4:00",
+			ExpectedTracks: []string{"Come Together", "Jóga"},
+		},
+		{
+			Name:           "combined",
+			Query:          "beatles format:flac -genre:pop",
+			ExpectedTracks: []string{"Something"},
+		},
+		{
+			Name:           "negated_unknown_value",
+			Query:          "-year:1969 -artist:bjork",
+			ExpectedAlbums: []string{"Abbey Road", "Björk", "Singles", "The Beatles"},
+			ExpectedTracks: []string{"Don't Let Me Down", "XY"},
+		},
+		{
+			Name:  "no_results",
+			Query: "zeppelin",
+		},
+		{
+			Name:  "short_tokens_must_match_exactly",
+			Query: "xyz",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			result, err := l.Search(music.MustNewQuery(testCase.Query), false, music.MustNewPage(0, 10))
+			require.NoError(t, err)
+			require.Equal(t, testCase.ExpectedAlbums, searchResultAlbumTitles(result))
+			require.Equal(t, testCase.ExpectedTracks, searchResultTrackTitles(result))
+			require.Equal(t, len(testCase.ExpectedAlbums), result.TotalAlbums)
+			require.Equal(t, len(testCase.ExpectedTracks), result.TotalTracks)
+		})
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	l := newSearchLibrary(t)
+
+	result, err := l.Search(music.MustNewQuery("beatles"), false, music.MustNewPage(1, 1))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Abbey Road"}, searchResultAlbumTitles(result))
+	require.Equal(t, []string{"Something"}, searchResultTrackTitles(result))
+	require.Equal(t, 2, result.TotalAlbums)
+	require.Equal(t, 2, result.TotalTracks)
+
+	result, err = l.Search(music.MustNewQuery("beatles"), false, music.MustNewPage(5, 10))
+	require.NoError(t, err)
+	require.Empty(t, result.Albums)
+	require.Empty(t, result.Tracks)
+	require.Equal(t, 2, result.TotalAlbums)
+	require.Equal(t, 2, result.TotalTracks)
+}
+
+func TestSearchAfterPartialUpdates(t *testing.T) {
+	l, ch, events := newSearchLibraryWithScanner(t)
+
+	ch <- scanner.Update{
+		Path: []string{"The Beatles", "Let It Be"},
+		Album: &scanner.Album{
+			Tracks: map[string]scanner.Track{
+				"Get Back": {Path: "getback.flac"},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	ch <- scanner.Update{
+		Path: []string{"Björk", "Homogenic"},
+	}
+	events.waitForLibraryChanged(t)
+
+	result, err := l.Search(music.MustNewQuery("beatles"), false, music.MustNewPage(0, 10))
+	require.NoError(t, err)
+	require.Equal(t, []string{"The Beatles", "Abbey Road", "Let It Be"}, searchResultAlbumTitles(result))
+
+	result, err = l.Search(music.MustNewQuery("get back"), false, music.MustNewPage(0, 10))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Get Back"}, searchResultTrackTitles(result))
+	require.Equal(t, []music.AlbumId{"The Beatles", "Let It Be"}, result.Tracks[0].Album.Path)
+
+	result, err = l.Search(music.MustNewQuery("joga"), false, music.MustNewPage(0, 10))
+	require.NoError(t, err)
+	require.Empty(t, result.Albums)
+	require.Empty(t, result.Tracks)
+
+	result, err = l.Search(music.MustNewQuery("format:flac"), false, music.MustNewPage(0, 10))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Get Back", "Something"}, searchResultTrackTitles(result))
+
+	result, err = l.Search(music.MustNewQuery("year:1997"), false, music.MustNewPage(0, 10))
+	require.NoError(t, err)
+	require.Empty(t, result.Tracks)
+
+	recent, err := l.RecentlyAdded(false, time.Time{}, music.MustNewPage(0, 10))
+	require.NoError(t, err)
+	require.Equal(t, 3, recent.Total)
+	require.Equal(t, "Let It Be", recent.Albums[0].Album.Title)
+
+	album, err := l.Browse(nil, false, music.Listing{})
+	require.NoError(t, err)
+	require.Len(t, album.Albums, 2)
+}
+
+func TestSearchIncludesTagsOfProbedTracks(t *testing.T) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	ts := &probingTrackStore{
+		metadata: make(map[string]store.Metadata),
+	}
+
+	l, err := library.New(newRoots(mockScanner{ch}), ts, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"Homogenic": {
+					Tracks: map[string]scanner.Track{
+						"Jóga": {Path: "joga.flac"},
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	result, err := l.Search(music.MustNewQuery("bjork"), false, music.MustNewPage(0, 10))
+	require.NoError(t, err)
+	require.Empty(t, result.Tracks)
+
+	ts.probe("joga.flac", store.Metadata{Artist: "Björk"})
+
+	require.Eventually(t, func() bool {
+		result, err := l.Search(music.MustNewQuery("bjork"), false, music.MustNewPage(0, 10))
+		require.NoError(t, err)
+		return len(result.Tracks) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+type probingTrackStore struct {
+	mockTrackStore
+	metadata map[string]store.Metadata
+	handlers []func(id string)
+	mutex    sync.Mutex
+}
+
+func (s *probingTrackStore) GetMetadata(id string) (store.Metadata, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	metadata, ok := s.metadata[id]
+	return metadata, ok
+}
+
+func (s *probingTrackStore) OnProbed(handler func(id string)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.handlers = append(s.handlers, handler)
+}
+
+func (s *probingTrackStore) probe(id string, metadata store.Metadata) {
+	s.mutex.Lock()
+	s.metadata[id] = metadata
+	handlers := s.handlers
+	s.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(id)
+	}
+}
+
+func newSearchLibrary(t *testing.T) *library.Library {
+	l, _, _ := newSearchLibraryWithScanner(t)
+	return l
+}
+
+func newSearchLibraryWithScanner(t *testing.T) (*library.Library, chan<- scanner.Update, *mockEventPublisher) {
+	ch := make(chan scanner.Update)
+	events := &mockEventPublisher{}
+	ts := metadataTrackStore{
+		metadata: map[string]store.Metadata{
+			"something.flac": {Artist: "The Beatles", Year: 1969, Genre: "Rock", Duration: 183 * time.Second},
+			"together.mp3":   {Artist: "The Beatles", Year: 1969, Genre: "Rock", Duration: 259 * time.Second},
+			"joga.flac":      {Artist: "Björk", Year: 1997, Genre: "Electronic", Duration: 305 * time.Second},
+		},
+	}
+
+	l, err := library.New(newRoots(mockScanner{ch}), ts, mockThumbnailStore{}, mockAccessLoader{}, mockIdGenerator{}, &mockSnapshotRepository{}, &mockPlayCountRepository{}, events)
+	require.NoError(t, err)
+
+	ch <- scanner.Update{
+		Album: &scanner.Album{
+			Albums: map[string]*scanner.Album{
+				"The Beatles": {
+					Albums: map[string]*scanner.Album{
+						"Abbey Road": {
+							Tracks: map[string]scanner.Track{
+								"Something":     {Path: "something.flac"},
+								"Come Together": {Path: "together.mp3"},
+							},
+						},
+					},
+				},
+				"Björk": {
+					Albums: map[string]*scanner.Album{
+						"Homogenic": {
+							Tracks: map[string]scanner.Track{
+								"Jóga": {Path: "joga.flac"},
+							},
+						},
+					},
+				},
+				"Singles": {
+					Tracks: map[string]scanner.Track{
+						"Don't Let Me Down": {Path: "singles/dont.ogg"},
+						"XY":                {Path: "singles/xy.ogg"},
+					},
+				},
+			},
+		},
+	}
+	events.waitForLibraryChanged(t)
+
+	return l, ch, events
+}
+
+func searchResultAlbumTitles(result music.SearchResult) []string {
+	var titles []string
+	for _, album := range result.Albums {
+		titles = append(titles, album.Title)
+	}
+	return titles
+}
+
+func searchResultTrackTitles(result music.SearchResult) []string {
+	var titles []string
+	for _, track := range result.Tracks {
+		titles = append(titles, track.Track.Title)
+	}
+	return titles
+}
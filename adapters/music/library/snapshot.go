@@ -0,0 +1,201 @@
+package library
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/scanner"
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Snapshot is a serializable representation of the library tree. It is
+// persisted after each update so that the library can be restored right after
+// a restart without waiting for the scanner.
+type Snapshot struct {
+	Root SnapshotAlbum `json:"root"`
+}
+
+type SnapshotAlbum struct {
+	Id            music.AlbumId   `json:"id"`
+	Title         string          `json:"title"`
+	ThumbnailPath string          `json:"thumbnailPath"`
+	ThumbnailId   music.FileId    `json:"thumbnailId"`
+	Access        *music.Access   `json:"access"`
+	Albums        []SnapshotAlbum `json:"albums"`
+	Tracks        []SnapshotTrack `json:"tracks"`
+
+	ThumbnailEmbedded bool `json:"thumbnailEmbedded,omitempty"`
+}
+
+type SnapshotTrack struct {
+	Id     music.TrackId     `json:"id"`
+	Title  string            `json:"title"`
+	Path   string            `json:"path"`
+	FileId music.FileId      `json:"fileId"`
+	Cue    *SnapshotCueTrack `json:"cue,omitempty"`
+	Disc   int               `json:"disc,omitempty"`
+	Lyrics string            `json:"lyrics,omitempty"`
+
+	Added   time.Time `json:"added"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type SnapshotCueTrack struct {
+	Number    int           `json:"number"`
+	Title     string        `json:"title"`
+	Performer string        `json:"performer"`
+	Start     time.Duration `json:"start"`
+	End       time.Duration `json:"end"`
+}
+
+func newSnapshotCueTrack(cue *scanner.CueTrack) *SnapshotCueTrack {
+	if cue == nil {
+		return nil
+	}
+	return &SnapshotCueTrack{
+		Number:    cue.Number,
+		Title:     cue.Title,
+		Performer: cue.Performer,
+		Start:     cue.Start,
+		End:       cue.End,
+	}
+}
+
+func fromSnapshotCueTrack(cue *SnapshotCueTrack) *scanner.CueTrack {
+	if cue == nil {
+		return nil
+	}
+	return &scanner.CueTrack{
+		Number:    cue.Number,
+		Title:     cue.Title,
+		Performer: cue.Performer,
+		Start:     cue.Start,
+		End:       cue.End,
+	}
+}
+
+func newSnapshot(root *album) Snapshot {
+	return Snapshot{
+		Root: newSnapshotAlbum("", root),
+	}
+}
+
+func newSnapshotAlbum(id music.AlbumId, a *album) SnapshotAlbum {
+	snapshotAlbum := SnapshotAlbum{
+		Id:            id,
+		Title:         a.title,
+		ThumbnailPath: a.thumbnailPath,
+		ThumbnailId:   a.thumbnailId,
+		Access:        a.access,
+
+		ThumbnailEmbedded: a.thumbnailEmbedded,
+	}
+
+	for id, child := range a.albums {
+		snapshotAlbum.Albums = append(snapshotAlbum.Albums, newSnapshotAlbum(id, child))
+	}
+
+	for id, t := range a.tracks {
+		snapshotAlbum.Tracks = append(snapshotAlbum.Tracks, SnapshotTrack{
+			Id:     id,
+			Title:  t.title,
+			Path:   t.path,
+			FileId: t.fileId,
+			Cue:    newSnapshotCueTrack(t.cue),
+			Disc:   t.disc,
+			Lyrics: t.lyrics,
+
+			Added:   t.added,
+			ModTime: t.modTime,
+		})
+	}
+
+	return snapshotAlbum
+}
+
+func fromSnapshotAlbum(snapshotAlbum SnapshotAlbum) *album {
+	a := newAlbum(snapshotAlbum.Title)
+	a.thumbnailPath = snapshotAlbum.ThumbnailPath
+	a.thumbnailId = snapshotAlbum.ThumbnailId
+	a.thumbnailEmbedded = snapshotAlbum.ThumbnailEmbedded
+	a.access = snapshotAlbum.Access
+
+	for _, child := range snapshotAlbum.Albums {
+		a.albums[child.Id] = fromSnapshotAlbum(child)
+	}
+
+	for _, t := range snapshotAlbum.Tracks {
+		a.tracks[t.Id] = track{
+			title:   t.Title,
+			path:    t.Path,
+			fileId:  t.FileId,
+			cue:     fromSnapshotCueTrack(t.Cue),
+			disc:    t.Disc,
+			lyrics:  t.Lyrics,
+			added:   t.Added,
+			modTime: t.ModTime,
+		}
+	}
+
+	return a
+}
+
+var snapshotKey = []byte("snapshot")
+
+type BoltSnapshotRepository struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+func NewBoltSnapshotRepository(db *bolt.DB) (*BoltSnapshotRepository, error) {
+	bucket := []byte("library")
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "could not create a bucket")
+	}
+
+	return &BoltSnapshotRepository{
+		db:     db,
+		bucket: bucket,
+	}, nil
+}
+
+// Load returns the last saved snapshot or nil if no snapshots were saved.
+func (r *BoltSnapshotRepository) Load() (*Snapshot, error) {
+	var snapshot *Snapshot
+
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		j := tx.Bucket(r.bucket).Get(snapshotKey)
+		if j == nil {
+			return nil
+		}
+
+		snapshot = &Snapshot{}
+		if err := json.Unmarshal(j, snapshot); err != nil {
+			return errors.Wrap(err, "json unmarshal failed")
+		}
+
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "transaction failed")
+	}
+
+	return snapshot, nil
+}
+
+// Save overwrites the previously saved snapshot.
+func (r *BoltSnapshotRepository) Save(snapshot Snapshot) error {
+	j, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.Wrap(err, "marshaling to json failed")
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(r.bucket).Put(snapshotKey, j)
+	})
+}
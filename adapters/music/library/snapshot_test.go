@@ -0,0 +1,71 @@
+package library_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/library"
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/eggplant/internal/fixture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltSnapshotRepository(t *testing.T) {
+	db, cleanup := fixture.Bolt(t)
+	defer cleanup()
+
+	r, err := library.NewBoltSnapshotRepository(db)
+	require.NoError(t, err)
+
+	snapshot, err := r.Load()
+	require.NoError(t, err)
+	require.Nil(t, snapshot)
+
+	saved := library.Snapshot{
+		Root: library.SnapshotAlbum{
+			Title: "Eggplant",
+			Albums: []library.SnapshotAlbum{
+				{
+					Id:            "a1",
+					Title:         "a1",
+					ThumbnailPath: "a1_thumbnail",
+					ThumbnailId:   "a1_thumbnail_id",
+					Access: &music.Access{
+						Public: true,
+					},
+					Tracks: []library.SnapshotTrack{
+						{
+							Id:      "a1t1",
+							Title:   "a1t1",
+							Path:    "a1t1_path",
+							FileId:  "a1t1_file_id",
+							Added:   time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+							ModTime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+						},
+						{
+							Id:     "a1t2",
+							Title:  "a1t2",
+							Path:   "a1t2_path",
+							FileId: "a1t2_file_id",
+							Disc:   1,
+							Cue: &library.SnapshotCueTrack{
+								Number:    2,
+								Title:     "a1t2_title",
+								Performer: "a1t2_performer",
+								Start:     time.Minute,
+								End:       2 * time.Minute,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	err = r.Save(saved)
+	require.NoError(t, err)
+
+	snapshot, err = r.Load()
+	require.NoError(t, err)
+	require.Equal(t, &saved, snapshot)
+}
@@ -0,0 +1,112 @@
+package library
+
+import (
+	"github.com/boreq/eggplant/application/music"
+)
+
+// albumStats are aggregated from the tracks of an album and its children so
+// that the albums can be sorted without looking at all of their tracks.
+type albumStats struct {
+	// year is the earliest year in which one of the tracks was released
+	// or zero if it isn't known.
+	year int
+
+	// plays is the sum of the play counts of the tracks.
+	plays int
+}
+
+func (s *albumStats) add(o albumStats) {
+	if o.year != 0 && (s.year == 0 || o.year < s.year) {
+		s.year = o.year
+	}
+	s.plays += o.plays
+}
+
+// newAlbumStats aggregates the stats of the album and its children.
+func (l *Library) newAlbumStats(root *album) map[*album]albumStats {
+	stats := make(map[*album]albumStats)
+	l.resolveAlbumStats(stats, root)
+	return stats
+}
+
+// updateAlbumStats aggregates the stats of the albums which were changed
+// while updating the tree. The stats of the other albums are copied from the
+// previous tree.
+func (l *Library) updateAlbumStats(previous map[*album]albumStats, root *album, before []changedAlbum) map[*album]albumStats {
+	stats := make(map[*album]albumStats, len(previous))
+	for a, s := range previous {
+		stats[a] = s
+	}
+
+	for _, changed := range before {
+		delete(stats, changed.album)
+	}
+
+	l.resolveAlbumStats(stats, root)
+	return stats
+}
+
+// resolveAlbumStats aggregates the stats of the album and the children whose
+// stats are missing.
+func (l *Library) resolveAlbumStats(stats map[*album]albumStats, a *album) albumStats {
+	if s, ok := stats[a]; ok {
+		return s
+	}
+
+	var s albumStats
+	for _, t := range a.tracks {
+		metadata, _ := l.trackStore.GetMetadata(t.fileId.String())
+		s.add(albumStats{
+			year:  metadata.Year,
+			plays: l.plays.get(t.fileId),
+		})
+	}
+
+	for _, child := range a.albums {
+		s.add(l.resolveAlbumStats(stats, child))
+	}
+
+	stats[a] = s
+	return s
+}
+
+// ancestorAlbums returns the albums located on the specified path starting
+// with the root album.
+func ancestorAlbums(root *album, ids []music.AlbumId) []changedAlbum {
+	albums := []changedAlbum{{album: root}}
+
+	current := root
+	for i, id := range ids {
+		child, ok := current.albums[id]
+		if !ok {
+			break
+		}
+
+		albums = append(albums, changedAlbum{
+			path:  ids[:i+1],
+			album: child,
+		})
+		current = child
+	}
+
+	return albums
+}
+
+// withPlay creates a tree in which the play of the track is included in the
+// stats of its album and the ancestors of that album.
+func (t *tree) withPlay(v treeTrack) *tree {
+	stats := make(map[*album]albumStats, len(t.stats))
+	for a, s := range t.stats {
+		stats[a] = s
+	}
+
+	for _, ancestor := range ancestorAlbums(t.root, v.path) {
+		s := stats[ancestor.album]
+		s.plays++
+		stats[ancestor.album] = s
+	}
+
+	c := *t
+	c.stats = stats
+	return &c
+}
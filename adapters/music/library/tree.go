@@ -0,0 +1,345 @@
+package library
+
+import (
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/scanner"
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/errors"
+)
+
+// tree is an immutable state of the library. Updates build a new tree which
+// atomically replaces the previous one so that the readers never wait for
+// each other or for the updates. Nothing reachable from a tree can be
+// modified after the tree is created.
+type tree struct {
+	root     *album
+	problems [][]scanner.Problem // indexed by root
+
+	// tracks locates the tracks by their file ids.
+	tracks map[music.FileId]treeTrack
+
+	search *searchIndex
+
+	// stats are aggregated for each album so that the albums can be sorted
+	// by the years and the play counts of their tracks.
+	stats map[*album]albumStats
+
+	// recent lists the albums which contain tracks starting with the most
+	// recently added ones.
+	recent []recentAlbum
+}
+
+type treeTrack struct {
+	track track
+	album *album
+	path  []music.AlbumId // path to the album
+}
+
+// newTree creates a tree from the specified root album. The access of each
+// album is resolved so that the readers don't have to look at the parents
+// of the albums.
+func (l *Library) newTree(root *album, problems [][]scanner.Problem) *tree {
+	t := &tree{
+		root:     root,
+		problems: problems,
+		tracks:   make(map[music.FileId]treeTrack),
+	}
+	l.resolveAccess(nil, root, defaultAccess)
+	resolveTimes(root)
+	t.indexTracks(nil, root)
+	t.search = l.newSearchIndex(root)
+	t.stats = l.newAlbumStats(root)
+	t.recent = newRecentAlbums(root)
+	return t
+}
+
+// changedAlbum is an album which was copied or replaced while updating the
+// tree.
+type changedAlbum struct {
+	path        []music.AlbumId
+	album       *album
+	parentTitle string
+}
+
+// updateTree creates a tree in which the album located at the specified path
+// was replaced. The new root album must share all albums with the previous
+// one apart from the ancestors of the replaced album. Only the replaced
+// album, its children and its ancestors are resolved and indexed again.
+func (l *Library) updateTree(previous *tree, root *album, ids []music.AlbumId, problems [][]scanner.Problem) *tree {
+	before := changedAlbums(previous.root, ids)
+	after := changedAlbums(root, ids)
+
+	l.resolveChangedAlbums(root, ids)
+
+	t := &tree{
+		root:     root,
+		problems: problems,
+		tracks:   make(map[music.FileId]treeTrack, len(previous.tracks)),
+	}
+
+	for fileId, v := range previous.tracks {
+		t.tracks[fileId] = v
+	}
+	for _, changed := range before {
+		for _, v := range changed.album.tracks {
+			delete(t.tracks, v.fileId)
+		}
+	}
+	for _, changed := range after {
+		indexAlbumTracks(t.tracks, changed.path, changed.album)
+	}
+
+	t.search = l.updateSearchIndex(previous.search, root, before, after)
+	t.stats = l.updateAlbumStats(previous.stats, root, before)
+	t.recent = updateRecentAlbums(previous.recent, before, after)
+	return t
+}
+
+// reindexTracks creates a tree in which the albums containing the specified
+// tracks are indexed again so that the search index and the stats of the
+// albums include the tags which weren't known when the albums were indexed.
+func (l *Library) reindexTracks(previous *tree, ids []music.FileId) *tree {
+	var changed []changedAlbum
+	var ancestors []changedAlbum
+	seen := make(map[*album]struct{})
+	for _, id := range ids {
+		v, ok := previous.tracks[id]
+		if !ok {
+			continue
+		}
+
+		if _, ok := seen[v.album]; ok {
+			continue
+		}
+		seen[v.album] = struct{}{}
+
+		changed = append(changed, changedAlbum{
+			path:        v.path,
+			album:       v.album,
+			parentTitle: parentTitle(previous.root, v.path),
+		})
+		ancestors = append(ancestors, ancestorAlbums(previous.root, v.path)...)
+	}
+
+	t := *previous
+	t.search = l.updateSearchIndex(previous.search, previous.root, changed, changed)
+	t.stats = l.updateAlbumStats(previous.stats, previous.root, ancestors)
+	return &t
+}
+
+// parentTitle returns the title of the parent of the album located at the
+// specified path or an empty string if the parent is the root album.
+func parentTitle(root *album, ids []music.AlbumId) string {
+	if len(ids) < 2 {
+		return ""
+	}
+
+	parent, err := getAlbum(root, ids[:len(ids)-1])
+	if err != nil {
+		return ""
+	}
+	return parent.title
+}
+
+// changedAlbums returns the ancestors of the album located at the specified
+// path, the album itself and its children. The albums which don't exist are
+// skipped.
+func changedAlbums(root *album, ids []music.AlbumId) []changedAlbum {
+	var albums []changedAlbum
+
+	current := root
+	parentTitle := ""
+	for i := 0; i < len(ids); i++ {
+		albums = append(albums, changedAlbum{
+			path:        ids[:i],
+			album:       current,
+			parentTitle: parentTitle,
+		})
+
+		child, ok := current.albums[ids[i]]
+		if !ok {
+			return albums
+		}
+
+		parentTitle = titleIfNotRoot(ids[:i], current)
+		current = child
+	}
+
+	collectChangedAlbums(&albums, ids, current, parentTitle)
+	return albums
+}
+
+func collectChangedAlbums(albums *[]changedAlbum, path []music.AlbumId, a *album, parentTitle string) {
+	*albums = append(*albums, changedAlbum{
+		path:        path,
+		album:       a,
+		parentTitle: parentTitle,
+	})
+
+	for id, child := range a.albums {
+		childPath := make([]music.AlbumId, len(path), len(path)+1)
+		copy(childPath, path)
+		collectChangedAlbums(albums, append(childPath, id), child, titleIfNotRoot(path, a))
+	}
+}
+
+// resolveChangedAlbums resolves the access and the times of the album located
+// at the specified path, its children and its ancestors.
+func (l *Library) resolveChangedAlbums(root *album, ids []music.AlbumId) {
+	ancestors := []*album{root}
+	l.resolveAlbumAccess(nil, root, defaultAccess)
+
+	current := root
+	for i, id := range ids {
+		child, ok := current.albums[id]
+		if !ok {
+			break
+		}
+
+		if i == len(ids)-1 {
+			l.resolveAccess(ids, child, current.resolvedAccess)
+			resolveTimes(child)
+			break
+		}
+
+		l.resolveAlbumAccess(ids[:i+1], child, current.resolvedAccess)
+		ancestors = append(ancestors, child)
+		current = child
+	}
+
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		resolveAlbumTimes(ancestors[i])
+	}
+}
+
+// resolveAccess sets the access of the album and its children. The albums
+// without access files inherit the access of their parents unless they
+// contain a root with a default access.
+func (l *Library) resolveAccess(ids []music.AlbumId, a *album, inherited music.Access) {
+	l.resolveAlbumAccess(ids, a, inherited)
+
+	for id, child := range a.albums {
+		childIds := make([]music.AlbumId, len(ids), len(ids)+1)
+		copy(childIds, ids)
+		l.resolveAccess(append(childIds, id), child, a.resolvedAccess)
+	}
+}
+
+// resolveAlbumAccess sets the access of the album without looking at its
+// children.
+func (l *Library) resolveAlbumAccess(ids []music.AlbumId, a *album, inherited music.Access) {
+	if a.access != nil {
+		inherited = *a.access
+	} else if access := l.rootDefaultAccess(ids); access != nil {
+		inherited = *access
+	}
+	a.resolvedAccess = inherited
+}
+
+// resolveTimes sets the times at which the album and its children were added
+// and modified based on the times of their tracks.
+func resolveTimes(a *album) {
+	for _, child := range a.albums {
+		resolveTimes(child)
+	}
+	resolveAlbumTimes(a)
+}
+
+// resolveAlbumTimes sets the times of the album using the times of its
+// tracks and the times already resolved for its children.
+func resolveAlbumTimes(a *album) {
+	a.added = time.Time{}
+	a.modTime = time.Time{}
+
+	update := func(added, modTime time.Time) {
+		if !added.IsZero() && (a.added.IsZero() || added.Before(a.added)) {
+			a.added = added
+		}
+		if modTime.After(a.modTime) {
+			a.modTime = modTime
+		}
+	}
+
+	for _, t := range a.tracks {
+		update(t.added, t.modTime)
+	}
+
+	for _, child := range a.albums {
+		update(child.added, child.modTime)
+	}
+}
+
+func (t *tree) indexTracks(path []music.AlbumId, a *album) {
+	indexAlbumTracks(t.tracks, path, a)
+
+	for id, child := range a.albums {
+		childPath := make([]music.AlbumId, len(path), len(path)+1)
+		copy(childPath, path)
+		t.indexTracks(append(childPath, id), child)
+	}
+}
+
+func indexAlbumTracks(tracks map[music.FileId]treeTrack, path []music.AlbumId, a *album) {
+	for _, v := range a.tracks {
+		tracks[v.fileId] = treeTrack{
+			track: v,
+			album: a,
+			path:  path,
+		}
+	}
+}
+
+// findTrack returns the track with the specified file id and the album which
+// contains it.
+func (t *tree) findTrack(id music.FileId) (track, *album, bool) {
+	v, ok := t.tracks[id]
+	if !ok {
+		return track{}, nil, false
+	}
+	return v.track, v.album, true
+}
+
+func (t *tree) getAlbum(ids []music.AlbumId) (*album, error) {
+	return getAlbum(t.root, ids)
+}
+
+func getAlbum(root *album, ids []music.AlbumId) (*album, error) {
+	current := root
+	for _, id := range ids {
+		child, ok := current.albums[id]
+		if !ok {
+			return nil, errors.Wrapf(music.ErrNotFound, "album '%s' not found", id)
+		}
+		current = child
+	}
+	return current, nil
+}
+
+// cloneAlbum copies the album so that the copy can be modified while building
+// a new tree. The children and the tracks are shared with the original album.
+func cloneAlbum(a *album) *album {
+	c := *a
+	c.albums = make(map[music.AlbumId]*album, len(a.albums))
+	for id, child := range a.albums {
+		c.albums[id] = child
+	}
+	return &c
+}
+
+func (t *tree) getParents(ids []music.AlbumId) ([]music.Album, error) {
+	parents := make([]music.Album, 0)
+	for i := 0; i < len(ids); i++ {
+		parentIds := ids[:i+1]
+		dir, err := t.getAlbum(parentIds)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get a parent album")
+		}
+		parent := music.Album{
+			Id:    parentIds[len(parentIds)-1],
+			Title: dir.title,
+		}
+		parents = append(parents, parent)
+	}
+	return parents, nil
+}
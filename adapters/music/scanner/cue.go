@@ -0,0 +1,208 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boreq/errors"
+)
+
+const cueExtension = ".cue"
+
+// cueFramesPerSecond is used to convert the "mm:ss:ff" timestamps used in the
+// cue sheets.
+const cueFramesPerSecond = 75
+
+type cueSheet struct {
+	Title     string
+	Performer string
+	Files     []cueFile
+}
+
+type cueFile struct {
+	Name   string
+	Tracks []cueSheetTrack
+}
+
+type cueSheetTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	Start     time.Duration
+}
+
+// parseCueSheet parses the commands describing the files and the tracks,
+// other commands are ignored. Cue sheets are often not encoded using UTF-8,
+// if that is the case Latin-1 encoding is assumed.
+func parseCueSheet(r io.Reader) (cueSheet, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return cueSheet{}, errors.Wrap(err, "read failed")
+	}
+
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(b) {
+		b = latin1ToUTF8(b)
+	}
+
+	var sheet cueSheet
+	var file *cueFile
+	var track *cueSheetTrack
+	var hasStart bool
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := splitCueLine(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FILE":
+			if len(fields) < 2 {
+				return cueSheet{}, fmt.Errorf("line %d: missing file name", lineNumber)
+			}
+			sheet.Files = append(sheet.Files, cueFile{Name: fields[1]})
+			file = &sheet.Files[len(sheet.Files)-1]
+			track = nil
+		case "TRACK":
+			if file == nil {
+				return cueSheet{}, fmt.Errorf("line %d: track outside of a file", lineNumber)
+			}
+			if track != nil && !hasStart {
+				return cueSheet{}, fmt.Errorf("line %d: previous track has no index", lineNumber)
+			}
+			if len(fields) < 3 {
+				return cueSheet{}, fmt.Errorf("line %d: malformed track", lineNumber)
+			}
+			if !strings.EqualFold(fields[2], "AUDIO") {
+				track = nil
+				continue
+			}
+			number, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return cueSheet{}, errors.Wrapf(err, "line %d: malformed track number", lineNumber)
+			}
+			file.Tracks = append(file.Tracks, cueSheetTrack{Number: number})
+			track = &file.Tracks[len(file.Tracks)-1]
+			hasStart = false
+		case "INDEX":
+			if track == nil {
+				continue
+			}
+			if len(fields) < 3 {
+				return cueSheet{}, fmt.Errorf("line %d: malformed index", lineNumber)
+			}
+			if fields[1] != "01" && fields[1] != "1" {
+				continue
+			}
+			start, err := parseCueTimestamp(fields[2])
+			if err != nil {
+				return cueSheet{}, errors.Wrapf(err, "line %d: malformed timestamp", lineNumber)
+			}
+			track.Start = start
+			hasStart = true
+		case "TITLE":
+			if len(fields) < 2 {
+				continue
+			}
+			if track != nil {
+				track.Title = fields[1]
+			} else if file == nil {
+				sheet.Title = fields[1]
+			}
+		case "PERFORMER":
+			if len(fields) < 2 {
+				continue
+			}
+			if track != nil {
+				track.Performer = fields[1]
+			} else if file == nil {
+				sheet.Performer = fields[1]
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return cueSheet{}, errors.Wrap(err, "scanner error")
+	}
+
+	if track != nil && !hasStart {
+		return cueSheet{}, errors.New("last track has no index")
+	}
+
+	return sheet, nil
+}
+
+// splitCueLine splits the line into fields separated by whitespace. Quoted
+// fields may contain whitespace.
+func splitCueLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	var inField, quoted bool
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\r'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
+
+// parseCueTimestamp parses timestamps in the "mm:ss:ff" format where ff are
+// frames.
+func parseCueTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid timestamp '%s'", s)
+	}
+
+	var values []int
+	for _, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid timestamp '%s'", s)
+		}
+		values = append(values, v)
+	}
+
+	minutes, seconds, frames := values[0], values[1], values[2]
+	if seconds >= 60 || frames >= cueFramesPerSecond {
+		return 0, fmt.Errorf("invalid timestamp '%s'", s)
+	}
+
+	d := time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	d += time.Duration(frames) * time.Second / cueFramesPerSecond
+	return d, nil
+}
+
+func latin1ToUTF8(b []byte) []byte {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return []byte(string(runes))
+}
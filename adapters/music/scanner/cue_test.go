@@ -0,0 +1,128 @@
+package scanner
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCueSheet(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Input  string
+		Result cueSheet
+		Error  bool
+	}{
+		{
+			Name: "multiple_files",
+			Input: "\xef\xbb\xbfPERFORMER \"Artist\"\r\n" +
+				"TITLE \"Album\"\r\n" +
+				"FILE \"a b.flac\" WAVE\r\n" +
+				"  TRACK 01 AUDIO\r\n" +
+				"    TITLE \"Song A\"\r\n" +
+				"    INDEX 01 00:00:00\r\n" +
+				"  TRACK 02 AUDIO\r\n" +
+				"    TITLE \"Song B\"\r\n" +
+				"    PERFORMER \"Other\"\r\n" +
+				"    INDEX 00 01:00:00\r\n" +
+				"    INDEX 01 01:02:15\r\n" +
+				"FILE \"c.flac\" WAVE\r\n" +
+				"  TRACK 03 AUDIO\r\n" +
+				"    INDEX 01 00:00:00\r\n",
+			Result: cueSheet{
+				Title:     "Album",
+				Performer: "Artist",
+				Files: []cueFile{
+					{
+						Name: "a b.flac",
+						Tracks: []cueSheetTrack{
+							{
+								Number: 1,
+								Title:  "Song A",
+								Start:  0,
+							},
+							{
+								Number:    2,
+								Title:     "Song B",
+								Performer: "Other",
+								Start:     time.Minute + 2*time.Second + 200*time.Millisecond,
+							},
+						},
+					},
+					{
+						Name: "c.flac",
+						Tracks: []cueSheetTrack{
+							{
+								Number: 3,
+								Start:  0,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "latin1",
+			Input: "FILE \"a.flac\" WAVE\nTRACK 01 AUDIO\nTITLE \"Caf\xe9\"\nINDEX 01 00:00:00\n",
+			Result: cueSheet{
+				Files: []cueFile{
+					{
+						Name: "a.flac",
+						Tracks: []cueSheetTrack{
+							{
+								Number: 1,
+								Title:  "Café",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "data_tracks_are_skipped",
+			Input: "FILE \"a.bin\" BINARY\nTRACK 01 MODE1/2352\nINDEX 01 00:00:00\nTRACK 02 AUDIO\nINDEX 01 10:00:00\n",
+			Result: cueSheet{
+				Files: []cueFile{
+					{
+						Name: "a.bin",
+						Tracks: []cueSheetTrack{
+							{
+								Number: 2,
+								Start:  10 * time.Minute,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "track_outside_of_a_file",
+			Input: "TRACK 01 AUDIO\nINDEX 01 00:00:00\n",
+			Error: true,
+		},
+		{
+			Name:  "missing_index",
+			Input: "FILE \"a.flac\" WAVE\nTRACK 01 AUDIO\n",
+			Error: true,
+		},
+		{
+			Name:  "invalid_timestamp",
+			Input: "FILE \"a.flac\" WAVE\nTRACK 01 AUDIO\nINDEX 01 00:00:75\n",
+			Error: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			result, err := parseCueSheet(strings.NewReader(testCase.Input))
+			if testCase.Error {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, testCase.Result, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boreq/errors"
+)
+
+// ignoreFileName is the name of the files which list the patterns of the
+// paths which should be excluded from the library. The patterns apply only to
+// the directory in which the file is located and its subdirectories.
+const ignoreFileName = ".eggplantignore"
+
+// ignorePattern is a single gitignore-style pattern.
+type ignorePattern struct {
+	pattern string
+
+	// negate is set for patterns which start with an exclamation mark.
+	// Such patterns include paths excluded by the previous patterns.
+	negate bool
+
+	// dirOnly is set for patterns which end with a slash. Such patterns
+	// match only directories.
+	dirOnly bool
+
+	// re matches slash-separated paths relative to the directory to which
+	// the pattern applies.
+	re *regexp.Regexp
+}
+
+func (p ignorePattern) matches(relativePath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return p.re.MatchString(relativePath)
+}
+
+// parseIgnorePattern parses a single line of an ignore file. False is
+// returned for lines which don't contain a pattern eg. comments.
+func parseIgnorePattern(line string) (ignorePattern, bool, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false, nil
+	}
+
+	pattern := ignorePattern{
+		pattern: line,
+	}
+
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if line == "" {
+		return ignorePattern{}, false, fmt.Errorf("invalid pattern '%s'", pattern.pattern)
+	}
+
+	// patterns which contain a slash are relative to the directory to
+	// which they apply, other patterns match names at any level
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expression := globToRegexp(line)
+	if anchored {
+		expression = "^" + expression + "$"
+	} else {
+		expression = "^(?:.*/)?" + expression + "$"
+	}
+
+	re, err := regexp.Compile(expression)
+	if err != nil {
+		return ignorePattern{}, false, errors.Wrapf(err, "invalid pattern '%s'", pattern.pattern)
+	}
+	pattern.re = re
+
+	return pattern, true, nil
+}
+
+// globToRegexp converts a glob to a regular expression. The wildcards don't
+// match slashes with the exception of the double asterisk which matches any
+// number of directories.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+func parseIgnorePatterns(lines []string) ([]ignorePattern, error) {
+	var patterns []ignorePattern
+	for _, line := range lines {
+		pattern, ok, err := parseIgnorePattern(line)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse a pattern")
+		}
+		if ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+func readIgnoreFile(file string) ([]ignorePattern, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open the file")
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "scanner error")
+	}
+
+	return parseIgnorePatterns(lines)
+}
+
+// ignoreFileCache stores the patterns read from the ignore files so that the
+// files aren't parsed again for each checked path. A cached entry is used
+// only as long as the modification time and the size of the file don't
+// change.
+type ignoreFileCache struct {
+	entries map[string]ignoreFileCacheEntry // key is a directory
+	mutex   sync.Mutex
+}
+
+type ignoreFileCacheEntry struct {
+	modTime  time.Time
+	size     int64
+	patterns []ignorePattern
+}
+
+func newIgnoreFileCache() *ignoreFileCache {
+	return &ignoreFileCache{
+		entries: make(map[string]ignoreFileCacheEntry),
+	}
+}
+
+// get returns the patterns listed in the ignore file located in the
+// specified directory or nil if the file doesn't exist.
+func (c *ignoreFileCache) get(dir string) ([]ignorePattern, error) {
+	file := filepath.Join(dir, ignoreFileName)
+	info, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.forget(dir)
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "stat failed")
+	}
+
+	c.mutex.Lock()
+	entry, ok := c.entries[dir]
+	c.mutex.Unlock()
+
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.patterns, nil
+	}
+
+	patterns, err := readIgnoreFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read '%s'", file)
+	}
+
+	c.mutex.Lock()
+	c.entries[dir] = ignoreFileCacheEntry{
+		modTime:  info.ModTime(),
+		size:     info.Size(),
+		patterns: patterns,
+	}
+	c.mutex.Unlock()
+
+	return patterns, nil
+}
+
+// forgetRecursive removes the entries of the specified directory and its
+// subdirectories.
+func (c *ignoreFileCache) forgetRecursive(dir string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for entryDir := range c.entries {
+		if isSubdirectory(dir, entryDir) {
+			delete(c.entries, entryDir)
+		}
+	}
+}
+
+func (c *ignoreFileCache) forget(dir string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, dir)
+}
+
+// ignoreMatcher checks if paths located in the scanned directory are ignored.
+// The global patterns apply to the entire scanned directory. The patterns
+// read from the ignore files apply only to the directories in which those
+// files are located. The last matching pattern decides if a path is ignored.
+type ignoreMatcher struct {
+	root   string
+	global []ignorePattern
+	cache  *ignoreFileCache
+	files  map[string][]ignorePattern // key is a directory
+}
+
+func newIgnoreMatcher(root string, global []ignorePattern, cache *ignoreFileCache) *ignoreMatcher {
+	return &ignoreMatcher{
+		root:   root,
+		global: global,
+		cache:  cache,
+		files:  make(map[string][]ignorePattern),
+	}
+}
+
+// load reads the ignore file located in the specified directory if it
+// exists.
+func (m *ignoreMatcher) load(dir string) error {
+	patterns, err := m.cache.get(dir)
+	if err != nil {
+		return err
+	}
+
+	if patterns != nil {
+		m.files[dir] = patterns
+	}
+	return nil
+}
+
+// loadParents reads the ignore files located in the parent directories of
+// the specified path starting with the root.
+func (m *ignoreMatcher) loadParents(path string) error {
+	names, ok := relativeNames(m.root, path)
+	if !ok {
+		return nil
+	}
+
+	dir := m.root
+	for _, name := range names {
+		if err := m.load(dir); err != nil {
+			return err
+		}
+		dir = filepath.Join(dir, name)
+	}
+
+	return nil
+}
+
+// matches checks if the path itself is ignored. Paths located in ignored
+// directories are not reported unless they are ignored as well.
+func (m *ignoreMatcher) matches(path string, isDir bool) bool {
+	names, ok := relativeNames(m.root, path)
+	if !ok || len(names) == 0 {
+		return false
+	}
+
+	ignored := false
+
+	apply := func(patterns []ignorePattern, relativePath string) {
+		for _, pattern := range patterns {
+			if pattern.matches(relativePath, isDir) {
+				ignored = !pattern.negate
+			}
+		}
+	}
+
+	apply(m.global, strings.Join(names, "/"))
+
+	dir := m.root
+	for i := range names {
+		apply(m.files[dir], strings.Join(names[i:], "/"))
+		dir = filepath.Join(dir, names[i])
+	}
+
+	return ignored
+}
+
+// relativeNames splits the path relative to the root. False is returned if
+// the path is outside of the root.
+func relativeNames(root, path string) ([]string, bool) {
+	relativePath, err := filepath.Rel(root, path)
+	if err != nil {
+		return nil, false
+	}
+
+	if relativePath == "." {
+		return nil, true
+	}
+
+	if relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(os.PathSeparator)) {
+		return nil, false
+	}
+
+	return strings.Split(relativePath, string(os.PathSeparator)), true
+}
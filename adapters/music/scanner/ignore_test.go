@@ -0,0 +1,203 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnorePatterns(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Pattern string
+		Path    string
+		IsDir   bool
+		Matches bool
+	}{
+		{
+			Name:    "name_at_top_level",
+			Pattern: "@eaDir",
+			Path:    "@eaDir",
+			IsDir:   true,
+			Matches: true,
+		},
+		{
+			Name:    "name_at_any_level",
+			Pattern: "@eaDir",
+			Path:    "a/b/@eaDir",
+			IsDir:   true,
+			Matches: true,
+		},
+		{
+			Name:    "name_is_not_a_prefix",
+			Pattern: "@eaDir",
+			Path:    "a/@eaDirectory",
+			IsDir:   true,
+			Matches: false,
+		},
+		{
+			Name:    "wildcard",
+			Pattern: "*.wav",
+			Path:    "a/b.wav",
+			Matches: true,
+		},
+		{
+			Name:    "wildcard_does_not_match_slashes",
+			Pattern: "a*/c",
+			Path:    "ab/b/c",
+			Matches: false,
+		},
+		{
+			Name:    "question_mark",
+			Pattern: "CD?",
+			Path:    "a/CD1",
+			IsDir:   true,
+			Matches: true,
+		},
+		{
+			Name:    "character_class",
+			Pattern: "[ab].mp3",
+			Path:    "b.mp3",
+			Matches: true,
+		},
+		{
+			Name:    "negated_character_class",
+			Pattern: "[!ab].mp3",
+			Path:    "b.mp3",
+			Matches: false,
+		},
+		{
+			Name:    "anchored",
+			Pattern: "/samples",
+			Path:    "a/samples",
+			IsDir:   true,
+			Matches: false,
+		},
+		{
+			Name:    "anchored_at_top_level",
+			Pattern: "/samples",
+			Path:    "samples",
+			IsDir:   true,
+			Matches: true,
+		},
+		{
+			Name:    "slash_in_the_middle_anchors",
+			Pattern: "a/b",
+			Path:    "c/a/b",
+			IsDir:   true,
+			Matches: false,
+		},
+		{
+			Name:    "directory_only",
+			Pattern: "wip/",
+			Path:    "wip",
+			IsDir:   false,
+			Matches: false,
+		},
+		{
+			Name:    "directory_only_directory",
+			Pattern: "wip/",
+			Path:    "a/wip",
+			IsDir:   true,
+			Matches: true,
+		},
+		{
+			Name:    "double_asterisk_prefix",
+			Pattern: "**/wip",
+			Path:    "a/b/wip",
+			IsDir:   true,
+			Matches: true,
+		},
+		{
+			Name:    "double_asterisk_in_the_middle",
+			Pattern: "a/**/wip",
+			Path:    "a/wip",
+			IsDir:   true,
+			Matches: true,
+		},
+		{
+			Name:    "double_asterisk_suffix",
+			Pattern: "a/**",
+			Path:    "a/b/c.mp3",
+			Matches: true,
+		},
+		{
+			Name:    "escaped_wildcard",
+			Pattern: `\*.mp3`,
+			Path:    "a.mp3",
+			Matches: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			pattern, ok, err := parseIgnorePattern(testCase.Pattern)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, testCase.Matches, pattern.matches(testCase.Path, testCase.IsDir))
+		})
+	}
+}
+
+func TestParseIgnorePatternSkipsCommentsAndBlankLines(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment"} {
+		_, ok, err := parseIgnorePattern(line)
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+}
+
+func TestIgnoreMatcherScopesPatterns(t *testing.T) {
+	root := filepath.Join("music")
+
+	global, err := parseIgnorePatterns([]string{"*.wav"})
+	require.NoError(t, err)
+
+	a, err := parseIgnorePatterns([]string{"/wip", "!keep.wav"})
+	require.NoError(t, err)
+
+	m := newIgnoreMatcher(root, global, newIgnoreFileCache())
+	m.files[filepath.Join(root, "a")] = a
+
+	require.True(t, m.matches(filepath.Join(root, "a", "wip"), true))
+	require.False(t, m.matches(filepath.Join(root, "b", "wip"), true))
+	require.False(t, m.matches(filepath.Join(root, "a", "b", "wip"), true))
+	require.True(t, m.matches(filepath.Join(root, "b", "keep.wav"), false))
+	require.False(t, m.matches(filepath.Join(root, "a", "keep.wav"), false))
+	require.False(t, m.matches(root, true))
+}
+
+func TestIgnoreFileCache(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, ignoreFileName)
+	c := newIgnoreFileCache()
+
+	patterns, err := c.get(dir)
+	require.NoError(t, err)
+	require.Nil(t, patterns)
+
+	require.NoError(t, os.WriteFile(file, []byte("a\n"), 0600))
+
+	patterns, err = c.get(dir)
+	require.NoError(t, err)
+	require.Len(t, patterns, 1)
+
+	cached, err := c.get(dir)
+	require.NoError(t, err)
+	require.Same(t, &patterns[0], &cached[0])
+
+	require.NoError(t, os.WriteFile(file, []byte("a\nb\n"), 0600))
+
+	patterns, err = c.get(dir)
+	require.NoError(t, err)
+	require.Len(t, patterns, 2)
+
+	require.NoError(t, os.Remove(file))
+
+	patterns, err = c.get(dir)
+	require.NoError(t, err)
+	require.Nil(t, patterns)
+	require.Empty(t, c.entries)
+}
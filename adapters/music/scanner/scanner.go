@@ -6,18 +6,46 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/boreq/eggplant/adapters/music/scanner/symwalk"
 	"github.com/boreq/eggplant/logging"
 	"github.com/boreq/errors"
-	"github.com/radovskyb/watcher"
 )
 
 // Track represents an audio track.
 type Track struct {
 	Path string
+
+	// Cue is set if the track was described by a cue sheet. Such tracks
+	// occupy only a part of the file.
+	Cue *CueTrack
+
+	// Disc is set if the track was located in a disc directory which was
+	// merged into its parent album. Otherwise it is set to zero.
+	Disc int
+
+	// Lyrics is a path of a lyrics file which has the same name as the
+	// track. If the lyrics file is not available then this field is set to
+	// an empty string.
+	Lyrics string
+}
+
+// CueTrack describes a track which is a part of a larger file.
+type CueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+
+	// Start and End specify the part of the file which is occupied by the
+	// track. End is set to zero if the track lasts until the end of the
+	// file.
+	Start time.Duration
+	End   time.Duration
 }
 
 func newTrack(path string) Track {
@@ -43,6 +71,29 @@ type Album struct {
 	Tracks map[string]Track
 }
 
+// Update describes a change in the scanned directory. An update replaces the
+// album located at the specified path together with all its children.
+type Update struct {
+	// Path lists the titles of the albums leading to the album which
+	// changed, starting from the one closest to the root. An empty path
+	// refers to the root album.
+	Path []string
+
+	// Album is set to nil if the album no longer exists. The root album is
+	// never set to nil.
+	Album *Album
+
+	// Problems lists all paths in the entire scanned directory which were
+	// skipped, not only the ones located in the updated album.
+	Problems []Problem
+}
+
+// Problem describes a path which was skipped as it couldn't be scanned.
+type Problem struct {
+	Path   string
+	Reason string
+}
+
 func newAlbum() *Album {
 	return &Album{
 		Albums: make(map[string]*Album),
@@ -54,6 +105,28 @@ type Config struct {
 	TrackExtensions     []string
 	ThumbnailStems      []string
 	ThumbnailExtensions []string
+
+	// Watcher specifies how the changes are detected.
+	Watcher Watcher
+
+	// WatcherDebounce specifies how long the scanner waits for further
+	// changes before rescanning the changed directories. This way a
+	// directory which is being copied is rescanned only once.
+	WatcherDebounce time.Duration
+
+	// WatcherPollingInterval is used only by the polling watcher.
+	WatcherPollingInterval time.Duration
+
+	// Directories which names match one of those regular expressions are
+	// merged into their parent albums. The first subexpression of each
+	// expression has to match the disc number.
+	DiscDirectoryPatterns []string
+
+	// IgnorePatterns lists gitignore-style patterns of the paths which
+	// are excluded from the library. The patterns apply to the entire
+	// scanned directory. Additional patterns can be specified using
+	// ignore files placed in the scanned directory.
+	IgnorePatterns []string
 }
 
 func (c Config) Validate() error {
@@ -87,15 +160,41 @@ func (c Config) Validate() error {
 		}
 	}
 
+	switch c.Watcher {
+	case WatcherNotify:
+	case WatcherPoll:
+		if c.WatcherPollingInterval <= 0 {
+			return errors.New("polling interval must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown watcher '%s'", c.Watcher)
+	}
+
+	if c.WatcherDebounce < 0 {
+		return errors.New("debounce can not be negative")
+	}
+
+	if _, err := compileDiscDirectoryPatterns(c.DiscDirectoryPatterns); err != nil {
+		return errors.Wrap(err, "invalid disc directory patterns")
+	}
+
+	if _, err := parseIgnorePatterns(c.IgnorePatterns); err != nil {
+		return errors.Wrap(err, "invalid ignore patterns")
+	}
+
 	return nil
 }
 
 // Scanner watches a hard drive directory containing audio files and produces
 // updates whenever its contents change.
 type Scanner struct {
-	directory string
-	config    Config
-	log       logging.Logger
+	directory      string
+	config         Config
+	discPatterns   []*regexp.Regexp
+	ignorePatterns []ignorePattern
+	ignoreFiles    *ignoreFileCache
+	problems       map[string]Problem
+	log            logging.Logger
 }
 
 // New creates a new scanner which will watch the specified directory when
@@ -105,58 +204,63 @@ func New(directory string, config Config) (*Scanner, error) {
 		return nil, errors.Wrap(err, "invalid configuration")
 	}
 
+	discPatterns, err := compileDiscDirectoryPatterns(config.DiscDirectoryPatterns)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not compile the disc directory patterns")
+	}
+
+	ignorePatterns, err := parseIgnorePatterns(config.IgnorePatterns)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse the ignore patterns")
+	}
+
 	l := &Scanner{
-		directory: directory,
-		config:    config,
-		log:       logging.New("scanner"),
+		directory:      directory,
+		config:         config,
+		discPatterns:   discPatterns,
+		ignorePatterns: ignorePatterns,
+		ignoreFiles:    newIgnoreFileCache(),
+		problems:       make(map[string]Problem),
+		log:            logging.New("scanner"),
 	}
 	return l, nil
 }
 
 // Start starts the watcher and returns a channel on which the updates are
 // sent whenever available. At least one update will be sent on the channel
-// immidiately after calling this method.
-func (s *Scanner) Start() (<-chan Album, error) {
+// immidiately after calling this method. The first update always describes
+// the root album. Further updates describe only the parts of the directory
+// which changed.
+func (s *Scanner) Start() (<-chan Update, error) {
 	// fail early since the initial load carries the highest failure
 	// possiblity
-	album, err := s.load()
+	album, problems, err := s.load(s.directory)
 	if err != nil {
 		return nil, errors.Wrap(err, "initial load failed")
 	}
+	s.replaceProblems(s.directory, problems)
 
-	w := watcher.New()
-	w.SetMaxEvents(1)
-
-	if err := w.AddRecursive(s.directory); err != nil {
-		return nil, errors.Wrap(err, "could not add a recursive watcher")
+	changes, err := newChangeWatcher(s.config, s.isIgnored).Watch(s.directory)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not start the watcher")
 	}
 
-	go func() {
-		if err := w.Start(time.Second * 10); err != nil {
-			s.log.Error("error starting the watcher", "err", err)
-		}
-	}()
-
-	ch := make(chan Album)
+	ch := make(chan Update)
 	go func() {
 		defer close(ch)
-		ch <- album
+		ch <- Update{Album: &album, Problems: s.currentProblems()}
 
-		for {
-			select {
-			case _, ok := <-w.Event:
-				if !ok {
-					return
-				}
-				album, err := s.load()
+		for dir := range changes {
+			dirs, ok := debounce(changes, dir, s.config.WatcherDebounce)
+			for _, dir := range minimalDirectories(dirs) {
+				update, err := s.loadUpdate(dir)
 				if err != nil {
-					s.log.Error("load error", "err", err)
+					s.log.Error("load error", "dir", dir, "err", err)
 					continue
 				}
-				ch <- album
-			case err := <-w.Error:
-				s.log.Error("watcher error", "err", err)
-			case <-w.Closed:
+				ch <- update
+			}
+			if !ok {
 				return
 			}
 		}
@@ -164,23 +268,190 @@ func (s *Scanner) Start() (<-chan Album, error) {
 	return ch, nil
 }
 
-func (s *Scanner) load() (Album, error) {
+// loadUpdate rescans the specified directory which has to be located in the
+// scanned directory.
+func (s *Scanner) loadUpdate(dir string) (Update, error) {
+	relativePath, err := filepath.Rel(s.directory, dir)
+	if err != nil {
+		return Update{}, errors.Wrap(err, "could not get a relative filepath")
+	}
+
+	if relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(os.PathSeparator)) {
+		return Update{}, fmt.Errorf("directory '%s' is outside of the scanned directory", dir)
+	}
+
+	// disc directories are merged into their parents so the parents have
+	// to be rescanned instead
+	if relativePath != "." {
+		names := strings.Split(relativePath, string(os.PathSeparator))
+		for i := 1; i < len(names); i++ {
+			if _, ok := s.discNumber(names[i]); ok {
+				relativePath = filepath.Join(names[:i]...)
+				dir = filepath.Join(s.directory, relativePath)
+				break
+			}
+		}
+	}
+
+	if relativePath == "." {
+		album, problems, err := s.load(dir)
+		if err != nil {
+			return Update{}, errors.Wrap(err, "could not load the root album")
+		}
+		s.replaceProblems(dir, problems)
+		return Update{Album: &album, Problems: s.currentProblems()}, nil
+	}
+
+	update := Update{
+		Path: strings.Split(relativePath, string(os.PathSeparator)),
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			s.ignoreFiles.forgetRecursive(dir)
+			s.replaceProblems(dir, nil)
+			update.Problems = s.currentProblems()
+			return update, nil
+		}
+		return Update{}, errors.Wrap(err, "stat failed")
+	}
+
+	album, problems, err := s.load(dir)
+	if err != nil {
+		return Update{}, errors.Wrap(err, "could not load the album")
+	}
+	s.replaceProblems(dir, problems)
+
+	if len(album.Albums) != 0 || len(album.Tracks) != 0 {
+		update.Album = &album
+	}
+	update.Problems = s.currentProblems()
+
+	return update, nil
+}
+
+// replaceProblems replaces the problems recorded for the specified directory
+// and its subdirectories.
+func (s *Scanner) replaceProblems(dir string, problems []Problem) {
+	for path := range s.problems {
+		if isSubdirectory(dir, path) {
+			delete(s.problems, path)
+		}
+	}
+
+	for _, problem := range problems {
+		s.problems[problem.Path] = problem
+	}
+}
+
+func (s *Scanner) currentProblems() []Problem {
+	var problems []Problem
+	for _, problem := range s.problems {
+		problems = append(problems, problem)
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].Path < problems[j].Path
+	})
+	return problems
+}
+
+// isIgnored checks if the path or any of its parent directories are excluded
+// from the library. The ignore files are parsed again only after they change
+// so that changes to those files are taken into account immediately.
+func (s *Scanner) isIgnored(path string) bool {
+	names, ok := relativeNames(s.directory, path)
+	if !ok {
+		return false
+	}
+
+	ignore := newIgnoreMatcher(s.directory, s.ignorePatterns, s.ignoreFiles)
+	current := s.directory
+	for i, name := range names {
+		if err := ignore.load(current); err != nil {
+			s.log.Debug("could not load an ignore file", "dir", current, "err", err)
+		}
+		current = filepath.Join(current, name)
+		isDir := i < len(names)-1 || isDirectory(current)
+		if ignore.matches(current, isDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Load performs a single scan of the entire directory without watching it
+// for changes.
+func (s *Scanner) Load() (Album, []Problem, error) {
+	return s.load(s.directory)
+}
+
+// load scans the specified directory and returns an album which represents
+// it. Paths which can't be scanned are skipped and returned as problems. An
+// error is returned only if the directory itself can't be scanned.
+func (s *Scanner) load(directory string) (Album, []Problem, error) {
 	visited := make(map[string]struct{})
+	var problems []Problem
+	var cueSheets []string
+	var lyricsFiles []string
+
+	ignore := newIgnoreMatcher(s.directory, s.ignorePatterns, s.ignoreFiles)
+	if err := ignore.loadParents(directory); err != nil {
+		s.log.Warn("could not load the ignore files", "dir", directory, "err", err)
+		problems = append(problems, Problem{
+			Path:   directory,
+			Reason: errors.Wrap(err, "could not load the ignore files of the parent directories").Error(),
+		})
+	}
+
+	skip := func(path string, info os.FileInfo, err error) error {
+		s.log.Warn("skipping a path", "path", path, "err", err)
+		problems = append(problems, Problem{
+			Path:   path,
+			Reason: err.Error(),
+		})
+		if info != nil && (info.IsDir() || info.Mode()&os.ModeSymlink != 0) {
+			return filepath.SkipDir
+		}
+		return nil
+	}
 
 	root := *newAlbum()
-	if err := symwalk.Walk(s.directory, func(path string, info os.FileInfo, err error) error {
+	if err := symwalk.Walk(directory, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return errors.Wrap(err, "received an error")
+			if path == directory {
+				return errors.Wrap(err, "received an error")
+			}
+			return skip(path, info, err)
+		}
+
+		isDir := info.IsDir() || info.Mode()&os.ModeSymlink != 0
+
+		if path != s.directory && ignore.matches(path, isDir) {
+			if isDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isDir {
+			if err := ignore.load(path); err != nil {
+				s.log.Warn("skipping an ignore file", "dir", path, "err", err)
+				problems = append(problems, Problem{
+					Path:   filepath.Join(path, ignoreFileName),
+					Reason: err.Error(),
+				})
+			}
 		}
 
 		realPath, err := filepath.EvalSymlinks(path)
 		if err != nil {
-			return errors.Wrap(err, "could not eval a symlink")
+			return skip(path, info, errors.Wrap(err, "could not eval a symlink"))
 		}
 
 		_, ok := visited[realPath]
 		if ok {
-			return fmt.Errorf("loop detected: '%s' visited multiple times", realPath)
+			return skip(path, info, fmt.Errorf("loop detected: '%s' visited multiple times", realPath))
 		}
 
 		visited[realPath] = struct{}{}
@@ -194,38 +465,165 @@ func (s *Scanner) load() (Album, error) {
 		}
 
 		if s.isThumbnail(path) {
-			if err := s.addThumbnail(&root, path); err != nil {
-				return errors.Wrap(err, "could not add a thumbnail")
+			if err := s.addThumbnail(&root, directory, path); err != nil {
+				return skip(path, info, errors.Wrap(err, "could not add a thumbnail"))
 			}
 			return nil
 		}
 
 		if s.isAccessFile(path) {
-			if err := s.addAccessFile(&root, path); err != nil {
-				return errors.Wrap(err, "could not add an access file")
+			if err := s.addAccessFile(&root, directory, path); err != nil {
+				return skip(path, info, errors.Wrap(err, "could not add an access file"))
 			}
 			return nil
 		}
 
+		if s.isCueSheet(path) {
+			cueSheets = append(cueSheets, path)
+			return nil
+		}
+
 		if s.isTrack(path) {
-			if err := s.addTrack(&root, path); err != nil {
-				return errors.Wrap(err, "could not add a track")
+			if err := s.addTrack(&root, directory, path); err != nil {
+				return skip(path, info, errors.Wrap(err, "could not add a track"))
 			}
 			return nil
 		}
 
+		if s.isLyricsFile(path) {
+			lyricsFiles = append(lyricsFiles, path)
+			return nil
+		}
+
 		return nil
 	}); err != nil {
-		return Album{}, errors.Wrap(err, "walk failed")
+		return Album{}, nil, errors.Wrap(err, "walk failed")
+	}
+
+	// cue sheets are processed last as they replace the tracks which are
+	// added during the walk
+	for _, path := range cueSheets {
+		if err := s.addCueSheet(&root, directory, path); err != nil {
+			s.log.Warn("skipping a cue sheet", "path", path, "err", err)
+			problems = append(problems, Problem{
+				Path:   path,
+				Reason: errors.Wrap(err, "could not add a cue sheet").Error(),
+			})
+		}
+	}
+
+	// lyrics files are matched with the tracks which remain after
+	// processing the cue sheets
+	for _, path := range lyricsFiles {
+		if err := s.addLyrics(&root, directory, path); err != nil {
+			s.log.Warn("skipping a lyrics file", "path", path, "err", err)
+			problems = append(problems, Problem{
+				Path:   path,
+				Reason: errors.Wrap(err, "could not add a lyrics file").Error(),
+			})
+		}
 	}
 
 	removeEmptyAlbums(&root)
+	s.mergeDiscDirectories(&root, directory == s.directory)
+
+	return root, problems, nil
+}
+
+// mergeDiscDirectories merges the disc directories into their parent albums.
+// Disc directories are never merged into the root album. Disc directories
+// which contain other albums or access files aren't merged.
+func (s *Scanner) mergeDiscDirectories(album *Album, isRoot bool) {
+	for _, child := range album.Albums {
+		s.mergeDiscDirectories(child, false)
+	}
+
+	if isRoot {
+		return
+	}
+
+	type disc struct {
+		name   string
+		number int
+		album  *Album
+	}
+
+	var discs []disc
+	for name, child := range album.Albums {
+		number, ok := s.discNumber(name)
+		if !ok || len(child.Albums) != 0 || child.AccessFile != "" {
+			continue
+		}
+		discs = append(discs, disc{name: name, number: number, album: child})
+	}
+
+	sort.Slice(discs, func(i, j int) bool {
+		if discs[i].number != discs[j].number {
+			return discs[i].number < discs[j].number
+		}
+		return discs[i].name < discs[j].name
+	})
+
+	for _, disc := range discs {
+		delete(album.Albums, disc.name)
+
+		if album.Thumbnail == "" {
+			album.Thumbnail = disc.album.Thumbnail
+		}
+
+		for _, title := range sortedTrackTitles(disc.album) {
+			track := disc.album.Tracks[title]
+			track.Disc = disc.number
+
+			if _, exists := album.Tracks[title]; exists {
+				title = fmt.Sprintf("%s (%s)", title, disc.name)
+			}
+			album.Tracks[title] = track
+		}
+	}
+}
 
-	return root, nil
+func (s *Scanner) discNumber(name string) (int, bool) {
+	for _, pattern := range s.discPatterns {
+		submatches := pattern.FindStringSubmatch(name)
+		if submatches == nil {
+			continue
+		}
+		number, err := strconv.Atoi(submatches[1])
+		if err != nil {
+			continue
+		}
+		return number, true
+	}
+	return 0, false
+}
+
+func compileDiscDirectoryPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var result []*regexp.Regexp
+	for _, pattern := range patterns {
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern '%s'", pattern)
+		}
+		if r.NumSubexp() < 1 {
+			return nil, fmt.Errorf("pattern '%s' doesn't have a subexpression matching the disc number", pattern)
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+func sortedTrackTitles(album *Album) []string {
+	var titles []string
+	for title := range album.Tracks {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return titles
 }
 
-func (s *Scanner) addTrack(root *Album, file string) error {
-	album, err := s.findAlbum(root, file)
+func (s *Scanner) addTrack(root *Album, directory, file string) error {
+	album, err := s.findAlbum(root, directory, file)
 	if err != nil {
 		return errors.Wrap(err, "could not find an album")
 	}
@@ -235,8 +633,128 @@ func (s *Scanner) addTrack(root *Album, file string) error {
 	return nil
 }
 
-func (s *Scanner) addThumbnail(root *Album, file string) error {
-	album, err := s.findAlbum(root, file)
+// addCueSheet replaces the tracks which are described by the cue sheet with
+// the tracks listed in it. Files are matched by their names. If a file isn't
+// found then a track which has the same name but a different extension is
+// used as cue sheets often describe the original rips which were later
+// converted to other formats.
+func (s *Scanner) addCueSheet(root *Album, directory, file string) error {
+	album, err := s.findAlbum(root, directory, file)
+	if err != nil {
+		return errors.Wrap(err, "could not find an album")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err, "could not open the file")
+	}
+	defer f.Close()
+
+	sheet, err := parseCueSheet(f)
+	if err != nil {
+		return errors.Wrap(err, "could not parse the cue sheet")
+	}
+
+	type replacement struct {
+		title  string
+		tracks []cueSheetTrack
+	}
+
+	var replacements []replacement
+	for _, cueFile := range sheet.Files {
+		title, ok := findCueFileTrack(album, filepath.Join(filepath.Dir(file), cueFile.Name))
+		if !ok {
+			return fmt.Errorf("file '%s' not found", cueFile.Name)
+		}
+		replacements = append(replacements, replacement{title: title, tracks: cueFile.Tracks})
+	}
+
+	for _, replacement := range replacements {
+		path := album.Tracks[replacement.title].Path
+		delete(album.Tracks, replacement.title)
+
+		for i, cueSheetTrack := range replacement.tracks {
+			track := newTrack(path)
+			track.Cue = &CueTrack{
+				Number:    cueSheetTrack.Number,
+				Title:     cueSheetTrack.Title,
+				Performer: cueSheetTrack.Performer,
+				Start:     cueSheetTrack.Start,
+			}
+
+			if track.Cue.Performer == "" {
+				track.Cue.Performer = sheet.Performer
+			}
+
+			if i+1 < len(replacement.tracks) {
+				track.Cue.End = replacement.tracks[i+1].Start
+			}
+
+			album.Tracks[cueTrackTitle(album, replacement.title, cueSheetTrack)] = track
+		}
+	}
+
+	return nil
+}
+
+func findCueFileTrack(album *Album, path string) (string, bool) {
+	for title, track := range album.Tracks {
+		if track.Cue == nil && track.Path == path {
+			return title, true
+		}
+	}
+
+	stem := filenameWithoutExtension(path)
+	for title, track := range album.Tracks {
+		if track.Cue == nil && filepath.Dir(track.Path) == filepath.Dir(path) && filenameWithoutExtension(track.Path) == stem {
+			return title, true
+		}
+	}
+
+	return "", false
+}
+
+// cueTrackTitle returns a unique title for the track described by a cue sheet.
+func cueTrackTitle(album *Album, fileTitle string, track cueSheetTrack) string {
+	title := track.Title
+	if title == "" {
+		title = fmt.Sprintf("%s - Track %02d", fileTitle, track.Number)
+	}
+
+	if _, exists := album.Tracks[title]; exists {
+		title = fmt.Sprintf("%s (%02d)", title, track.Number)
+	}
+
+	return title
+}
+
+// addLyrics assigns the lyrics file to the tracks which have the same name.
+// Tracks described by cue sheets don't have lyrics files as those would
+// describe the entire file. If a track has multiple lyrics files then the
+// one with the extension listed first in lyricsExtensions is used.
+func (s *Scanner) addLyrics(root *Album, directory, file string) error {
+	album, err := s.findAlbum(root, directory, file)
+	if err != nil {
+		return errors.Wrap(err, "could not find an album")
+	}
+
+	stem := filenameWithoutExtension(file)
+	for title, track := range album.Tracks {
+		if track.Cue != nil || filepath.Dir(track.Path) != filepath.Dir(file) || filenameWithoutExtension(track.Path) != stem {
+			continue
+		}
+
+		if track.Lyrics == "" || lyricsExtensionIndex(file) < lyricsExtensionIndex(track.Lyrics) {
+			track.Lyrics = file
+			album.Tracks[title] = track
+		}
+	}
+
+	return nil
+}
+
+func (s *Scanner) addThumbnail(root *Album, directory, file string) error {
+	album, err := s.findAlbum(root, directory, file)
 	if err != nil {
 		return errors.Wrap(err, "could not find an album")
 	}
@@ -245,8 +763,8 @@ func (s *Scanner) addThumbnail(root *Album, file string) error {
 	return nil
 }
 
-func (s *Scanner) addAccessFile(root *Album, file string) error {
-	album, err := s.findAlbum(root, file)
+func (s *Scanner) addAccessFile(root *Album, directory, file string) error {
+	album, err := s.findAlbum(root, directory, file)
 	if err != nil {
 		return errors.Wrap(err, "could not find an album")
 	}
@@ -284,6 +802,30 @@ func (s *Scanner) hasThumbnailExtension(path string) bool {
 	return false
 }
 
+// lyricsExtensions lists the extensions of the lyrics files starting with the
+// preferred ones.
+var lyricsExtensions = []string{".lrc", ".txt"}
+
+func (s *Scanner) isLyricsFile(path string) bool {
+	return lyricsExtensionIndex(path) >= 0
+}
+
+// lyricsExtensionIndex returns the index of the extension of the file in
+// lyricsExtensions or -1 if the file isn't a lyrics file.
+func lyricsExtensionIndex(path string) int {
+	ext := filepath.Ext(path)
+	for i, lyricsExt := range lyricsExtensions {
+		if strings.EqualFold(ext, lyricsExt) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Scanner) isCueSheet(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), cueExtension)
+}
+
 func (s *Scanner) isTrack(path string) bool {
 	ext := filepath.Ext(path)
 	for _, trackExt := range s.config.TrackExtensions {
@@ -294,8 +836,8 @@ func (s *Scanner) isTrack(path string) bool {
 	return false
 }
 
-func (s *Scanner) findAlbum(root *Album, file string) (*Album, error) {
-	relativePath, err := filepath.Rel(s.directory, file)
+func (s *Scanner) findAlbum(root *Album, directory, file string) (*Album, error) {
+	relativePath, err := filepath.Rel(directory, file)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not get a relative filepath")
 	}
@@ -1,19 +1,22 @@
 package scanner_test
 
 import (
+	"io/ioutil"
+	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/boreq/eggplant/adapters/music/scanner"
-	"github.com/boreq/errors"
 	"github.com/stretchr/testify/require"
 )
 
 func TestScanner(t *testing.T) {
 	testCases := []struct {
-		Name   string
-		Result scanner.Album
-		Error  error
+		Name     string
+		Result   scanner.Album
+		Problems []scanner.Problem
+		Error    error
 	}{
 		{
 			Name: "flat",
@@ -346,9 +349,137 @@ func TestScanner(t *testing.T) {
 			},
 		},
 		{
-			Name:   "symlinks_loop",
-			Result: scanner.Album{},
-			Error:  errors.New("initial load failed: walk failed: loop detected: 'test_data/symlinks_loop/a' visited multiple times"),
+			Name: "cue",
+			Result: scanner.Album{
+				Thumbnail:  "",
+				AccessFile: "",
+				Albums:     map[string]*scanner.Album{},
+				Tracks: map[string]scanner.Track{
+					"First Song": {
+						Path: "test_data/cue/album.mp3",
+						Cue: &scanner.CueTrack{
+							Number:    1,
+							Title:     "First Song",
+							Performer: "Some Artist",
+							Start:     0,
+							End:       3*time.Minute + 12*time.Second + 37*time.Second/75,
+						},
+					},
+					"Second Song": {
+						Path: "test_data/cue/album.mp3",
+						Cue: &scanner.CueTrack{
+							Number:    2,
+							Title:     "Second Song",
+							Performer: "Other Artist",
+							Start:     3*time.Minute + 12*time.Second + 37*time.Second/75,
+							End:       0,
+						},
+					},
+					"bonus": {
+						Path: "test_data/cue/bonus.mp3",
+					},
+				},
+			},
+		},
+		{
+			Name: "discs",
+			Result: scanner.Album{
+				Thumbnail:  "",
+				AccessFile: "",
+				Albums: map[string]*scanner.Album{
+					"a": {
+						Thumbnail:  "test_data/discs/a/thumbnail.jpg",
+						AccessFile: "",
+						Albums:     map[string]*scanner.Album{},
+						Tracks: map[string]scanner.Track{
+							"a": {
+								Path: "test_data/discs/a/CD1/a.mp3",
+								Disc: 1,
+							},
+							"b": {
+								Path: "test_data/discs/a/CD1/b.mp3",
+								Disc: 1,
+							},
+							"a (CD2)": {
+								Path: "test_data/discs/a/CD2/a.mp3",
+								Disc: 2,
+							},
+						},
+					},
+					"b": {
+						Thumbnail:  "",
+						AccessFile: "",
+						Albums: map[string]*scanner.Album{
+							"Disc 1": {
+								Thumbnail:  "",
+								AccessFile: "",
+								Albums: map[string]*scanner.Album{
+									"c": {
+										Thumbnail:  "",
+										AccessFile: "",
+										Albums:     map[string]*scanner.Album{},
+										Tracks: map[string]scanner.Track{
+											"a": {
+												Path: "test_data/discs/b/Disc 1/c/a.mp3",
+											},
+										},
+									},
+								},
+								Tracks: map[string]scanner.Track{},
+							},
+						},
+						Tracks: map[string]scanner.Track{},
+					},
+				},
+				Tracks: map[string]scanner.Track{},
+			},
+		},
+		{
+			Name: "lyrics",
+			Result: scanner.Album{
+				Thumbnail:  "",
+				AccessFile: "",
+				Albums:     map[string]*scanner.Album{},
+				Tracks: map[string]scanner.Track{
+					"a": {
+						Path:   "test_data/lyrics/a.mp3",
+						Lyrics: "test_data/lyrics/a.lrc",
+					},
+					"b": {
+						Path:   "test_data/lyrics/b.mp3",
+						Lyrics: "test_data/lyrics/b.TXT",
+					},
+					"c": {
+						Path: "test_data/lyrics/c.mp3",
+					},
+				},
+			},
+		},
+		{
+			Name: "symlinks_loop",
+			Result: scanner.Album{
+				Thumbnail:  "",
+				AccessFile: "",
+				Albums: map[string]*scanner.Album{
+					"a": {
+						Thumbnail:  "",
+						AccessFile: "",
+						Albums:     map[string]*scanner.Album{},
+						Tracks: map[string]scanner.Track{
+							"a": {
+								Path: "test_data/symlinks_loop/a/a.mp3",
+							},
+						},
+					},
+				},
+				Tracks: map[string]scanner.Track{},
+			},
+			Problems: []scanner.Problem{
+				{
+					Path:   "test_data/symlinks_loop/a/a",
+					Reason: "loop detected: 'test_data/symlinks_loop/a' visited multiple times",
+				},
+			},
 		},
 	}
 
@@ -364,8 +495,8 @@ func TestScanner(t *testing.T) {
 			if testCase.Error == nil {
 				require.NoError(t, err)
 
-				album := <-c
-				require.Equal(t, testCase.Result, album)
+				update := <-c
+				require.Equal(t, scanner.Update{Album: &testCase.Result, Problems: testCase.Problems}, update)
 			} else {
 				require.EqualError(t, err, testCase.Error.Error())
 			}
@@ -373,6 +504,289 @@ func TestScanner(t *testing.T) {
 	}
 }
 
+func TestScannerSendsPartialUpdates(t *testing.T) {
+	watchers := []scanner.Watcher{
+		scanner.WatcherNotify,
+		scanner.WatcherPoll,
+	}
+
+	for _, watcher := range watchers {
+		t.Run(string(watcher), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "eggplant_test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			createFile(t, path.Join(dir, "a", "a.mp3"))
+			createFile(t, path.Join(dir, "b", "a.mp3"))
+
+			config := testConfig()
+			config.Watcher = watcher
+
+			s, err := scanner.New(dir, config)
+			require.NoError(t, err)
+
+			c, err := s.Start()
+			require.NoError(t, err)
+
+			update := <-c
+			require.Empty(t, update.Path)
+			require.Len(t, update.Album.Albums, 2)
+
+			<-time.After(200 * time.Millisecond)
+
+			createFile(t, path.Join(dir, "c", "d", "a.mp3"))
+			createFile(t, path.Join(dir, "c", "d", "b.mp3"))
+
+			update = receiveUpdate(t, c)
+			require.Equal(t, []string{"c"}, update.Path)
+			require.Equal(t, &scanner.Album{
+				Albums: map[string]*scanner.Album{
+					"d": {
+						Albums: map[string]*scanner.Album{},
+						Tracks: map[string]scanner.Track{
+							"a": {
+								Path: path.Join(dir, "c", "d", "a.mp3"),
+							},
+							"b": {
+								Path: path.Join(dir, "c", "d", "b.mp3"),
+							},
+						},
+					},
+				},
+				Tracks: map[string]scanner.Track{},
+			}, update.Album)
+
+			err = os.RemoveAll(path.Join(dir, "b"))
+			require.NoError(t, err)
+
+			update = receiveUpdate(t, c)
+			require.Equal(t, []string{"b"}, update.Path)
+			require.Nil(t, update.Album)
+		})
+	}
+}
+
+func TestScannerRescansParentsOfDiscDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eggplant_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	createFile(t, path.Join(dir, "a", "CD1", "a.mp3"))
+
+	s, err := scanner.New(dir, testConfig())
+	require.NoError(t, err)
+
+	c, err := s.Start()
+	require.NoError(t, err)
+
+	update := <-c
+	require.Empty(t, update.Path)
+
+	<-time.After(200 * time.Millisecond)
+
+	createFile(t, path.Join(dir, "a", "CD2", "b.mp3"))
+
+	update = receiveUpdate(t, c)
+	require.Equal(t, []string{"a"}, update.Path)
+	require.Equal(t, &scanner.Album{
+		Albums: map[string]*scanner.Album{},
+		Tracks: map[string]scanner.Track{
+			"a": {
+				Path: path.Join(dir, "a", "CD1", "a.mp3"),
+				Disc: 1,
+			},
+			"b": {
+				Path: path.Join(dir, "a", "CD2", "b.mp3"),
+				Disc: 2,
+			},
+		},
+	}, update.Album)
+}
+
+func TestScannerSkipsDanglingSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eggplant_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	createFile(t, path.Join(dir, "a", "a.mp3"))
+
+	err = os.Symlink(path.Join(dir, "nonexistent"), path.Join(dir, "a", "b.mp3"))
+	require.NoError(t, err)
+
+	s, err := scanner.New(dir, testConfig())
+	require.NoError(t, err)
+
+	album, problems, err := s.Load()
+	require.NoError(t, err)
+
+	require.Equal(t, scanner.Album{
+		Albums: map[string]*scanner.Album{
+			"a": {
+				Albums: map[string]*scanner.Album{},
+				Tracks: map[string]scanner.Track{
+					"a": {
+						Path: path.Join(dir, "a", "a.mp3"),
+					},
+				},
+			},
+		},
+		Tracks: map[string]scanner.Track{},
+	}, album)
+
+	require.Len(t, problems, 1)
+	require.Equal(t, path.Join(dir, "a", "b.mp3"), problems[0].Path)
+	require.Contains(t, problems[0].Reason, "could not eval a symlink")
+}
+
+func TestScannerWatchesDirectoriesWithPathsWhichCantBeRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eggplant_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	createFile(t, path.Join(dir, "a", "a.mp3"))
+	createFile(t, path.Join(dir, "unreadable", "a.mp3"))
+
+	err = os.Symlink(path.Join(dir, "nonexistent"), path.Join(dir, "a", "b.mp3"))
+	require.NoError(t, err)
+
+	err = os.Chmod(path.Join(dir, "unreadable"), 0)
+	require.NoError(t, err)
+	defer os.Chmod(path.Join(dir, "unreadable"), os.ModePerm)
+
+	config := testConfig()
+	config.Watcher = scanner.WatcherNotify
+
+	s, err := scanner.New(dir, config)
+	require.NoError(t, err)
+
+	c, err := s.Start()
+	require.NoError(t, err)
+
+	update := receiveUpdate(t, c)
+	require.Empty(t, update.Path)
+	require.Contains(t, update.Album.Albums, "a")
+
+	<-time.After(200 * time.Millisecond)
+
+	createFile(t, path.Join(dir, "b", "a.mp3"))
+
+	update = receiveUpdate(t, c)
+	require.Equal(t, []string{"b"}, update.Path)
+}
+
+func TestScannerSkipsIgnoredPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eggplant_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	createFile(t, path.Join(dir, "a", "a.mp3"))
+	createFile(t, path.Join(dir, "a", "wip", "a.mp3"))
+	createFile(t, path.Join(dir, "a", "@eaDir", "a.mp3"))
+	createFile(t, path.Join(dir, "b", "wip", "a.mp3"))
+	createFile(t, path.Join(dir, "b", "sample.mp3"))
+	writeFile(t, path.Join(dir, "a", ".eggplantignore"), "# work in progress\nwip/\n")
+
+	config := testConfig()
+	config.IgnorePatterns = []string{"@eaDir/", "sample.mp3"}
+
+	s, err := scanner.New(dir, config)
+	require.NoError(t, err)
+
+	album, problems, err := s.Load()
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	require.Equal(t, scanner.Album{
+		Albums: map[string]*scanner.Album{
+			"a": {
+				Albums: map[string]*scanner.Album{},
+				Tracks: map[string]scanner.Track{
+					"a": {
+						Path: path.Join(dir, "a", "a.mp3"),
+					},
+				},
+			},
+			"b": {
+				Albums: map[string]*scanner.Album{
+					"wip": {
+						Albums: map[string]*scanner.Album{},
+						Tracks: map[string]scanner.Track{
+							"a": {
+								Path: path.Join(dir, "b", "wip", "a.mp3"),
+							},
+						},
+					},
+				},
+				Tracks: map[string]scanner.Track{},
+			},
+		},
+		Tracks: map[string]scanner.Track{},
+	}, album)
+}
+
+func TestScannerDoesNotSendUpdatesForIgnoredPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eggplant_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	createFile(t, path.Join(dir, "a", "a.mp3"))
+	createFile(t, path.Join(dir, "wip", "a.mp3"))
+	writeFile(t, path.Join(dir, ".eggplantignore"), "wip\n")
+
+	s, err := scanner.New(dir, testConfig())
+	require.NoError(t, err)
+
+	c, err := s.Start()
+	require.NoError(t, err)
+
+	update := <-c
+	require.Len(t, update.Album.Albums, 1)
+
+	<-time.After(200 * time.Millisecond)
+
+	createFile(t, path.Join(dir, "wip", "b.mp3"))
+	createFile(t, path.Join(dir, "b", "a.mp3"))
+
+	update = receiveUpdate(t, c)
+	require.Equal(t, []string{"b"}, update.Path)
+
+	err = os.Remove(path.Join(dir, ".eggplantignore"))
+	require.NoError(t, err)
+
+	update = receiveUpdate(t, c)
+	require.Empty(t, update.Path)
+	require.Len(t, update.Album.Albums, 3)
+}
+
+func writeFile(t *testing.T, file string, content string) {
+	err := os.MkdirAll(path.Dir(file), os.ModePerm)
+	require.NoError(t, err)
+
+	err = ioutil.WriteFile(file, []byte(content), os.ModePerm)
+	require.NoError(t, err)
+}
+
+func receiveUpdate(t *testing.T, c <-chan scanner.Update) scanner.Update {
+	select {
+	case update := <-c:
+		return update
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return scanner.Update{}
+	}
+}
+
+func createFile(t *testing.T, file string) {
+	err := os.MkdirAll(path.Dir(file), os.ModePerm)
+	require.NoError(t, err)
+
+	f, err := os.Create(file)
+	require.NoError(t, err)
+
+	err = f.Close()
+	require.NoError(t, err)
+}
+
 func TestScannerFailsIfDirectoryDoesNotExist(t *testing.T) {
 	config := testConfig()
 
@@ -394,6 +808,12 @@ func testConfig() scanner.Config {
 		ThumbnailExtensions: []string{
 			".jpg",
 		},
+		Watcher:                scanner.WatcherNotify,
+		WatcherDebounce:        500 * time.Millisecond,
+		WatcherPollingInterval: 50 * time.Millisecond,
+		DiscDirectoryPatterns: []string{
+			`(?i)^(?:cd|disc|disk)[ _.-]*(\d+)\b`,
+		},
 	}
 }
 
@@ -33,14 +33,16 @@ func walk(path string, info os.FileInfo, walkFn WalkFunc) error {
 	}
 
 	if info.Mode()&os.ModeSymlink != 0 {
+		// walkFn decides if the walk should continue when a symlink
+		// can't be followed eg. because it is dangling
 		targetPath, err := filepath.EvalSymlinks(path)
 		if err != nil {
-			return errors.Wrap(err, "could not eval a symlink")
+			return walkFn(path, info, errors.Wrap(err, "could not eval a symlink"))
 		}
 
 		targetInfo, err := os.Lstat(targetPath)
 		if err != nil {
-			return errors.Wrap(err, "could not lstat a symlink target")
+			return walkFn(path, info, errors.Wrap(err, "could not lstat a symlink target"))
 		}
 
 		if targetInfo.Mode()&os.ModeDir == 0 {
@@ -71,7 +73,7 @@ func walk(path string, info os.FileInfo, walkFn WalkFunc) error {
 		} else {
 			err = walk(filename, fileInfo, walkFn)
 			if err != nil {
-				if !fileInfo.IsDir() || err != filepath.SkipDir {
+				if !isDirOrSymlink(fileInfo) || err != filepath.SkipDir {
 					return err
 				}
 			}
@@ -81,6 +83,12 @@ func walk(path string, info os.FileInfo, walkFn WalkFunc) error {
 	return nil
 }
 
+// isDirOrSymlink returns true for symlinks as well since symlinks pointing to
+// directories are walked just like directories.
+func isDirOrSymlink(info os.FileInfo) bool {
+	return info.IsDir() || info.Mode()&os.ModeSymlink != 0
+}
+
 func readDirNames(dirname string) ([]string, error) {
 	f, err := os.Open(dirname)
 	if err != nil {
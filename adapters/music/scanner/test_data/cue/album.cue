@@ -0,0 +1,12 @@
+REM GENRE Rock
+PERFORMER "Some Artist"
+TITLE "Some Album"
+FILE "album.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "First Song"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Second Song"
+    PERFORMER "Other Artist"
+    INDEX 00 03:10:00
+    INDEX 01 03:12:37
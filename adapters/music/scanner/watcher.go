@@ -0,0 +1,104 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Watcher specifies how the changes in the scanned directory are detected.
+type Watcher string
+
+const (
+	// WatcherNotify relies on the file system notifications provided by
+	// the kernel (eg. inotify).
+	WatcherNotify Watcher = "notify"
+
+	// WatcherPoll periodically lists the contents of the scanned directory.
+	// It should be used when the file system notifications aren't
+	// available eg. for network file systems.
+	WatcherPoll Watcher = "poll"
+)
+
+// changeWatcher reports the directories which have to be rescanned.
+type changeWatcher interface {
+	// Watch starts watching the specified directory. Paths of the
+	// directories which have to be rescanned are sent over the returned
+	// channel. The directories may no longer exist.
+	Watch(directory string) (<-chan string, error)
+}
+
+// ignoreFunc reports paths which are excluded from the library. Changes to
+// those paths don't have to be watched.
+type ignoreFunc func(path string) bool
+
+func newChangeWatcher(config Config, ignored ignoreFunc) changeWatcher {
+	switch config.Watcher {
+	case WatcherPoll:
+		return newPollingWatcher(config.WatcherPollingInterval, ignored)
+	default:
+		return newNotifyWatcher(ignored)
+	}
+}
+
+// debounce waits until no changes are received for the specified duration
+// and then returns all received directories. False is returned if the channel
+// was closed.
+func debounce(changes <-chan string, first string, window time.Duration) ([]string, bool) {
+	dirs := []string{first}
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+
+	for {
+		select {
+		case dir, ok := <-changes:
+			if !ok {
+				return dirs, false
+			}
+			dirs = append(dirs, dir)
+
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(window)
+		case <-timer.C:
+			return dirs, true
+		}
+	}
+}
+
+// minimalDirectories returns directories which have to be rescanned. Since
+// the directories are rescanned together with their subdirectories, the
+// subdirectories of the returned directories are not included in the result.
+func minimalDirectories(dirs []string) []string {
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) < len(dirs[j])
+	})
+
+	var result []string
+	for _, dir := range dirs {
+		if !isSubdirectoryOfAny(result, dir) {
+			result = append(result, dir)
+		}
+	}
+	return result
+}
+
+func isSubdirectoryOfAny(parents []string, dir string) bool {
+	for _, parent := range parents {
+		if isSubdirectory(parent, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSubdirectory(parent, dir string) bool {
+	return dir == parent || strings.HasPrefix(dir, parent+string(os.PathSeparator))
+}
+
+func parentDirectory(path string) string {
+	return filepath.Dir(path)
+}
@@ -0,0 +1,212 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/boreq/eggplant/adapters/music/scanner/symwalk"
+	"github.com/boreq/eggplant/logging"
+	"github.com/boreq/errors"
+	"github.com/fsnotify/fsnotify"
+)
+
+// notifyWatcher relies on the file system notifications provided by the
+// kernel (inotify on Linux). As those notifications aren't recursive each
+// subdirectory of the watched directory is watched separately.
+type notifyWatcher struct {
+	watcher *fsnotify.Watcher
+
+	// dirs stores the paths of the directories which are or were watched.
+	// As multiple events are received when a directory is removed, the
+	// directories which no longer exist remain in this map so that the
+	// events can be correctly interpreted. The value indicates whether
+	// the directory is still being watched.
+	dirs map[string]bool
+
+	// ignored directories are not watched
+	ignored ignoreFunc
+
+	mutex sync.Mutex
+	log   logging.Logger
+}
+
+func newNotifyWatcher(ignored ignoreFunc) *notifyWatcher {
+	return &notifyWatcher{
+		dirs:    make(map[string]bool),
+		ignored: ignored,
+		log:     logging.New("scanner.notifyWatcher"),
+	}
+}
+
+func (n *notifyWatcher) Watch(directory string) (<-chan string, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create a watcher")
+	}
+	n.watcher = w
+
+	if err := n.addRecursive(directory); err != nil {
+		w.Close()
+		return nil, errors.Wrap(err, "could not add a recursive watcher (consider using the polling watcher)")
+	}
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				for _, dir := range n.handleEvent(event) {
+					ch <- dir
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				n.log.Error("watcher error", "err", err)
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func (n *notifyWatcher) handleEvent(event fsnotify.Event) []string {
+	path := event.Name
+
+	if n.ignored(path) {
+		return nil
+	}
+
+	// directories which are no longer ignored have to be watched
+	if filepath.Base(path) == ignoreFileName {
+		if err := n.addRecursive(parentDirectory(path)); err != nil {
+			n.log.Error("could not watch the directory again", "path", path, "err", err)
+		}
+	}
+
+	switch {
+	case event.Op&fsnotify.Create != 0:
+		if isDirectory(path) {
+			if err := n.addRecursive(path); err != nil {
+				n.log.Error("could not watch a new directory", "path", path, "err", err)
+			}
+			return []string{path}
+		}
+		n.forget(path)
+		return []string{parentDirectory(path)}
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		if n.isKnownDirectory(path) {
+			n.removeRecursive(path)
+			return []string{path}
+		}
+		return []string{parentDirectory(path)}
+	default:
+		// directories don't have to be rescanned when their
+		// attributes change, changed files receive their own events
+		if n.isKnownDirectory(path) {
+			return nil
+		}
+		return []string{parentDirectory(path)}
+	}
+}
+
+// addRecursive watches the directory and its subdirectories. The paths which
+// can't be watched such as dangling symlinks or unreadable directories are
+// skipped the same way the scanner skips them so that they don't prevent the
+// rest of the library from being watched.
+func (n *notifyWatcher) addRecursive(directory string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	visited := make(map[string]struct{})
+
+	return symwalk.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == directory {
+				return errors.Wrap(err, "received an error")
+			}
+			return n.skip(path, err)
+		}
+
+		if !isDirectory(path) {
+			return nil
+		}
+
+		if path != directory && n.ignored(path) {
+			return filepath.SkipDir
+		}
+
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return n.skip(path, errors.Wrap(err, "could not eval a symlink"))
+		}
+
+		if _, ok := visited[realPath]; ok {
+			return filepath.SkipDir
+		}
+		visited[realPath] = struct{}{}
+
+		if err := n.watcher.Add(path); err != nil {
+			if path == directory {
+				return errors.Wrapf(err, "could not watch '%s'", path)
+			}
+			return n.skip(path, errors.Wrap(err, "could not add a watch"))
+		}
+		n.dirs[path] = true
+		return nil
+	})
+}
+
+// skip logs the path which can't be watched and skips it. The directories are
+// skipped with their contents as those can't be read or watched either.
+func (n *notifyWatcher) skip(path string, err error) error {
+	n.log.Warn("not watching a path", "path", path, "err", err)
+	if isDirectory(path) {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+func (n *notifyWatcher) removeRecursive(directory string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for dir, watched := range n.dirs {
+		if watched && isSubdirectory(directory, dir) {
+			// the watch may already be gone if the directory was
+			// removed
+			n.watcher.Remove(dir)
+			n.dirs[dir] = false
+		}
+	}
+}
+
+// forget should be called when a file is created in place of a directory.
+func (n *notifyWatcher) forget(path string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	delete(n.dirs, path)
+}
+
+func (n *notifyWatcher) isKnownDirectory(path string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	_, ok := n.dirs[path]
+	return ok
+}
+
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return info.IsDir()
+}
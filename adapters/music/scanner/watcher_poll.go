@@ -0,0 +1,92 @@
+package scanner
+
+import (
+	"os"
+	"time"
+
+	"github.com/boreq/eggplant/logging"
+	"github.com/boreq/errors"
+	"github.com/radovskyb/watcher"
+)
+
+// pollingWatcher periodically lists the contents of the watched directory and
+// compares them with the previous listing.
+type pollingWatcher struct {
+	interval time.Duration
+	ignored  ignoreFunc
+	log      logging.Logger
+}
+
+func newPollingWatcher(interval time.Duration, ignored ignoreFunc) *pollingWatcher {
+	return &pollingWatcher{
+		interval: interval,
+		ignored:  ignored,
+		log:      logging.New("scanner.pollingWatcher"),
+	}
+}
+
+func (p *pollingWatcher) Watch(directory string) (<-chan string, error) {
+	w := watcher.New()
+
+	w.AddFilterHook(func(info os.FileInfo, fullPath string) error {
+		if p.ignored(fullPath) {
+			return watcher.ErrSkip
+		}
+		return nil
+	})
+
+	if err := w.AddRecursive(directory); err != nil {
+		return nil, errors.Wrap(err, "could not add a recursive watcher")
+	}
+
+	go func() {
+		if err := w.Start(p.interval); err != nil {
+			p.log.Error("error starting the watcher", "err", err)
+		}
+	}()
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+
+		for {
+			select {
+			case event, ok := <-w.Event:
+				if !ok {
+					return
+				}
+				for _, dir := range p.eventDirectories(event) {
+					ch <- dir
+				}
+			case err := <-w.Error:
+				p.log.Error("watcher error", "err", err)
+			case <-w.Closed:
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func (p *pollingWatcher) eventDirectories(event watcher.Event) []string {
+	if event.IsDir() {
+		// directories receive write events when their contents
+		// change, the changed files receive their own events
+		if event.Op == watcher.Write || event.Op == watcher.Chmod {
+			return nil
+		}
+
+		if event.Op == watcher.Rename || event.Op == watcher.Move {
+			return []string{event.Path, event.OldPath}
+		}
+
+		return []string{event.Path}
+	}
+
+	if event.Op == watcher.Rename || event.Op == watcher.Move {
+		return []string{parentDirectory(event.Path), parentDirectory(event.OldPath)}
+	}
+
+	return []string{parentDirectory(event.Path)}
+}
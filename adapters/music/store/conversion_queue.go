@@ -0,0 +1,256 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/boreq/eggplant/application/queries"
+)
+
+type conversionPriority int
+
+const (
+	// priorityInteractive is used for the conversions which the clients
+	// are waiting for.
+	priorityInteractive conversionPriority = iota
+
+	// priorityBackground is used for the conversions which no one is
+	// waiting for yet.
+	priorityBackground
+)
+
+type queuedConversion struct {
+	conversion scheduledConversion
+	priority   conversionPriority
+	queued     time.Time
+}
+
+// conversionQueue passes the scheduled conversions to the workers. The
+// interactive conversions are always started before the background ones and
+// the background conversions never occupy all workers if there is more than
+// one so that the interactive conversions don't have to wait for them.
+type conversionQueue struct {
+	workers            int
+	interactive        []queuedConversion
+	background         []queuedConversion
+	runningInteractive int
+	runningBackground  int
+	waits              map[conversionPriority]*conversionWaits
+	signal             chan struct{}
+	mutex              sync.Mutex
+}
+
+// conversionWaits describes the time the conversions of one priority spent in
+// the queue before they were started.
+type conversionWaits struct {
+	started   int64
+	totalWait time.Duration
+}
+
+func newConversionQueue(workers int) *conversionQueue {
+	return &conversionQueue{
+		workers: workers,
+		waits: map[conversionPriority]*conversionWaits{
+			priorityInteractive: {},
+			priorityBackground:  {},
+		},
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// Push queues the conversion. A background conversion is dropped if the item
+// is already queued and an interactive conversion replaces the background
+// conversion of the same item.
+func (q *conversionQueue) Push(conversion scheduledConversion, priority conversionPriority) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	queued := queuedConversion{
+		conversion: conversion,
+		priority:   priority,
+		queued:     time.Now(),
+	}
+
+	switch priority {
+	case priorityInteractive:
+		q.background = removeQueuedItem(q.background, conversion.ItemId)
+		q.interactive = append(q.interactive, queued)
+	case priorityBackground:
+		if containsQueuedItem(q.interactive, conversion.ItemId) || containsQueuedItem(q.background, conversion.ItemId) {
+			return
+		}
+		q.background = append(q.background, queued)
+	}
+
+	q.notify()
+}
+
+// Stats returns the number of queued conversions and the time they spend
+// waiting to be started.
+func (q *conversionQueue) Stats() queries.ConversionQueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.removeCancelled()
+
+	now := time.Now()
+	return queries.ConversionQueueStats{
+		Workers:     q.workers,
+		Interactive: q.priorityStats(now, q.interactive, q.runningInteractive, q.waits[priorityInteractive]),
+		Background:  q.priorityStats(now, q.background, q.runningBackground, q.waits[priorityBackground]),
+	}
+}
+
+func (q *conversionQueue) priorityStats(now time.Time, conversions []queuedConversion, running int, waits *conversionWaits) queries.ConversionPriorityStats {
+	stats := queries.ConversionPriorityStats{
+		Queued:  len(conversions),
+		Running: running,
+		Started: waits.started,
+	}
+
+	if waits.started > 0 {
+		stats.AverageWait = (waits.totalWait / time.Duration(waits.started)).Seconds()
+	}
+
+	if len(conversions) > 0 {
+		stats.LongestWait = now.Sub(conversions[0].queued).Seconds()
+	}
+
+	return stats
+}
+
+// Run starts the workers which call the handler for each queued conversion.
+// Run blocks until the context is cancelled.
+func (q *conversionQueue) Run(ctx context.Context, handler func(conversion scheduledConversion)) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.worker(ctx, handler)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *conversionQueue) worker(ctx context.Context, handler func(conversion scheduledConversion)) {
+	for {
+		select {
+		case <-q.signal:
+			for {
+				queued, ok := q.pop()
+				if !ok {
+					break
+				}
+
+				handler(queued.conversion)
+				q.done(queued.priority)
+
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *conversionQueue) pop() (queuedConversion, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.removeCancelled()
+
+	var queued queuedConversion
+	switch {
+	case len(q.interactive) > 0:
+		queued = q.interactive[0]
+		q.interactive = q.interactive[1:]
+		q.runningInteractive++
+	case len(q.background) > 0 && q.runningBackground < q.backgroundWorkers():
+		queued = q.background[0]
+		q.background = q.background[1:]
+		q.runningBackground++
+	default:
+		return queuedConversion{}, false
+	}
+
+	waits := q.waits[queued.priority]
+	waits.started++
+	waits.totalWait += time.Since(queued.queued)
+
+	// wake up another worker if there is more work to do
+	if len(q.interactive) > 0 || len(q.background) > 0 {
+		q.notify()
+	}
+
+	return queued, true
+}
+
+func (q *conversionQueue) done(priority conversionPriority) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	switch priority {
+	case priorityInteractive:
+		q.runningInteractive--
+	case priorityBackground:
+		q.runningBackground--
+	}
+
+	// the background conversions could have been waiting for a worker
+	if len(q.background) > 0 {
+		q.notify()
+	}
+}
+
+// backgroundWorkers returns the number of workers which can run the
+// background conversions at the same time.
+func (q *conversionQueue) backgroundWorkers() int {
+	if q.workers > 1 {
+		return q.workers - 1
+	}
+	return 1
+}
+
+// removeCancelled removes the interactive conversions which no one waits for
+// anymore.
+func (q *conversionQueue) removeCancelled() {
+	var interactive []queuedConversion
+	for _, queued := range q.interactive {
+		if queued.conversion.Ctx.Err() == nil {
+			interactive = append(interactive, queued)
+		}
+	}
+	q.interactive = interactive
+}
+
+func (q *conversionQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func containsQueuedItem(conversions []queuedConversion, itemId string) bool {
+	for _, queued := range conversions {
+		if queued.conversion.ItemId == itemId {
+			return true
+		}
+	}
+	return false
+}
+
+func removeQueuedItem(conversions []queuedConversion, itemId string) []queuedConversion {
+	var result []queuedConversion
+	for _, queued := range conversions {
+		if queued.conversion.ItemId != itemId {
+			result = append(result, queued)
+		}
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversionQueuePrefersInteractiveConversions(t *testing.T) {
+	q := newConversionQueue(2)
+
+	q.Push(newTestConversion(context.Background(), "background1"), priorityBackground)
+	q.Push(newTestConversion(context.Background(), "background2"), priorityBackground)
+	q.Push(newTestConversion(context.Background(), "interactive"), priorityInteractive)
+
+	stats := q.Stats()
+	require.Equal(t, 2, stats.Workers)
+	require.Equal(t, 1, stats.Interactive.Queued)
+	require.Equal(t, 2, stats.Background.Queued)
+
+	queued, ok := q.pop()
+	require.True(t, ok)
+	require.Equal(t, "interactive", queued.conversion.ItemId)
+
+	queued, ok = q.pop()
+	require.True(t, ok)
+	require.Equal(t, "background1", queued.conversion.ItemId)
+
+	// one worker is reserved for the interactive conversions
+	_, ok = q.pop()
+	require.False(t, ok)
+
+	stats = q.Stats()
+	require.Equal(t, 1, stats.Interactive.Running)
+	require.Equal(t, int64(1), stats.Interactive.Started)
+	require.Equal(t, 1, stats.Background.Running)
+	require.Equal(t, 1, stats.Background.Queued)
+
+	q.done(priorityBackground)
+
+	queued, ok = q.pop()
+	require.True(t, ok)
+	require.Equal(t, "background2", queued.conversion.ItemId)
+}
+
+func TestConversionQueueDeduplicatesBackgroundConversions(t *testing.T) {
+	q := newConversionQueue(1)
+
+	q.Push(newTestConversion(context.Background(), "a"), priorityBackground)
+	q.Push(newTestConversion(context.Background(), "a"), priorityBackground)
+	q.Push(newTestConversion(context.Background(), "b"), priorityBackground)
+	q.Push(newTestConversion(context.Background(), "b"), priorityInteractive)
+	q.Push(newTestConversion(context.Background(), "b"), priorityBackground)
+
+	stats := q.Stats()
+	require.Equal(t, 1, stats.Interactive.Queued)
+	require.Equal(t, 1, stats.Background.Queued)
+}
+
+func TestConversionQueueSkipsCancelledConversions(t *testing.T) {
+	q := newConversionQueue(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.Push(newTestConversion(ctx, "cancelled"), priorityInteractive)
+	q.Push(newTestConversion(context.Background(), "a"), priorityInteractive)
+	cancel()
+
+	require.Equal(t, 1, q.Stats().Interactive.Queued)
+
+	queued, ok := q.pop()
+	require.True(t, ok)
+	require.Equal(t, "a", queued.conversion.ItemId)
+}
+
+func newTestConversion(ctx context.Context, id string) scheduledConversion {
+	return scheduledConversion{
+		ItemId:    id,
+		Ctx:       ctx,
+		ErrCh:     make(chan error, 1),
+		StartedCh: make(chan *conversionProgress, 1),
+	}
+}
@@ -0,0 +1,259 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/boreq/errors"
+)
+
+const (
+	id3HeaderSize      = 10
+	id3FrameHeaderSize = 10
+
+	id3EncodingISO88591 = 0
+	id3EncodingUTF16    = 1
+	id3EncodingUTF16BE  = 2
+	id3EncodingUTF8     = 3
+
+	// id3TimestampMilliseconds is the only supported timestamp format of
+	// the SYLT frames, the other one uses MPEG frames.
+	id3TimestampMilliseconds = 2
+)
+
+// readID3Lyrics reads the lyrics stored in the USLT and SYLT frames of the
+// ID3v2.3 or ID3v2.4 tag located at the beginning of the file. Synchronized
+// lyrics take precedence. Tags which use unsynchronisation and frames which
+// are compressed or encrypted are not supported.
+func readID3Lyrics(r io.Reader) (Lyrics, bool, error) {
+	header := make([]byte, id3HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Lyrics{}, false, nil
+		}
+		return Lyrics{}, false, errors.Wrap(err, "could not read the header")
+	}
+
+	if !bytes.Equal(header[:3], []byte("ID3")) {
+		return Lyrics{}, false, nil
+	}
+
+	version := header[3]
+	if version != 3 && version != 4 {
+		return Lyrics{}, false, nil
+	}
+
+	flags := header[5]
+	if flags&0x80 != 0 {
+		return Lyrics{}, false, nil
+	}
+
+	body := make([]byte, syncsafeInt(header[6:10]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Lyrics{}, false, errors.Wrap(err, "could not read the tag")
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && len(body) >= 4 {
+		if version == 3 {
+			pos = 4 + int(binary.BigEndian.Uint32(body[:4]))
+		} else {
+			pos = syncsafeInt(body[:4])
+		}
+	}
+
+	var unsynchronized *Lyrics
+	for pos+id3FrameHeaderSize <= len(body) {
+		frameHeader := body[pos : pos+id3FrameHeaderSize]
+		if frameHeader[0] == 0 {
+			break // padding
+		}
+
+		id := string(frameHeader[:4])
+		size := int(binary.BigEndian.Uint32(frameHeader[4:8]))
+		if version == 4 {
+			size = syncsafeInt(frameHeader[4:8])
+		}
+		formatFlags := frameHeader[9]
+
+		pos += id3FrameHeaderSize
+		if size < 0 || pos+size > len(body) {
+			return Lyrics{}, false, fmt.Errorf("frame '%s' exceeds the tag", id)
+		}
+		data := body[pos : pos+size]
+		pos += size
+
+		if formatFlags != 0 {
+			continue
+		}
+
+		switch id {
+		case "SYLT":
+			lyrics, ok, err := parseSYLT(data)
+			if err != nil {
+				return Lyrics{}, false, errors.Wrap(err, "could not parse the SYLT frame")
+			}
+			if ok {
+				return lyrics, true, nil
+			}
+		case "USLT":
+			if unsynchronized != nil {
+				continue
+			}
+			lyrics, err := parseUSLT(data)
+			if err != nil {
+				return Lyrics{}, false, errors.Wrap(err, "could not parse the USLT frame")
+			}
+			unsynchronized = &lyrics
+		}
+	}
+
+	if unsynchronized != nil {
+		return *unsynchronized, true, nil
+	}
+
+	return Lyrics{}, false, nil
+}
+
+// parseUSLT parses the contents of a frame containing unsynchronized lyrics.
+func parseUSLT(data []byte) (Lyrics, error) {
+	if len(data) < 4 {
+		return Lyrics{}, errors.New("frame too short")
+	}
+
+	encoding := data[0]
+	_, rest, err := readID3String(encoding, data[4:]) // description
+	if err != nil {
+		return Lyrics{}, errors.Wrap(err, "could not read the description")
+	}
+
+	text, err := decodeID3String(encoding, rest)
+	if err != nil {
+		return Lyrics{}, errors.Wrap(err, "could not read the text")
+	}
+
+	return parseLyrics(text), nil
+}
+
+// parseSYLT parses the contents of a frame containing synchronized lyrics.
+// False is returned if the frame uses an unsupported timestamp format.
+func parseSYLT(data []byte) (Lyrics, bool, error) {
+	if len(data) < 6 {
+		return Lyrics{}, false, errors.New("frame too short")
+	}
+
+	encoding := data[0]
+	if data[4] != id3TimestampMilliseconds {
+		return Lyrics{}, false, nil
+	}
+
+	_, rest, err := readID3String(encoding, data[6:]) // description
+	if err != nil {
+		return Lyrics{}, false, errors.Wrap(err, "could not read the description")
+	}
+
+	var lyrics Lyrics
+	for len(rest) > 0 {
+		var text string
+		text, rest, err = readID3String(encoding, rest)
+		if err != nil {
+			return Lyrics{}, false, errors.Wrap(err, "could not read the text")
+		}
+
+		if len(rest) < 4 {
+			return Lyrics{}, false, errors.New("missing timestamp")
+		}
+		timestamp := time.Duration(binary.BigEndian.Uint32(rest[:4])) * time.Millisecond
+		rest = rest[4:]
+
+		lyrics.Lines = append(lyrics.Lines, LyricsLine{
+			Time: timestamp,
+			Text: trimLyricsLine(text),
+		})
+	}
+
+	if len(lyrics.Lines) == 0 {
+		return Lyrics{}, false, nil
+	}
+
+	return lyrics, true, nil
+}
+
+// trimLyricsLine removes the line breaks which are often placed at the
+// beginning of the lines stored in SYLT frames.
+func trimLyricsLine(s string) string {
+	return strings.Trim(s, "\r\n ")
+}
+
+// readID3String reads a terminated string and returns the remaining data.
+// Strings which aren't terminated are read until the end of the data.
+func readID3String(encoding byte, data []byte) (string, []byte, error) {
+	end, terminatorLength := -1, 1
+	switch encoding {
+	case id3EncodingISO88591, id3EncodingUTF8:
+		end = bytes.IndexByte(data, 0)
+	case id3EncodingUTF16, id3EncodingUTF16BE:
+		terminatorLength = 2
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown encoding '%d'", encoding)
+	}
+
+	if end < 0 {
+		s, err := decodeID3String(encoding, data)
+		return s, nil, err
+	}
+
+	s, err := decodeID3String(encoding, data[:end])
+	return s, data[end+terminatorLength:], err
+}
+
+func decodeID3String(encoding byte, data []byte) (string, error) {
+	switch encoding {
+	case id3EncodingISO88591:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	case id3EncodingUTF8:
+		return string(bytes.TrimRight(data, "\x00")), nil
+	case id3EncodingUTF16, id3EncodingUTF16BE:
+		var order binary.ByteOrder = binary.BigEndian
+		if len(data) >= 2 {
+			if data[0] == 0xff && data[1] == 0xfe {
+				order = binary.LittleEndian
+				data = data[2:]
+			} else if data[0] == 0xfe && data[1] == 0xff {
+				data = data[2:]
+			}
+		}
+
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			units = append(units, order.Uint16(data[i:]))
+		}
+		return string(utf16.Decode(units)), nil
+	default:
+		return "", fmt.Errorf("unknown encoding '%d'", encoding)
+	}
+}
+
+// syncsafeInt decodes an integer which uses only 7 bits of each byte.
+func syncsafeInt(b []byte) int {
+	var n int
+	for _, v := range b {
+		n = n<<7 | int(v&0x7f)
+	}
+	return n
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadID3Lyrics(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Input      []byte
+		ExpectedOk bool
+		Expected   Lyrics
+	}{
+		{
+			Name:       "not_id3",
+			Input:      []byte("fLaC"),
+			ExpectedOk: false,
+		},
+		{
+			Name: "no_lyrics",
+			Input: id3Tag(3,
+				id3Frame(3, "TIT2", append([]byte{id3EncodingUTF8}, "Title"...)),
+			),
+			ExpectedOk: false,
+		},
+		{
+			Name: "uslt",
+			Input: id3Tag(3,
+				id3Frame(3, "USLT", concat(
+					[]byte{id3EncodingISO88591},
+					[]byte("eng"),
+					[]byte("description\x00"),
+					[]byte("First line\nSecond line"),
+				)),
+			),
+			ExpectedOk: true,
+			Expected: Lyrics{
+				Text: "First line\nSecond line",
+			},
+		},
+		{
+			Name: "uslt_utf16",
+			Input: id3Tag(4,
+				id3Frame(4, "USLT", concat(
+					[]byte{id3EncodingUTF16},
+					[]byte("eng"),
+					[]byte{0xff, 0xfe, 0x00, 0x00},
+					[]byte{0xff, 0xfe, 'Z', 0x00, 0x7c, 0x01},
+				)),
+			),
+			ExpectedOk: true,
+			Expected: Lyrics{
+				Text: "Zż",
+			},
+		},
+		{
+			Name: "sylt_takes_precedence",
+			Input: id3Tag(4,
+				id3Frame(4, "USLT", concat(
+					[]byte{id3EncodingUTF8},
+					[]byte("eng"),
+					[]byte("\x00"),
+					[]byte("Unsynchronized"),
+				)),
+				id3Frame(4, "SYLT", concat(
+					[]byte{id3EncodingUTF8},
+					[]byte("eng"),
+					[]byte{id3TimestampMilliseconds, 1},
+					[]byte("\x00"),
+					[]byte("First line\x00"), uint32Bytes(1000),
+					[]byte("\nSecond line\x00"), uint32Bytes(2500),
+				)),
+			),
+			ExpectedOk: true,
+			Expected: Lyrics{
+				Lines: []LyricsLine{
+					{Time: time.Second, Text: "First line"},
+					{Time: 2*time.Second + 500*time.Millisecond, Text: "Second line"},
+				},
+			},
+		},
+		{
+			Name: "sylt_with_mpeg_frames",
+			Input: id3Tag(3,
+				id3Frame(3, "SYLT", concat(
+					[]byte{id3EncodingUTF8},
+					[]byte("eng"),
+					[]byte{1, 1},
+					[]byte("\x00"),
+					[]byte("First line\x00"), uint32Bytes(1000),
+				)),
+			),
+			ExpectedOk: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			lyrics, ok, err := readID3Lyrics(bytes.NewReader(testCase.Input))
+			require.NoError(t, err)
+			require.Equal(t, testCase.ExpectedOk, ok)
+			require.Equal(t, testCase.Expected, lyrics)
+		})
+	}
+}
+
+func id3Tag(version byte, frames ...[]byte) []byte {
+	body := concat(frames...)
+	body = append(body, make([]byte, 16)...) // padding
+
+	header := []byte{'I', 'D', '3', version, 0, 0}
+	header = append(header, syncsafeBytes(len(body))...)
+	return append(header, body...)
+}
+
+func id3Frame(version byte, id string, data []byte) []byte {
+	frame := []byte(id)
+	if version == 4 {
+		frame = append(frame, syncsafeBytes(len(data))...)
+	} else {
+		frame = append(frame, uint32Bytes(uint32(len(data)))...)
+	}
+	frame = append(frame, 0, 0)
+	return append(frame, data...)
+}
+
+func syncsafeBytes(n int) []byte {
+	return []byte{
+		byte(n >> 21 & 0x7f),
+		byte(n >> 14 & 0x7f),
+		byte(n >> 7 & 0x7f),
+		byte(n & 0x7f),
+	}
+}
+
+func uint32Bytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
+
+func concat(slices ...[]byte) []byte {
+	var result []byte
+	for _, slice := range slices {
+		result = append(result, slice...)
+	}
+	return result
+}
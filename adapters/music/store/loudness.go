@@ -0,0 +1,197 @@
+package store
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/boreq/errors"
+)
+
+const (
+	// ReferenceLoudness is the loudness in LUFS to which the tracks are
+	// normalized. This is the reference used by ReplayGain 2.0.
+	ReferenceLoudness = -18
+
+	// r128ReferenceLoudness is the loudness in LUFS used as a reference by
+	// the R128 gain tags.
+	r128ReferenceLoudness = -23
+
+	// silenceLoudness is the loudness in LUFS reported for silent tracks.
+	// Such tracks aren't normalized.
+	silenceLoudness = -70
+)
+
+// GainToLoudness converts the gain to the loudness of the track.
+func GainToLoudness(gain float64) float64 {
+	return ReferenceLoudness - gain
+}
+
+// LoudnessToGain converts the loudness of the track to the gain which
+// normalizes it.
+func LoudnessToGain(loudness float64) float64 {
+	return ReferenceLoudness - loudness
+}
+
+// parseReplayGain parses ReplayGain tags such as "-6.54 dB".
+func parseReplayGain(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && strings.EqualFold(s[len(s)-2:], "db") {
+		s = strings.TrimSpace(s[:len(s)-2])
+	}
+
+	gain, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(gain) || math.IsInf(gain, 0) {
+		return 0, false
+	}
+	return gain, true
+}
+
+// parseR128Gain parses R128 gain tags used by Opus files. Those tags store
+// the gain in the Q7.8 format relative to the R128 reference loudness.
+func parseR128Gain(s string) (float64, bool) {
+	q, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, false
+	}
+	return float64(q)/256 + ReferenceLoudness - r128ReferenceLoudness, true
+}
+
+// probeGain returns the gain stored in the ReplayGain or R128 tags.
+func probeGain(tags probeTags, replayGainKey, r128Key string) *float64 {
+	if gain, ok := parseReplayGain(tags.get(replayGainKey)); ok {
+		return &gain
+	}
+
+	if gain, ok := parseR128Gain(tags.get(r128Key)); ok {
+		return &gain
+	}
+
+	return nil
+}
+
+// parseReplayGainPeak parses ReplayGain peak tags such as "0.988525". The
+// peaks are expressed as linear amplitudes where 1.0 is the full scale.
+func parseReplayGainPeak(s string) (float64, bool) {
+	peak, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(peak) || math.IsInf(peak, 0) || peak <= 0 {
+		return 0, false
+	}
+	return peak, true
+}
+
+// probePeak returns the peak stored in the ReplayGain tags.
+func probePeak(tags probeTags, replayGainKey string) *float64 {
+	if peak, ok := parseReplayGainPeak(tags.get(replayGainKey)); ok {
+		return &peak
+	}
+	return nil
+}
+
+// loudnessMeasurement is the result of measuring the loudness of an item.
+type loudnessMeasurement struct {
+	// Loudness is the integrated loudness in LUFS. It is set only if the
+	// item isn't silent.
+	Loudness *float64
+
+	// Peak is the highest absolute sample value where 1.0 is the full
+	// scale. It is set only if it was reported.
+	Peak *float64
+}
+
+// errLoudnessNotMeasurable is returned if the item can't be decoded or ffmpeg
+// didn't report its loudness. Measuring the loudness of such items again
+// wouldn't help unlike after ffmpeg is killed or fails to read the file.
+var errLoudnessNotMeasurable = errors.New("loudness can't be measured")
+
+// undecodableMessages are printed by ffmpeg if the file is corrupted or it
+// doesn't contain audio.
+var undecodableMessages = []string{
+	"Invalid data found when processing input",
+	"does not contain any stream",
+	"matches no streams",
+	"no decoder found",
+}
+
+// isUndecodable checks if ffmpeg failed as the file can't be decoded.
+func isUndecodable(stderr string) bool {
+	for _, message := range undecodableMessages {
+		if strings.Contains(stderr, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEBUR128Output extracts the integrated loudness and the sample peak
+// from the summary printed by the ebur128 filter.
+func parseEBUR128Output(s string) (loudnessMeasurement, error) {
+	var measurement loudnessMeasurement
+
+	loudness, ok, err := parseEBUR128Summary(s)
+	if err != nil {
+		return loudnessMeasurement{}, errors.Wrap(err, "could not parse the loudness")
+	}
+
+	if ok {
+		measurement.Loudness = &loudness
+	}
+
+	if peak, ok := parseEBUR128Peak(s); ok {
+		measurement.Peak = &peak
+	}
+
+	return measurement, nil
+}
+
+var ebur128SamplePeak = regexp.MustCompile(`Sample peak:\s*Peak:\s*(-?[0-9.]+|-inf) dBFS`)
+
+// parseEBUR128Peak extracts the sample peak from the summary printed by the
+// ebur128 filter and converts it to a linear amplitude. False is returned if
+// the peak wasn't reported or the item is silent.
+func parseEBUR128Peak(s string) (float64, bool) {
+	matches := ebur128SamplePeak.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+
+	value := matches[len(matches)-1][1]
+	if value == "-inf" {
+		return 0, false
+	}
+
+	peak, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return math.Pow(10, peak/20), true
+}
+
+var ebur128IntegratedLoudness = regexp.MustCompile(`Integrated loudness:\s*I:\s*(-?[0-9.]+|-inf) LUFS`)
+
+// parseEBUR128Summary extracts the integrated loudness from the summary
+// printed by the ebur128 filter. False is returned for silent tracks.
+func parseEBUR128Summary(s string) (float64, bool, error) {
+	matches := ebur128IntegratedLoudness.FindAllStringSubmatch(s, -1)
+	if len(matches) == 0 {
+		return 0, false, errors.New("summary not found")
+	}
+
+	value := matches[len(matches)-1][1]
+	if value == "-inf" {
+		return 0, false, nil
+	}
+
+	loudness, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "could not parse the loudness")
+	}
+
+	if loudness <= silenceLoudness {
+		return 0, false, nil
+	}
+
+	return loudness, true, nil
+}
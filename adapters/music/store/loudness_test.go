@@ -0,0 +1,153 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReplayGain(t *testing.T) {
+	testCases := []struct {
+		Input      string
+		Expected   float64
+		ExpectedOk bool
+	}{
+		{Input: "-6.54 dB", Expected: -6.54, ExpectedOk: true},
+		{Input: "+1.20 dB", Expected: 1.2, ExpectedOk: true},
+		{Input: "3.5dB", Expected: 3.5, ExpectedOk: true},
+		{Input: "-2", Expected: -2, ExpectedOk: true},
+		{Input: "", ExpectedOk: false},
+		{Input: "dB", ExpectedOk: false},
+		{Input: "loud", ExpectedOk: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Input, func(t *testing.T) {
+			gain, ok := parseReplayGain(testCase.Input)
+			require.Equal(t, testCase.ExpectedOk, ok)
+			require.InDelta(t, testCase.Expected, gain, 0.0001)
+		})
+	}
+}
+
+func TestParseR128Gain(t *testing.T) {
+	gain, ok := parseR128Gain("-512")
+	require.True(t, ok)
+	require.InDelta(t, 3, gain, 0.0001)
+
+	gain, ok = parseR128Gain("0")
+	require.True(t, ok)
+	require.InDelta(t, 5, gain, 0.0001)
+
+	_, ok = parseR128Gain("-1.5 dB")
+	require.False(t, ok)
+}
+
+func TestParseEBUR128Summary(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Input         string
+		Expected      float64
+		ExpectedOk    bool
+		ExpectedError bool
+	}{
+		{
+			Name: "summary",
+			Input: `[Parsed_ebur128_0 @ 0x55d5c8a0] Summary:
+
+  Integrated loudness:
+    I:         -11.2 LUFS
+    Threshold: -21.4 LUFS
+
+  Loudness range:
+    LRA:         5.3 LU
+`,
+			Expected:   -11.2,
+			ExpectedOk: true,
+		},
+		{
+			Name:       "silence",
+			Input:      "Integrated loudness:\n    I:         -70.0 LUFS\n",
+			ExpectedOk: false,
+		},
+		{
+			Name:       "infinity",
+			Input:      "Integrated loudness:\n    I:         -inf LUFS\n",
+			ExpectedOk: false,
+		},
+		{
+			Name:          "missing",
+			Input:         "Output file is empty, nothing was encoded",
+			ExpectedError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			loudness, ok, err := parseEBUR128Summary(testCase.Input)
+			if testCase.ExpectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.ExpectedOk, ok)
+			require.InDelta(t, testCase.Expected, loudness, 0.0001)
+		})
+	}
+}
+
+func TestParseEBUR128Output(t *testing.T) {
+	measurement, err := parseEBUR128Output(`[Parsed_ebur128_0 @ 0x55d5c8a0] Summary:
+
+  Integrated loudness:
+    I:         -11.2 LUFS
+    Threshold: -21.4 LUFS
+
+  Loudness range:
+    LRA:         5.3 LU
+
+  Sample peak:
+    Peak:       -6.0 dBFS
+`)
+	require.NoError(t, err)
+	require.NotNil(t, measurement.Loudness)
+	require.InDelta(t, -11.2, *measurement.Loudness, 0.0001)
+	require.NotNil(t, measurement.Peak)
+	require.InDelta(t, 0.5012, *measurement.Peak, 0.0001)
+
+	measurement, err = parseEBUR128Output("Integrated loudness:\n    I:         -inf LUFS\nSample peak:\n    Peak:       -inf dBFS\n")
+	require.NoError(t, err)
+	require.Nil(t, measurement.Loudness)
+	require.Nil(t, measurement.Peak)
+
+	_, err = parseEBUR128Output("Output file is empty, nothing was encoded")
+	require.Error(t, err)
+}
+
+func TestParseReplayGainPeak(t *testing.T) {
+	peak, ok := parseReplayGainPeak("0.988525")
+	require.True(t, ok)
+	require.InDelta(t, 0.988525, peak, 0.0001)
+
+	_, ok = parseReplayGainPeak("0")
+	require.False(t, ok)
+
+	_, ok = parseReplayGainPeak("loud")
+	require.False(t, ok)
+}
+
+func TestIsUndecodable(t *testing.T) {
+	require.True(t, isUndecodable("/music/a.mp3: Invalid data found when processing input"))
+	require.True(t, isUndecodable("Stream map '0:a:0' matches no streams."))
+	require.False(t, isUndecodable("/music/a.mp3: No such file or directory"))
+	require.False(t, isUndecodable(""))
+}
+
+func TestLoudnessToGain(t *testing.T) {
+	require.InDelta(t, -6.8, LoudnessToGain(-11.2), 0.0001)
+	require.InDelta(t, -11.2, GainToLoudness(LoudnessToGain(-11.2)), 0.0001)
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boreq/errors"
+)
+
+// Lyrics are either synchronized with the track or consist only of plain
+// text.
+type Lyrics struct {
+	// Lines are set if the lyrics are synchronized with the track.
+	Lines []LyricsLine
+
+	// Text is set if the lyrics aren't synchronized with the track.
+	Text string
+}
+
+// LyricsLine is a line which should be displayed at the specified time.
+type LyricsLine struct {
+	Time time.Duration
+	Text string
+}
+
+// ReadLyricsFile reads the lyrics stored in an LRC or a plain text file.
+func ReadLyricsFile(path string) (Lyrics, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Lyrics{}, errors.Wrap(err, "could not read the file")
+	}
+	return parseLyrics(string(b)), nil
+}
+
+var (
+	lrcTimestamp     = regexp.MustCompile(`^\[(\d+):(\d+(?:[.:]\d+)?)\]`)
+	lrcWordTimestamp = regexp.MustCompile(`<\d+:\d+(?:[.:]\d+)?>`)
+	lrcOffset        = regexp.MustCompile(`^\[offset:\s*([+-]?\d+)\s*\]$`)
+)
+
+// parseLyrics parses lyrics in the LRC format. If the lyrics don't contain
+// any timestamps they are treated as plain text. Word timestamps used by the
+// enhanced LRC format are removed.
+func parseLyrics(s string) Lyrics {
+	var lines []LyricsLine
+	var offset time.Duration
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+
+		if submatches := lrcOffset.FindStringSubmatch(line); submatches != nil {
+			milliseconds, err := strconv.Atoi(submatches[1])
+			if err == nil {
+				offset = time.Duration(milliseconds) * time.Millisecond
+			}
+			continue
+		}
+
+		var times []time.Duration
+		for {
+			submatches := lrcTimestamp.FindStringSubmatch(line)
+			if submatches == nil {
+				break
+			}
+			t, err := parseLRCTime(submatches[1], submatches[2])
+			if err != nil {
+				break
+			}
+			times = append(times, t)
+			line = line[len(submatches[0]):]
+		}
+
+		text := strings.TrimSpace(lrcWordTimestamp.ReplaceAllString(line, ""))
+		for _, t := range times {
+			lines = append(lines, LyricsLine{Time: t, Text: text})
+		}
+	}
+
+	if len(lines) == 0 {
+		return Lyrics{
+			Text: strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n")),
+		}
+	}
+
+	// a positive offset means that the lyrics should be displayed earlier
+	for i := range lines {
+		lines[i].Time -= offset
+		if lines[i].Time < 0 {
+			lines[i].Time = 0
+		}
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Time < lines[j].Time
+	})
+
+	return Lyrics{
+		Lines: lines,
+	}
+}
+
+func parseLRCTime(minutes, seconds string) (time.Duration, error) {
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not parse minutes")
+	}
+
+	s, err := strconv.ParseFloat(strings.Replace(seconds, ":", ".", 1), 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not parse seconds")
+	}
+
+	return time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second)), nil
+}
+
+// isLyricsTag checks if the tag reported by ffprobe contains lyrics. ID3
+// USLT frames are reported as "lyrics" followed by the language and the
+// description, Vorbis comments usually use "LYRICS" or "UNSYNCEDLYRICS".
+func isLyricsTag(key string) bool {
+	key = strings.ToLower(key)
+	return key == "lyrics" || strings.HasPrefix(key, "lyrics-") || key == "unsyncedlyrics"
+}
+
+// findProbeLyrics returns the lyrics stored in the tags listed in the JSON
+// output of ffprobe invoked with the -show_format and -show_streams flags.
+func findProbeLyrics(b []byte) (Lyrics, bool, error) {
+	tags, err := parseProbeTags(b)
+	if err != nil {
+		return Lyrics{}, false, errors.Wrap(err, "could not parse the tags")
+	}
+
+	var keys []string
+	for key := range tags {
+		if isLyricsTag(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return Lyrics{}, false, nil
+	}
+
+	sort.Strings(keys)
+	return parseLyrics(tags[keys[0]]), true, nil
+}
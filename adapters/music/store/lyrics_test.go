@@ -0,0 +1,111 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLyrics(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Input    string
+		Expected Lyrics
+	}{
+		{
+			Name:  "plain_text",
+			Input: "First line\r\nSecond line\r\n",
+			Expected: Lyrics{
+				Text: "First line\nSecond line",
+			},
+		},
+		{
+			Name: "lrc",
+			Input: `[ar:Artist]
+[ti:Title]
+[00:12.00]First line
+[00:17.20]Second line
+[01:02.5]Third line
+`,
+			Expected: Lyrics{
+				Lines: []LyricsLine{
+					{Time: 12 * time.Second, Text: "First line"},
+					{Time: 17*time.Second + 200*time.Millisecond, Text: "Second line"},
+					{Time: time.Minute + 2*time.Second + 500*time.Millisecond, Text: "Third line"},
+				},
+			},
+		},
+		{
+			Name: "multiple_timestamps",
+			Input: `[00:30.00][00:10.00]Chorus
+[00:20.00]Verse
+`,
+			Expected: Lyrics{
+				Lines: []LyricsLine{
+					{Time: 10 * time.Second, Text: "Chorus"},
+					{Time: 20 * time.Second, Text: "Verse"},
+					{Time: 30 * time.Second, Text: "Chorus"},
+				},
+			},
+		},
+		{
+			Name: "offset",
+			Input: `[offset:+500]
+[00:00.20]First line
+[00:10.00]Second line
+`,
+			Expected: Lyrics{
+				Lines: []LyricsLine{
+					{Time: 0, Text: "First line"},
+					{Time: 9*time.Second + 500*time.Millisecond, Text: "Second line"},
+				},
+			},
+		},
+		{
+			Name:  "enhanced",
+			Input: `[00:01.00]<00:01.00>First <00:01.50>line`,
+			Expected: Lyrics{
+				Lines: []LyricsLine{
+					{Time: time.Second, Text: "First line"},
+				},
+			},
+		},
+		{
+			Name:  "empty_line",
+			Input: "[00:01.00]First line\n[00:05.00]\n",
+			Expected: Lyrics{
+				Lines: []LyricsLine{
+					{Time: time.Second, Text: "First line"},
+					{Time: 5 * time.Second, Text: ""},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			require.Equal(t, testCase.Expected, parseLyrics(testCase.Input))
+		})
+	}
+}
+
+func TestFindProbeLyrics(t *testing.T) {
+	lyrics, ok, err := findProbeLyrics([]byte(`{
+		"streams": [
+			{
+				"codec_type": "audio",
+				"tags": {
+					"UNSYNCEDLYRICS": "First line\nSecond line"
+				}
+			}
+		]
+	}`))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Lyrics{Text: "First line\nSecond line"}, lyrics)
+
+	_, ok, err = findProbeLyrics([]byte(`{"format": {"tags": {"title": "Title"}}}`))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
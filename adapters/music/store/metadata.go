@@ -0,0 +1,245 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boreq/errors"
+)
+
+// Metadata describes a track. It is extracted from the track file itself
+// and includes the information stored in the tags embedded in the file
+// (ID3, Vorbis comments, MP4 atoms etc.). Fields which couldn't be determined
+// are set to their zero values.
+type Metadata struct {
+	Duration    time.Duration `json:"duration"`
+	Title       string        `json:"title"`
+	Artist      string        `json:"artist"`
+	AlbumArtist string        `json:"albumArtist"`
+	Album       string        `json:"album"`
+	TrackNumber int           `json:"trackNumber"`
+	DiscNumber  int           `json:"discNumber"`
+	Year        int           `json:"year"`
+	Genre       string        `json:"genre"`
+
+	// Picture is a fingerprint of the cover art embedded in the file. It is
+	// used to determine if the cover art of different files is the same. If
+	// the file doesn't contain embedded cover art this field is empty.
+	Picture string `json:"picture"`
+
+	// Lyrics is set if the file contains embedded lyrics.
+	Lyrics bool `json:"lyrics"`
+
+	// TrackGain and AlbumGain normalize the loudness of the track in dB.
+	// They are read from the ReplayGain or R128 tags. If the file doesn't
+	// contain the track gain then it is measured in the background and set
+	// later. The album gain is never measured as that requires knowing the
+	// other tracks of the album. Unknown values are set to nil.
+	TrackGain *float64 `json:"trackGain"`
+	AlbumGain *float64 `json:"albumGain"`
+
+	// TrackPeak and AlbumPeak are the highest absolute sample values of
+	// the track and of the album where 1.0 is the full scale. They are
+	// read from the ReplayGain tags or measured together with the loudness
+	// and limit the gain so that the tracks don't clip. Unknown values are
+	// set to nil.
+	TrackPeak *float64 `json:"trackPeak"`
+	AlbumPeak *float64 `json:"albumPeak"`
+
+	// LoudnessMeasured is set once the loudness of the file was measured
+	// or turned out to be impossible to measure eg. because the file is
+	// silent so that the file isn't decoded again.
+	LoudnessMeasured bool `json:"loudnessMeasured"`
+
+	// Format is the name of the container format and Codec is the name of
+	// the codec of the first audio stream as reported by ffprobe eg. "ogg"
+	// and "opus". Bitrate of the audio is expressed in bits per second and
+	// is zero if it isn't known.
+	Format  string `json:"format"`
+	Codec   string `json:"codec"`
+	Bitrate int    `json:"bitrate"`
+}
+
+type probeOutput struct {
+	Streams []probeStream `json:"streams"`
+	Format  probeFormat   `json:"format"`
+}
+
+type probeStream struct {
+	CodecType   string            `json:"codec_type"`
+	CodecName   string            `json:"codec_name"`
+	BitRate     string            `json:"bit_rate"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	Disposition probeDisposition  `json:"disposition"`
+	Tags        map[string]string `json:"tags"`
+}
+
+type probeDisposition struct {
+	AttachedPic int `json:"attached_pic"`
+}
+
+type probeFormat struct {
+	FormatName string            `json:"format_name"`
+	Duration   string            `json:"duration"`
+	BitRate    string            `json:"bit_rate"`
+	Tags       map[string]string `json:"tags"`
+}
+
+// parseProbeOutput parses the JSON output of ffprobe invoked with the
+// -show_format and -show_streams flags.
+func parseProbeOutput(b []byte) (Metadata, error) {
+	var output probeOutput
+	if err := json.Unmarshal(b, &output); err != nil {
+		return Metadata{}, errors.Wrap(err, "json unmarshal failed")
+	}
+
+	var metadata Metadata
+
+	if output.Format.Duration != "" {
+		duration, err := time.ParseDuration(strings.TrimSpace(output.Format.Duration) + "s")
+		if err != nil {
+			return Metadata{}, errors.Wrap(err, "could not parse the duration")
+		}
+		metadata.Duration = duration
+	}
+
+	tags := collectProbeTags(output)
+
+	metadata.Title = tags.get("title")
+	metadata.Artist = tags.get("artist")
+	metadata.AlbumArtist = tags.get("album_artist", "albumartist", "album artist")
+	metadata.Album = tags.get("album")
+	metadata.TrackNumber = parseNumber(tags.get("track", "tracknumber"))
+	metadata.DiscNumber = parseNumber(tags.get("disc", "discnumber"))
+	metadata.Year = parseNumber(tags.get("date", "year", "originaldate"))
+	metadata.Genre = tags.get("genre")
+
+	for key := range tags {
+		if isLyricsTag(key) {
+			metadata.Lyrics = true
+		}
+	}
+
+	metadata.TrackGain = probeGain(tags, "replaygain_track_gain", "r128_track_gain")
+	metadata.AlbumGain = probeGain(tags, "replaygain_album_gain", "r128_album_gain")
+	metadata.TrackPeak = probePeak(tags, "replaygain_track_peak")
+	metadata.AlbumPeak = probePeak(tags, "replaygain_album_peak")
+
+	metadata.Picture = probePicture(output)
+
+	metadata.Format = output.Format.FormatName
+	metadata.Bitrate = parseNumber(output.Format.BitRate)
+	for _, stream := range output.Streams {
+		if stream.CodecType == "audio" {
+			metadata.Codec = stream.CodecName
+			// the bitrate of the stream excludes the overhead of the
+			// container and isn't known for some codecs eg. FLAC
+			if bitrate := parseNumber(stream.BitRate); bitrate != 0 {
+				metadata.Bitrate = bitrate
+			}
+			break
+		}
+	}
+
+	return metadata, nil
+}
+
+// parseProbeTags returns the tags listed in the JSON output of ffprobe
+// invoked with the -show_format and -show_streams flags.
+func parseProbeTags(b []byte) (probeTags, error) {
+	var output probeOutput
+	if err := json.Unmarshal(b, &output); err != nil {
+		return nil, errors.Wrap(err, "json unmarshal failed")
+	}
+	return collectProbeTags(output), nil
+}
+
+// collectProbeTags merges the tags of the container and its audio streams.
+// Containers such as FLAC or MP3 store the tags at the format level whereas
+// Ogg stores the Vorbis comments in the audio stream.
+func collectProbeTags(output probeOutput) probeTags {
+	tags := newProbeTags()
+	tags.add(output.Format.Tags)
+	for _, stream := range output.Streams {
+		if stream.CodecType == "audio" {
+			tags.add(stream.Tags)
+		}
+	}
+	return tags
+}
+
+// probePicture returns the fingerprint of the attached picture eg. embedded
+// cover art or an empty string if the output doesn't list one. The
+// fingerprint is derived from the codec, dimensions and tags of the picture
+// reported by ffprobe so that the picture doesn't have to be extracted from
+// each file. Different pictures which share all of those properties are
+// treated as the same picture.
+func probePicture(output probeOutput) string {
+	for _, stream := range output.Streams {
+		if stream.CodecType == "video" && stream.Disposition.AttachedPic != 0 {
+			var keys []string
+			for key := range stream.Tags {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			h := sha256.New()
+			fmt.Fprintf(h, "%s\x00%d\x00%d", stream.CodecName, stream.Width, stream.Height)
+			for _, key := range keys {
+				fmt.Fprintf(h, "\x00%s\x00%s", key, stream.Tags[key])
+			}
+			return hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	return ""
+}
+
+// probeTags stores tags using lowercase keys as different formats use
+// different conventions. Tags which were added first take precedence.
+type probeTags map[string]string
+
+func newProbeTags() probeTags {
+	return make(probeTags)
+}
+
+func (t probeTags) add(tags map[string]string) {
+	for key, value := range tags {
+		key = strings.ToLower(key)
+		value = strings.TrimSpace(value)
+		if _, ok := t[key]; !ok && value != "" {
+			t[key] = value
+		}
+	}
+}
+
+func (t probeTags) get(keys ...string) string {
+	for _, key := range keys {
+		if value, ok := t[key]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseNumber extracts the leading number from values such as "3/12" or
+// "1999-05-01". Zero is returned if the value doesn't start with a number.
+func parseNumber(s string) int {
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if end >= 0 {
+		s = s[:end]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
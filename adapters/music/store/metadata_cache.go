@@ -0,0 +1,140 @@
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/boreq/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltMetadataCache persists the metadata of the tracks so that the tracks
+// don't have to be probed again after a restart. The metadata is keyed by item
+// ids which change whenever the underlying files change.
+type BoltMetadataCache struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// metadataBucket changes whenever fields are added to the metadata so that
+// the files are probed again. The buckets listed in oldMetadataBuckets are
+// removed.
+var metadataBucket = []byte("metadata_v7")
+
+var oldMetadataBuckets = [][]byte{
+	[]byte("metadata"),
+	[]byte("metadata_v2"),
+	[]byte("metadata_v3"),
+	[]byte("metadata_v4"),
+	[]byte("metadata_v5"),
+	[]byte("metadata_v6"),
+}
+
+func NewBoltMetadataCache(db *bolt.DB) (*BoltMetadataCache, error) {
+	bucket := metadataBucket
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, oldBucket := range oldMetadataBuckets {
+			if tx.Bucket(oldBucket) != nil {
+				if err := tx.DeleteBucket(oldBucket); err != nil {
+					return errors.Wrap(err, "could not delete an old bucket")
+				}
+			}
+		}
+
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "could not create a bucket")
+	}
+
+	return &BoltMetadataCache{
+		db:     db,
+		bucket: bucket,
+	}, nil
+}
+
+// Get returns the metadata of the specified item. If the metadata isn't
+// present in the cache false is returned.
+func (c *BoltMetadataCache) Get(id string) (Metadata, bool, error) {
+	var metadata Metadata
+	var ok bool
+
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		j := tx.Bucket(c.bucket).Get([]byte(id))
+		if j == nil {
+			return nil
+		}
+
+		if err := json.Unmarshal(j, &metadata); err != nil {
+			return errors.Wrap(err, "json unmarshal failed")
+		}
+
+		ok = true
+		return nil
+	}); err != nil {
+		return Metadata{}, false, errors.Wrap(err, "transaction failed")
+	}
+
+	return metadata, ok, nil
+}
+
+// Put inserts the metadata of the specified item into the cache, the
+// previous entry is overwritten.
+func (c *BoltMetadataCache) Put(id string, metadata Metadata) error {
+	j, err := json.Marshal(metadata)
+	if err != nil {
+		return errors.Wrap(err, "marshaling to json failed")
+	}
+
+	return c.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(c.bucket).Put([]byte(id), j)
+	})
+}
+
+// Remove removes the metadata of the specified items.
+func (c *BoltMetadataCache) Remove(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return c.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(c.bucket)
+		for _, id := range ids {
+			if err := b.Delete([]byte(id)); err != nil {
+				return errors.Wrap(err, "delete failed")
+			}
+		}
+		return nil
+	})
+}
+
+// Retain removes the metadata of all items which are not present in the
+// provided list.
+func (c *BoltMetadataCache) Retain(ids []string) error {
+	existingIds := make(map[string]struct{})
+	for _, id := range ids {
+		existingIds[id] = struct{}{}
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(c.bucket)
+
+		var toRemove [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			if _, ok := existingIds[string(k)]; !ok {
+				toRemove = append(toRemove, k)
+			}
+			return nil
+		}); err != nil {
+			return errors.Wrap(err, "iteration failed")
+		}
+
+		for _, k := range toRemove {
+			if err := b.Delete(k); err != nil {
+				return errors.Wrap(err, "delete failed")
+			}
+		}
+
+		return nil
+	})
+}
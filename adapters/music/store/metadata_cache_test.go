@@ -0,0 +1,64 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/adapters/music/store"
+	"github.com/boreq/eggplant/internal/fixture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltMetadataCache(t *testing.T) {
+	db, cleanup := fixture.Bolt(t)
+	defer cleanup()
+
+	c, err := store.NewBoltMetadataCache(db)
+	require.NoError(t, err)
+
+	_, ok, err := c.Get("id1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	metadata := store.Metadata{
+		Duration:    10 * time.Second,
+		Title:       "title",
+		TrackNumber: 1,
+	}
+
+	err = c.Put("id1", metadata)
+	require.NoError(t, err)
+
+	err = c.Put("id2", metadata)
+	require.NoError(t, err)
+
+	result, ok, err := c.Get("id1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, metadata, result)
+
+	err = c.Put("id3", metadata)
+	require.NoError(t, err)
+
+	err = c.Retain([]string{"id2", "id3"})
+	require.NoError(t, err)
+
+	_, ok, err = c.Get("id1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, ok, err = c.Get("id2")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	err = c.Remove([]string{"id2"})
+	require.NoError(t, err)
+
+	_, ok, err = c.Get("id2")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, ok, err = c.Get("id3")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
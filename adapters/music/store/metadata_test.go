@@ -0,0 +1,268 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProbeOutput(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Input    string
+		Expected Metadata
+	}{
+		{
+			Name:     "empty",
+			Input:    `{}`,
+			Expected: Metadata{},
+		},
+		{
+			Name: "id3",
+			Input: `{
+				"streams": [
+					{
+						"codec_type": "audio"
+					}
+				],
+				"format": {
+					"duration": "215.510204",
+					"tags": {
+						"title": "Title",
+						"artist": "Artist",
+						"album_artist": "Album artist",
+						"album": "Album",
+						"track": "3/12",
+						"disc": "1/2",
+						"date": "1999",
+						"genre": "Rock"
+					}
+				}
+			}`,
+			Expected: Metadata{
+				Duration:    215510204 * time.Microsecond,
+				Title:       "Title",
+				Artist:      "Artist",
+				AlbumArtist: "Album artist",
+				Album:       "Album",
+				TrackNumber: 3,
+				DiscNumber:  1,
+				Year:        1999,
+				Genre:       "Rock",
+			},
+		},
+		{
+			Name: "vorbis_comments_in_stream",
+			Input: `{
+				"streams": [
+					{
+						"codec_type": "audio",
+						"tags": {
+							"TITLE": "Title",
+							"ARTIST": "Artist",
+							"ALBUMARTIST": "Album artist",
+							"ALBUM": "Album",
+							"TRACKNUMBER": "07",
+							"DISCNUMBER": "2",
+							"DATE": "2004-05-01",
+							"GENRE": "Jazz"
+						}
+					}
+				],
+				"format": {
+					"duration": "10.000000"
+				}
+			}`,
+			Expected: Metadata{
+				Duration:    10 * time.Second,
+				Title:       "Title",
+				Artist:      "Artist",
+				AlbumArtist: "Album artist",
+				Album:       "Album",
+				TrackNumber: 7,
+				DiscNumber:  2,
+				Year:        2004,
+				Genre:       "Jazz",
+			},
+		},
+		{
+			Name: "format_tags_take_precedence",
+			Input: `{
+				"streams": [
+					{
+						"codec_type": "audio",
+						"tags": {
+							"title": "Stream title"
+						}
+					},
+					{
+						"codec_type": "video",
+						"tags": {
+							"artist": "Cover artist"
+						}
+					}
+				],
+				"format": {
+					"tags": {
+						"TITLE": "Format title"
+					}
+				}
+			}`,
+			Expected: Metadata{
+				Title: "Format title",
+			},
+		},
+		{
+			Name: "malformed_numbers",
+			Input: `{
+				"format": {
+					"tags": {
+						"track": "A1",
+						"date": "unknown"
+					}
+				}
+			}`,
+			Expected: Metadata{},
+		},
+		{
+			Name: "replay_gain",
+			Input: `{
+				"format": {
+					"tags": {
+						"REPLAYGAIN_TRACK_GAIN": "-6.54 dB",
+						"REPLAYGAIN_ALBUM_GAIN": "+1.20 dB",
+						"REPLAYGAIN_TRACK_PEAK": "0.988525",
+						"REPLAYGAIN_ALBUM_PEAK": "1.000000"
+					}
+				}
+			}`,
+			Expected: Metadata{
+				TrackGain: float64Ptr(-6.54),
+				AlbumGain: float64Ptr(1.2),
+				TrackPeak: float64Ptr(0.988525),
+				AlbumPeak: float64Ptr(1),
+			},
+		},
+		{
+			Name: "r128_gain",
+			Input: `{
+				"streams": [
+					{
+						"codec_type": "audio",
+						"tags": {
+							"R128_TRACK_GAIN": "-512"
+						}
+					}
+				]
+			}`,
+			Expected: Metadata{
+				TrackGain: float64Ptr(3),
+			},
+		},
+		{
+			Name: "stream_bitrate",
+			Input: `{
+				"streams": [
+					{
+						"codec_type": "video",
+						"codec_name": "mjpeg"
+					},
+					{
+						"codec_type": "audio",
+						"codec_name": "opus",
+						"bit_rate": "128000"
+					}
+				],
+				"format": {
+					"format_name": "ogg",
+					"bit_rate": "131072"
+				}
+			}`,
+			Expected: Metadata{
+				Format:  "ogg",
+				Codec:   "opus",
+				Bitrate: 128000,
+			},
+		},
+		{
+			Name: "format_bitrate",
+			Input: `{
+				"streams": [
+					{
+						"codec_type": "audio",
+						"codec_name": "flac"
+					}
+				],
+				"format": {
+					"format_name": "flac",
+					"bit_rate": "912345"
+				}
+			}`,
+			Expected: Metadata{
+				Format:  "flac",
+				Codec:   "flac",
+				Bitrate: 912345,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			metadata, err := parseProbeOutput([]byte(testCase.Input))
+			require.NoError(t, err)
+			require.Equal(t, testCase.Expected, metadata)
+		})
+	}
+}
+
+func TestProbePicture(t *testing.T) {
+	picture := func(width int, comment string) string {
+		return fmt.Sprintf(`{
+			"streams": [
+				{
+					"codec_type": "audio"
+				},
+				{
+					"codec_type": "video",
+					"codec_name": "mjpeg",
+					"width": %d,
+					"height": 500,
+					"disposition": {
+						"default": 0,
+						"attached_pic": 1
+					},
+					"tags": {
+						"comment": "%s"
+					}
+				}
+			]
+		}`, width, comment)
+	}
+
+	probe := func(input string) string {
+		metadata, err := parseProbeOutput([]byte(input))
+		require.NoError(t, err)
+		return metadata.Picture
+	}
+
+	require.Empty(t, probe(`{}`))
+	require.Empty(t, probe(`{
+		"streams": [
+			{
+				"codec_type": "video",
+				"disposition": {
+					"default": 1,
+					"attached_pic": 0
+				}
+			}
+		]
+	}`))
+
+	front := probe(picture(500, "Cover (front)"))
+	require.NotEmpty(t, front)
+	require.Equal(t, front, probe(picture(500, "Cover (front)")))
+	require.NotEqual(t, front, probe(picture(600, "Cover (front)")))
+	require.NotEqual(t, front, probe(picture(500, "Cover (back)")))
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/errors"
+)
+
+// originalFormat describes a format of the original files which can be
+// played by the clients.
+type originalFormat struct {
+	// format and codec are the names reported by ffprobe.
+	format string
+	codec  string
+
+	// mediaType and codecs are used in the media types advertised by the
+	// clients eg. "audio/ogg; codecs=opus".
+	mediaType string
+	codecs    string
+}
+
+var originalFormats = []originalFormat{
+	{format: "mp3", codec: "mp3", mediaType: "audio/mpeg", codecs: "mp3"},
+	{format: "ogg", codec: "opus", mediaType: "audio/ogg", codecs: "opus"},
+	{format: "ogg", codec: "vorbis", mediaType: "audio/ogg", codecs: "vorbis"},
+	{format: "ogg", codec: "flac", mediaType: "audio/ogg", codecs: "flac"},
+	{format: "flac", codec: "flac", mediaType: "audio/flac", codecs: "flac"},
+	{format: "mov,mp4,m4a,3gp,3g2,mj2", codec: "aac", mediaType: "audio/mp4", codecs: "mp4a.40.2"},
+	{format: "mov,mp4,m4a,3gp,3g2,mj2", codec: "alac", mediaType: "audio/mp4", codecs: "alac"},
+	{format: "aac", codec: "aac", mediaType: "audio/aac", codecs: "mp4a.40.2"},
+	{format: "matroska,webm", codec: "opus", mediaType: "audio/webm", codecs: "opus"},
+	{format: "matroska,webm", codec: "vorbis", mediaType: "audio/webm", codecs: "vorbis"},
+	{format: "wav", codec: "pcm_s16le", mediaType: "audio/wav", codecs: "1"},
+}
+
+func findOriginalFormat(metadata Metadata) (originalFormat, bool) {
+	for _, format := range originalFormats {
+		if format.format == metadata.Format && format.codec == metadata.Codec {
+			return format, true
+		}
+	}
+	return originalFormat{}, false
+}
+
+// GetOriginalTrack returns the original file if the client can play it and
+// its bitrate doesn't exceed the configured limit. False is returned if the
+// direct streaming is disabled, the item wasn't probed yet or it occupies
+// only a part of the file.
+func (s *TrackStore) GetOriginalTrack(id string, formats music.PlayableFormats) (music.ConvertedFile, bool, error) {
+	if s.directStreamingMaxBitrate <= 0 {
+		return music.ConvertedFile{}, false, nil
+	}
+
+	item, ok := s.getLockedItem(id)
+	if !ok || item.Start > 0 || item.End > 0 {
+		return music.ConvertedFile{}, false, nil
+	}
+
+	metadata, ok := s.GetMetadata(id)
+	if !ok {
+		return music.ConvertedFile{}, false, nil
+	}
+
+	format, ok := findOriginalFormat(metadata)
+	if !ok {
+		return music.ConvertedFile{}, false, nil
+	}
+
+	if metadata.Bitrate <= 0 || metadata.Bitrate > s.directStreamingMaxBitrate*1000 {
+		return music.ConvertedFile{}, false, nil
+	}
+
+	if !formats.CanPlay(format.mediaType, format.codecs, metadata.Bitrate) {
+		return music.ConvertedFile{}, false, nil
+	}
+
+	f, err := os.Open(item.Path)
+	if err != nil {
+		return music.ConvertedFile{}, false, errors.Wrap(err, "could not open the file")
+	}
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return music.ConvertedFile{}, false, errors.Wrap(err, "stat failed")
+	}
+
+	return music.ConvertedFile{
+		Name:        filepath.Base(item.Path),
+		Modtime:     fileInfo.ModTime(),
+		ContentType: format.mediaType,
+		Content:     f,
+	}, true, nil
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/application/music"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOriginalTrack(t *testing.T) {
+	dir := t.TempDir()
+
+	mp3 := filepath.Join(dir, "track.mp3")
+	require.NoError(t, os.WriteFile(mp3, []byte("content"), 0600))
+
+	gain := -3.0
+	cache := mockMetadataCache{
+		"mp3":     {Format: "mp3", Codec: "mp3", Bitrate: 192000, TrackGain: &gain},
+		"lossy":   {Format: "mp3", Codec: "mp3", Bitrate: 320000, TrackGain: &gain},
+		"flac":    {Format: "flac", Codec: "flac", Bitrate: 900000, TrackGain: &gain},
+		"unknown": {Format: "mp3", Codec: "mp3", TrackGain: &gain},
+		"cue":     {Format: "mp3", Codec: "mp3", Bitrate: 192000, TrackGain: &gain},
+	}
+
+	newTrackStore := func(t *testing.T, maxBitrate int) *TrackStore {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		s, err := NewTrackStore(ctx, dir, cache, &mockEventPublisher{}, []TranscodingProfile{
+			{Name: "default", Codec: "libopus", Container: "ogg"},
+		}, maxBitrate, 0)
+		require.NoError(t, err)
+
+		s.SetItems([]Item{
+			{Id: "mp3", Path: mp3},
+			{Id: "lossy", Path: mp3},
+			{Id: "flac", Path: mp3},
+			{Id: "unknown", Path: mp3},
+			{Id: "cue", Path: mp3, Start: time.Minute},
+		})
+		return s
+	}
+
+	formats, err := music.NewPlayableFormats([]string{"audio/mpeg", "audio/flac"}, 0)
+	require.NoError(t, err)
+
+	s := newTrackStore(t, 256)
+
+	f, ok, err := s.GetOriginalTrack("mp3", formats)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "track.mp3", f.Name)
+	require.Equal(t, "audio/mpeg", f.ContentType)
+	require.NoError(t, f.Content.Close())
+
+	for _, id := range []string{"lossy", "flac", "unknown", "cue", "missing"} {
+		_, ok, err := s.GetOriginalTrack(id, formats)
+		require.NoError(t, err, id)
+		require.False(t, ok, id)
+	}
+
+	ogg, err := music.NewPlayableFormats([]string{"audio/ogg"}, 0)
+	require.NoError(t, err)
+
+	_, ok, err = s.GetOriginalTrack("mp3", ogg)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	disabled := newTrackStore(t, 0)
+
+	_, ok, err = disabled.GetOriginalTrack("mp3", formats)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+type mockMetadataCache map[string]Metadata
+
+func (c mockMetadataCache) Get(id string) (Metadata, bool, error) {
+	metadata, ok := c[id]
+	return metadata, ok, nil
+}
+
+func (c mockMetadataCache) Put(id string, metadata Metadata) error {
+	return nil
+}
+
+func (c mockMetadataCache) Remove(ids []string) error {
+	return nil
+}
+
+func (c mockMetadataCache) Retain(ids []string) error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boreq/errors"
+)
+
+// partialFilePollEvery specifies how often a file which is being converted is
+// checked for new data after all data written so far was read.
+const partialFilePollEvery = 50 * time.Millisecond
+
+// conversionProgress is shared by the listeners which read the output of a
+// conversion while it is still running.
+type conversionProgress struct {
+	tmpOutputFile string
+	outputFile    string
+
+	done  chan struct{}
+	err   error
+	mutex sync.Mutex
+}
+
+func newConversionProgress(tmpOutputFile, outputFile string) *conversionProgress {
+	return &conversionProgress{
+		tmpOutputFile: tmpOutputFile,
+		outputFile:    outputFile,
+		done:          make(chan struct{}),
+	}
+}
+
+// finish must be called exactly once after the conversion ends.
+func (p *conversionProgress) finish(err error) {
+	p.mutex.Lock()
+	p.err = err
+	p.mutex.Unlock()
+
+	close(p.done)
+}
+
+// finished returns true and the error with which the conversion ended if it
+// already ended.
+func (p *conversionProgress) finished() (bool, error) {
+	select {
+	case <-p.done:
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		return true, p.err
+	default:
+		return false, nil
+	}
+}
+
+// partialFile reads the output of a conversion which is still running.
+// Reading blocks until more data is written or the conversion ends.
+type partialFile struct {
+	ctx      context.Context
+	progress *conversionProgress
+	file     *os.File
+}
+
+func newPartialFile(ctx context.Context, progress *conversionProgress) *partialFile {
+	return &partialFile{
+		ctx:      ctx,
+		progress: progress,
+	}
+}
+
+func (f *partialFile) Read(b []byte) (int, error) {
+	for {
+		// the conversion has to be checked before reading so that the
+		// data written right before it ended isn't skipped
+		finished, err := f.progress.finished()
+		if finished && err != nil {
+			return 0, errors.Wrap(err, "conversion failed")
+		}
+
+		n, err := f.read(b, finished)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+
+		if finished {
+			return 0, io.EOF
+		}
+
+		select {
+		case <-f.progress.done:
+		case <-time.After(partialFilePollEvery):
+		case <-f.ctx.Done():
+			return 0, f.ctx.Err()
+		}
+	}
+}
+
+// read returns io.EOF if the file wasn't created yet. The temporary file is
+// renamed once the conversion ends.
+func (f *partialFile) read(b []byte, finished bool) (int, error) {
+	if f.file == nil {
+		name := f.progress.tmpOutputFile
+		if finished {
+			name = f.progress.outputFile
+		}
+
+		file, err := os.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) && !finished {
+				return 0, io.EOF
+			}
+			return 0, errors.Wrap(err, "could not open the file")
+		}
+		f.file = file
+	}
+
+	return f.file.Read(b)
+}
+
+// Seek is not supported as the size of the file isn't known.
+func (f *partialFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("seeking a file which is being converted is not supported")
+}
+
+func (f *partialFile) Close() error {
+	if f.file != nil {
+		return f.file.Close()
+	}
+	return nil
+}
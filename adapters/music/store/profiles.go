@@ -0,0 +1,132 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/errors"
+)
+
+// TranscodingProfile describes the format of the converted tracks.
+type TranscodingProfile struct {
+	Name string
+
+	// Codec is the name of the ffmpeg encoder eg. "libopus".
+	Codec string
+
+	// Bitrate is passed to ffmpeg eg. "96k". The default bitrate of the
+	// encoder is used if it is empty.
+	Bitrate string
+
+	// Container is the extension of the converted files which selects
+	// their format eg. "ogg".
+	Container string
+
+	// SampleRate in Hz and the number of channels of the track are
+	// preserved if they are zero.
+	SampleRate int
+	Channels   int
+}
+
+type container struct {
+	contentType string
+
+	// progressive is set if the files can be played while they are being
+	// written. Other formats are finalized by seeking back to the header.
+	progressive bool
+}
+
+var containers = map[string]container{
+	"ogg":  {contentType: "audio/ogg", progressive: true},
+	"oga":  {contentType: "audio/ogg", progressive: true},
+	"opus": {contentType: "audio/ogg", progressive: true},
+	"mp3":  {contentType: "audio/mpeg", progressive: true},
+	"m4a":  {contentType: "audio/mp4"},
+	"aac":  {contentType: "audio/aac", progressive: true},
+	"flac": {contentType: "audio/flac"},
+	"webm": {contentType: "audio/webm", progressive: true},
+	"wav":  {contentType: "audio/wav"},
+}
+
+// ContentType returns the media type of the converted files.
+func (p TranscodingProfile) ContentType() string {
+	return containers[p.Container].contentType
+}
+
+// Progressive returns true if the converted files can be played while they
+// are being written.
+func (p TranscodingProfile) Progressive() bool {
+	return containers[p.Container].progressive
+}
+
+func (p TranscodingProfile) validate() error {
+	if _, err := music.NewTranscodingProfile(p.Name); err != nil || p.Name == "" {
+		return fmt.Errorf("invalid name '%s'", p.Name)
+	}
+
+	if p.Codec == "" {
+		return errors.New("codec can not be empty")
+	}
+
+	if _, ok := containers[p.Container]; !ok {
+		return fmt.Errorf("unsupported container '%s'", p.Container)
+	}
+
+	if p.SampleRate < 0 {
+		return errors.New("sample rate can not be negative")
+	}
+
+	if p.Channels < 0 {
+		return errors.New("number of channels can not be negative")
+	}
+
+	return nil
+}
+
+// transcodingProfiles holds the configured profiles. The first profile is
+// the default one.
+type transcodingProfiles struct {
+	profiles []TranscodingProfile
+}
+
+func newTranscodingProfiles(profiles []TranscodingProfile) (transcodingProfiles, error) {
+	if len(profiles) == 0 {
+		return transcodingProfiles{}, errors.New("at least one profile is required")
+	}
+
+	names := make(map[string]bool)
+	for _, profile := range profiles {
+		if err := profile.validate(); err != nil {
+			return transcodingProfiles{}, errors.Wrapf(err, "invalid profile '%s'", profile.Name)
+		}
+
+		if names[profile.Name] {
+			return transcodingProfiles{}, fmt.Errorf("duplicate profile '%s'", profile.Name)
+		}
+		names[profile.Name] = true
+	}
+
+	return transcodingProfiles{
+		profiles: profiles,
+	}, nil
+}
+
+// get returns the profile with the specified name or the default profile if
+// the name is empty.
+func (p transcodingProfiles) get(name string) (TranscodingProfile, bool) {
+	if name == "" {
+		return p.defaultProfile(), true
+	}
+
+	for _, profile := range p.profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+
+	return TranscodingProfile{}, false
+}
+
+func (p transcodingProfiles) defaultProfile() TranscodingProfile {
+	return p.profiles[0]
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// workQueue lists the ids of the items which should be processed by
+// background workers. Each id is queued at most once until it is forgotten.
+type workQueue struct {
+	ids    []string
+	queued map[string]struct{}
+	signal chan struct{}
+	mutex  sync.Mutex
+}
+
+func newWorkQueue() *workQueue {
+	return &workQueue{
+		queued: make(map[string]struct{}),
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// Push queues the id unless it was already queued.
+func (q *workQueue) Push(id string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, ok := q.queued[id]; ok {
+		return
+	}
+
+	q.queued[id] = struct{}{}
+	q.ids = append(q.ids, id)
+	q.notify()
+}
+
+// Len returns the number of ids which are waiting to be processed.
+func (q *workQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.ids)
+}
+
+// Forget removes the ids from the queue so that they can be queued again.
+func (q *workQueue) Forget(ids []string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, id := range ids {
+		delete(q.queued, id)
+	}
+	q.removeForgotten()
+}
+
+// Retain forgets all ids which aren't present in the provided map.
+func (q *workQueue) Retain(ids map[string]bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for id := range q.queued {
+		if !ids[id] {
+			delete(q.queued, id)
+		}
+	}
+	q.removeForgotten()
+}
+
+// Run starts the specified number of workers which call the handler for each
+// queued id. Run blocks until the context is cancelled.
+func (q *workQueue) Run(ctx context.Context, workers int, handler func(id string)) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.worker(ctx, handler)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *workQueue) worker(ctx context.Context, handler func(id string)) {
+	for {
+		select {
+		case <-q.signal:
+			for {
+				id, ok := q.pop()
+				if !ok {
+					break
+				}
+
+				handler(id)
+
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *workQueue) pop() (string, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.ids) == 0 {
+		return "", false
+	}
+
+	id := q.ids[0]
+	q.ids = q.ids[1:]
+
+	// wake up another worker if there is more work to do
+	if len(q.ids) > 0 {
+		q.notify()
+	}
+
+	return id, true
+}
+
+func (q *workQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *workQueue) removeForgotten() {
+	var ids []string
+	for _, id := range q.ids {
+		if _, ok := q.queued[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	q.ids = ids
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := newWorkQueue()
+
+	ch := make(chan string, 10)
+	go q.Run(ctx, 1, func(id string) {
+		ch <- id
+	})
+
+	q.Push("a")
+	q.Push("b")
+	q.Push("a")
+	require.Equal(t, []string{"a", "b"}, receiveIds(t, ch, 2))
+
+	q.Push("a")
+	q.Forget([]string{"b"})
+	q.Push("b")
+
+	// the queue is processed in order so the ids pushed before the last one
+	// were already processed when it is received
+	q.Push("last")
+	require.Equal(t, []string{"b", "last"}, receiveIds(t, ch, 2))
+}
+
+func receiveIds(t *testing.T, ch <-chan string, n int) []string {
+	var ids []string
+	for i := 0; i < n; i++ {
+		select {
+		case id := <-ch:
+			ids = append(ids, id)
+		case <-time.After(5 * time.Second):
+			t.Fatal("ids weren't processed")
+		}
+	}
+	return ids
+}
+
+func TestWorkQueueRetain(t *testing.T) {
+	q := newWorkQueue()
+	q.Push("a")
+	q.Push("b")
+	q.Push("c")
+	require.Equal(t, 3, q.Len())
+
+	q.Retain(map[string]bool{"b": true})
+	require.Equal(t, 1, q.Len())
+
+	id, ok := q.pop()
+	require.True(t, ok)
+	require.Equal(t, "b", id)
+
+	_, ok = q.pop()
+	require.False(t, ok)
+	require.Equal(t, 0, q.Len())
+}
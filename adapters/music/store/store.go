@@ -4,6 +4,8 @@ package store
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"os"
 	"path"
 	"runtime"
@@ -36,38 +38,109 @@ const (
 type Item struct {
 	Id   string
 	Path string
+
+	// Start and End specify a part of the file which should be converted.
+	// If End is set to zero then the file is converted until its end. This
+	// is used for tracks described by cue sheets.
+	Start time.Duration
+	End   time.Duration
+
+	// Embedded is set if the item is an audio file and the cover art
+	// embedded in it should be converted instead of the file itself. This
+	// is used for thumbnails.
+	Embedded bool
+
+	// Gain in dB is applied to the audio during the conversion. This is
+	// used to normalize the loudness of the tracks.
+	Gain float64
+
+	// Profile is the name of the transcoding profile used to convert a
+	// track. The default profile is used if it is empty.
+	Profile string
+}
+
+// variant is an item converted with a gain applied or using a non-default
+// profile. The variants are converted and cached separately from the item
+// itself.
+type variant struct {
+	ItemId  string
+	Gain    float64
+	Profile string
+}
+
+// variantId returns the id of the file converted from the specified item
+// with the specified gain applied using the specified profile. Gains are
+// rounded to 0.1 dB so that the number of variants stays low. The names of
+// the profiles start with a letter so they can't be confused with the gains.
+func variantId(itemId string, gain float64, profile string) string {
+	id := itemId
+	if tenths := int(math.Round(gain * 10)); tenths != 0 {
+		id = fmt.Sprintf("%s_%d", id, tenths)
+	}
+	if profile != "" {
+		id = fmt.Sprintf("%s_%s", id, profile)
+	}
+	return id
+}
+
+// EventPublisher notifies the clients about the conversions.
+type EventPublisher interface {
+	Publish(event music.Event)
 }
 
 type Converter interface {
-	OutputFile(id string) string
-	TemporaryOutputFile(id string) string
+	OutputFile(item Item) string
+	TemporaryOutputFile(item Item) string
 	OutputDirectory() string
 	Convert(item Item) error
+
+	// Progressive returns true if the temporary output file of the item
+	// can be read while the item is being converted.
+	Progressive(item Item) bool
 }
 
 type Store struct {
 	items            map[string]Item      // key is item id
-	itemsAccessTimes map[string]time.Time // key is item id
+	itemsAccessTimes map[string]time.Time // key is item or variant id
+	variants         map[string]variant   // key is variant id
 
 	ongoingConversions map[string][]scheduledConversion // key is item id
-	conversionsCh      chan scheduledConversion
+	progress           map[string]*conversionProgress   // key is item id
+	conversions        *conversionQueue
 
 	mutex sync.Mutex
 
 	log       logging.Logger
 	converter Converter
+	events    EventPublisher
+	kind      music.ConversionKind
 }
 
-func NewStore(ctx context.Context, log logging.Logger, converter Converter) (*Store, error) {
+// NewStore creates a store which runs the specified number of conversions at
+// the same time. If the number of workers is zero then it is equal to the
+// number of CPUs.
+func NewStore(ctx context.Context, log logging.Logger, converter Converter, events EventPublisher, kind music.ConversionKind, workers int) (*Store, error) {
+	if workers < 0 {
+		return nil, errors.New("number of workers can not be negative")
+	}
+
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+
 	s := &Store{
 		items:            make(map[string]Item),
 		itemsAccessTimes: make(map[string]time.Time),
+		variants:         make(map[string]variant),
 
 		ongoingConversions: make(map[string][]scheduledConversion),
-		conversionsCh:      make(chan scheduledConversion),
+		progress:           make(map[string]*conversionProgress),
+		conversions:        newConversionQueue(workers),
 
 		log:       log,
 		converter: converter,
+		events:    events,
+		kind:      kind,
 	}
 
 	s.startConversionWorkers(ctx)
@@ -104,6 +177,12 @@ func (s *Store) GetStats() (queries.StoreStats, error) {
 	return stats, nil
 }
 
+// GetConversionQueueStats returns the number of queued conversions and the
+// time they spend waiting to be started.
+func (s *Store) GetConversionQueueStats() queries.ConversionQueueStats {
+	return s.conversions.Stats()
+}
+
 func (s *Store) SetItems(items []Item) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -113,14 +192,66 @@ func (s *Store) SetItems(items []Item) {
 		s.items[item.Id] = item
 	}
 
-	for itemId := range s.itemsAccessTimes {
-		if _, ok := s.items[itemId]; !ok {
-			delete(s.itemsAccessTimes, itemId)
+	s.removeStaleVariants()
+}
+
+// removeStaleVariants forgets the access times and the variants of the items
+// which no longer exist.
+func (s *Store) removeStaleVariants() {
+	for id := range s.itemsAccessTimes {
+		if _, ok := s.items[s.itemId(id)]; !ok {
+			delete(s.itemsAccessTimes, id)
 		}
 	}
+
+	for id, variant := range s.variants {
+		if _, ok := s.items[variant.ItemId]; !ok {
+			delete(s.variants, id)
+		}
+	}
+}
+
+// itemId returns the id of the item from which the file with the specified id
+// is converted.
+func (s *Store) itemId(id string) string {
+	if variant, ok := s.variants[id]; ok {
+		return variant.ItemId
+	}
+	return id
+}
+
+// AddItems adds the specified items to the store. Items with the same ids
+// are replaced.
+func (s *Store) AddItems(items []Item) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, item := range items {
+		s.items[item.Id] = item
+	}
+}
+
+// RemoveItems removes the items with the specified ids from the store.
+func (s *Store) RemoveItems(ids []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, id := range ids {
+		delete(s.items, id)
+	}
+
+	s.removeStaleVariants()
 }
 
 func (s *Store) GetConvertedFile(ctx context.Context, id string) (music.ConvertedFile, error) {
+	return s.getConvertedVariant(ctx, variant{ItemId: id})
+}
+
+// getConvertedVariant returns the item converted with the gain and using the
+// profile specified by the variant.
+func (s *Store) getConvertedVariant(ctx context.Context, v variant) (music.ConvertedFile, error) {
+	id := s.addVariant(v)
+
 	ch := make(chan ConvertedFileOrError, 0)
 	go func() {
 		convertedFile, err := s.getConvertedFile(ctx, id)
@@ -144,6 +275,9 @@ func (s *Store) GetConvertedFile(ctx context.Context, id string) (music.Converte
 	}
 }
 
+// getConvertedFile returns the converted file. If the file has to be converted
+// and the converter supports it then a partial file is returned as soon as
+// the conversion starts.
 func (s *Store) getConvertedFile(ctx context.Context, id string) (music.ConvertedFile, error) {
 	f, err := s.getFile(id)
 	if err != nil {
@@ -151,9 +285,16 @@ func (s *Store) getConvertedFile(ctx context.Context, id string) (music.Converte
 			return music.ConvertedFile{}, errors.Wrap(err, "error getting the file")
 		}
 
-		errCh := s.scheduleConversion(ctx, id)
+		conversion := s.scheduleConversion(ctx, id)
 		select {
-		case err := <-errCh:
+		case progress := <-conversion.StartedCh:
+			return music.ConvertedFile{
+				Name:    progress.outputFile,
+				Modtime: time.Now(),
+				Content: newPartialFile(ctx, progress),
+				Partial: true,
+			}, nil
+		case err := <-conversion.ErrCh:
 			if err != nil {
 				return music.ConvertedFile{}, errors.Wrap(err, "conversion error")
 			}
@@ -179,60 +320,109 @@ func (s *Store) getConvertedFile(ctx context.Context, id string) (music.Converte
 	}, nil
 }
 
+// addVariant registers the variant of the item and returns its id.
+func (s *Store) addVariant(v variant) string {
+	id := variantId(v.ItemId, v.Gain, v.Profile)
+	if id == v.ItemId {
+		return id
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	v.Gain = math.Round(v.Gain*10) / 10
+	s.variants[id] = v
+	return id
+}
+
+// getItem returns the item from which the file with the specified id should be
+// converted.
+func (s *Store) getItem(id string) (Item, bool) {
+	variant, isVariant := s.variants[id]
+	if !isVariant {
+		item, ok := s.items[id]
+		return item, ok
+	}
+
+	item, ok := s.items[variant.ItemId]
+	if !ok {
+		return Item{}, false
+	}
+	item.Id = id
+	item.Gain = variant.Gain
+	item.Profile = variant.Profile
+	return item, true
+}
+
 func (s *Store) getFile(id string) (*os.File, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, ok := s.items[id]; !ok {
+	item, ok := s.getItem(id)
+	if !ok {
 		return nil, errors.New("item does not exist")
 	}
 
 	s.itemsAccessTimes[id] = time.Now()
-	return os.Open(s.converter.OutputFile(id))
+	return os.Open(s.converter.OutputFile(item))
 }
 
-func (s *Store) scheduleConversion(ctx context.Context, id string) <-chan error {
+// scheduleConversion schedules the conversion of the item unless it is
+// already being converted in which case the returned conversion shares the
+// running one.
+func (s *Store) scheduleConversion(ctx context.Context, id string) scheduledConversion {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	errCh := make(chan error)
 	conversion := scheduledConversion{
-		ItemId: id,
-		Ctx:    ctx,
-		ErrCh:  errCh,
+		ItemId:    id,
+		Ctx:       ctx,
+		ErrCh:     make(chan error, 1),
+		StartedCh: make(chan *conversionProgress, 1),
 	}
 
-	go func() {
-		select {
-		case s.conversionsCh <- conversion:
-		case <-ctx.Done():
-			return
-		}
-	}()
+	if progress, ok := s.progress[id]; ok {
+		s.ongoingConversions[id] = append(s.ongoingConversions[id], conversion)
+		conversion.StartedCh <- progress
+		return conversion
+	}
 
-	return errCh
+	s.conversions.Push(conversion, priorityInteractive)
+	return conversion
 }
 
-func (s *Store) startConversionWorkers(ctx context.Context) {
-	for i := 0; i < runtime.NumCPU(); i++ {
-		go s.conversionWorker(ctx)
+// convertInBackground schedules a low-priority conversion of the variant. The
+// variant is marked as accessed so that it isn't removed before it is
+// requested. No one waits for the background conversions so they are never
+// cancelled.
+func (s *Store) convertInBackground(v variant) {
+	id := s.addVariant(v)
+
+	s.mutex.Lock()
+	s.itemsAccessTimes[id] = time.Now()
+	s.mutex.Unlock()
+
+	conversion := scheduledConversion{
+		ItemId:    id,
+		Ctx:       context.Background(),
+		ErrCh:     make(chan error, 1),
+		StartedCh: make(chan *conversionProgress, 1),
 	}
+
+	s.conversions.Push(conversion, priorityBackground)
+}
+
+func (s *Store) startConversionWorkers(ctx context.Context) {
+	go s.conversions.Run(ctx, s.conversionWorker)
 }
 
 func (s *Store) startCleanupWorker(ctx context.Context) {
 	go s.cleanupWorker(ctx)
 }
 
-func (s *Store) conversionWorker(ctx context.Context) {
-	for {
-		select {
-		case conversion := <-s.conversionsCh:
-			if err := s.convert(conversion); err != nil {
-				s.log.Error("conversion failed", "err", err)
-			}
-		case <-ctx.Done():
-			return
-		}
+func (s *Store) conversionWorker(conversion scheduledConversion) {
+	if err := s.convert(conversion); err != nil {
+		s.log.Error("conversion failed", "err", err)
 	}
 }
 
@@ -261,9 +451,11 @@ func (s *Store) convert(conversion scheduledConversion) (err error) {
 	if shouldConvert := s.beginConversion(conversion); !shouldConvert {
 		return nil
 	}
-	defer s.endConversion(conversion, err)
+	defer func() {
+		s.endConversion(conversion, err)
+	}()
 
-	item, ok := s.items[conversion.ItemId]
+	item, ok := s.getLockedItem(conversion.ItemId)
 	if !ok {
 		return errors.New("item does not exist")
 	}
@@ -282,19 +474,57 @@ func (s *Store) convert(conversion scheduledConversion) (err error) {
 		s.log.Debug("conversion ended", "err", err, "duration", time.Since(start))
 	}()
 
+	s.publishConversion(music.EventTypeConversionStarted, item)
+
+	if s.converter.Progressive(item) {
+		if err := s.startProgress(item); err != nil {
+			return errors.Wrap(err, "could not start tracking the progress")
+		}
+	}
+
 	if err := s.converter.Convert(item); err != nil {
+		s.publishConversion(music.EventTypeConversionFailed, item)
 		return errors.Wrapf(err, "conversion of '%s' failed", item.Path)
 	}
 
+	s.publishConversion(music.EventTypeConversionFinished, item)
 	return nil
 }
 
+// publishConversion publishes a conversion event which refers to the item
+// from which the variants are converted.
+func (s *Store) publishConversion(eventType music.EventType, item Item) {
+	s.mutex.Lock()
+	itemId := s.itemId(item.Id)
+	s.mutex.Unlock()
+
+	s.events.Publish(music.Event{
+		Type: eventType,
+		Conversion: &music.Conversion{
+			Kind:    s.kind,
+			FileId:  music.FileId(itemId),
+			Gain:    item.Gain,
+			Profile: music.TranscodingProfile(item.Profile),
+		},
+	})
+}
+
+func (s *Store) getLockedItem(id string) (Item, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.getItem(id)
+}
+
 func (s *Store) beginConversion(item scheduledConversion) bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	if _, isAlreadyBeingConverted := s.ongoingConversions[item.ItemId]; isAlreadyBeingConverted {
 		s.ongoingConversions[item.ItemId] = append(s.ongoingConversions[item.ItemId], item)
+		if progress, ok := s.progress[item.ItemId]; ok {
+			item.StartedCh <- progress
+		}
 		return false
 	}
 
@@ -302,15 +532,40 @@ func (s *Store) beginConversion(item scheduledConversion) bool {
 	return true
 }
 
+// startProgress removes the stale temporary file and lets the listeners read
+// the output of the conversion while it is running.
+func (s *Store) startProgress(item Item) error {
+	tmpOutputFile := s.converter.TemporaryOutputFile(item)
+	if err := os.Remove(tmpOutputFile); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "could not remove the temporary file")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	progress := newConversionProgress(tmpOutputFile, s.converter.OutputFile(item))
+	s.progress[item.Id] = progress
+
+	for _, scheduledConversion := range s.ongoingConversions[item.Id] {
+		scheduledConversion.StartedCh <- progress
+	}
+
+	return nil
+}
+
+// endConversion notifies the listeners. The channels are buffered so that
+// the listeners which no longer wait don't block the store.
 func (s *Store) endConversion(conversion scheduledConversion, err error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if progress, ok := s.progress[conversion.ItemId]; ok {
+		progress.finish(err)
+		delete(s.progress, conversion.ItemId)
+	}
+
 	for _, scheduledConversion := range s.ongoingConversions[conversion.ItemId] {
-		select {
-		case scheduledConversion.ErrCh <- err:
-		case <-scheduledConversion.Ctx.Done():
-		}
+		scheduledConversion.ErrCh <- err
 	}
 
 	delete(s.ongoingConversions, conversion.ItemId)
@@ -324,15 +579,19 @@ func (s *Store) cleanup() error {
 
 	filesThatCanExist := make(map[string]struct{})
 
-	for itemId, accessTime := range s.itemsAccessTimes {
+	for id, accessTime := range s.itemsAccessTimes {
 		if time.Since(accessTime) < cacheItemsFor {
-			filesThatCanExist[s.converter.OutputFile(itemId)] = struct{}{}
+			if item, ok := s.getItem(id); ok {
+				filesThatCanExist[s.converter.OutputFile(item)] = struct{}{}
+			}
 		}
 	}
 
-	for itemId := range s.ongoingConversions {
-		filesThatCanExist[s.converter.OutputFile(itemId)] = struct{}{}
-		filesThatCanExist[s.converter.TemporaryOutputFile(itemId)] = struct{}{}
+	for id := range s.ongoingConversions {
+		if item, ok := s.getItem(id); ok {
+			filesThatCanExist[s.converter.OutputFile(item)] = struct{}{}
+			filesThatCanExist[s.converter.TemporaryOutputFile(item)] = struct{}{}
+		}
 	}
 
 	dir := s.converter.OutputDirectory()
@@ -358,7 +617,7 @@ func (s *Store) cleanup() error {
 }
 
 func (s *Store) outputFileExists(item Item) (bool, error) {
-	file := s.converter.OutputFile(item.Id)
+	file := s.converter.OutputFile(item)
 	return exists(file)
 }
 
@@ -375,6 +634,11 @@ func (s *Store) ensureOutputDirectoryExists() error {
 func (s *Store) getOriginalSize() (int64, error) {
 	var sum int64
 	for _, item := range s.items {
+		// the size of the embedded cover art isn't known
+		if item.Embedded {
+			continue
+		}
+
 		fileInfo, err := os.Stat(item.Path)
 		if err != nil {
 			return 0, errors.Wrap(err, "could not stat")
@@ -417,8 +681,11 @@ func exists(file string) (bool, error) {
 	return true, nil
 }
 
+// scheduledConversion is created for each listener waiting for a conversion.
+// The channels are buffered and receive at most one value each.
 type scheduledConversion struct {
-	ItemId string
-	Ctx    context.Context
-	ErrCh  chan<- error
+	ItemId    string
+	Ctx       context.Context
+	ErrCh     chan error
+	StartedCh chan *conversionProgress
 }
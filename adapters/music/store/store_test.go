@@ -0,0 +1,274 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/eggplant/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariantId(t *testing.T) {
+	require.Equal(t, "id", variantId("id", 0, ""))
+	require.Equal(t, "id", variantId("id", 0.04, ""))
+	require.Equal(t, "id_-65", variantId("id", -6.54, ""))
+	require.Equal(t, "id_12", variantId("id", 1.2, ""))
+	require.Equal(t, "id_mp3", variantId("id", 0, "mp3"))
+	require.Equal(t, "id_12_mp3", variantId("id", 1.2, "mp3"))
+}
+
+func TestStorePublishesConversionEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	converter := &mockConverter{dir: t.TempDir(), fail: map[string]bool{"broken": true}}
+	events := &mockEventPublisher{}
+
+	s, err := NewStore(ctx, logging.New("test"), converter, events, music.ConversionKindTrack, 0)
+	require.NoError(t, err)
+
+	s.SetItems([]Item{
+		{Id: "id", Path: "path"},
+		{Id: "broken", Path: "broken_path"},
+	})
+
+	f, err := s.getConvertedVariant(ctx, variant{ItemId: "id", Gain: -6.5, Profile: "low"})
+	require.NoError(t, err)
+	f.Content.Close()
+
+	_, err = s.GetConvertedFile(ctx, "broken")
+	require.Error(t, err)
+
+	require.Equal(t, []music.Event{
+		{
+			Type:       music.EventTypeConversionStarted,
+			Conversion: &music.Conversion{Kind: music.ConversionKindTrack, FileId: "id", Gain: -6.5, Profile: "low"},
+		},
+		{
+			Type:       music.EventTypeConversionFinished,
+			Conversion: &music.Conversion{Kind: music.ConversionKindTrack, FileId: "id", Gain: -6.5, Profile: "low"},
+		},
+		{
+			Type:       music.EventTypeConversionStarted,
+			Conversion: &music.Conversion{Kind: music.ConversionKindTrack, FileId: "broken"},
+		},
+		{
+			Type:       music.EventTypeConversionFailed,
+			Conversion: &music.Conversion{Kind: music.ConversionKindTrack, FileId: "broken"},
+		},
+	}, events.Events())
+}
+
+func TestStoreConvertsInBackground(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	converter := &mockConverter{dir: t.TempDir()}
+
+	s, err := NewStore(ctx, logging.New("test"), converter, &mockEventPublisher{}, music.ConversionKindTrack, 0)
+	require.NoError(t, err)
+
+	s.SetItems([]Item{
+		{Id: "id", Path: "path"},
+	})
+
+	v := variant{ItemId: "id", Gain: -6.5, Profile: "low"}
+
+	s.convertInBackground(v)
+	s.convertInBackground(v)
+
+	require.Eventually(t, func() bool {
+		return converter.Conversions() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	f, err := s.getConvertedVariant(ctx, v)
+	require.NoError(t, err)
+	f.Content.Close()
+
+	require.Equal(t, 1, converter.Conversions())
+}
+
+func TestStoreStreamsPartialFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	converter := &mockConverter{dir: t.TempDir(), progressive: true, release: make(chan struct{})}
+
+	s, err := NewStore(ctx, logging.New("test"), converter, &mockEventPublisher{}, music.ConversionKindTrack, 0)
+	require.NoError(t, err)
+
+	s.SetItems([]Item{
+		{Id: "id", Path: "path"},
+	})
+
+	first, err := s.GetConvertedFile(ctx, "id")
+	require.NoError(t, err)
+	defer first.Content.Close()
+	require.True(t, first.Partial)
+
+	b := make([]byte, len("first"))
+	_, err = io.ReadFull(first.Content, b)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(b))
+
+	// the second listener shares the running conversion
+	second, err := s.GetConvertedFile(ctx, "id")
+	require.NoError(t, err)
+	defer second.Content.Close()
+	require.True(t, second.Partial)
+
+	close(converter.release)
+
+	b, err = io.ReadAll(first.Content)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(b))
+
+	b, err = io.ReadAll(second.Content)
+	require.NoError(t, err)
+	require.Equal(t, "firstsecond", string(b))
+
+	var complete music.ConvertedFile
+	require.Eventually(t, func() bool {
+		f, err := s.GetConvertedFile(ctx, "id")
+		require.NoError(t, err)
+		if f.Partial {
+			f.Content.Close()
+			return false
+		}
+		complete = f
+		return true
+	}, time.Second, 10*time.Millisecond)
+	defer complete.Content.Close()
+
+	b, err = io.ReadAll(complete.Content)
+	require.NoError(t, err)
+	require.Equal(t, "firstsecond", string(b))
+
+	require.Equal(t, 1, converter.Conversions())
+}
+
+func TestStorePartialFileReportsFailures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	converter := &mockConverter{
+		dir:         t.TempDir(),
+		progressive: true,
+		release:     make(chan struct{}),
+		fail:        map[string]bool{"id": true},
+	}
+
+	s, err := NewStore(ctx, logging.New("test"), converter, &mockEventPublisher{}, music.ConversionKindTrack, 0)
+	require.NoError(t, err)
+
+	s.SetItems([]Item{
+		{Id: "id", Path: "path"},
+	})
+
+	f, err := s.GetConvertedFile(ctx, "id")
+	require.NoError(t, err)
+	defer f.Content.Close()
+	require.True(t, f.Partial)
+
+	close(converter.release)
+
+	_, err = io.ReadAll(f.Content)
+	require.Error(t, err)
+}
+
+type mockConverter struct {
+	dir         string
+	fail        map[string]bool
+	progressive bool
+
+	// release is closed to let the progressive conversions finish after
+	// writing the first part of the output.
+	release chan struct{}
+
+	conversions int
+	mutex       sync.Mutex
+}
+
+func (c *mockConverter) OutputFile(item Item) string {
+	return path.Join(c.dir, item.Id)
+}
+
+func (c *mockConverter) TemporaryOutputFile(item Item) string {
+	return path.Join(c.dir, "_"+item.Id)
+}
+
+func (c *mockConverter) OutputDirectory() string {
+	return c.dir
+}
+
+func (c *mockConverter) Convert(item Item) error {
+	c.mutex.Lock()
+	c.conversions++
+	c.mutex.Unlock()
+
+	if !c.progressive {
+		if c.fail[item.Id] {
+			return errors.New("conversion failed")
+		}
+		return os.WriteFile(c.OutputFile(item), nil, 0600)
+	}
+
+	f, err := os.Create(c.TemporaryOutputFile(item))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString("first"); err != nil {
+		return err
+	}
+
+	<-c.release
+
+	if c.fail[item.Id] {
+		return errors.New("conversion failed")
+	}
+
+	if _, err := f.WriteString("second"); err != nil {
+		return err
+	}
+
+	return os.Rename(c.TemporaryOutputFile(item), c.OutputFile(item))
+}
+
+func (c *mockConverter) Progressive(item Item) bool {
+	return c.progressive
+}
+
+func (c *mockConverter) Conversions() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.conversions
+}
+
+type mockEventPublisher struct {
+	events []music.Event
+	mutex  sync.Mutex
+}
+
+func (p *mockEventPublisher) Publish(event music.Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.events = append(p.events, event)
+}
+
+func (p *mockEventPublisher) Events() []music.Event {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]music.Event(nil), p.events...)
+}
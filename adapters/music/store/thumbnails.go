@@ -1,15 +1,19 @@
 package store
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"image"
 	_ "image/gif"
 	"image/jpeg"
 	_ "image/png"
+	"io"
 	"os"
+	"os/exec"
 	"path"
 
+	"github.com/boreq/eggplant/application/music"
 	"github.com/boreq/eggplant/logging"
 	"github.com/boreq/errors"
 	"github.com/nfnt/resize"
@@ -19,10 +23,10 @@ const thumbnailSize = 200
 const thumbnailExtension = "jpg"
 const thumbnailDirectory = "thumbnails"
 
-func NewThumbnailStore(ctx context.Context, dataDir string) (*Store, error) {
+func NewThumbnailStore(ctx context.Context, dataDir string, events EventPublisher, conversionWorkers int) (*Store, error) {
 	log := logging.New("thumbnailStore")
 	converter := NewThumbnailConverter(dataDir)
-	return NewStore(ctx, log, converter)
+	return NewStore(ctx, log, converter, events, music.ConversionKindThumbnail, conversionWorkers)
 }
 
 func NewThumbnailConverter(dataDir string) *ThumbnailConverter {
@@ -39,14 +43,13 @@ type ThumbnailConverter struct {
 }
 
 func (c *ThumbnailConverter) Convert(item Item) error {
-	outputPath := c.OutputFile(item.Id)
-	tmpOutputPath := c.TemporaryOutputFile(item.Id)
+	outputPath := c.OutputFile(item)
+	tmpOutputPath := c.TemporaryOutputFile(item)
 
-	f, err := os.Open(item.Path)
+	img, err := c.decode(item)
 	if err != nil {
-		return errors.Wrap(err, "could not open the input file")
+		return errors.Wrap(err, "decoding failed")
 	}
-	defer f.Close()
 
 	output, err := os.Create(tmpOutputPath)
 	if err != nil {
@@ -54,11 +57,6 @@ func (c *ThumbnailConverter) Convert(item Item) error {
 	}
 	defer output.Close()
 
-	img, _, err := image.Decode(f)
-	if err != nil {
-		return errors.Wrap(err, "decoding failed")
-	}
-
 	resized := resize.Resize(thumbnailSize, thumbnailSize, img, resize.Lanczos3)
 
 	options := &jpeg.Options{
@@ -75,16 +73,77 @@ func (c *ThumbnailConverter) Convert(item Item) error {
 	return nil
 }
 
+func (c *ThumbnailConverter) decode(item Item) (image.Image, error) {
+	var r io.Reader
+
+	if item.Embedded {
+		picture, err := extractEmbeddedPicture(item.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not extract the embedded picture")
+		}
+		r = bytes.NewReader(picture)
+	} else {
+		f, err := os.Open(item.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not open the input file")
+		}
+		defer f.Close()
+		r = f
+	}
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "image decode failed")
+	}
+
+	return img, nil
+}
+
 func (c *ThumbnailConverter) OutputDirectory() string {
 	return path.Join(c.dataDir, thumbnailDirectory)
 }
 
-func (c *ThumbnailConverter) OutputFile(id string) string {
-	file := fmt.Sprintf("%s.%s", id, thumbnailExtension)
+func (c *ThumbnailConverter) Progressive(item Item) bool {
+	return false
+}
+
+func (c *ThumbnailConverter) OutputFile(item Item) string {
+	file := fmt.Sprintf("%s.%s", item.Id, thumbnailExtension)
 	return path.Join(c.OutputDirectory(), file)
 }
 
-func (c *ThumbnailConverter) TemporaryOutputFile(id string) string {
-	file := fmt.Sprintf("_%s.%s", id, thumbnailExtension)
+func (c *ThumbnailConverter) TemporaryOutputFile(item Item) string {
+	file := fmt.Sprintf("_%s.%s", item.Id, thumbnailExtension)
 	return path.Join(c.OutputDirectory(), file)
 }
+
+// extractEmbeddedPicture uses ffmpeg to extract the cover art embedded in an
+// audio file without reencoding it.
+func extractEmbeddedPicture(file string) ([]byte, error) {
+	args := []string{
+		"-v",
+		"error",
+		"-i",
+		file,
+		"-map",
+		"0:v:0",
+		"-c",
+		"copy",
+		"-f",
+		"image2pipe",
+		"-",
+	}
+	cmd := exec.Command("ffmpeg", args...)
+	bufErr := &bytes.Buffer{}
+	cmd.Stderr = bufErr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "ffmpeg execution failed: %s", bufErr.String())
+	}
+
+	if len(output) == 0 {
+		return nil, errors.New("ffmpeg returned no data")
+	}
+
+	return output, nil
+}
@@ -1,129 +1,445 @@
 package store
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
-	"strings"
+	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/boreq/eggplant/application/music"
 	"github.com/boreq/eggplant/logging"
 	"github.com/boreq/errors"
 )
 
 const (
-	trackExtension = "ogg"
 	trackDirectory = "tracks"
 )
 
+// MetadataCache persists the metadata so that it survives restarts.
+type MetadataCache interface {
+	Get(id string) (Metadata, bool, error)
+	Put(id string, metadata Metadata) error
+	Remove(ids []string) error
+	Retain(ids []string) error
+}
+
 type TrackStore struct {
 	*Store
-	durationCache      map[string]time.Duration
-	durationCacheMutex sync.Mutex
-	converter          *TrackConverter
-	log                logging.Logger
+	metadataCache           map[string]Metadata
+	metadataCacheMutex      sync.RWMutex
+	persistentMetadataCache MetadataCache
+	converter               *TrackConverter
+	probeQueue              *workQueue
+	loudnessQueue           *workQueue
+	probedHandlers          []func(id string)
+	probedHandlersMutex     sync.Mutex
+	log                     logging.Logger
+
+	// directStreamingMaxBitrate is expressed in kbit/s. The original files
+	// are never returned if it is zero.
+	directStreamingMaxBitrate int
+
+	// persistentMetadataCacheMutex orders the writes to the persistent
+	// cache. The writes wait for the disk so they are never performed
+	// while holding metadataCacheMutex which would block the readers.
+	persistentMetadataCacheMutex sync.Mutex
 }
 
-func NewTrackStore(ctx context.Context, dataDir string) (*TrackStore, error) {
+func NewTrackStore(ctx context.Context, dataDir string, persistentMetadataCache MetadataCache, events EventPublisher, profiles []TranscodingProfile, directStreamingMaxBitrate int, conversionWorkers int) (*TrackStore, error) {
+	if directStreamingMaxBitrate < 0 {
+		return nil, errors.New("direct streaming bitrate can not be negative")
+	}
+
 	log := logging.New("trackStore")
-	converter := NewTrackConverter(dataDir)
-	store, err := NewStore(ctx, log, converter)
+	converter, err := NewTrackConverter(dataDir, profiles)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create a converter")
+	}
+	store, err := NewStore(ctx, log, converter, events, music.ConversionKindTrack, conversionWorkers)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not create a store")
 	}
 	s := &TrackStore{
-		Store:         store,
-		durationCache: make(map[string]time.Duration),
-		converter:     converter,
-		log:           log,
+		Store:                   store,
+		metadataCache:           make(map[string]Metadata),
+		persistentMetadataCache: persistentMetadataCache,
+		converter:               converter,
+		probeQueue:              newWorkQueue(),
+		loudnessQueue:           newWorkQueue(),
+		log:                     log,
+
+		directStreamingMaxBitrate: directStreamingMaxBitrate,
 	}
+	go s.probeQueue.Run(ctx, runtime.NumCPU(), s.probe)
+
+	// measuring the loudness requires decoding the entire item so the items
+	// are processed one by one
+	go s.loudnessQueue.Run(ctx, 1, s.measureLoudness)
+
 	return s, nil
 }
 
-func (s *TrackStore) GetDuration(id string) time.Duration {
-	s.durationCacheMutex.Lock()
-	defer s.durationCacheMutex.Unlock()
+// GetConvertedTrack returns the track converted using the specified profile
+// with the specified gain applied. The default profile is used if the profile
+// is zero. Each profile is cached separately.
+func (s *TrackStore) GetConvertedTrack(ctx context.Context, id string, profile music.TranscodingProfile, gain float64) (music.ConvertedFile, error) {
+	v, p, err := s.trackVariant(id, profile, gain)
+	if err != nil {
+		return music.ConvertedFile{}, errors.Wrap(err, "could not select the variant")
+	}
+
+	file, err := s.getConvertedVariant(ctx, v)
+	if err != nil {
+		return music.ConvertedFile{}, errors.Wrap(err, "could not get the converted variant")
+	}
+
+	file.ContentType = p.ContentType()
+	return file, nil
+}
+
+// PreTranscodeTrack schedules a low-priority background conversion of the
+// track which will likely be requested soon.
+func (s *TrackStore) PreTranscodeTrack(id string, profile music.TranscodingProfile, gain float64) error {
+	v, _, err := s.trackVariant(id, profile, gain)
+	if err != nil {
+		return errors.Wrap(err, "could not select the variant")
+	}
+
+	s.convertInBackground(v)
+	return nil
+}
+
+// trackVariant returns the variant of the track converted using the specified
+// profile. The files converted using the default profile are shared with
+// GetConvertedFile.
+func (s *TrackStore) trackVariant(id string, profile music.TranscodingProfile, gain float64) (variant, TranscodingProfile, error) {
+	p, ok := s.converter.profiles.get(profile.String())
+	if !ok {
+		return variant{}, TranscodingProfile{}, errors.Wrapf(music.ErrUnknownProfile, "profile '%s'", profile)
+	}
+
+	name := p.Name
+	if name == s.converter.profiles.defaultProfile().Name {
+		name = ""
+	}
+
+	return variant{ItemId: id, Gain: gain, Profile: name}, p, nil
+}
+
+// GetMetadata returns the metadata of the specified item. This function never
+// runs external processes: the items are probed in the background after they
+// are added to the store. False is returned if the item wasn't probed yet. If
+// the metadata couldn't be extracted then a zero value is returned.
+func (s *TrackStore) GetMetadata(id string) (Metadata, bool) {
+	if _, ok := s.getLockedItem(id); !ok {
+		return Metadata{}, true
+	}
+
+	s.metadataCacheMutex.RLock()
+	metadata, ok := s.metadataCache[id]
+	s.metadataCacheMutex.RUnlock()
+
+	if ok {
+		return metadata, true
+	}
+
+	return s.loadMetadata(id)
+}
+
+// loadMetadata loads the metadata from the persistent cache. The item is
+// probed in the background if its metadata wasn't persisted.
+func (s *TrackStore) loadMetadata(id string) (Metadata, bool) {
+	metadata, ok, err := s.persistentMetadataCache.Get(id)
+	if err != nil {
+		s.log.Error("could not get the persisted metadata", "err", err)
+	}
+
+	if !ok {
+		s.probeQueue.Push(id)
+		return Metadata{}, false
+	}
+
+	s.metadataCacheMutex.Lock()
+	defer s.metadataCacheMutex.Unlock()
+
+	// the item could have been probed in the meantime
+	if cached, ok := s.metadataCache[id]; ok {
+		return cached, true
+	}
+
+	s.cacheMetadata(id, metadata)
+	return metadata, true
+}
+
+// cacheMetadata must be called with metadataCacheMutex locked.
+func (s *TrackStore) cacheMetadata(id string, metadata Metadata) {
+	s.metadataCache[id] = metadata
 
-	item, ok := s.getItem(id)
+	if (metadata.TrackGain == nil || metadata.TrackPeak == nil) && !metadata.LoudnessMeasured {
+		s.loudnessQueue.Push(id)
+	}
+}
+
+func (s *TrackStore) probe(id string) {
+	defer s.publishProgress()
+
+	// the metadata could have been already loaded from the persistent cache
+	if _, ok := s.GetMetadata(id); ok {
+		return
+	}
+
+	item, ok := s.getLockedItem(id)
 	if !ok {
-		return 0
+		return
 	}
 
-	if duration, ok := s.durationCache[id]; ok {
-		return duration
+	metadata, err := s.converter.probe(item)
+	if err != nil {
+		s.log.Debug("metadata could not be extracted", "id", id, "err", err)
 	}
 
-	duration, err := s.converter.checkDuration(item)
+	if s.storeProbedMetadata(id, metadata, err) {
+		s.notifyProbed(id)
+	}
+}
+
+// storeProbedMetadata caches the metadata extracted from the item. False is
+// returned if the metadata couldn't be extracted.
+func (s *TrackStore) storeProbedMetadata(id string, metadata Metadata, err error) bool {
+	s.metadataCacheMutex.Lock()
+
+	// the failures aren't persisted so that the item is probed again
+	// after a restart
 	if err != nil {
-		s.log.Debug("duration could not be measured", "err", err)
-		return 0
+		s.metadataCache[id] = Metadata{}
+		s.metadataCacheMutex.Unlock()
+		return false
+	}
+
+	s.cacheMetadata(id, metadata)
+	s.metadataCacheMutex.Unlock()
+
+	s.persistMetadata(id)
+	return true
+}
+
+// persistMetadata writes the cached metadata of the item to the persistent
+// cache. The latest cached metadata is written so that the metadata written
+// concurrently by the probes and the loudness measurements is never replaced
+// with its older version.
+func (s *TrackStore) persistMetadata(id string) {
+	s.persistentMetadataCacheMutex.Lock()
+	defer s.persistentMetadataCacheMutex.Unlock()
+
+	s.metadataCacheMutex.RLock()
+	metadata, ok := s.metadataCache[id]
+	s.metadataCacheMutex.RUnlock()
+
+	// the item could have been removed in the meantime
+	if !ok {
+		return
+	}
+
+	if err := s.persistentMetadataCache.Put(id, metadata); err != nil {
+		s.log.Error("could not persist the metadata", "err", err)
+	}
+}
+
+// OnProbed registers a handler which is called after the metadata of an item
+// is extracted in the background. The handler is called without holding any
+// locks so it can access the store.
+func (s *TrackStore) OnProbed(handler func(id string)) {
+	s.probedHandlersMutex.Lock()
+	defer s.probedHandlersMutex.Unlock()
+
+	s.probedHandlers = append(s.probedHandlers, handler)
+}
+
+func (s *TrackStore) notifyProbed(id string) {
+	s.probedHandlersMutex.Lock()
+	handlers := make([]func(id string), len(s.probedHandlers))
+	copy(handlers, s.probedHandlers)
+	s.probedHandlersMutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(id)
 	}
-	s.durationCache[id] = duration
-	return duration
 }
 
-func (s *TrackStore) getItem(id string) (Item, bool) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *TrackStore) measureLoudness(id string) {
+	defer s.publishProgress()
 
-	i, ok := s.items[id]
-	return i, ok
+	item, ok := s.getLockedItem(id)
+	if !ok {
+		return
+	}
+
+	measurement, err := s.converter.measureLoudness(item)
+	if err != nil {
+		s.log.Debug("loudness could not be measured", "id", id, "err", err)
+
+		// the transient failures aren't persisted so that the loudness
+		// is measured again after a restart
+		if !errors.Is(err, errLoudnessNotMeasurable) {
+			return
+		}
+	}
+
+	s.metadataCacheMutex.Lock()
+	metadata, ok := s.metadataCache[id]
+	if !ok {
+		s.metadataCacheMutex.Unlock()
+		return
+	}
+
+	// the silent items and the items which can't be decoded are marked
+	// so that they aren't decoded again after a restart
+	metadata.LoudnessMeasured = true
+
+	if measurement.Loudness != nil && metadata.TrackGain == nil {
+		gain := LoudnessToGain(*measurement.Loudness)
+		metadata.TrackGain = &gain
+	}
+
+	if metadata.TrackPeak == nil {
+		metadata.TrackPeak = measurement.Peak
+	}
+
+	s.metadataCache[id] = metadata
+	s.metadataCacheMutex.Unlock()
+
+	s.persistMetadata(id)
+}
+
+// GetEmbeddedLyrics returns the lyrics embedded in the specified item. False
+// is returned if the item doesn't contain lyrics.
+func (s *TrackStore) GetEmbeddedLyrics(id string) (Lyrics, bool, error) {
+	item, ok := s.getLockedItem(id)
+	if !ok {
+		return Lyrics{}, false, nil
+	}
+
+	lyrics, ok, err := s.converter.lyrics(item)
+	if err != nil {
+		return Lyrics{}, false, errors.Wrap(err, "could not read the lyrics")
+	}
+	return lyrics, ok, nil
 }
 
 func (s *TrackStore) SetItems(items []Item) {
-	s.cleanupDurationCache(items)
+	s.cleanupMetadataCache(items)
 	s.Store.SetItems(items)
+	s.scheduleProbes(items)
 }
 
-func (s *TrackStore) cleanupDurationCache(items []Item) {
-	s.durationCacheMutex.Lock()
-	defer s.durationCacheMutex.Unlock()
+func (s *TrackStore) AddItems(items []Item) {
+	s.Store.AddItems(items)
+	s.scheduleProbes(items)
+}
+
+func (s *TrackStore) RemoveItems(ids []string) {
+	s.removeFromMetadataCache(ids)
+	s.Store.RemoveItems(ids)
+}
+
+func (s *TrackStore) scheduleProbes(items []Item) {
+	for _, item := range items {
+		s.probeQueue.Push(item.Id)
+	}
+	s.publishProgress()
+}
+
+// publishProgress publishes the number of tracks which are waiting to be
+// processed in the background.
+func (s *TrackStore) publishProgress() {
+	s.events.Publish(music.Event{
+		Type: music.EventTypeScanProgress,
+		Progress: &music.ScanProgress{
+			PendingMetadata: s.probeQueue.Len(),
+			PendingLoudness: s.loudnessQueue.Len(),
+		},
+	})
+}
+
+func (s *TrackStore) removeFromMetadataCache(ids []string) {
+	s.persistentMetadataCacheMutex.Lock()
+	defer s.persistentMetadataCacheMutex.Unlock()
+
+	s.metadataCacheMutex.Lock()
+	for _, id := range ids {
+		delete(s.metadataCache, id)
+	}
+	s.metadataCacheMutex.Unlock()
+
+	s.probeQueue.Forget(ids)
+	s.loudnessQueue.Forget(ids)
+
+	if err := s.persistentMetadataCache.Remove(ids); err != nil {
+		s.log.Error("could not remove the persisted metadata", "err", err)
+	}
+}
+
+func (s *TrackStore) cleanupMetadataCache(items []Item) {
+	s.persistentMetadataCacheMutex.Lock()
+	defer s.persistentMetadataCacheMutex.Unlock()
 
 	existingItems := make(map[string]bool)
+	var ids []string
 	for _, item := range items {
 		existingItems[item.Id] = true
+		ids = append(ids, item.Id)
 	}
 
-	for id := range s.durationCache {
+	s.metadataCacheMutex.Lock()
+	for id := range s.metadataCache {
 		if _, exists := existingItems[id]; !exists {
-			delete(s.durationCache, id)
+			delete(s.metadataCache, id)
 		}
 	}
+	s.metadataCacheMutex.Unlock()
+
+	s.probeQueue.Retain(existingItems)
+	s.loudnessQueue.Retain(existingItems)
+
+	if err := s.persistentMetadataCache.Retain(ids); err != nil {
+		s.log.Error("could not clean up the persisted metadata", "err", err)
+	}
 }
 
 type TrackConverter struct {
-	dataDir string
-	log     logging.Logger
+	dataDir  string
+	profiles transcodingProfiles
+	log      logging.Logger
 }
 
-func NewTrackConverter(dataDir string) *TrackConverter {
+// NewTrackConverter creates a converter which uses the first of the specified
+// profiles by default.
+func NewTrackConverter(dataDir string, profiles []TranscodingProfile) (*TrackConverter, error) {
+	p, err := newTranscodingProfiles(profiles)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid transcoding profiles")
+	}
+
 	converter := &TrackConverter{
-		dataDir: dataDir,
-		log:     logging.New("trackConverter"),
+		dataDir:  dataDir,
+		profiles: p,
+		log:      logging.New("trackConverter"),
 	}
-	return converter
+	return converter, nil
 }
 
 func (c *TrackConverter) Convert(item Item) error {
-	outputPath := c.OutputFile(item.Id)
-	tmpOutputPath := c.TemporaryOutputFile(item.Id)
+	outputPath := c.OutputFile(item)
+	tmpOutputPath := c.TemporaryOutputFile(item)
 
-	args := []string{
-		"-y",
-		"-i",
-		item.Path,
-		"-vn",
-		"-c:a",
-		"libopus",
-		"-b:a",
-		"96K",
-		tmpOutputPath,
-	}
-	cmd := exec.Command("ffmpeg", args...)
+	cmd := exec.Command("ffmpeg", convertArgs(item, c.profile(item), tmpOutputPath)...)
 	bufErr := &bytes.Buffer{}
 	cmd.Stderr = bufErr
 	c.log.Debug("converting", "command", cmd.String())
@@ -139,51 +455,221 @@ func (c *TrackConverter) Convert(item Item) error {
 	return nil
 }
 
-func (c *TrackConverter) checkDuration(item Item) (time.Duration, error) {
+func convertArgs(item Item, profile TranscodingProfile, outputPath string) []string {
+	var args []string
+	args = append(args, "-y")
+
+	if item.Start > 0 {
+		args = append(args, "-ss", formatSeconds(item.Start))
+	}
+
+	args = append(args, "-i", item.Path)
+
+	if item.End > 0 {
+		args = append(args, "-t", formatSeconds(item.End-item.Start))
+	}
+
+	if item.Gain != 0 {
+		args = append(args, "-af", fmt.Sprintf("volume=%sdB", strconv.FormatFloat(item.Gain, 'f', 1, 64)))
+	}
+
+	args = append(args, "-vn", "-c:a", profile.Codec)
+
+	if profile.Bitrate != "" {
+		args = append(args, "-b:a", profile.Bitrate)
+	}
+
+	if profile.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(profile.SampleRate))
+	}
+
+	if profile.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(profile.Channels))
+	}
+
+	// the output is read while it is being written
+	if profile.Progressive() {
+		args = append(args, "-flush_packets", "1")
+	}
+
+	args = append(args, outputPath)
+	return args
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+func (c *TrackConverter) probe(item Item) (Metadata, error) {
 	filePath := item.Path
 
 	// check if a file exists at all
 	if _, err := os.Stat(filePath); err != nil {
-		return 0, errors.Wrap(err, "stat failed")
+		return Metadata{}, errors.Wrap(err, "stat failed")
+	}
+
+	output, err := c.runProbe(filePath)
+	if err != nil {
+		return Metadata{}, errors.Wrap(err, "could not probe the file")
 	}
 
+	metadata, err := parseProbeOutput(output)
+	if err != nil {
+		return Metadata{}, errors.Wrap(err, "could not parse the ffprobe output")
+	}
+
+	if !metadata.Lyrics {
+		_, ok, err := readFileID3Lyrics(filePath)
+		if err != nil {
+			c.log.Debug("ID3 lyrics could not be read", "err", err)
+		}
+		metadata.Lyrics = ok
+	}
+
+	// the tracks described by cue sheets occupy only a part of the file
+	if item.End > 0 {
+		metadata.Duration = item.End - item.Start
+	} else if item.Start > 0 && metadata.Duration > item.Start {
+		metadata.Duration -= item.Start
+	}
+
+	// the track gain and peak of a file described by a cue sheet describe
+	// the entire file
+	if item.Start > 0 || item.End > 0 {
+		metadata.TrackGain = nil
+		metadata.TrackPeak = nil
+	}
+
+	return metadata, nil
+}
+
+// measureLoudness measures the integrated loudness and the sample peak of the
+// item using the ebur128 filter. This requires decoding the entire item.
+func (c *TrackConverter) measureLoudness(item Item) (loudnessMeasurement, error) {
+	cmd := exec.Command("ffmpeg", measureLoudnessArgs(item)...)
+	bufErr := &bytes.Buffer{}
+	cmd.Stderr = bufErr
+	c.log.Debug("measuring loudness", "command", cmd.String())
+	if err := cmd.Run(); err != nil {
+		c.log.Error("command error", "stderr", bufErr.String())
+		if isUndecodable(bufErr.String()) {
+			return loudnessMeasurement{}, errors.Wrapf(errLoudnessNotMeasurable, "ffmpeg execution failed: %s", err)
+		}
+		return loudnessMeasurement{}, errors.Wrap(err, "ffmpeg execution failed")
+	}
+
+	measurement, err := parseEBUR128Output(bufErr.String())
+	if err != nil {
+		return loudnessMeasurement{}, errors.Wrapf(errLoudnessNotMeasurable, "could not parse the ffmpeg output: %s", err)
+	}
+
+	return measurement, nil
+}
+
+func measureLoudnessArgs(item Item) []string {
+	var args []string
+	args = append(args, "-nostats", "-hide_banner")
+
+	if item.Start > 0 {
+		args = append(args, "-ss", formatSeconds(item.Start))
+	}
+
+	args = append(args, "-i", item.Path)
+
+	if item.End > 0 {
+		args = append(args, "-t", formatSeconds(item.End-item.Start))
+	}
+
+	// the loudness of each frame is logged at the verbose level so that
+	// only the summary is printed
+	args = append(args,
+		"-map",
+		"0:a:0",
+		"-af",
+		"ebur128=framelog=verbose:peak=sample",
+		"-f",
+		"null",
+		"-",
+	)
+	return args
+}
+
+// lyrics reads the lyrics embedded in the file. The ID3 frames are read
+// directly as ffprobe doesn't report synchronized lyrics.
+func (c *TrackConverter) lyrics(item Item) (Lyrics, bool, error) {
+	lyrics, ok, err := readFileID3Lyrics(item.Path)
+	if err != nil {
+		c.log.Debug("ID3 lyrics could not be read", "err", err)
+	}
+
+	if ok {
+		return lyrics, true, nil
+	}
+
+	output, err := c.runProbe(item.Path)
+	if err != nil {
+		return Lyrics{}, false, errors.Wrap(err, "could not probe the file")
+	}
+
+	return findProbeLyrics(output)
+}
+
+func (c *TrackConverter) runProbe(filePath string) ([]byte, error) {
 	args := []string{
 		"-v",
 		"error",
-		"-show_entries",
-		"format=duration",
+		"-show_format",
+		"-show_streams",
 		"-of",
-		"default=noprint_wrappers=1:nokey=1",
+		"json",
 		filePath,
 	}
 	cmd := exec.Command("ffprobe", args...)
 	bufErr := &bytes.Buffer{}
 	cmd.Stderr = bufErr
-	c.log.Debug("checking duration", "command", cmd.String())
+	c.log.Debug("probing", "command", cmd.String())
 	output, err := cmd.Output()
 	if err != nil {
 		c.log.Error("command error", "stderr", bufErr.String())
-		return 0, errors.Wrap(err, "ffprobe execution failed")
+		return nil, errors.Wrap(err, "ffprobe execution failed")
 	}
+	return output, nil
+}
 
-	normalized := strings.TrimSpace(string(output)) + "s"
-	duration, err := time.ParseDuration(normalized)
+func readFileID3Lyrics(filePath string) (Lyrics, bool, error) {
+	f, err := os.Open(filePath)
 	if err != nil {
-		return 0, errors.Wrap(err, "could not parse the duration")
+		return Lyrics{}, false, errors.Wrap(err, "could not open the file")
 	}
-	return duration, nil
+	defer f.Close()
+
+	return readID3Lyrics(bufio.NewReader(f))
 }
 
 func (c *TrackConverter) OutputDirectory() string {
 	return path.Join(c.dataDir, trackDirectory)
 }
 
-func (c *TrackConverter) OutputFile(id string) string {
-	file := fmt.Sprintf("%s.%s", id, trackExtension)
+func (c *TrackConverter) OutputFile(item Item) string {
+	file := fmt.Sprintf("%s.%s", item.Id, c.profile(item).Container)
 	return path.Join(c.OutputDirectory(), file)
 }
 
-func (c *TrackConverter) TemporaryOutputFile(id string) string {
-	file := fmt.Sprintf("_%s.%s", id, trackExtension)
+func (c *TrackConverter) TemporaryOutputFile(item Item) string {
+	file := fmt.Sprintf("_%s.%s", item.Id, c.profile(item).Container)
 	return path.Join(c.OutputDirectory(), file)
 }
+
+func (c *TrackConverter) Progressive(item Item) bool {
+	return c.profile(item).Progressive()
+}
+
+// profile returns the profile used to convert the item. The profiles of the
+// variants are checked when they are requested so the default profile is
+// returned only for the items which don't specify a profile.
+func (c *TrackConverter) profile(item Item) TranscodingProfile {
+	if profile, ok := c.profiles.get(item.Profile); ok {
+		return profile
+	}
+	return c.profiles.defaultProfile()
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertArgs(t *testing.T) {
+	defaultProfile := TranscodingProfile{
+		Name:      "default",
+		Codec:     "libopus",
+		Bitrate:   "96K",
+		Container: "ogg",
+	}
+
+	testCases := []struct {
+		Name    string
+		Item    Item
+		Profile *TranscodingProfile
+		Result  []string
+	}{
+		{
+			Name: "whole_file",
+			Item: Item{
+				Path: "/path/to/file.flac",
+			},
+			Result: []string{"-y", "-i", "/path/to/file.flac", "-vn", "-c:a", "libopus", "-b:a", "96K", "-flush_packets", "1", "output.ogg"},
+		},
+		{
+			Name: "range",
+			Item: Item{
+				Path:  "/path/to/file.flac",
+				Start: 90 * time.Second,
+				End:   200*time.Second + 500*time.Millisecond,
+			},
+			Result: []string{"-y", "-ss", "90", "-i", "/path/to/file.flac", "-t", "110.5", "-vn", "-c:a", "libopus", "-b:a", "96K", "-flush_packets", "1", "output.ogg"},
+		},
+		{
+			Name: "until_the_end_of_the_file",
+			Item: Item{
+				Path:  "/path/to/file.flac",
+				Start: 90 * time.Second,
+			},
+			Result: []string{"-y", "-ss", "90", "-i", "/path/to/file.flac", "-vn", "-c:a", "libopus", "-b:a", "96K", "-flush_packets", "1", "output.ogg"},
+		},
+		{
+			Name: "gain",
+			Item: Item{
+				Path: "/path/to/file.flac",
+				Gain: -6.54,
+			},
+			Result: []string{"-y", "-i", "/path/to/file.flac", "-af", "volume=-6.5dB", "-vn", "-c:a", "libopus", "-b:a", "96K", "-flush_packets", "1", "output.ogg"},
+		},
+		{
+			Name: "profile",
+			Item: Item{
+				Path: "/path/to/file.flac",
+			},
+			Profile: &TranscodingProfile{
+				Name:       "mono",
+				Codec:      "libmp3lame",
+				Bitrate:    "64k",
+				Container:  "mp3",
+				SampleRate: 22050,
+				Channels:   1,
+			},
+			Result: []string{"-y", "-i", "/path/to/file.flac", "-vn", "-c:a", "libmp3lame", "-b:a", "64k", "-ar", "22050", "-ac", "1", "-flush_packets", "1", "output.ogg"},
+		},
+		{
+			Name: "profile_without_bitrate",
+			Item: Item{
+				Path: "/path/to/file.flac",
+			},
+			Profile: &TranscodingProfile{
+				Name:      "lossless",
+				Codec:     "flac",
+				Container: "flac",
+			},
+			Result: []string{"-y", "-i", "/path/to/file.flac", "-vn", "-c:a", "flac", "output.ogg"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			profile := defaultProfile
+			if testCase.Profile != nil {
+				profile = *testCase.Profile
+			}
+			require.Equal(t, testCase.Result, convertArgs(testCase.Item, profile, "output.ogg"))
+		})
+	}
+}
+
+func TestTrackConverterOutputFile(t *testing.T) {
+	converter, err := NewTrackConverter("/data", []TranscodingProfile{
+		{Name: "default", Codec: "libopus", Container: "ogg"},
+		{Name: "mp3", Codec: "libmp3lame", Container: "mp3"},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, "/data/tracks/id.ogg", converter.OutputFile(Item{Id: "id"}))
+	require.Equal(t, "/data/tracks/id_mp3.mp3", converter.OutputFile(Item{Id: "id_mp3", Profile: "mp3"}))
+	require.Equal(t, "/data/tracks/_id_mp3.mp3", converter.TemporaryOutputFile(Item{Id: "id_mp3", Profile: "mp3"}))
+}
+
+func TestNewTrackConverterValidatesProfiles(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Profiles []TranscodingProfile
+		Valid    bool
+	}{
+		{
+			Name: "valid",
+			Profiles: []TranscodingProfile{
+				{Name: "default", Codec: "libopus", Bitrate: "96k", Container: "ogg"},
+				{Name: "mp3-low", Codec: "libmp3lame", Bitrate: "64k", Container: "mp3", SampleRate: 22050, Channels: 1},
+			},
+			Valid: true,
+		},
+		{
+			Name:     "empty",
+			Profiles: nil,
+			Valid:    false,
+		},
+		{
+			Name: "invalid_name",
+			Profiles: []TranscodingProfile{
+				{Name: "Default", Codec: "libopus", Container: "ogg"},
+			},
+			Valid: false,
+		},
+		{
+			Name: "empty_name",
+			Profiles: []TranscodingProfile{
+				{Name: "", Codec: "libopus", Container: "ogg"},
+			},
+			Valid: false,
+		},
+		{
+			Name: "duplicate_name",
+			Profiles: []TranscodingProfile{
+				{Name: "default", Codec: "libopus", Container: "ogg"},
+				{Name: "default", Codec: "libmp3lame", Container: "mp3"},
+			},
+			Valid: false,
+		},
+		{
+			Name: "missing_codec",
+			Profiles: []TranscodingProfile{
+				{Name: "default", Container: "ogg"},
+			},
+			Valid: false,
+		},
+		{
+			Name: "unknown_container",
+			Profiles: []TranscodingProfile{
+				{Name: "default", Codec: "libopus", Container: "exe"},
+			},
+			Valid: false,
+		},
+		{
+			Name: "negative_channels",
+			Profiles: []TranscodingProfile{
+				{Name: "default", Codec: "libopus", Container: "ogg", Channels: -1},
+			},
+			Valid: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			_, err := NewTrackConverter("/data", testCase.Profiles)
+			if testCase.Valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestMeasureLoudnessArgs(t *testing.T) {
+	item := Item{
+		Path:  "/path/to/file.flac",
+		Start: 90 * time.Second,
+		End:   200*time.Second + 500*time.Millisecond,
+	}
+
+	require.Equal(t,
+		[]string{"-nostats", "-hide_banner", "-ss", "90", "-i", "/path/to/file.flac", "-t", "110.5", "-map", "0:a:0", "-af", "ebur128=framelog=verbose:peak=sample", "-f", "null", "-"},
+		measureLoudnessArgs(item),
+	)
+}
+
+func TestGetMetadataDoesNotWaitForPersistingMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache := newBlockingMetadataCache(map[string]Metadata{
+		"persisted": {Title: "persisted", LoudnessMeasured: true},
+	})
+	defer close(cache.unblock)
+
+	s, err := NewTrackStore(ctx, t.TempDir(), cache, &mockEventPublisher{}, []TranscodingProfile{
+		{Name: "default", Codec: "libopus", Container: "ogg"},
+	}, 0, 0)
+	require.NoError(t, err)
+
+	s.SetItems([]Item{
+		{Id: "persisted", Path: "/nonexistent/persisted.mp3"},
+		{Id: "probed", Path: "/nonexistent/probed.mp3"},
+	})
+
+	go s.storeProbedMetadata("probed", Metadata{Title: "probed", LoudnessMeasured: true}, nil)
+
+	select {
+	case <-cache.putting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	result := make(chan Metadata)
+	go func() {
+		metadata, _ := s.GetMetadata("persisted")
+		result <- metadata
+	}()
+
+	select {
+	case metadata := <-result:
+		require.Equal(t, "persisted", metadata.Title)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+// blockingMetadataCache blocks all writes until it is unblocked.
+type blockingMetadataCache struct {
+	metadata map[string]Metadata
+	putting  chan struct{}
+	unblock  chan struct{}
+}
+
+func newBlockingMetadataCache(metadata map[string]Metadata) *blockingMetadataCache {
+	return &blockingMetadataCache{
+		metadata: metadata,
+		putting:  make(chan struct{}, 1),
+		unblock:  make(chan struct{}),
+	}
+}
+
+func (c *blockingMetadataCache) Get(id string) (Metadata, bool, error) {
+	metadata, ok := c.metadata[id]
+	return metadata, ok, nil
+}
+
+func (c *blockingMetadataCache) Put(id string, metadata Metadata) error {
+	select {
+	case c.putting <- struct{}{}:
+	default:
+	}
+	<-c.unblock
+	return nil
+}
+
+func (c *blockingMetadataCache) Remove(ids []string) error {
+	return nil
+}
+
+func (c *blockingMetadataCache) Retain(ids []string) error {
+	return nil
+}
@@ -13,12 +13,18 @@ type Application struct {
 }
 
 type Music struct {
-	Thumbnail *music.ThumbnailHandler
-	Track     *music.TrackHandler
-	Browse    *music.BrowseHandler
-	Search    *music.SearchHandler
+	Thumbnail     *music.ThumbnailHandler
+	Track         *music.TrackHandler
+	Browse        *music.BrowseHandler
+	Search        *music.SearchHandler
+	Lyrics        *music.LyricsHandler
+	RecordPlay    *music.RecordPlayHandler
+	RecentlyAdded *music.RecentlyAddedHandler
+	Subscribe     *music.SubscribeHandler
 }
 
 type Queries struct {
-	Stats *queries.StatsHandler
+	Stats            *queries.StatsHandler
+	ScanProblems     *queries.ScanProblemsHandler
+	ConversionQueues *queries.ConversionQueuesHandler
 }
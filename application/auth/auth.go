@@ -10,6 +10,10 @@ var ErrUnauthorized = errors.New("unauthorized")
 var ErrUsernameTaken = errors.New("username taken")
 var ErrNotFound = errors.New("not found")
 
+// VisitTimeout is the time after which a user who wasn't seen is considered
+// to be starting a new visit.
+const VisitTimeout = 30 * time.Minute
+
 type CryptoStringGenerator interface {
 	Generate(bytes int) (string, error)
 }
@@ -74,6 +78,27 @@ type User struct {
 	Created       time.Time    `json:"created"`
 	LastSeen      time.Time    `json:"lastSeen"`
 	Sessions      []Session    `json:"sessions"`
+
+	// PreviousVisit is the time at which the user was last seen during
+	// their previous visit. It is zero during the first visit.
+	PreviousVisit time.Time `json:"previousVisit"`
+
+	// Normalization is the loudness normalization applied to the tracks
+	// played by the user unless it is specified with each request.
+	Normalization string `json:"normalization"`
+}
+
+// UpdateLastSeen records that the user was seen at the specified time. The
+// previous visit ends if the user wasn't seen for longer than VisitTimeout.
+func (u *User) UpdateLastSeen(t time.Time) {
+	if !t.After(u.LastSeen) {
+		return
+	}
+
+	if !u.LastSeen.IsZero() && t.Sub(u.LastSeen) > VisitTimeout {
+		u.PreviousVisit = u.LastSeen
+	}
+	u.LastSeen = t
 }
 
 type Session struct {
@@ -86,7 +111,26 @@ type ReadUser struct {
 	Administrator bool          `json:"administrator"`
 	Created       time.Time     `json:"created"`
 	LastSeen      time.Time     `json:"lastSeen"`
+	PreviousVisit time.Time     `json:"previousVisit"`
 	Sessions      []ReadSession `json:"sessions"`
+	Normalization string        `json:"normalization"`
+}
+
+// NewSince returns the time since which the changes are new to the user
+// visiting at the specified time, which is the end of their previous visit.
+// The user's last seen time may not have been updated yet so if the user
+// wasn't seen for longer than VisitTimeout then it marks the end of the
+// previous visit.
+func (u ReadUser) NewSince(now time.Time) time.Time {
+	if now.Sub(u.LastSeen) > VisitTimeout {
+		return u.LastSeen
+	}
+
+	if !u.PreviousVisit.IsZero() {
+		return u.PreviousVisit
+	}
+
+	return u.Created
 }
 
 type ReadSession struct {
@@ -120,6 +164,7 @@ type Auth struct {
 	CreateInvitation *CreateInvitationHandler
 	Remove           *RemoveHandler
 	SetPassword      *SetPasswordHandler
+	SetNormalization *SetNormalizationHandler
 }
 
 const maxUsernameLen = 100
@@ -159,6 +204,8 @@ func toReadUser(user User) ReadUser {
 		Administrator: user.Administrator,
 		Created:       user.Created,
 		LastSeen:      user.LastSeen,
+		PreviousVisit: user.PreviousVisit,
+		Normalization: user.Normalization,
 	}
 	for _, session := range user.Sessions {
 		rv.Sessions = append(rv.Sessions, ReadSession{
@@ -0,0 +1,78 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/application/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserUpdateLastSeen(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	u := auth.User{
+		Created:  start,
+		LastSeen: start,
+	}
+
+	u.UpdateLastSeen(start.Add(10 * time.Minute))
+	require.Equal(t, start.Add(10*time.Minute), u.LastSeen)
+	require.True(t, u.PreviousVisit.IsZero())
+
+	u.UpdateLastSeen(start.Add(5 * time.Minute))
+	require.Equal(t, start.Add(10*time.Minute), u.LastSeen)
+
+	u.UpdateLastSeen(start.Add(2 * time.Hour))
+	require.Equal(t, start.Add(2*time.Hour), u.LastSeen)
+	require.Equal(t, start.Add(10*time.Minute), u.PreviousVisit)
+}
+
+func TestReadUserNewSince(t *testing.T) {
+	created := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	lastSeen := created.Add(24 * time.Hour)
+	previousVisit := created.Add(time.Hour)
+
+	testCases := []struct {
+		Name     string
+		User     auth.ReadUser
+		Now      time.Time
+		Expected time.Time
+	}{
+		{
+			Name: "during_the_first_visit",
+			User: auth.ReadUser{
+				Created:  created,
+				LastSeen: lastSeen,
+			},
+			Now:      lastSeen.Add(time.Minute),
+			Expected: created,
+		},
+		{
+			Name: "during_a_visit",
+			User: auth.ReadUser{
+				Created:       created,
+				LastSeen:      lastSeen,
+				PreviousVisit: previousVisit,
+			},
+			Now:      lastSeen.Add(time.Minute),
+			Expected: previousVisit,
+		},
+		{
+			Name: "at_the_start_of_a_visit",
+			User: auth.ReadUser{
+				Created:       created,
+				LastSeen:      lastSeen,
+				PreviousVisit: previousVisit,
+			},
+			Now:      lastSeen.Add(time.Hour),
+			Expected: lastSeen,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			require.Equal(t, testCase.Expected, testCase.User.NewSince(testCase.Now))
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+import "github.com/boreq/errors"
+
+type SetNormalization struct {
+	Username      string
+	Normalization string
+}
+
+type SetNormalizationHandler struct {
+	transactionProvider TransactionProvider
+}
+
+func NewSetNormalizationHandler(
+	transactionProvider TransactionProvider,
+) *SetNormalizationHandler {
+	return &SetNormalizationHandler{
+		transactionProvider: transactionProvider,
+	}
+}
+
+func (h *SetNormalizationHandler) Execute(cmd SetNormalization) error {
+	return h.transactionProvider.Write(func(r *TransactableRepositories) error {
+		u, err := r.Users.Get(cmd.Username)
+		if err != nil {
+			return errors.Wrap(err, "could not get the user")
+		}
+
+		u.Normalization = cmd.Normalization
+
+		return r.Users.Put(*u)
+	})
+}
@@ -1,10 +1,199 @@
 package music
 
-import "github.com/boreq/errors"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/boreq/errors"
+)
+
+// SortKey specifies the order of the listed albums and tracks.
+type SortKey string
+
+const (
+	// SortKeyDefault sorts the albums by their names and the tracks in the
+	// order in which they appear on the album.
+	SortKeyDefault SortKey = ""
+
+	// SortKeyName sorts the albums and tracks by their names. Numbers in
+	// the names are compared by their values.
+	SortKeyName SortKey = "name"
+
+	SortKeyAdded    SortKey = "added"
+	SortKeyYear     SortKey = "year"
+	SortKeyModified SortKey = "modified"
+	SortKeyPlays    SortKey = "plays"
+)
+
+func NewSortKey(s string) (SortKey, error) {
+	switch k := SortKey(s); k {
+	case SortKeyDefault, SortKeyName, SortKeyAdded, SortKeyYear, SortKeyModified, SortKeyPlays:
+		return k, nil
+	default:
+		return SortKeyDefault, fmt.Errorf("invalid sort key '%s'", s)
+	}
+}
+
+type SortDirection string
+
+const (
+	SortAscending  SortDirection = "asc"
+	SortDescending SortDirection = "desc"
+)
+
+// NewSortDirection returns the ascending direction if the string is empty.
+func NewSortDirection(s string) (SortDirection, error) {
+	switch d := SortDirection(s); d {
+	case "", SortAscending:
+		return SortAscending, nil
+	case SortDescending:
+		return d, nil
+	default:
+		return SortAscending, fmt.Errorf("invalid sort direction '%s'", s)
+	}
+}
+
+// Cursor points to the last item of a listed page. Listing continues after
+// that item even if other items were added or removed in the meantime. The
+// string representation of a cursor is opaque to the clients.
+type Cursor struct {
+	track bool
+	id    string
+	index int
+}
+
+type cursorJSON struct {
+	Track bool   `json:"t,omitempty"`
+	Id    string `json:"i"`
+	Index int    `json:"n"`
+}
+
+// NewCursor decodes a cursor returned by String.
+func NewCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errors.Wrap(err, "invalid encoding")
+	}
+
+	var j cursorJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return Cursor{}, errors.Wrap(err, "json unmarshal failed")
+	}
+
+	if j.Id == "" || j.Index < 0 {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+
+	return Cursor{
+		track: j.Track,
+		id:    j.Id,
+		index: j.Index,
+	}, nil
+}
+
+// NewAlbumCursor points to an album listed at the specified index.
+func NewAlbumCursor(id AlbumId, index int) Cursor {
+	return Cursor{id: id.String(), index: index}
+}
+
+// NewTrackCursor points to a track listed at the specified index. The
+// tracks are listed after the albums.
+func NewTrackCursor(id TrackId, index int) Cursor {
+	return Cursor{track: true, id: id.String(), index: index}
+}
+
+func (c Cursor) IsZero() bool {
+	return c == Cursor{}
+}
+
+// PointsTo returns true if the cursor points to the specified album or track.
+func (c Cursor) PointsTo(track bool, id string) bool {
+	return c.track == track && c.id == id
+}
+
+func (c Cursor) Index() int {
+	return c.index
+}
+
+func (c Cursor) String() string {
+	if c.IsZero() {
+		return ""
+	}
+
+	b, err := json.Marshal(cursorJSON{
+		Track: c.track,
+		Id:    c.id,
+		Index: c.index,
+	})
+	if err != nil {
+		panic(err) // marshaling this struct can't fail
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Listing specifies the order of the listed albums and tracks and which of
+// them are returned. The albums are listed before the tracks. The zero value
+// lists all albums and tracks in the default order.
+type Listing struct {
+	sort      SortKey
+	direction SortDirection
+	after     Cursor
+	limit     int
+}
+
+// NewListing creates a listing which returns at most limit items following
+// the item pointed to by the cursor. If the limit is zero then all items are
+// returned. A zero cursor starts at the beginning.
+func NewListing(sort SortKey, direction SortDirection, after Cursor, limit int) (Listing, error) {
+	if limit < 0 {
+		return Listing{}, errors.New("limit can not be negative")
+	}
+
+	if limit > maxPageLimit {
+		return Listing{}, fmt.Errorf("limit can not be larger than %d", maxPageLimit)
+	}
+
+	if direction == "" {
+		direction = SortAscending
+	}
+
+	return Listing{
+		sort:      sort,
+		direction: direction,
+		after:     after,
+		limit:     limit,
+	}, nil
+}
+
+func MustNewListing(sort SortKey, direction SortDirection, after Cursor, limit int) Listing {
+	v, err := NewListing(sort, direction, after, limit)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (l Listing) Sort() SortKey {
+	return l.sort
+}
+
+func (l Listing) Descending() bool {
+	return l.direction == SortDescending
+}
+
+func (l Listing) After() Cursor {
+	return l.after
+}
+
+func (l Listing) Limit() int {
+	return l.limit
+}
 
 type Browse struct {
 	Ids        []AlbumId
 	PublicOnly bool
+	Listing    Listing
 }
 
 type BrowseHandler struct {
@@ -18,12 +207,12 @@ func NewBrowseHandler(library Library) *BrowseHandler {
 }
 
 func (h *BrowseHandler) Execute(cmd Browse) (Album, error) {
-	album, err := h.library.Browse(cmd.Ids, cmd.PublicOnly)
+	album, err := h.library.Browse(cmd.Ids, cmd.PublicOnly, cmd.Listing)
 	if err != nil {
 		return Album{}, errors.Wrap(err, "could not browse the album")
 	}
 
-	if len(cmd.Ids) > 0 && len(album.Albums) == 0 && len(album.Tracks) == 0 {
+	if len(cmd.Ids) > 0 && album.TotalAlbums == 0 && album.TotalTracks == 0 {
 		return Album{}, ErrForbidden
 	}
 
@@ -2,6 +2,7 @@ package music_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/boreq/eggplant/application/music"
 	"github.com/stretchr/testify/require"
@@ -38,13 +39,123 @@ func TestIfNoTracksAndAlbumsButThisIsTheRootDoNotReturnForbidden(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestIfPageIsEmptyButAlbumIsNotDoNotReturnForbidden(t *testing.T) {
+	l := pagedLibrary{}
+
+	h := music.NewBrowseHandler(l)
+
+	cmd := music.Browse{
+		Ids: []music.AlbumId{
+			"a",
+		},
+		PublicOnly: false,
+	}
+
+	_, err := h.Execute(cmd)
+	require.NoError(t, err)
+}
+
+func TestNewSortKey(t *testing.T) {
+	for _, s := range []string{"", "name", "added", "year", "modified", "plays"} {
+		k, err := music.NewSortKey(s)
+		require.NoError(t, err)
+		require.Equal(t, s, string(k))
+	}
+
+	_, err := music.NewSortKey("invalid")
+	require.Error(t, err)
+}
+
+func TestNewSortDirection(t *testing.T) {
+	d, err := music.NewSortDirection("")
+	require.NoError(t, err)
+	require.Equal(t, music.SortAscending, d)
+
+	d, err = music.NewSortDirection("desc")
+	require.NoError(t, err)
+	require.Equal(t, music.SortDescending, d)
+
+	_, err = music.NewSortDirection("invalid")
+	require.Error(t, err)
+}
+
+func TestCursor(t *testing.T) {
+	for _, cursor := range []music.Cursor{
+		music.NewAlbumCursor("album", 5),
+		music.NewTrackCursor("track", 10),
+	} {
+		decoded, err := music.NewCursor(cursor.String())
+		require.NoError(t, err)
+		require.Equal(t, cursor, decoded)
+	}
+
+	cursor := music.NewTrackCursor("id", 3)
+	require.True(t, cursor.PointsTo(true, "id"))
+	require.False(t, cursor.PointsTo(false, "id"))
+	require.False(t, cursor.PointsTo(true, "other"))
+	require.Equal(t, 3, cursor.Index())
+
+	require.Empty(t, music.Cursor{}.String())
+
+	for _, s := range []string{"!", "bm90IGpzb24", "e30"} {
+		_, err := music.NewCursor(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestNewListing(t *testing.T) {
+	listing, err := music.NewListing(music.SortKeyYear, "", music.Cursor{}, 0)
+	require.NoError(t, err)
+	require.Equal(t, music.SortKeyYear, listing.Sort())
+	require.False(t, listing.Descending())
+	require.Equal(t, 0, listing.Limit())
+
+	_, err = music.NewListing(music.SortKeyDefault, music.SortAscending, music.Cursor{}, -1)
+	require.Error(t, err)
+
+	_, err = music.NewListing(music.SortKeyDefault, music.SortAscending, music.Cursor{}, 101)
+	require.Error(t, err)
+}
+
+type pagedLibrary struct {
+	mockLibrary
+}
+
+func (pagedLibrary) Browse(ids []music.AlbumId, publicOnly bool, listing music.Listing) (music.Album, error) {
+	return music.Album{TotalTracks: 1}, nil
+}
+
 type mockLibrary struct {
 }
 
-func (mockLibrary) Browse(ids []music.AlbumId, publicOnly bool) (music.Album, error) {
+func (mockLibrary) Browse(ids []music.AlbumId, publicOnly bool, listing music.Listing) (music.Album, error) {
 	return music.Album{}, nil
 }
 
-func (mockLibrary) Search(query string, publicOnly bool) (music.SearchResult, error) {
+func (mockLibrary) Search(query music.Query, publicOnly bool, page music.Page) (music.SearchResult, error) {
 	return music.SearchResult{}, nil
 }
+
+func (mockLibrary) Lyrics(id music.FileId) (music.Lyrics, error) {
+	return music.Lyrics{}, nil
+}
+
+func (mockLibrary) RecentlyAdded(publicOnly bool, since time.Time, page music.Page) (music.RecentAlbums, error) {
+	return music.RecentAlbums{}, nil
+}
+
+func (mockLibrary) RecordPlay(id music.FileId) error {
+	return nil
+}
+
+func (mockLibrary) Gain(id music.FileId, normalization music.Normalization) (float64, bool, error) {
+	return 0, false, nil
+}
+
+func (mockLibrary) CanAccessTrack(id music.FileId, publicOnly bool) (bool, error) {
+	return !publicOnly, nil
+}
+
+func (mockLibrary) NextTracks(id music.FileId, limit int) ([]music.FileId, error) {
+	return nil, nil
+}
@@ -0,0 +1,87 @@
+package music
+
+import (
+	"context"
+)
+
+type EventType string
+
+const (
+	// EventTypeLibraryChanged is published after the library was updated.
+	EventTypeLibraryChanged EventType = "libraryChanged"
+
+	EventTypeConversionStarted  EventType = "conversionStarted"
+	EventTypeConversionFinished EventType = "conversionFinished"
+	EventTypeConversionFailed   EventType = "conversionFailed"
+
+	// EventTypeScanProgress is published while the tracks are being
+	// probed and their loudness is being measured in the background.
+	EventTypeScanProgress EventType = "scanProgress"
+)
+
+type Event struct {
+	Type EventType
+
+	// Albums are the paths of the albums which were replaced or removed
+	// by an update of the library. The albums contained in them could
+	// have changed as well.
+	Albums [][]AlbumId
+
+	// Conversion is set for the conversion events.
+	Conversion *Conversion
+
+	// Progress is set for the scan progress events.
+	Progress *ScanProgress
+}
+
+type ConversionKind string
+
+const (
+	ConversionKindTrack     ConversionKind = "track"
+	ConversionKindThumbnail ConversionKind = "thumbnail"
+)
+
+type Conversion struct {
+	Kind   ConversionKind
+	FileId FileId
+
+	// Gain in dB applied to the converted track.
+	Gain float64
+
+	// Profile used to convert the track. It is empty for the default
+	// profile.
+	Profile TranscodingProfile
+}
+
+type ScanProgress struct {
+	// PendingMetadata is the number of tracks which are waiting to be
+	// probed.
+	PendingMetadata int
+
+	// PendingLoudness is the number of tracks which are waiting for their
+	// loudness to be measured.
+	PendingLoudness int
+}
+
+type EventSubscriber interface {
+	// Subscribe returns a channel on which the events are sent until the
+	// context is cancelled. Only the latest progress event waits to be
+	// received. Subscribers which don't receive the other events quickly
+	// enough are disconnected by closing the channel and have to subscribe
+	// again and reload the library as they could have missed some changes.
+	Subscribe(ctx context.Context) <-chan Event
+}
+
+type SubscribeHandler struct {
+	subscriber EventSubscriber
+}
+
+func NewSubscribeHandler(subscriber EventSubscriber) *SubscribeHandler {
+	return &SubscribeHandler{
+		subscriber: subscriber,
+	}
+}
+
+func (h *SubscribeHandler) Execute(ctx context.Context) <-chan Event {
+	return h.subscriber.Subscribe(ctx)
+}
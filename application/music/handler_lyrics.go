@@ -0,0 +1,23 @@
+package music
+
+import (
+	"github.com/boreq/errors"
+)
+
+type LyricsHandler struct {
+	library Library
+}
+
+func NewLyricsHandler(library Library) *LyricsHandler {
+	return &LyricsHandler{
+		library: library,
+	}
+}
+
+func (h *LyricsHandler) Execute(id FileId) (Lyrics, error) {
+	lyrics, err := h.library.Lyrics(id)
+	if err != nil {
+		return Lyrics{}, errors.Wrap(err, "could not get the lyrics")
+	}
+	return lyrics, nil
+}
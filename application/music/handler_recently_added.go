@@ -0,0 +1,33 @@
+package music
+
+import (
+	"errors"
+	"time"
+)
+
+type RecentlyAdded struct {
+	PublicOnly bool
+	Page       Page
+
+	// Since marks the albums which were added after it as new. No albums
+	// are marked if it is zero.
+	Since time.Time
+}
+
+type RecentlyAddedHandler struct {
+	library Library
+}
+
+func NewRecentlyAddedHandler(library Library) *RecentlyAddedHandler {
+	return &RecentlyAddedHandler{
+		library: library,
+	}
+}
+
+func (h *RecentlyAddedHandler) Execute(cmd RecentlyAdded) (RecentAlbums, error) {
+	if cmd.Page.IsZero() {
+		return RecentAlbums{}, errors.New("zero value of page")
+	}
+
+	return h.library.RecentlyAdded(cmd.PublicOnly, cmd.Since, cmd.Page)
+}
@@ -0,0 +1,26 @@
+package music
+
+import (
+	"github.com/boreq/errors"
+)
+
+type RecordPlay struct {
+	Id FileId
+}
+
+type RecordPlayHandler struct {
+	library Library
+}
+
+func NewRecordPlayHandler(library Library) *RecordPlayHandler {
+	return &RecordPlayHandler{
+		library: library,
+	}
+}
+
+func (h *RecordPlayHandler) Execute(cmd RecordPlay) error {
+	if err := h.library.RecordPlay(cmd.Id); err != nil {
+		return errors.Wrap(err, "could not record the play")
+	}
+	return nil
+}
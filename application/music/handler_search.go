@@ -5,44 +5,59 @@ import (
 	"fmt"
 )
 
-const maxQueryLength = 100
+const (
+	DefaultPageLimit = 20
+	maxPageLimit     = 100
+)
 
-type Query struct {
-	s string
+// Page selects a part of a list.
+type Page struct {
+	offset int
+	limit  int
 }
 
-func NewQuery(s string) (Query, error) {
-	if s == "" {
-		return Query{}, errors.New("query can not be empty")
+func NewPage(offset, limit int) (Page, error) {
+	if offset < 0 {
+		return Page{}, errors.New("offset can not be negative")
+	}
+
+	if limit <= 0 {
+		return Page{}, errors.New("limit must be positive")
 	}
 
-	if len(s) > maxQueryLength {
-		return Query{}, fmt.Errorf("query can not be longer than %d", maxQueryLength)
+	if limit > maxPageLimit {
+		return Page{}, fmt.Errorf("limit can not be larger than %d", maxPageLimit)
 	}
 
-	return Query{
-		s: s,
+	return Page{
+		offset: offset,
+		limit:  limit,
 	}, nil
 }
 
-func MustNewQuery(s string) Query {
-	v, err := NewQuery(s)
+func MustNewPage(offset, limit int) Page {
+	v, err := NewPage(offset, limit)
 	if err != nil {
 		panic(err)
 	}
 	return v
 }
 
-func (q Query) IsZero() bool {
-	return q == Query{}
+func (p Page) IsZero() bool {
+	return p == Page{}
 }
 
-func (q Query) String() string {
-	return q.s
+func (p Page) Offset() int {
+	return p.offset
+}
+
+func (p Page) Limit() int {
+	return p.limit
 }
 
 type Search struct {
 	Query      Query
+	Page       Page
 	PublicOnly bool
 }
 
@@ -61,5 +76,9 @@ func (h *SearchHandler) Execute(cmd Search) (SearchResult, error) {
 		return SearchResult{}, errors.New("zero value of query")
 	}
 
-	return h.library.Search(cmd.Query.String(), cmd.PublicOnly)
+	if cmd.Page.IsZero() {
+		return SearchResult{}, errors.New("zero value of page")
+	}
+
+	return h.library.Search(cmd.Query, cmd.PublicOnly, cmd.Page)
 }
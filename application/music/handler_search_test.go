@@ -0,0 +1,60 @@
+package music_test
+
+import (
+	"testing"
+
+	"github.com/boreq/eggplant/application/music"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPage(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Offset        int
+		Limit         int
+		ExpectedError bool
+	}{
+		{
+			Name:   "valid",
+			Offset: 10,
+			Limit:  20,
+		},
+		{
+			Name:   "maximum_limit",
+			Offset: 0,
+			Limit:  100,
+		},
+		{
+			Name:          "negative_offset",
+			Offset:        -1,
+			Limit:         20,
+			ExpectedError: true,
+		},
+		{
+			Name:          "zero_limit",
+			Offset:        0,
+			Limit:         0,
+			ExpectedError: true,
+		},
+		{
+			Name:          "limit_too_large",
+			Offset:        0,
+			Limit:         101,
+			ExpectedError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			page, err := music.NewPage(testCase.Offset, testCase.Limit)
+			if testCase.ExpectedError {
+				require.Error(t, err)
+				require.True(t, page.IsZero())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.Offset, page.Offset())
+			require.Equal(t, testCase.Limit, page.Limit())
+		})
+	}
+}
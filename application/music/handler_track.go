@@ -2,9 +2,15 @@ package music
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/boreq/eggplant/logging"
 	"github.com/boreq/errors"
 )
 
@@ -16,24 +22,311 @@ type ConvertedFile struct {
 	// Modtime is used to figure out if the content has changed.
 	Modtime time.Time
 
+	// ContentType is the media type of the content. If it is empty then
+	// it is detected using the name.
+	ContentType string
+
 	// Content must be closed by the caller.
 	Content io.ReadSeekCloser
+
+	// Partial is set if the file is still being converted. Reading the
+	// content blocks until more data is written and it can't be seeked.
+	Partial bool
+}
+
+// Normalization specifies which gain is applied to the tracks to normalize
+// their loudness.
+type Normalization string
+
+const (
+	NormalizationNone  Normalization = ""
+	NormalizationTrack Normalization = "track"
+	NormalizationAlbum Normalization = "album"
+)
+
+func NewNormalization(s string) (Normalization, error) {
+	switch n := Normalization(s); n {
+	case NormalizationNone, NormalizationTrack, NormalizationAlbum:
+		return n, nil
+	default:
+		return NormalizationNone, fmt.Errorf("invalid normalization '%s'", s)
+	}
+}
+
+const maxTranscodingProfileLength = 50
+
+var isTranscodingProfileValid = regexp.MustCompile(`^[a-z][a-z0-9-]*$`).MatchString
+
+// TranscodingProfile is the name of a profile which selects the format of the
+// converted tracks. The zero value selects the default profile.
+type TranscodingProfile string
+
+func NewTranscodingProfile(s string) (TranscodingProfile, error) {
+	if len(s) > maxTranscodingProfileLength {
+		return "", fmt.Errorf("profile can not be longer than %d", maxTranscodingProfileLength)
+	}
+
+	if s != "" && !isTranscodingProfileValid(s) {
+		return "", fmt.Errorf("profile '%s' must start with a lowercase letter followed by lowercase letters, digits or hyphens", s)
+	}
+
+	return TranscodingProfile(s), nil
+}
+
+func (p TranscodingProfile) IsZero() bool {
+	return p == ""
+}
+
+func (p TranscodingProfile) String() string {
+	return string(p)
+}
+
+// PlayableFormats lists the formats which the client can play without
+// transcoding and the highest bitrate which it accepts. The zero value
+// doesn't accept any formats.
+type PlayableFormats struct {
+	formats    []playableFormat
+	maxBitrate int
+}
+
+type playableFormat struct {
+	mediaType string
+
+	// codecs are empty if any codec is accepted.
+	codecs []string
 }
 
+// NewPlayableFormats parses media types such as "audio/mpeg" or
+// "audio/ogg; codecs=opus". Wildcards and types with a zero quality are
+// skipped as clients list wildcards even though they can't play every
+// format. The bitrate is expressed in kbit/s and zero means that it isn't
+// limited by the client.
+func NewPlayableFormats(mediaTypes []string, maxBitrate int) (PlayableFormats, error) {
+	if maxBitrate < 0 {
+		return PlayableFormats{}, errors.New("bitrate can not be negative")
+	}
+
+	result := PlayableFormats{
+		maxBitrate: maxBitrate,
+	}
+
+	for _, s := range mediaTypes {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+
+		mediaType, params, err := mime.ParseMediaType(s)
+		if err != nil {
+			return PlayableFormats{}, errors.Wrapf(err, "invalid media type '%s'", s)
+		}
+
+		if strings.Contains(mediaType, "*") || isZeroQuality(params["q"]) {
+			continue
+		}
+
+		format := playableFormat{
+			mediaType: mediaType,
+		}
+		for _, codec := range strings.Split(params["codecs"], ",") {
+			if codec = strings.ToLower(strings.TrimSpace(codec)); codec != "" {
+				format.codecs = append(format.codecs, codec)
+			}
+		}
+		result.formats = append(result.formats, format)
+	}
+
+	return result, nil
+}
+
+func isZeroQuality(s string) bool {
+	q, err := strconv.ParseFloat(s, 64)
+	return err == nil && q == 0
+}
+
+func (f PlayableFormats) IsZero() bool {
+	return len(f.formats) == 0
+}
+
+// CanPlay checks if the client can play a file of the specified media type
+// encoded using the specified codec. The bitrate is expressed in bits per
+// second and zero means that it isn't known.
+func (f PlayableFormats) CanPlay(mediaType, codec string, bitrate int) bool {
+	if f.maxBitrate > 0 && (bitrate <= 0 || bitrate > f.maxBitrate*1000) {
+		return false
+	}
+
+	for _, format := range f.formats {
+		if format.mediaType == strings.ToLower(mediaType) && format.acceptsCodec(codec) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f playableFormat) acceptsCodec(codec string) bool {
+	if len(f.codecs) == 0 {
+		return true
+	}
+
+	for _, c := range f.codecs {
+		if c == strings.ToLower(codec) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type GetTrack struct {
+	Id            string
+	Normalization Normalization
+	Profile       TranscodingProfile
+
+	// Formats are the formats which the client can play. The original file
+	// is returned if the client can play it, a profile isn't specified and
+	// a gain doesn't have to be applied. The original files of the tracks
+	// are returned only to the users who can access them.
+	Formats    PlayableFormats
+	PublicOnly bool
+
+	// Seeking is set if the client requested a part of the track which
+	// doesn't start at its beginning. The next tracks were already
+	// pre-transcoded when the beginning of the track was requested.
+	Seeking bool
+}
+
+// PreTranscodingDepth is the number of tracks following the requested track
+// on its album which are converted in the background. Zero disables the
+// pre-transcoding.
+type PreTranscodingDepth int
+
 type TrackHandler struct {
 	trackStore TrackStore
+	library    Library
+	depth      PreTranscodingDepth
+	log        logging.Logger
 }
 
-func NewTrackHandler(trackStore TrackStore) *TrackHandler {
+func NewTrackHandler(trackStore TrackStore, library Library, depth PreTranscodingDepth) *TrackHandler {
 	return &TrackHandler{
 		trackStore: trackStore,
+		library:    library,
+		depth:      depth,
+		log:        logging.New("music.TrackHandler"),
 	}
 }
 
-func (h *TrackHandler) Execute(ctx context.Context, id string) (ConvertedFile, error) {
-	p, err := h.trackStore.GetConvertedFile(ctx, id)
+func (h *TrackHandler) Execute(ctx context.Context, cmd GetTrack) (ConvertedFile, error) {
+	gain, err := h.gain(FileId(cmd.Id), cmd.Normalization)
+	if err != nil {
+		return ConvertedFile{}, errors.Wrap(err, "could not get the gain")
+	}
+
+	if !cmd.Formats.IsZero() && cmd.Profile.IsZero() && gain == 0 {
+		p, ok, err := h.getOriginalTrack(cmd)
+		if err != nil {
+			return ConvertedFile{}, errors.Wrap(err, "could not get the original track")
+		}
+
+		if ok {
+			return p, nil
+		}
+	}
+
+	p, err := h.trackStore.GetConvertedTrack(ctx, cmd.Id, cmd.Profile, gain)
 	if err != nil {
 		return ConvertedFile{}, errors.Wrap(err, "could not get the track")
 	}
+
+	// the requested track can be played even if the next tracks can't be
+	// pre-transcoded
+	if err := h.preTranscode(cmd); err != nil {
+		h.log.Error("could not pre-transcode the next tracks", "id", cmd.Id, "err", err)
+	}
+
 	return p, nil
 }
+
+// gain returns the gain which should be applied to the track or zero if it
+// isn't known.
+func (h *TrackHandler) gain(id FileId, normalization Normalization) (float64, error) {
+	if normalization == NormalizationNone {
+		return 0, nil
+	}
+
+	gain, ok, err := h.library.Gain(id, normalization)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, errors.Wrap(err, "could not get the gain")
+	}
+
+	if !ok {
+		return 0, nil
+	}
+
+	return gain, nil
+}
+
+// getOriginalTrack returns the original file of the track if the user can
+// access it and the client can play it. The tracks which can't be accessed
+// are transcoded as usual as their ids already have to be known.
+func (h *TrackHandler) getOriginalTrack(cmd GetTrack) (ConvertedFile, bool, error) {
+	ok, err := h.canAccess(cmd)
+	if err != nil {
+		return ConvertedFile{}, false, errors.Wrap(err, "could not check access")
+	}
+
+	if !ok {
+		return ConvertedFile{}, false, nil
+	}
+
+	return h.trackStore.GetOriginalTrack(cmd.Id, cmd.Formats)
+}
+
+// preTranscode schedules the conversions of the tracks which follow the
+// requested track on its album as they will likely be requested next. They
+// are converted using the same profile and normalization.
+func (h *TrackHandler) preTranscode(cmd GetTrack) error {
+	if h.depth <= 0 || cmd.Seeking {
+		return nil
+	}
+
+	ok, err := h.canAccess(cmd)
+	if err != nil {
+		return errors.Wrap(err, "could not check access")
+	}
+
+	if !ok {
+		return nil
+	}
+
+	ids, err := h.library.NextTracks(FileId(cmd.Id), int(h.depth))
+	if err != nil {
+		return errors.Wrap(err, "could not get the next tracks")
+	}
+
+	for _, id := range ids {
+		gain, err := h.gain(id, cmd.Normalization)
+		if err != nil {
+			return errors.Wrap(err, "could not get the gain")
+		}
+
+		if err := h.trackStore.PreTranscodeTrack(id.String(), cmd.Profile, gain); err != nil {
+			return errors.Wrap(err, "could not schedule the conversion")
+		}
+	}
+
+	return nil
+}
+
+// canAccess returns false for the tracks which aren't a part of the library.
+func (h *TrackHandler) canAccess(cmd GetTrack) (bool, error) {
+	ok, err := h.library.CanAccessTrack(FileId(cmd.Id), cmd.PublicOnly)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return ok, nil
+}
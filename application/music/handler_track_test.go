@@ -0,0 +1,316 @@
+package music_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boreq/eggplant/application/music"
+	"github.com/boreq/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackHandlerAppliesGain(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Normalization music.Normalization
+		ExpectedGain  float64
+	}{
+		{
+			Name:          "none",
+			Normalization: music.NormalizationNone,
+			ExpectedGain:  0,
+		},
+		{
+			Name:          "track",
+			Normalization: music.NormalizationTrack,
+			ExpectedGain:  -3,
+		},
+		{
+			Name:          "album",
+			Normalization: music.NormalizationAlbum,
+			ExpectedGain:  -5,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			trackStore := &mockTrackStore{}
+			h := music.NewTrackHandler(trackStore, gainLibrary{}, 0)
+
+			cmd := music.GetTrack{
+				Id:            "id",
+				Normalization: testCase.Normalization,
+			}
+
+			_, err := h.Execute(context.Background(), cmd)
+			require.NoError(t, err)
+			require.Equal(t, testCase.ExpectedGain, trackStore.gain)
+		})
+	}
+}
+
+func TestTrackHandlerPassesProfile(t *testing.T) {
+	trackStore := &mockTrackStore{}
+	h := music.NewTrackHandler(trackStore, gainLibrary{}, 0)
+
+	cmd := music.GetTrack{
+		Id:      "id",
+		Profile: music.TranscodingProfile("mp3"),
+	}
+
+	_, err := h.Execute(context.Background(), cmd)
+	require.NoError(t, err)
+	require.Equal(t, music.TranscodingProfile("mp3"), trackStore.profile)
+}
+
+func TestTrackHandlerReturnsOriginal(t *testing.T) {
+	formats, err := music.NewPlayableFormats([]string{"audio/mpeg"}, 0)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name             string
+		Cmd              music.GetTrack
+		ExpectedOriginal bool
+	}{
+		{
+			Name: "playable",
+			Cmd: music.GetTrack{
+				Id:      "id",
+				Formats: formats,
+			},
+			ExpectedOriginal: true,
+		},
+		{
+			Name: "no_formats",
+			Cmd: music.GetTrack{
+				Id: "id",
+			},
+			ExpectedOriginal: false,
+		},
+		{
+			Name: "profile",
+			Cmd: music.GetTrack{
+				Id:      "id",
+				Formats: formats,
+				Profile: music.TranscodingProfile("mp3"),
+			},
+			ExpectedOriginal: false,
+		},
+		{
+			Name: "gain",
+			Cmd: music.GetTrack{
+				Id:            "id",
+				Formats:       formats,
+				Normalization: music.NormalizationTrack,
+			},
+			ExpectedOriginal: false,
+		},
+		{
+			Name: "forbidden",
+			Cmd: music.GetTrack{
+				Id:         "id",
+				Formats:    formats,
+				PublicOnly: true,
+			},
+			ExpectedOriginal: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			trackStore := &mockTrackStore{}
+			h := music.NewTrackHandler(trackStore, gainLibrary{}, 0)
+
+			p, err := h.Execute(context.Background(), testCase.Cmd)
+			require.NoError(t, err)
+			require.Equal(t, testCase.ExpectedOriginal, p.Name == "original")
+		})
+	}
+}
+
+func TestTrackHandlerPreTranscodesNextTracks(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Depth       music.PreTranscodingDepth
+		Cmd         music.GetTrack
+		ExpectedIds []string
+	}{
+		{
+			Name:  "enabled",
+			Depth: 2,
+			Cmd: music.GetTrack{
+				Id:            "id",
+				Profile:       music.TranscodingProfile("mp3"),
+				Normalization: music.NormalizationAlbum,
+			},
+			ExpectedIds: []string{"next1", "next2"},
+		},
+		{
+			Name:  "disabled",
+			Depth: 0,
+			Cmd: music.GetTrack{
+				Id: "id",
+			},
+			ExpectedIds: nil,
+		},
+		{
+			Name:  "forbidden",
+			Depth: 2,
+			Cmd: music.GetTrack{
+				Id:         "id",
+				PublicOnly: true,
+			},
+			ExpectedIds: nil,
+		},
+		{
+			Name:  "seeking",
+			Depth: 2,
+			Cmd: music.GetTrack{
+				Id:      "id",
+				Seeking: true,
+			},
+			ExpectedIds: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			trackStore := &mockTrackStore{}
+			h := music.NewTrackHandler(trackStore, gainLibrary{}, testCase.Depth)
+
+			_, err := h.Execute(context.Background(), testCase.Cmd)
+			require.NoError(t, err)
+			require.Equal(t, testCase.ExpectedIds, trackStore.preTranscoded)
+
+			for _, profile := range trackStore.preTranscodedProfiles {
+				require.Equal(t, testCase.Cmd.Profile, profile)
+			}
+
+			for _, gain := range trackStore.preTranscodedGains {
+				require.Equal(t, float64(-5), gain)
+			}
+		})
+	}
+}
+
+func TestTrackHandlerIgnoresPreTranscodingErrors(t *testing.T) {
+	trackStore := &mockTrackStore{
+		preTranscodeErr: errors.New("queue is full"),
+	}
+	h := music.NewTrackHandler(trackStore, gainLibrary{}, 2)
+
+	p, err := h.Execute(context.Background(), music.GetTrack{Id: "id"})
+	require.NoError(t, err)
+	require.Equal(t, "converted", p.Name)
+	require.Equal(t, []string{"next1"}, trackStore.preTranscoded)
+}
+
+func TestPlayableFormats(t *testing.T) {
+	formats, err := music.NewPlayableFormats([]string{
+		"audio/mpeg",
+		`audio/ogg; codecs="opus"`,
+		"audio/flac;q=0",
+		"audio/*",
+		"*/*;q=0.5",
+	}, 0)
+	require.NoError(t, err)
+
+	require.True(t, formats.CanPlay("audio/mpeg", "mp3", 320000))
+	require.True(t, formats.CanPlay("audio/mpeg", "mp3", 0))
+	require.True(t, formats.CanPlay("audio/ogg", "opus", 128000))
+	require.False(t, formats.CanPlay("audio/ogg", "vorbis", 128000))
+	require.False(t, formats.CanPlay("audio/flac", "flac", 900000))
+	require.False(t, formats.CanPlay("audio/wav", "1", 1411000))
+
+	limited, err := music.NewPlayableFormats([]string{"audio/mpeg"}, 192)
+	require.NoError(t, err)
+
+	require.True(t, limited.CanPlay("audio/mpeg", "mp3", 192000))
+	require.False(t, limited.CanPlay("audio/mpeg", "mp3", 320000))
+	require.False(t, limited.CanPlay("audio/mpeg", "mp3", 0))
+
+	require.True(t, music.PlayableFormats{}.IsZero())
+	require.False(t, music.PlayableFormats{}.CanPlay("audio/mpeg", "mp3", 128000))
+
+	_, err = music.NewPlayableFormats([]string{"audio/"}, 0)
+	require.Error(t, err)
+
+	_, err = music.NewPlayableFormats(nil, -1)
+	require.Error(t, err)
+}
+
+func TestNewTranscodingProfile(t *testing.T) {
+	for _, s := range []string{"", "mp3", "opus-48k", "a1"} {
+		p, err := music.NewTranscodingProfile(s)
+		require.NoError(t, err)
+		require.Equal(t, s, p.String())
+	}
+
+	for _, s := range []string{"MP3", "1mp3", "-mp3", "mp3_low", "mp3 low", strings.Repeat("a", 51)} {
+		_, err := music.NewTranscodingProfile(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestNewNormalization(t *testing.T) {
+	for _, s := range []string{"", "track", "album"} {
+		n, err := music.NewNormalization(s)
+		require.NoError(t, err)
+		require.Equal(t, s, string(n))
+	}
+
+	_, err := music.NewNormalization("invalid")
+	require.Error(t, err)
+}
+
+type mockTrackStore struct {
+	gain    float64
+	profile music.TranscodingProfile
+
+	preTranscoded         []string
+	preTranscodedProfiles []music.TranscodingProfile
+	preTranscodedGains    []float64
+	preTranscodeErr       error
+}
+
+func (s *mockTrackStore) GetConvertedFile(ctx context.Context, id string) (music.ConvertedFile, error) {
+	return music.ConvertedFile{}, nil
+}
+
+func (s *mockTrackStore) GetConvertedTrack(ctx context.Context, id string, profile music.TranscodingProfile, gain float64) (music.ConvertedFile, error) {
+	s.gain = gain
+	s.profile = profile
+	return music.ConvertedFile{Name: "converted"}, nil
+}
+
+func (s *mockTrackStore) GetOriginalTrack(id string, formats music.PlayableFormats) (music.ConvertedFile, bool, error) {
+	return music.ConvertedFile{Name: "original"}, true, nil
+}
+
+func (s *mockTrackStore) PreTranscodeTrack(id string, profile music.TranscodingProfile, gain float64) error {
+	s.preTranscoded = append(s.preTranscoded, id)
+	s.preTranscodedProfiles = append(s.preTranscodedProfiles, profile)
+	s.preTranscodedGains = append(s.preTranscodedGains, gain)
+	return s.preTranscodeErr
+}
+
+type gainLibrary struct {
+	mockLibrary
+}
+
+func (gainLibrary) Gain(id music.FileId, normalization music.Normalization) (float64, bool, error) {
+	if normalization == music.NormalizationAlbum {
+		return -5, true, nil
+	}
+	return -3, true, nil
+}
+
+func (gainLibrary) NextTracks(id music.FileId, limit int) ([]music.FileId, error) {
+	var ids []music.FileId
+	for i := 1; i <= limit; i++ {
+		ids = append(ids, music.FileId(fmt.Sprintf("next%d", i)))
+	}
+	return ids, nil
+}
@@ -3,22 +3,41 @@ package music
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var ErrForbidden = errors.New("forbidden")
 var ErrNotFound = errors.New("not found")
+var ErrUnknownProfile = errors.New("unknown profile")
 
 type ThumbnailStore interface {
 	GetConvertedFile(ctx context.Context, id string) (ConvertedFile, error)
 }
 
 type TrackStore interface {
-	GetConvertedFile(ctx context.Context, id string) (ConvertedFile, error)
+	// GetConvertedTrack returns the track converted using the specified
+	// profile with the specified gain in dB applied to it.
+	// ErrUnknownProfile is returned if the profile isn't configured.
+	GetConvertedTrack(ctx context.Context, id string, profile TranscodingProfile, gain float64) (ConvertedFile, error)
+
+	// GetOriginalTrack returns the original file of the track if it can be
+	// played by the client without transcoding. False is returned if the
+	// track has to be transcoded.
+	GetOriginalTrack(id string, formats PlayableFormats) (ConvertedFile, bool, error)
+
+	// PreTranscodeTrack schedules a low-priority background conversion of
+	// the track which will likely be requested soon.
+	PreTranscodeTrack(id string, profile TranscodingProfile, gain float64) error
 }
 
 type SearchResult struct {
 	Albums []BasicAlbum
 	Tracks []SearchResultTrack
+
+	// TotalAlbums and TotalTracks are the numbers of all matching albums
+	// and tracks regardless of the requested page.
+	TotalAlbums int
+	TotalTracks int
 }
 
 type BasicAlbum struct {
@@ -32,9 +51,49 @@ type SearchResultTrack struct {
 	Album BasicAlbum
 }
 
+type RecentAlbums struct {
+	Albums []RecentAlbum
+
+	// Total is the number of all albums regardless of the requested page.
+	// TotalNew is the number of the albums which are marked as new.
+	Total    int
+	TotalNew int
+}
+
+type RecentAlbum struct {
+	Album BasicAlbum
+
+	// Added is the time at which the album was first seen.
+	Added time.Time
+
+	// New is set if the album was added since the specified time.
+	New bool
+}
+
 type Library interface {
-	Browse(ids []AlbumId, publicOnly bool) (Album, error)
-	Search(query string, publicOnly bool) (SearchResult, error)
+	Browse(ids []AlbumId, publicOnly bool, listing Listing) (Album, error)
+	Search(query Query, publicOnly bool, page Page) (SearchResult, error)
+	Lyrics(id FileId) (Lyrics, error)
+
+	// RecentlyAdded lists the albums which contain tracks starting with
+	// the most recently added ones. The albums added after the specified
+	// time are marked as new.
+	RecentlyAdded(publicOnly bool, since time.Time, page Page) (RecentAlbums, error)
+
+	// RecordPlay increases the play count of the track.
+	RecordPlay(id FileId) error
+
+	// Gain returns the gain in dB which normalizes the loudness of the
+	// track. False is returned if the gain isn't known.
+	Gain(id FileId, normalization Normalization) (float64, bool, error)
+
+	// CanAccessTrack checks if the track is a part of an album which can
+	// be accessed.
+	CanAccessTrack(id FileId, publicOnly bool) (bool, error)
+
+	// NextTracks returns at most limit tracks which follow the track on
+	// its album.
+	NextTracks(id FileId, limit int) ([]FileId, error)
 }
 
 type Thumbnail struct {
@@ -46,6 +105,47 @@ type Track struct {
 	FileId   FileId  `json:"fileId,omitempty"`
 	Title    string  `json:"title,omitempty"`
 	Duration float64 `json:"duration,omitempty"`
+
+	// Thumbnail is set if the cover art embedded in the track differs
+	// from the cover art of the album.
+	Thumbnail *Thumbnail `json:"thumbnail,omitempty"`
+
+	// Pending is set if the track wasn't probed yet. In that case the
+	// fields extracted from the track file are missing.
+	Pending bool `json:"pending,omitempty"`
+
+	// Lyrics is set if the lyrics of the track are available.
+	Lyrics bool `json:"lyrics,omitempty"`
+
+	// TrackGain and AlbumGain are the gains in dB which normalize the
+	// loudness of the track and the album. They are nil if the loudness
+	// isn't known yet.
+	TrackGain *float64 `json:"trackGain,omitempty"`
+	AlbumGain *float64 `json:"albumGain,omitempty"`
+
+	// Fields below are extracted from the tags embedded in the track file
+	// and are set to their zero values if not available.
+	Artist      string `json:"artist,omitempty"`
+	AlbumArtist string `json:"albumArtist,omitempty"`
+	Album       string `json:"album,omitempty"`
+	TrackNumber int    `json:"trackNumber,omitempty"`
+	DiscNumber  int    `json:"discNumber,omitempty"`
+	Year        int    `json:"year,omitempty"`
+	Genre       string `json:"genre,omitempty"`
+}
+
+type Lyrics struct {
+	// Lines are set if the lyrics are synchronized with the track.
+	Lines []LyricsLine `json:"lines,omitempty"`
+
+	// Text is set if the lyrics aren't synchronized with the track.
+	Text string `json:"text,omitempty"`
+}
+
+type LyricsLine struct {
+	// Time is expressed in seconds just like the duration of the track.
+	Time float64 `json:"time"`
+	Text string  `json:"text"`
 }
 
 type Album struct {
@@ -60,6 +160,14 @@ type Album struct {
 	Parents []Album `json:"parents,omitempty"`
 	Albums  []Album `json:"albums,omitempty"`
 	Tracks  []Track `json:"tracks,omitempty"`
+
+	// TotalAlbums and TotalTracks are the numbers of all albums and tracks
+	// in this album regardless of the requested listing. NextCursor is set
+	// if more albums or tracks can be listed after the returned ones. These
+	// fields are set only for the browsed album.
+	TotalAlbums int    `json:"totalAlbums,omitempty"`
+	TotalTracks int    `json:"totalTracks,omitempty"`
+	NextCursor  string `json:"nextCursor,omitempty"`
 }
 
 type Access struct {
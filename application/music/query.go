@@ -0,0 +1,420 @@
+package music
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxQueryLength = 100
+
+// Query is a parsed search query. A query consists of terms separated by
+// spaces which all have to be satisfied by the results:
+//
+//	word                  matches a word in the title, artist or album
+//	"some phrase"         matches consecutive words
+//	field:value           matches a word in a field eg. artist:beatles
+//	field:"some phrase"   matches consecutive words in a field
+//	year:1990..1999       matches a range, either end can be omitted
+//	duration:>600         compares a number using <, <=, >, >= or =
+//	-term                 excludes the results matching the term
+//
+// Durations are specified in seconds or as m:ss or h:mm:ss.
+type Query struct {
+	s     string
+	terms []QueryTerm
+}
+
+func NewQuery(s string) (Query, error) {
+	if s == "" {
+		return Query{}, errors.New("query can not be empty")
+	}
+
+	if len(s) > maxQueryLength {
+		return Query{}, fmt.Errorf("query can not be longer than %d", maxQueryLength)
+	}
+
+	terms, err := parseQuery(s)
+	if err != nil {
+		return Query{}, err
+	}
+
+	if len(terms) == 0 {
+		return Query{}, errors.New("query doesn't contain any terms")
+	}
+
+	return Query{
+		s:     s,
+		terms: terms,
+	}, nil
+}
+
+func MustNewQuery(s string) Query {
+	v, err := NewQuery(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (q Query) IsZero() bool {
+	return q.s == ""
+}
+
+func (q Query) String() string {
+	return q.s
+}
+
+// Terms returns the terms of the query which all have to be satisfied by the
+// results.
+func (q Query) Terms() []QueryTerm {
+	terms := make([]QueryTerm, len(q.terms))
+	copy(terms, q.terms)
+	return terms
+}
+
+type QueryField string
+
+const (
+	// QueryFieldAny matches the title, artist or album.
+	QueryFieldAny QueryField = ""
+
+	QueryFieldTitle    QueryField = "title"
+	QueryFieldArtist   QueryField = "artist"
+	QueryFieldAlbum    QueryField = "album"
+	QueryFieldGenre    QueryField = "genre"
+	QueryFieldPath     QueryField = "path"
+	QueryFieldFormat   QueryField = "format"
+	QueryFieldYear     QueryField = "year"
+	QueryFieldDuration QueryField = "duration"
+)
+
+// IsNumeric returns true if the values of the field are compared as
+// numbers.
+func (f QueryField) IsNumeric() bool {
+	return f == QueryFieldYear || f == QueryFieldDuration
+}
+
+var queryFields = map[QueryField]bool{
+	QueryFieldTitle:    true,
+	QueryFieldArtist:   true,
+	QueryFieldAlbum:    true,
+	QueryFieldGenre:    true,
+	QueryFieldPath:     true,
+	QueryFieldFormat:   true,
+	QueryFieldYear:     true,
+	QueryFieldDuration: true,
+}
+
+// QueryTerm is a single condition of a query. Terms of numeric fields have
+// comparisons, other terms have text.
+type QueryTerm struct {
+	Field   QueryField
+	Negated bool
+
+	// Text is matched against the words of the field. If the text isn't a
+	// phrase then the last word of the text may also match a prefix of a
+	// word.
+	Text   string
+	Phrase bool
+
+	// Comparisons are all satisfied by the matching values. Durations are
+	// compared in seconds.
+	Comparisons []QueryComparison
+}
+
+type QueryOperator string
+
+const (
+	QueryOperatorEqual          QueryOperator = "="
+	QueryOperatorLess           QueryOperator = "<"
+	QueryOperatorLessOrEqual    QueryOperator = "<="
+	QueryOperatorGreater        QueryOperator = ">"
+	QueryOperatorGreaterOrEqual QueryOperator = ">="
+)
+
+type QueryComparison struct {
+	Operator QueryOperator
+	Value    float64
+}
+
+// Matches returns true if the value satisfies the comparison.
+func (c QueryComparison) Matches(v float64) bool {
+	switch c.Operator {
+	case QueryOperatorLess:
+		return v < c.Value
+	case QueryOperatorLessOrEqual:
+		return v <= c.Value
+	case QueryOperatorGreater:
+		return v > c.Value
+	case QueryOperatorGreaterOrEqual:
+		return v >= c.Value
+	default:
+		return v == c.Value
+	}
+}
+
+// QuerySyntaxError describes why a query couldn't be parsed. The position is
+// the number of the character at which the error was found starting from 1.
+type QuerySyntaxError struct {
+	Position int
+	Message  string
+}
+
+func (e QuerySyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+}
+
+type queryParser struct {
+	s   string
+	pos int // byte offset
+}
+
+func parseQuery(s string) ([]QueryTerm, error) {
+	p := &queryParser{s: s}
+
+	var terms []QueryTerm
+	for {
+		p.skipSpaces()
+		if p.done() {
+			return terms, nil
+		}
+
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+}
+
+func (p *queryParser) parseTerm() (QueryTerm, error) {
+	var term QueryTerm
+
+	if p.peek() == '-' {
+		negation := p.position()
+		p.pos++
+		if p.done() || p.isSpace() {
+			return QueryTerm{}, p.errorAt(negation, "expected a term after '-'")
+		}
+		term.Negated = true
+	}
+
+	if field, ok := p.parseField(); ok {
+		if !queryFields[field] {
+			return QueryTerm{}, p.errorf("unknown field '%s', quote the term to search for it", field)
+		}
+		fieldStart := p.position()
+		term.Field = field
+		p.pos += len(field) + 1
+
+		if p.done() || p.isSpace() {
+			return QueryTerm{}, p.errorAt(fieldStart, "expected a value after '%s:'", field)
+		}
+	}
+
+	start := p.position()
+	value, phrase, err := p.parseValue()
+	if err != nil {
+		return QueryTerm{}, err
+	}
+
+	if term.Field.IsNumeric() {
+		if phrase {
+			return QueryTerm{}, p.errorAt(start, "value of '%s' can not be quoted", term.Field)
+		}
+
+		comparisons, err := parseComparisons(term.Field, value)
+		if err != nil {
+			return QueryTerm{}, p.errorAt(start, "%s", err)
+		}
+		term.Comparisons = comparisons
+		return term, nil
+	}
+
+	term.Text = value
+	term.Phrase = phrase
+	return term, nil
+}
+
+// parseField returns the name of the field if the term starts with one.
+func (p *queryParser) parseField() (QueryField, bool) {
+	for i, r := range p.s[p.pos:] {
+		if r == ':' {
+			return QueryField(p.s[p.pos : p.pos+i]), i > 0
+		}
+		if !unicode.IsLetter(r) {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (p *queryParser) parseValue() (string, bool, error) {
+	if p.peek() != '"' {
+		start := p.pos
+		for !p.done() && !p.isSpace() {
+			if p.peek() == '"' {
+				return "", false, p.errorf("unexpected quote")
+			}
+			p.next()
+		}
+		return p.s[start:p.pos], false, nil
+	}
+
+	open := p.position()
+	p.pos++
+
+	end := strings.IndexRune(p.s[p.pos:], '"')
+	if end < 0 {
+		return "", false, p.errorAt(open, "unterminated quote")
+	}
+
+	value := p.s[p.pos : p.pos+end]
+	p.pos += end + 1
+
+	if !p.done() && !p.isSpace() {
+		return "", false, p.errorf("expected a space after the closing quote")
+	}
+
+	if strings.TrimSpace(value) == "" {
+		return "", false, p.errorAt(open, "empty phrase")
+	}
+
+	return value, true, nil
+}
+
+func (p *queryParser) skipSpaces() {
+	for !p.done() && p.isSpace() {
+		p.next()
+	}
+}
+
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *queryParser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.s[p.pos:])
+	return r
+}
+
+func (p *queryParser) next() {
+	_, size := utf8.DecodeRuneInString(p.s[p.pos:])
+	p.pos += size
+}
+
+func (p *queryParser) isSpace() bool {
+	return unicode.IsSpace(p.peek())
+}
+
+// position returns the current position counted in characters starting
+// from 1.
+func (p *queryParser) position() int {
+	return utf8.RuneCountInString(p.s[:p.pos]) + 1
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.position(), format, args...)
+}
+
+func (p *queryParser) errorAt(position int, format string, args ...interface{}) error {
+	return QuerySyntaxError{
+		Position: position,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// parseComparisons parses a range such as 1990..1999 or a comparison such
+// as >=1990 or 1990.
+func parseComparisons(field QueryField, s string) ([]QueryComparison, error) {
+	if i := strings.Index(s, ".."); i >= 0 {
+		from, to := s[:i], s[i+2:]
+		if from == "" && to == "" {
+			return nil, fmt.Errorf("range of '%s' needs at least one end", field)
+		}
+
+		var comparisons []QueryComparison
+		if from != "" {
+			v, err := parseNumber(field, from)
+			if err != nil {
+				return nil, err
+			}
+			comparisons = append(comparisons, QueryComparison{Operator: QueryOperatorGreaterOrEqual, Value: v})
+		}
+		if to != "" {
+			v, err := parseNumber(field, to)
+			if err != nil {
+				return nil, err
+			}
+			comparisons = append(comparisons, QueryComparison{Operator: QueryOperatorLessOrEqual, Value: v})
+		}
+		if len(comparisons) == 2 && comparisons[0].Value > comparisons[1].Value {
+			return nil, fmt.Errorf("range of '%s' can not end before it starts", field)
+		}
+		return comparisons, nil
+	}
+
+	operator := QueryOperatorEqual
+	for _, o := range []QueryOperator{
+		QueryOperatorLessOrEqual,
+		QueryOperatorGreaterOrEqual,
+		QueryOperatorLess,
+		QueryOperatorGreater,
+		QueryOperatorEqual,
+	} {
+		if strings.HasPrefix(s, string(o)) {
+			operator = o
+			s = s[len(o):]
+			break
+		}
+	}
+
+	v, err := parseNumber(field, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return []QueryComparison{{Operator: operator, Value: v}}, nil
+}
+
+func parseNumber(field QueryField, s string) (float64, error) {
+	if field == QueryFieldDuration {
+		v, ok := parseDuration(s)
+		if !ok {
+			return 0, fmt.Errorf("invalid duration '%s', expected seconds, m:ss or h:mm:ss", s)
+		}
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s', expected a number", field, s)
+	}
+	return float64(v), nil
+}
+
+// parseDuration parses seconds or a duration formatted as m:ss or h:mm:ss
+// and returns the number of seconds.
+func parseDuration(s string) (float64, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+
+	var seconds float64
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return 0, false
+		}
+		if i > 0 && (len(part) != 2 || v >= 60) {
+			return 0, false
+		}
+		seconds = seconds*60 + float64(v)
+	}
+	return seconds, true
+}
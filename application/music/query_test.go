@@ -0,0 +1,215 @@
+package music_test
+
+import (
+	"testing"
+
+	"github.com/boreq/eggplant/application/music"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewQuery(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Query         string
+		ExpectedTerms []music.QueryTerm
+		ExpectedError string
+	}{
+		{
+			Name:  "words",
+			Query: "  abbey   road ",
+			ExpectedTerms: []music.QueryTerm{
+				{Text: "abbey"},
+				{Text: "road"},
+			},
+		},
+		{
+			Name:  "phrase",
+			Query: `"abbey road" -"let it be"`,
+			ExpectedTerms: []music.QueryTerm{
+				{Text: "abbey road", Phrase: true},
+				{Text: "let it be", Phrase: true, Negated: true},
+			},
+		},
+		{
+			Name:  "fields",
+			Query: `artist:"the beatles" -album:help format:flac genre:rock path:a/b title:x`,
+			ExpectedTerms: []music.QueryTerm{
+				{Field: music.QueryFieldArtist, Text: "the beatles", Phrase: true},
+				{Field: music.QueryFieldAlbum, Text: "help", Negated: true},
+				{Field: music.QueryFieldFormat, Text: "flac"},
+				{Field: music.QueryFieldGenre, Text: "rock"},
+				{Field: music.QueryFieldPath, Text: "a/b"},
+				{Field: music.QueryFieldTitle, Text: "x"},
+			},
+		},
+		{
+			Name:  "ranges",
+			Query: "year:1990..1999 year:..2000 year:1990.. year:1995",
+			ExpectedTerms: []music.QueryTerm{
+				{
+					Field: music.QueryFieldYear,
+					Comparisons: []music.QueryComparison{
+						{Operator: music.QueryOperatorGreaterOrEqual, Value: 1990},
+						{Operator: music.QueryOperatorLessOrEqual, Value: 1999},
+					},
+				},
+				{
+					Field: music.QueryFieldYear,
+					Comparisons: []music.QueryComparison{
+						{Operator: music.QueryOperatorLessOrEqual, Value: 2000},
+					},
+				},
+				{
+					Field: music.QueryFieldYear,
+					Comparisons: []music.QueryComparison{
+						{Operator: music.QueryOperatorGreaterOrEqual, Value: 1990},
+					},
+				},
+				{
+					Field: music.QueryFieldYear,
+					Comparisons: []music.QueryComparison{
+						{Operator: music.QueryOperatorEqual, Value: 1995},
+					},
+				},
+			},
+		},
+		{
+			Name:  "comparisons",
+			Query: "duration:>600 duration:<=3:05 -duration:>=1:00:00",
+			ExpectedTerms: []music.QueryTerm{
+				{
+					Field: music.QueryFieldDuration,
+					Comparisons: []music.QueryComparison{
+						{Operator: music.QueryOperatorGreater, Value: 600},
+					},
+				},
+				{
+					Field: music.QueryFieldDuration,
+					Comparisons: []music.QueryComparison{
+						{Operator: music.QueryOperatorLessOrEqual, Value: 185},
+					},
+				},
+				{
+					Field:   music.QueryFieldDuration,
+					Negated: true,
+					Comparisons: []music.QueryComparison{
+						{Operator: music.QueryOperatorGreaterOrEqual, Value: 3600},
+					},
+				},
+			},
+		},
+		{
+			Name:          "empty",
+			Query:         "",
+			ExpectedError: "query can not be empty",
+		},
+		{
+			Name:          "only_spaces",
+			Query:         "   ",
+			ExpectedError: "query doesn't contain any terms",
+		},
+		{
+			Name:          "unterminated_quote",
+			Query:         `abbey "road`,
+			ExpectedError: "unterminated quote at position 7",
+		},
+		{
+			Name:          "empty_phrase",
+			Query:         `"  "`,
+			ExpectedError: "empty phrase at position 1",
+		},
+		{
+			Name:          "text_after_quote",
+			Query:         `"abbey"road`,
+			ExpectedError: "expected a space after the closing quote at position 8",
+		},
+		{
+			Name:          "quote_inside_word",
+			Query:         `abb"ey`,
+			ExpectedError: "unexpected quote at position 4",
+		},
+		{
+			Name:          "lone_negation",
+			Query:         "abbey - road",
+			ExpectedError: "expected a term after '-' at position 7",
+		},
+		{
+			Name:          "only_negation",
+			Query:         "-",
+			ExpectedError: "expected a term after '-' at position 1",
+		},
+		{
+			Name:          "unknown_field",
+			Query:         "re:zero",
+			ExpectedError: "unknown field 're', quote the term to search for it at position 1",
+		},
+		{
+			Name:          "missing_value",
+			Query:         "björk artist:",
+			ExpectedError: "expected a value after 'artist:' at position 7",
+		},
+		{
+			Name:          "invalid_year",
+			Query:         "year:nineties",
+			ExpectedError: "invalid year 'nineties', expected a number at position 6",
+		},
+		{
+			Name:          "invalid_duration",
+			Query:         "duration:>3:5",
+			ExpectedError: "invalid duration '3:5', expected seconds, m:ss or h:mm:ss at position 10",
+		},
+		{
+			Name:          "empty_range",
+			Query:         "year:..",
+			ExpectedError: "range of 'year' needs at least one end at position 6",
+		},
+		{
+			Name:          "inverted_range",
+			Query:         "year:1999..1990",
+			ExpectedError: "range of 'year' can not end before it starts at position 6",
+		},
+		{
+			Name:          "quoted_number",
+			Query:         `year:"1990"`,
+			ExpectedError: "value of 'year' can not be quoted at position 6",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			query, err := music.NewQuery(testCase.Query)
+			if testCase.ExpectedError != "" {
+				require.EqualError(t, err, testCase.ExpectedError)
+				require.True(t, query.IsZero())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.ExpectedTerms, query.Terms())
+			require.Equal(t, testCase.Query, query.String())
+		})
+	}
+}
+
+func TestQueryComparisonMatches(t *testing.T) {
+	testCases := []struct {
+		Operator music.QueryOperator
+		Value    float64
+		Expected bool
+	}{
+		{music.QueryOperatorEqual, 10, true},
+		{music.QueryOperatorEqual, 11, false},
+		{music.QueryOperatorLess, 9, true},
+		{music.QueryOperatorLess, 10, false},
+		{music.QueryOperatorLessOrEqual, 10, true},
+		{music.QueryOperatorLessOrEqual, 11, false},
+		{music.QueryOperatorGreater, 11, true},
+		{music.QueryOperatorGreater, 10, false},
+		{music.QueryOperatorGreaterOrEqual, 10, true},
+		{music.QueryOperatorGreaterOrEqual, 9, false},
+	}
+
+	for _, testCase := range testCases {
+		c := music.QueryComparison{Operator: testCase.Operator, Value: 10}
+		require.Equal(t, testCase.Expected, c.Matches(testCase.Value), "%s %v", testCase.Operator, testCase.Value)
+	}
+}
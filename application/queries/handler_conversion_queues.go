@@ -0,0 +1,24 @@
+package queries
+
+type ConversionQueuesHandler struct {
+	trackStore     TrackStore
+	thumbnailStore ThumbnailStore
+}
+
+func NewConversionQueuesHandler(
+	trackStore TrackStore,
+	thumbnailStore ThumbnailStore,
+) *ConversionQueuesHandler {
+	return &ConversionQueuesHandler{
+		trackStore:     trackStore,
+		thumbnailStore: thumbnailStore,
+	}
+}
+
+func (h *ConversionQueuesHandler) Execute() (ConversionQueues, error) {
+	queues := ConversionQueues{
+		Thumbnails: h.thumbnailStore.GetConversionQueueStats(),
+		Tracks:     h.trackStore.GetConversionQueueStats(),
+	}
+	return queues, nil
+}
@@ -0,0 +1,19 @@
+package queries
+
+type ScanProblemsHandler struct {
+	library Library
+}
+
+func NewScanProblemsHandler(library Library) *ScanProblemsHandler {
+	return &ScanProblemsHandler{
+		library: library,
+	}
+}
+
+func (h *ScanProblemsHandler) Execute() ([]ScanProblem, error) {
+	problems := h.library.ScanProblems()
+	if problems == nil {
+		problems = make([]ScanProblem, 0)
+	}
+	return problems, nil
+}
@@ -6,10 +6,12 @@ type UserRepository interface {
 
 type TrackStore interface {
 	GetStats() (StoreStats, error)
+	GetConversionQueueStats() ConversionQueueStats
 }
 
 type ThumbnailStore interface {
 	GetStats() (StoreStats, error)
+	GetConversionQueueStats() ConversionQueueStats
 }
 
 type Stats struct {
@@ -25,6 +27,32 @@ type StoreStats struct {
 	ConvertedSize  int64 `json:"convertedSize"`
 }
 
+type ConversionQueues struct {
+	Thumbnails ConversionQueueStats `json:"thumbnails"`
+	Tracks     ConversionQueueStats `json:"tracks"`
+}
+
+// ConversionQueueStats describes the conversions performed by a store. The
+// conversions which the clients are waiting for are started before the
+// background conversions.
+type ConversionQueueStats struct {
+	Workers     int                     `json:"workers"`
+	Interactive ConversionPriorityStats `json:"interactive"`
+	Background  ConversionPriorityStats `json:"background"`
+}
+
+// ConversionPriorityStats describes the conversions with one priority. The
+// wait times are expressed in seconds. AverageWait is measured over all
+// conversions started so far and LongestWait is the wait time of the oldest
+// queued conversion.
+type ConversionPriorityStats struct {
+	Queued      int     `json:"queued"`
+	Running     int     `json:"running"`
+	Started     int64   `json:"started"`
+	AverageWait float64 `json:"averageWait"`
+	LongestWait float64 `json:"longestWait"`
+}
+
 type TransactionProvider interface {
 	Read(handler TransactionHandler) error
 }
@@ -34,3 +62,14 @@ type TransactionHandler func(repositories *TransactableRepositories) error
 type TransactableRepositories struct {
 	Users UserRepository
 }
+
+type Library interface {
+	ScanProblems() []ScanProblem
+}
+
+// ScanProblem describes a path in the music directory which was skipped
+// during the scan.
+type ScanProblem struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
@@ -11,6 +11,7 @@ var MainCmd = guinea.Command{
 		"run":            &runCmd,
 		"default_config": &defaultConfigCmd,
 		"users":          &users.UsersCmd,
+		"scan":           &scanCmd,
 	},
 	ShortDescription: "a music streaming service",
 	Description: `
@@ -71,8 +71,10 @@ func validateConfig(conf *config.Config) error {
 		return errors.Wrap(err, "problem with the data directory")
 	}
 
-	if err := validateDirectory(conf.MusicDirectory); err != nil {
-		return errors.Wrap(err, "problem with the music directory")
+	for _, root := range conf.Roots() {
+		if err := validateDirectory(root.Directory); err != nil {
+			return errors.Wrapf(err, "problem with the music directory '%s'", root.Directory)
+		}
 	}
 
 	return nil
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/boreq/eggplant/application/queries"
+	"github.com/boreq/eggplant/internal/wire"
+	"github.com/boreq/errors"
+	"github.com/boreq/guinea"
+)
+
+var scanCmd = guinea.Command{
+	Run: runScan,
+	Arguments: []guinea.Argument{
+		{
+			Name:        "config",
+			Optional:    false,
+			Multiple:    false,
+			Description: "Path to a configuration file",
+		},
+	},
+	ShortDescription: "lists the paths which are skipped when scanning your music",
+	Description: `
+Scans the music directories and lists the paths which couldn't be scanned
+together with the reasons why they were skipped. Those paths are not
+displayed in the library.
+`,
+}
+
+func runScan(c guinea.Context) error {
+	conf, err := loadConfig(c.Arguments[0])
+	if err != nil {
+		return errors.Wrap(err, "could not load the configuration")
+	}
+
+	scanners, err := wire.BuildScanners(conf)
+	if err != nil {
+		return errors.Wrap(err, "could not create the scanners")
+	}
+
+	result := make([]queries.ScanProblem, 0)
+	for _, scanner := range scanners {
+		_, problems, err := scanner.Load()
+		if err != nil {
+			return errors.Wrap(err, "scan failed")
+		}
+
+		for _, problem := range problems {
+			result = append(result, queries.ScanProblem{
+				Path:   problem.Path,
+				Reason: problem.Reason,
+			})
+		}
+	}
+
+	j, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal to json")
+	}
+
+	fmt.Println(string(j))
+
+	return nil
+}
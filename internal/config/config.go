@@ -3,6 +3,7 @@ package config
 
 import (
 	"io"
+	"time"
 
 	"github.com/pelletier/go-toml"
 )
@@ -12,9 +13,50 @@ type ExposedConfig struct {
 
 	MusicDirectory string `toml:"music_directory" comment:"Path to a directory containing your music."`
 
+	MusicRoots []MusicRoot `toml:"music_roots" comment:"Multiple directories containing your music. Each directory is displayed as\n a separate top-level album with the specified name. If this list isn't empty\n then the music directory is ignored."`
+
 	DataDirectory string `toml:"data_directory" comment:"Path to a directory which will be used for data storage. Eggplant will store\n its database in this directory. This directory should never be purged."`
 
 	CacheDirectory string `toml:"cache_directory" comment:"Path to a directory which will be used for caching converted tracks and\n thumbnails. You should not remove files from this directory unless necessary\n as Eggplant ensures that old data is automatically removed and removing the\n cached files will force Eggplant to convert all tracks and thumbnails again."`
+
+	Watcher string `toml:"watcher" comment:"Specifies how changes in the music directory are detected. The \"notify\"\n watcher relies on the notifications provided by the operating system and\n reacts to changes almost immediately. The \"poll\" watcher periodically\n lists the contents of the music directory and should be used if the\n notifications are not available eg. on network file systems."`
+
+	WatcherDebounce time.Duration `toml:"watcher_debounce" comment:"Changes which occur within this period are processed together. This\n prevents the library from being updated many times while large amounts of\n files are being copied into the music directory."`
+
+	WatcherPollingInterval time.Duration `toml:"watcher_polling_interval" comment:"Specifies how often the music directory is listed when the \"poll\"\n watcher is used."`
+
+	TrackIdentity string `toml:"track_identity" comment:"Specifies how the tracks are identified. The \"path\" identity changes\n whenever a track is renamed, moved or modified. The \"content\" identity is\n based on the contents of the files and is preserved when the tracks are\n renamed or moved which keeps links to them working. Changing this setting\n causes all tracks to be converted again."`
+
+	DiscDirectoryPatterns []string `toml:"disc_directory_patterns" comment:"Directories which names match one of those regular expressions are treated\n as discs and merged into their parent albums eg. \"CD1\" and \"CD2\". The\n first subexpression of each regular expression has to match the disc\n number."`
+
+	TranscodingProfiles []TranscodingProfile `toml:"transcoding_profiles" comment:"Formats to which the tracks are converted. The clients select a profile by\n its name and the first profile is used if they don't. The output of each\n profile is cached separately."`
+
+	DirectStreamingMaxBitrate int `toml:"direct_streaming_max_bitrate" comment:"The original files are sent to the clients which can play them instead of\n being transcoded if their bitrate doesn't exceed this limit in kbit/s.\n Setting this to zero disables the direct streaming."`
+
+	PreTranscodingDepth int `toml:"pretranscoding_depth" comment:"Number of tracks following the played track on its album which are\n converted in the background so that they can be played without waiting.\n Setting this to zero disables the pre-transcoding."`
+
+	TrackConversionWorkers int `toml:"track_conversion_workers" comment:"Number of tracks which are converted at the same time. The conversions\n which the users are waiting for are started before the background ones.\n Setting this to zero uses the number of CPUs."`
+
+	ThumbnailConversionWorkers int `toml:"thumbnail_conversion_workers" comment:"Number of thumbnails which are converted at the same time. Setting this to\n zero uses the number of CPUs."`
+
+	IgnorePatterns []string `toml:"ignore_patterns" comment:"Paths matching one of those gitignore-style patterns are excluded from the\n library. Additional patterns can be placed in \".eggplantignore\" files. The\n patterns listed in those files apply only to the directories in which the\n files are located."`
+}
+
+// MusicRoot is a directory which is displayed as a top-level album.
+type MusicRoot struct {
+	Name      string `toml:"name" comment:"Title of the top-level album."`
+	Directory string `toml:"directory" comment:"Path to a directory containing your music."`
+	Public    bool   `toml:"public" comment:"Specifies if the albums are public unless access files state otherwise."`
+}
+
+// TranscodingProfile describes the format of the converted tracks.
+type TranscodingProfile struct {
+	Name       string `toml:"name" comment:"Name of the profile which can contain lowercase letters, digits and dashes."`
+	Codec      string `toml:"codec" comment:"Name of the ffmpeg encoder eg. \"libopus\", \"libmp3lame\" or \"aac\"."`
+	Bitrate    string `toml:"bitrate" comment:"Bitrate passed to ffmpeg eg. \"96k\". The default bitrate of the encoder is\n used if it is empty."`
+	Container  string `toml:"container" comment:"Extension of the converted files which selects their format eg. \"ogg\",\n \"mp3\" or \"m4a\"."`
+	SampleRate int    `toml:"sample_rate" comment:"Sample rate in Hz. The sample rate of the track is preserved if it is zero."`
+	Channels   int    `toml:"channels" comment:"Number of channels. The channels of the track are preserved if it is zero."`
 }
 
 type Config struct {
@@ -46,6 +88,57 @@ func Default() *Config {
 			MusicDirectory: "/path/to/music",
 			DataDirectory:  "/path/to/data",
 			CacheDirectory: "/path/to/cache",
+
+			Watcher:                "notify",
+			WatcherDebounce:        2 * time.Second,
+			WatcherPollingInterval: 10 * time.Second,
+
+			TrackIdentity: "path",
+
+			DiscDirectoryPatterns: []string{
+				`(?i)^(?:cd|disc|disk)[ _.-]*(\d+)\b`,
+			},
+
+			IgnorePatterns: []string{
+				"@eaDir/",
+			},
+
+			DirectStreamingMaxBitrate: 320,
+
+			PreTranscodingDepth: 2,
+
+			TranscodingProfiles: []TranscodingProfile{
+				{
+					Name:      "default",
+					Codec:     "libopus",
+					Bitrate:   "96k",
+					Container: "ogg",
+				},
+				{
+					Name:      "low",
+					Codec:     "libopus",
+					Bitrate:   "48k",
+					Container: "ogg",
+				},
+				{
+					Name:      "high",
+					Codec:     "libopus",
+					Bitrate:   "192k",
+					Container: "ogg",
+				},
+				{
+					Name:      "aac",
+					Codec:     "aac",
+					Bitrate:   "192k",
+					Container: "m4a",
+				},
+				{
+					Name:      "mp3",
+					Codec:     "libmp3lame",
+					Bitrate:   "320k",
+					Container: "mp3",
+				},
+			},
 		},
 		TrackExtensions: []string{
 			".flac",
@@ -73,6 +166,20 @@ func Default() *Config {
 	return conf
 }
 
+// Roots returns the configured music roots. If no music roots are configured
+// then the music directory is returned as a single root without a name.
+func (c *Config) Roots() []MusicRoot {
+	if len(c.MusicRoots) > 0 {
+		return c.MusicRoots
+	}
+
+	return []MusicRoot{
+		{
+			Directory: c.MusicDirectory,
+		},
+	}
+}
+
 func Marshal(w io.Writer, cfg ExposedConfig) error {
 	return toml.NewEncoder(w).Encode(cfg)
 }
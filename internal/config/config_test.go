@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/boreq/eggplant/internal/config"
@@ -28,3 +29,39 @@ func TestGolden(t *testing.T) {
 
 	require.Equal(t, string(expected), actual.String())
 }
+
+func TestRoots(t *testing.T) {
+	conf := config.Default()
+
+	require.Equal(t, []config.MusicRoot{
+		{
+			Directory: "/path/to/music",
+		},
+	}, conf.Roots())
+
+	input := `
+[[music_roots]]
+name = "Music"
+directory = "/path/to/music"
+
+[[music_roots]]
+name = "Incoming"
+directory = "/path/to/incoming"
+public = true
+`
+
+	err := config.Unmarshal(strings.NewReader(input), &conf.ExposedConfig)
+	require.NoError(t, err)
+
+	require.Equal(t, []config.MusicRoot{
+		{
+			Name:      "Music",
+			Directory: "/path/to/music",
+		},
+		{
+			Name:      "Incoming",
+			Directory: "/path/to/incoming",
+			Public:    true,
+		},
+	}, conf.Roots())
+}
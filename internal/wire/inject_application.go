@@ -24,15 +24,22 @@ var appSet = wire.NewSet(
 	auth.NewRegisterHandler,
 	auth.NewRemoveHandler,
 	auth.NewSetPasswordHandler,
+	auth.NewSetNormalizationHandler,
 
 	wire.Struct(new(application.Music), "*"),
 	music.NewTrackHandler,
 	music.NewThumbnailHandler,
 	music.NewBrowseHandler,
 	music.NewSearchHandler,
+	music.NewLyricsHandler,
+	music.NewRecordPlayHandler,
+	music.NewRecentlyAddedHandler,
+	music.NewSubscribeHandler,
 
 	wire.Struct(new(application.Queries), "*"),
 	queries.NewStatsHandler,
+	queries.NewScanProblemsHandler,
+	queries.NewConversionQueuesHandler,
 
 	authAdapters.NewAuthTransactionProvider,
 	wire.Bind(new(auth.TransactionProvider), new(*authAdapters.AuthTransactionProvider)),
@@ -2,7 +2,9 @@ package wire
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/boreq/eggplant/adapters/music/events"
 	"github.com/boreq/eggplant/adapters/music/library"
 	"github.com/boreq/eggplant/adapters/music/scanner"
 	"github.com/boreq/eggplant/adapters/music/store"
@@ -16,58 +18,125 @@ import (
 //lint:ignore U1000 because
 var musicSet = wire.NewSet(
 	newLibrary,
+	newRoots,
+	newScanners,
 	newTrackStore,
 	newThumbnailStore,
 	newScannerConfig,
+	newPreTranscodingDepth,
+	newIdGenerator,
 	library.NewDelimiterAccessLoader,
-	library.NewIdGenerator,
+	library.NewBoltIdentityRepository,
+	library.NewBoltSnapshotRepository,
+	library.NewBoltPlayCountRepository,
+	store.NewBoltMetadataCache,
+	events.NewBus,
 
 	wire.Bind(new(library.AccessLoader), new(*library.DelimiterAccessLoader)),
+	wire.Bind(new(library.IdentityRepository), new(*library.BoltIdentityRepository)),
+	wire.Bind(new(library.SnapshotRepository), new(*library.BoltSnapshotRepository)),
+	wire.Bind(new(library.PlayCountRepository), new(*library.BoltPlayCountRepository)),
+	wire.Bind(new(library.EventPublisher), new(*events.Bus)),
+	wire.Bind(new(store.MetadataCache), new(*store.BoltMetadataCache)),
+	wire.Bind(new(store.EventPublisher), new(*events.Bus)),
 	wire.Bind(new(library.TrackStore), new(*store.TrackStore)),
 	wire.Bind(new(library.ThumbnailStore), new(*store.Store)),
 	wire.Bind(new(music.TrackStore), new(*store.TrackStore)),
 	wire.Bind(new(music.ThumbnailStore), new(*store.Store)),
 	wire.Bind(new(music.Library), new(*library.Library)),
+	wire.Bind(new(music.EventSubscriber), new(*events.Bus)),
 	wire.Bind(new(queries.TrackStore), new(*store.TrackStore)),
 	wire.Bind(new(queries.ThumbnailStore), new(*store.Store)),
+	wire.Bind(new(queries.Library), new(*library.Library)),
 )
 
 func newLibrary(
+	roots []library.Root,
 	accessLoader library.AccessLoader,
 	trackStore library.TrackStore,
 	thumbnailStore library.ThumbnailStore,
 	idGenerator library.IdGenerator,
-	conf *config.Config,
-	scannerConf scanner.Config,
+	snapshotRepository library.SnapshotRepository,
+	playCountRepository library.PlayCountRepository,
+	events library.EventPublisher,
 ) (*library.Library, error) {
-	scan, err := scanner.New(conf.MusicDirectory, scannerConf)
+	lib, err := library.New(roots, trackStore, thumbnailStore, accessLoader, idGenerator, snapshotRepository, playCountRepository, events)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not create a scanner")
+		return nil, errors.Wrap(err, "could not create a library")
 	}
 
-	ch, err := scan.Start()
-	if err != nil {
-		return nil, errors.Wrap(err, "could not start a scanner")
+	return lib, nil
+}
+
+// newScanners creates a scanner for each music root.
+func newScanners(conf *config.Config, scannerConf scanner.Config) ([]*scanner.Scanner, error) {
+	var scanners []*scanner.Scanner
+	for _, root := range conf.Roots() {
+		scan, err := scanner.New(root.Directory, scannerConf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not create a scanner for '%s'", root.Directory)
+		}
+		scanners = append(scanners, scan)
 	}
+	return scanners, nil
+}
 
-	lib, err := library.New(ch, trackStore, thumbnailStore, accessLoader, idGenerator)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not create a library")
+func newRoots(conf *config.Config, scanners []*scanner.Scanner) []library.Root {
+	var roots []library.Root
+	for i, root := range conf.Roots() {
+		libraryRoot := library.Root{
+			Title:   root.Name,
+			Scanner: scanners[i],
+		}
+
+		if root.Public {
+			libraryRoot.DefaultAccess = &music.Access{
+				Public: true,
+			}
+		}
+
+		roots = append(roots, libraryRoot)
 	}
+	return roots
+}
 
-	return lib, nil
+func newIdGenerator(ctx context.Context, conf *config.Config, identityRepository library.IdentityRepository) (library.IdGenerator, error) {
+	switch conf.TrackIdentity {
+	case "path":
+		return library.NewIdGenerator(), nil
+	case "content":
+		idGenerator, err := library.NewContentIdGenerator(ctx, identityRepository)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create a content id generator")
+		}
+		return idGenerator, nil
+	default:
+		return nil, fmt.Errorf("unknown track identity '%s'", conf.TrackIdentity)
+	}
 }
 
-func newTrackStore(ctx context.Context, conf *config.Config) (*store.TrackStore, error) {
-	trackStore, err := store.NewTrackStore(ctx, conf.CacheDirectory)
+func newTrackStore(ctx context.Context, conf *config.Config, metadataCache store.MetadataCache, events store.EventPublisher) (*store.TrackStore, error) {
+	var profiles []store.TranscodingProfile
+	for _, profile := range conf.TranscodingProfiles {
+		profiles = append(profiles, store.TranscodingProfile{
+			Name:       profile.Name,
+			Codec:      profile.Codec,
+			Bitrate:    profile.Bitrate,
+			Container:  profile.Container,
+			SampleRate: profile.SampleRate,
+			Channels:   profile.Channels,
+		})
+	}
+
+	trackStore, err := store.NewTrackStore(ctx, conf.CacheDirectory, metadataCache, events, profiles, conf.DirectStreamingMaxBitrate, conf.TrackConversionWorkers)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not create a track store")
 	}
 	return trackStore, nil
 }
 
-func newThumbnailStore(ctx context.Context, conf *config.Config) (*store.Store, error) {
-	thumbnailStore, err := store.NewThumbnailStore(ctx, conf.CacheDirectory)
+func newThumbnailStore(ctx context.Context, conf *config.Config, events store.EventPublisher) (*store.Store, error) {
+	thumbnailStore, err := store.NewThumbnailStore(ctx, conf.CacheDirectory, events, conf.ThumbnailConversionWorkers)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not create a thumbnail store")
 	}
@@ -79,5 +148,17 @@ func newScannerConfig(conf *config.Config) scanner.Config {
 		TrackExtensions:     conf.TrackExtensions,
 		ThumbnailStems:      conf.ThumbnailStems,
 		ThumbnailExtensions: conf.ThumbnailExtensions,
+
+		Watcher:                scanner.Watcher(conf.Watcher),
+		WatcherDebounce:        conf.WatcherDebounce,
+		WatcherPollingInterval: conf.WatcherPollingInterval,
+
+		DiscDirectoryPatterns: conf.DiscDirectoryPatterns,
+
+		IgnorePatterns: conf.IgnorePatterns,
 	}
 }
+
+func newPreTranscodingDepth(conf *config.Config) music.PreTranscodingDepth {
+	return music.PreTranscodingDepth(conf.PreTranscodingDepth)
+}
@@ -6,6 +6,7 @@ package wire
 import (
 	"context"
 
+	"github.com/boreq/eggplant/adapters/music/scanner"
 	"github.com/boreq/eggplant/application/auth"
 	"github.com/boreq/eggplant/application/queries"
 	"github.com/boreq/eggplant/internal/config"
@@ -47,6 +48,14 @@ func BuildAuth(conf *config.Config) (*auth.Auth, error) {
 	return nil, nil
 }
 
+func BuildScanners(conf *config.Config) ([]*scanner.Scanner, error) {
+	wire.Build(
+		musicSet,
+	)
+
+	return nil, nil
+}
+
 func BuildService(ctx context.Context, conf *config.Config) (*service.Service, error) {
 	wire.Build(
 		service.NewService,
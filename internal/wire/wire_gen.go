@@ -10,7 +10,10 @@ import (
 	"context"
 
 	auth2 "github.com/boreq/eggplant/adapters/auth"
+	"github.com/boreq/eggplant/adapters/music/events"
 	"github.com/boreq/eggplant/adapters/music/library"
+	"github.com/boreq/eggplant/adapters/music/scanner"
+	"github.com/boreq/eggplant/adapters/music/store"
 	"github.com/boreq/eggplant/application"
 	"github.com/boreq/eggplant/application/auth"
 	"github.com/boreq/eggplant/application/music"
@@ -69,6 +72,7 @@ func BuildAuthForTest(db *bbolt.DB) (*auth.Auth, error) {
 	createInvitationHandler := auth.NewCreateInvitationHandler(cryptoStringGenerator, authTransactionProvider)
 	removeHandler := auth.NewRemoveHandler(authTransactionProvider)
 	setPasswordHandler := auth.NewSetPasswordHandler(bcryptPasswordHasher, authTransactionProvider)
+	setNormalizationHandler := auth.NewSetNormalizationHandler(authTransactionProvider)
 	authAuth := &auth.Auth{
 		RegisterInitial:  registerInitialHandler,
 		Register:         registerHandler,
@@ -79,6 +83,7 @@ func BuildAuthForTest(db *bbolt.DB) (*auth.Auth, error) {
 		CreateInvitation: createInvitationHandler,
 		Remove:           removeHandler,
 		SetPassword:      setPasswordHandler,
+		SetNormalization: setNormalizationHandler,
 	}
 	return authAuth, nil
 }
@@ -106,6 +111,7 @@ func BuildAuth(conf *config.Config) (*auth.Auth, error) {
 	createInvitationHandler := auth.NewCreateInvitationHandler(cryptoStringGenerator, authTransactionProvider)
 	removeHandler := auth.NewRemoveHandler(authTransactionProvider)
 	setPasswordHandler := auth.NewSetPasswordHandler(bcryptPasswordHasher, authTransactionProvider)
+	setNormalizationHandler := auth.NewSetNormalizationHandler(authTransactionProvider)
 	authAuth := &auth.Auth{
 		RegisterInitial:  registerInitialHandler,
 		Register:         registerHandler,
@@ -116,10 +122,20 @@ func BuildAuth(conf *config.Config) (*auth.Auth, error) {
 		CreateInvitation: createInvitationHandler,
 		Remove:           removeHandler,
 		SetPassword:      setPasswordHandler,
+		SetNormalization: setNormalizationHandler,
 	}
 	return authAuth, nil
 }
 
+func BuildScanners(conf *config.Config) ([]*scanner.Scanner, error) {
+	scannerConfig := newScannerConfig(conf)
+	v, err := newScanners(conf, scannerConfig)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
 func BuildService(ctx context.Context, conf *config.Config) (*service.Service, error) {
 	bcryptPasswordHasher := auth2.NewBcryptPasswordHasher()
 	db, err := newBolt(conf)
@@ -143,6 +159,7 @@ func BuildService(ctx context.Context, conf *config.Config) (*service.Service, e
 	createInvitationHandler := auth.NewCreateInvitationHandler(cryptoStringGenerator, authTransactionProvider)
 	removeHandler := auth.NewRemoveHandler(authTransactionProvider)
 	setPasswordHandler := auth.NewSetPasswordHandler(bcryptPasswordHasher, authTransactionProvider)
+	setNormalizationHandler := auth.NewSetNormalizationHandler(authTransactionProvider)
 	authAuth := auth.Auth{
 		RegisterInitial:  registerInitialHandler,
 		Register:         registerHandler,
@@ -153,37 +170,76 @@ func BuildService(ctx context.Context, conf *config.Config) (*service.Service, e
 		CreateInvitation: createInvitationHandler,
 		Remove:           removeHandler,
 		SetPassword:      setPasswordHandler,
+		SetNormalization: setNormalizationHandler,
 	}
-	store, err := newThumbnailStore(ctx, conf)
+	bus := events.NewBus()
+	storeStore, err := newThumbnailStore(ctx, conf, bus)
 	if err != nil {
 		return nil, err
 	}
-	thumbnailHandler := music.NewThumbnailHandler(store)
-	trackStore, err := newTrackStore(ctx, conf)
+	thumbnailHandler := music.NewThumbnailHandler(storeStore)
+	boltMetadataCache, err := store.NewBoltMetadataCache(db)
+	if err != nil {
+		return nil, err
+	}
+	trackStore, err := newTrackStore(ctx, conf, boltMetadataCache, bus)
 	if err != nil {
 		return nil, err
 	}
-	trackHandler := music.NewTrackHandler(trackStore)
-	delimiterAccessLoader := library.NewDelimiterAccessLoader()
-	idGenerator := library.NewIdGenerator()
 	scannerConfig := newScannerConfig(conf)
-	libraryLibrary, err := newLibrary(delimiterAccessLoader, trackStore, store, idGenerator, conf, scannerConfig)
+	v, err := newScanners(conf, scannerConfig)
+	if err != nil {
+		return nil, err
+	}
+	v2 := newRoots(conf, v)
+	delimiterAccessLoader := library.NewDelimiterAccessLoader()
+	boltIdentityRepository, err := library.NewBoltIdentityRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	idGenerator, err := newIdGenerator(ctx, conf, boltIdentityRepository)
+	if err != nil {
+		return nil, err
+	}
+	boltSnapshotRepository, err := library.NewBoltSnapshotRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	boltPlayCountRepository, err := library.NewBoltPlayCountRepository(db)
+	if err != nil {
+		return nil, err
+	}
+	libraryLibrary, err := newLibrary(v2, delimiterAccessLoader, trackStore, storeStore, idGenerator, boltSnapshotRepository, boltPlayCountRepository, bus)
 	if err != nil {
 		return nil, err
 	}
+	preTranscodingDepth := newPreTranscodingDepth(conf)
+	trackHandler := music.NewTrackHandler(trackStore, libraryLibrary, preTranscodingDepth)
 	browseHandler := music.NewBrowseHandler(libraryLibrary)
 	searchHandler := music.NewSearchHandler(libraryLibrary)
+	lyricsHandler := music.NewLyricsHandler(libraryLibrary)
+	recordPlayHandler := music.NewRecordPlayHandler(libraryLibrary)
+	recentlyAddedHandler := music.NewRecentlyAddedHandler(libraryLibrary)
+	subscribeHandler := music.NewSubscribeHandler(bus)
 	applicationMusic := application.Music{
-		Thumbnail: thumbnailHandler,
-		Track:     trackHandler,
-		Browse:    browseHandler,
-		Search:    searchHandler,
+		Thumbnail:     thumbnailHandler,
+		Track:         trackHandler,
+		Browse:        browseHandler,
+		Search:        searchHandler,
+		Lyrics:        lyricsHandler,
+		RecordPlay:    recordPlayHandler,
+		RecentlyAdded: recentlyAddedHandler,
+		Subscribe:     subscribeHandler,
 	}
 	wireQueryRepositoriesProvider := newQueryRepositoriesProvider()
 	queryTransactionProvider := auth2.NewQueryTransactionProvider(db, wireQueryRepositoriesProvider)
-	statsHandler := queries.NewStatsHandler(trackStore, store, queryTransactionProvider)
+	statsHandler := queries.NewStatsHandler(trackStore, storeStore, queryTransactionProvider)
+	scanProblemsHandler := queries.NewScanProblemsHandler(libraryLibrary)
+	conversionQueuesHandler := queries.NewConversionQueuesHandler(trackStore, storeStore)
 	applicationQueries := application.Queries{
-		Stats: statsHandler,
+		Stats:            statsHandler,
+		ScanProblems:     scanProblemsHandler,
+		ConversionQueues: conversionQueuesHandler,
 	}
 	applicationApplication := &application.Application{
 		Auth:    authAuth,
@@ -44,6 +44,12 @@ func (h *HttpAuthProvider) Get(r *http.Request) (*AuthenticatedUser, error) {
 	return &u, nil
 }
 
+// getToken returns the token sent in the header. The token can also be sent
+// as a query parameter since browsers can't set the headers of the requests
+// which subscribe to the events.
 func (h *HttpAuthProvider) getToken(r *http.Request) auth.AccessToken {
-	return auth.AccessToken(r.Header.Get("Access-Token"))
+	if token := r.Header.Get("Access-Token"); token != "" {
+		return auth.AccessToken(token)
+	}
+	return auth.AccessToken(r.URL.Query().Get("token"))
 }
@@ -1,10 +1,46 @@
 package http
 
-import "github.com/boreq/eggplant/application/music"
+import (
+	"time"
+
+	"github.com/boreq/eggplant/application/music"
+)
 
 type searchResult struct {
-	Albums []basicAlbum        `json:"albums,omitempty"`
-	Tracks []searchResultTrack `json:"tracks,omitempty"`
+	Albums      []basicAlbum        `json:"albums,omitempty"`
+	Tracks      []searchResultTrack `json:"tracks,omitempty"`
+	TotalAlbums int                 `json:"totalAlbums"`
+	TotalTracks int                 `json:"totalTracks"`
+}
+
+type recentAlbums struct {
+	Albums   []recentAlbum `json:"albums,omitempty"`
+	Total    int           `json:"total"`
+	TotalNew int           `json:"totalNew"`
+}
+
+type recentAlbum struct {
+	Album basicAlbum `json:"album"`
+	Added time.Time  `json:"added"`
+	New   bool       `json:"new,omitempty"`
+}
+
+type event struct {
+	Albums     [][]string    `json:"albums,omitempty"`
+	Conversion *conversion   `json:"conversion,omitempty"`
+	Progress   *scanProgress `json:"progress,omitempty"`
+}
+
+type conversion struct {
+	Kind    string  `json:"kind"`
+	FileId  string  `json:"fileId"`
+	Gain    float64 `json:"gain,omitempty"`
+	Profile string  `json:"profile,omitempty"`
+}
+
+type scanProgress struct {
+	PendingMetadata int `json:"pendingMetadata"`
+	PendingLoudness int `json:"pendingLoudness"`
 }
 
 type basicAlbum struct {
@@ -23,10 +59,22 @@ type searchResultTrack struct {
 }
 
 type track struct {
-	Id       string  `json:"id,omitempty"`
-	FileId   string  `json:"fileId,omitempty"`
-	Title    string  `json:"title,omitempty"`
-	Duration float64 `json:"duration,omitempty"`
+	Id          string     `json:"id,omitempty"`
+	FileId      string     `json:"fileId,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Duration    float64    `json:"duration,omitempty"`
+	Thumbnail   *thumbnail `json:"thumbnail,omitempty"`
+	Pending     bool       `json:"pending,omitempty"`
+	Lyrics      bool       `json:"lyrics,omitempty"`
+	TrackGain   *float64   `json:"trackGain,omitempty"`
+	AlbumGain   *float64   `json:"albumGain,omitempty"`
+	Artist      string     `json:"artist,omitempty"`
+	AlbumArtist string     `json:"albumArtist,omitempty"`
+	Album       string     `json:"album,omitempty"`
+	TrackNumber int        `json:"trackNumber,omitempty"`
+	DiscNumber  int        `json:"discNumber,omitempty"`
+	Year        int        `json:"year,omitempty"`
+	Genre       string     `json:"genre,omitempty"`
 }
 
 func toSearchResult(result music.SearchResult) searchResult {
@@ -35,7 +83,57 @@ func toSearchResult(result music.SearchResult) searchResult {
 		Tracks: toSearchResultTracks(
 			result.Tracks,
 		),
+		TotalAlbums: result.TotalAlbums,
+		TotalTracks: result.TotalTracks,
+	}
+}
+
+func toRecentAlbums(result music.RecentAlbums) recentAlbums {
+	var albums []recentAlbum
+	for _, album := range result.Albums {
+		albums = append(albums, recentAlbum{
+			Album: toBasicAlbum(album.Album),
+			Added: album.Added,
+			New:   album.New,
+		})
+	}
+
+	return recentAlbums{
+		Albums:   albums,
+		Total:    result.Total,
+		TotalNew: result.TotalNew,
+	}
+}
+
+func toEvent(e music.Event) event {
+	result := event{}
+
+	for _, path := range e.Albums {
+		albumPath := toPath(path)
+		if albumPath == nil {
+			// the root album
+			albumPath = []string{}
+		}
+		result.Albums = append(result.Albums, albumPath)
 	}
+
+	if e.Conversion != nil {
+		result.Conversion = &conversion{
+			Kind:    string(e.Conversion.Kind),
+			FileId:  e.Conversion.FileId.String(),
+			Gain:    e.Conversion.Gain,
+			Profile: e.Conversion.Profile.String(),
+		}
+	}
+
+	if e.Progress != nil {
+		result.Progress = &scanProgress{
+			PendingMetadata: e.Progress.PendingMetadata,
+			PendingLoudness: e.Progress.PendingLoudness,
+		}
+	}
+
+	return result
 }
 
 func toBasicAlbums(albums []music.BasicAlbum) []basicAlbum {
@@ -96,9 +194,21 @@ func toSearchResultTrack(track music.SearchResultTrack) searchResultTrack {
 
 func toTrack(t music.Track) track {
 	return track{
-		Id:       t.Id.String(),
-		FileId:   t.FileId.String(),
-		Title:    t.Title,
-		Duration: t.Duration,
+		Id:          t.Id.String(),
+		FileId:      t.FileId.String(),
+		Title:       t.Title,
+		Duration:    t.Duration,
+		Thumbnail:   toThumbnail(t.Thumbnail),
+		Pending:     t.Pending,
+		Lyrics:      t.Lyrics,
+		TrackGain:   t.TrackGain,
+		AlbumGain:   t.AlbumGain,
+		Artist:      t.Artist,
+		AlbumArtist: t.AlbumArtist,
+		Album:       t.Album,
+		TrackNumber: t.TrackNumber,
+		DiscNumber:  t.DiscNumber,
+		Year:        t.Year,
+		Genre:       t.Genre,
 	}
 }
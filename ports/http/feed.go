@@ -0,0 +1,111 @@
+package http
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boreq/eggplant/application/music"
+)
+
+const feedTitle = "Eggplant: recently added"
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Id      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+}
+
+// toAtomFeed creates a feed in which each album links to its page in the
+// frontend. The album pages are also used as the ids of the entries as they
+// are stable and unique.
+func toAtomFeed(base string, result music.RecentAlbums, now time.Time) atomFeed {
+	feed := atomFeed{
+		Id:      base + "/api/recent.atom",
+		Title:   feedTitle,
+		Updated: formatAtomTime(now),
+		Author: atomAuthor{
+			Name: "Eggplant",
+		},
+		Links: []atomLink{
+			{
+				Rel:  "self",
+				Type: "application/atom+xml",
+				Href: base + "/api/recent.atom",
+			},
+			{
+				Rel:  "alternate",
+				Type: "text/html",
+				Href: base + "/browse",
+			},
+		},
+	}
+
+	if len(result.Albums) > 0 {
+		feed.Updated = formatAtomTime(result.Albums[0].Added)
+	}
+
+	for _, album := range result.Albums {
+		href := base + "/browse/" + escapePath(album.Album.Path)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Id:      href,
+			Title:   album.Album.Title,
+			Updated: formatAtomTime(album.Added),
+			Link: atomLink{
+				Rel:  "alternate",
+				Type: "text/html",
+				Href: href,
+			},
+		})
+	}
+
+	return feed
+}
+
+func formatAtomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func escapePath(ids []music.AlbumId) string {
+	var segments []string
+	for _, id := range ids {
+		segments = append(segments, url.PathEscape(id.String()))
+	}
+	return strings.Join(segments, "/")
+}
+
+// baseURL returns the scheme and the host under which the request was
+// received taking reverse proxies into account.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
@@ -1,9 +1,16 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +26,14 @@ import (
 
 var isIdValid = regexp.MustCompile(`^[a-zA-Z0-9]+$`).MatchString
 
+// partialContentBufferSize is the maximum amount of data sent to the client at
+// once while a track is being converted.
+const partialContentBufferSize = 32 * 1024
+
+// eventsKeepAliveEvery specifies how often a comment is sent to the clients
+// subscribed to the events if no events are being published.
+const eventsKeepAliveEvery = 30 * time.Second
+
 type AuthenticatedUser struct {
 	User  auth.ReadUser
 	Token auth.AccessToken
@@ -47,9 +62,16 @@ func NewHandler(app *application.Application, authProvider AuthProvider) (*Handl
 	h.router.HandlerFunc(http.MethodGet, "/api/browse/*path", rest.Wrap(h.browse))
 	h.router.HandlerFunc(http.MethodGet, "/api/stats", rest.Wrap(Cache(30*time.Second, h.stats)))
 	h.router.HandlerFunc(http.MethodGet, "/api/search", rest.Wrap(h.search))
+	h.router.HandlerFunc(http.MethodGet, "/api/recent", rest.Wrap(h.recentlyAdded))
+	h.router.GET("/api/recent.atom", h.recentlyAddedFeed)
+	h.router.HandlerFunc(http.MethodGet, "/api/scan-problems", rest.Wrap(h.scanProblems))
+	h.router.HandlerFunc(http.MethodGet, "/api/conversion-queues", rest.Wrap(h.conversionQueues))
 
 	h.router.GET("/api/track/:id", h.track)
+	h.router.HandlerFunc(http.MethodGet, "/api/track/:id/lyrics", rest.Wrap(h.lyrics))
+	h.router.HandlerFunc(http.MethodPost, "/api/track/:id/play", rest.Wrap(h.recordPlay))
 	h.router.GET("/api/thumbnail/:id", h.thumbnail)
+	h.router.GET("/api/events", h.events)
 
 	h.router.HandlerFunc(http.MethodPost, "/api/auth/register-initial", rest.Wrap(h.registerInitial))
 	h.router.HandlerFunc(http.MethodPost, "/api/auth/register", rest.Wrap(h.register))
@@ -59,6 +81,7 @@ func NewHandler(app *application.Application, authProvider AuthProvider) (*Handl
 	h.router.HandlerFunc(http.MethodGet, "/api/auth", rest.Wrap(h.getCurrentUser))
 	h.router.HandlerFunc(http.MethodGet, "/api/auth/users", rest.Wrap(h.getUsers))
 	h.router.HandlerFunc(http.MethodPost, "/api/auth/users/:username/remove", rest.Wrap(h.removeUser))
+	h.router.HandlerFunc(http.MethodPost, "/api/auth/normalization", rest.Wrap(h.setNormalization))
 
 	// Frontend
 	ffs, err := frontend.NewFrontendFileSystem()
@@ -94,9 +117,15 @@ func (h *Handler) browse(r *http.Request) rest.RestResponse {
 		ids = append(ids, music.AlbumId(name))
 	}
 
+	listing, err := getListing(r)
+	if err != nil {
+		return rest.ErrBadRequest.WithMessage(fmt.Sprintf("Invalid listing: %s.", err))
+	}
+
 	cmd := music.Browse{
 		Ids:        ids,
 		PublicOnly: u == nil,
+		Listing:    listing,
 	}
 
 	album, err := h.app.Music.Browse.Execute(cmd)
@@ -125,11 +154,17 @@ func (h *Handler) search(r *http.Request) rest.RestResponse {
 
 	query, err := music.NewQuery(r.URL.Query().Get("query"))
 	if err != nil {
-		return rest.ErrBadRequest.WithMessage("Invalid query.")
+		return rest.ErrBadRequest.WithMessage(fmt.Sprintf("Invalid query: %s.", err))
+	}
+
+	page, err := getPage(r)
+	if err != nil {
+		return rest.ErrBadRequest.WithMessage("Invalid offset or limit.")
 	}
 
 	cmd := music.Search{
 		Query:      query,
+		Page:       page,
 		PublicOnly: u == nil,
 	}
 
@@ -144,6 +179,128 @@ func (h *Handler) search(r *http.Request) rest.RestResponse {
 	)
 }
 
+// recentlyAdded lists the recently added albums. The albums added since the
+// previous visit of the signed in user are marked as new.
+func (h *Handler) recentlyAdded(r *http.Request) rest.RestResponse {
+	u, err := h.authProvider.Get(r)
+	if err != nil {
+		h.log.Error("auth provider get failed", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	page, err := getPage(r)
+	if err != nil {
+		return rest.ErrBadRequest.WithMessage("Invalid offset or limit.")
+	}
+
+	cmd := music.RecentlyAdded{
+		PublicOnly: u == nil,
+		Page:       page,
+	}
+
+	if u != nil {
+		cmd.Since = u.User.NewSince(time.Now())
+	}
+
+	result, err := h.app.Music.RecentlyAdded.Execute(cmd)
+	if err != nil {
+		h.log.Error("recently added error", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	return rest.NewResponse(
+		toRecentAlbums(result),
+	)
+}
+
+// recentlyAddedFeed renders the recently added public albums as an Atom feed
+// so that the new arrivals can be followed using feed readers.
+func (h *Handler) recentlyAddedFeed(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	page, err := getPage(r)
+	if err != nil {
+		h.log.Warn("invalid page", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cmd := music.RecentlyAdded{
+		PublicOnly: true,
+		Page:       page,
+	}
+
+	result, err := h.app.Music.RecentlyAdded.Execute(cmd)
+	if err != nil {
+		h.log.Error("recently added error", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := xml.MarshalIndent(toAtomFeed(baseURL(r), result, time.Now()), "", "  ")
+	if err != nil {
+		h.log.Error("could not marshal the feed", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
+
+// getPage returns the page specified using the offset and limit query
+// parameters. Both parameters are optional.
+func getPage(r *http.Request) (music.Page, error) {
+	offset, err := getIntParameter(r, "offset", 0)
+	if err != nil {
+		return music.Page{}, errors.Wrap(err, "invalid offset")
+	}
+
+	limit, err := getIntParameter(r, "limit", music.DefaultPageLimit)
+	if err != nil {
+		return music.Page{}, errors.Wrap(err, "invalid limit")
+	}
+
+	return music.NewPage(offset, limit)
+}
+
+// getListing returns the listing specified using the sort, direction, cursor
+// and limit query parameters. All parameters are optional, all items are
+// listed if the limit isn't specified.
+func getListing(r *http.Request) (music.Listing, error) {
+	sort, err := music.NewSortKey(r.URL.Query().Get("sort"))
+	if err != nil {
+		return music.Listing{}, errors.Wrap(err, "invalid sort")
+	}
+
+	direction, err := music.NewSortDirection(r.URL.Query().Get("direction"))
+	if err != nil {
+		return music.Listing{}, errors.Wrap(err, "invalid direction")
+	}
+
+	var cursor music.Cursor
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		cursor, err = music.NewCursor(s)
+		if err != nil {
+			return music.Listing{}, errors.Wrap(err, "invalid cursor")
+		}
+	}
+
+	limit, err := getIntParameter(r, "limit", 0)
+	if err != nil {
+		return music.Listing{}, errors.Wrap(err, "invalid limit")
+	}
+
+	return music.NewListing(sort, direction, cursor, limit)
+}
+
+func getIntParameter(r *http.Request, name string, defaultValue int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(s)
+}
+
 func (h *Handler) track(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	id := ps.ByName("id")
 	if !isIdValid(id) {
@@ -152,18 +309,236 @@ func (h *Handler) track(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 		return
 	}
 
-	p, err := h.app.Music.Track.Execute(r.Context(), id)
+	u, err := h.authProvider.Get(r)
+	if err != nil {
+		h.log.Error("auth provider get failed", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	normalization, err := getNormalization(r, u)
 	if err != nil {
+		h.log.Warn("invalid normalization", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	formats, err := getPlayableFormats(r)
+	if err != nil {
+		h.log.Warn("invalid playable formats", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	profile, err := music.NewTranscodingProfile(r.URL.Query().Get("profile"))
+	if err != nil {
+		h.log.Warn("invalid profile", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cmd := music.GetTrack{
+		Id:            id,
+		Normalization: normalization,
+		Profile:       profile,
+		Formats:       formats,
+		PublicOnly:    u == nil,
+		Seeking:       isSeeking(r),
+	}
+
+	p, err := h.app.Music.Track.Execute(r.Context(), cmd)
+	if err != nil {
+		if errors.Is(err, music.ErrUnknownProfile) {
+			h.log.Warn("unknown profile", "profile", profile)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 		h.log.Error("track error", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	defer p.Content.Close()
 
+	if p.ContentType != "" {
+		w.Header().Set("Content-Type", p.ContentType)
+	}
+	// the original file may be returned depending on the Accept header
+	w.Header().Add("Vary", "Accept")
+
+	if p.Partial {
+		h.streamPartialContent(w, r, p)
+		return
+	}
+
 	w.Header().Add("Accept-Ranges", "bytes")
 	http.ServeContent(w, r, p.Name, p.Modtime, p.Content)
 }
 
+// streamPartialContent sends the file which is still being converted as it
+// is written. The size of the file isn't known so the ranges aren't supported
+// and the entire file is always sent. The clients can request ranges once the
+// conversion ends.
+func (h *Handler) streamPartialContent(w http.ResponseWriter, r *http.Request, p music.ConvertedFile) {
+	if p.ContentType == "" {
+		w.Header().Set("Content-Type", mime.TypeByExtension(filepath.Ext(p.Name)))
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, partialContentBufferSize)
+	for {
+		n, err := p.Content.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				h.log.Debug("could not write the partial content", "err", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, context.Canceled) {
+				h.log.Error("could not read the partial content", "err", err)
+			}
+			return
+		}
+	}
+}
+
+// isSeeking checks if the requested range doesn't start at the beginning of
+// the content. Invalid ranges are treated as seeking as well.
+func isSeeking(r *http.Request) bool {
+	s := r.Header.Get("Range")
+	if s == "" {
+		return false
+	}
+	return !strings.HasPrefix(s, "bytes=0-")
+}
+
+// getNormalization returns the normalization specified in the query. If it
+// isn't specified then the normalization preferred by the user is used.
+func getNormalization(r *http.Request, u *AuthenticatedUser) (music.Normalization, error) {
+	if values, ok := r.URL.Query()["normalize"]; ok {
+		return music.NewNormalization(values[0])
+	}
+
+	if u == nil {
+		return music.NormalizationNone, nil
+	}
+
+	return music.NewNormalization(u.User.Normalization)
+}
+
+// getPlayableFormats returns the formats listed in the "formats" query
+// parameter or in the Accept header if the parameter isn't specified. The
+// formats are separated with commas just like in the Accept header. Malformed
+// Accept headers are ignored as they weren't necessarily set by the client.
+func getPlayableFormats(r *http.Request) (music.PlayableFormats, error) {
+	var maxBitrate int
+	if s := r.URL.Query().Get("max_bitrate"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return music.PlayableFormats{}, errors.Wrap(err, "invalid max bitrate")
+		}
+		maxBitrate = v
+	}
+
+	if values, ok := r.URL.Query()["formats"]; ok {
+		return music.NewPlayableFormats(splitMediaTypes(values[0]), maxBitrate)
+	}
+
+	formats, err := music.NewPlayableFormats(splitMediaTypes(r.Header.Get("Accept")), maxBitrate)
+	if err != nil {
+		return music.NewPlayableFormats(nil, maxBitrate)
+	}
+	return formats, nil
+}
+
+// splitMediaTypes splits a list of media types on the commas which aren't
+// quoted eg. in `audio/mp4; codecs="mp4a.40.2,alac"`.
+func splitMediaTypes(s string) []string {
+	var result []string
+	var quoted bool
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				result = append(result, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, s[start:])
+}
+
+func (h *Handler) lyrics(r *http.Request) rest.RestResponse {
+	ps := httprouter.ParamsFromContext(r.Context())
+	id := ps.ByName("id")
+	if !isIdValid(id) {
+		h.log.Warn("invalid track id", "id", id)
+		return rest.ErrBadRequest
+	}
+
+	lyrics, err := h.app.Music.Lyrics.Execute(music.FileId(id))
+	if err != nil {
+		if errors.Is(err, music.ErrNotFound) {
+			return rest.ErrNotFound
+		}
+
+		h.log.Error("lyrics error", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	return rest.NewResponse(lyrics)
+}
+
+func (h *Handler) recordPlay(r *http.Request) rest.RestResponse {
+	ps := httprouter.ParamsFromContext(r.Context())
+	id := ps.ByName("id")
+	if !isIdValid(id) {
+		h.log.Warn("invalid track id", "id", id)
+		return rest.ErrBadRequest
+	}
+
+	// the play counts are shared by all users so the anonymous visitors
+	// can't change them
+	u, err := h.authProvider.Get(r)
+	if err != nil {
+		h.log.Error("auth provider get failed", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	if u == nil {
+		return rest.ErrUnauthorized
+	}
+
+	cmd := music.RecordPlay{
+		Id: music.FileId(id),
+	}
+
+	if err := h.app.Music.RecordPlay.Execute(cmd); err != nil {
+		if errors.Is(err, music.ErrNotFound) {
+			return rest.ErrNotFound
+		}
+
+		h.log.Error("record play error", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	return rest.NewResponse(nil)
+}
+
 func (h *Handler) thumbnail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	id := ps.ByName("id")
 	if !isIdValid(id) {
@@ -184,6 +559,66 @@ func (h *Handler) thumbnail(w http.ResponseWriter, r *http.Request, ps httproute
 	http.ServeContent(w, r, p.Name, p.Modtime, p.Content)
 }
 
+// events streams the events to the signed in users using Server-Sent Events.
+// Each event is sent with its type as the event name and its details encoded
+// as JSON in the data field.
+func (h *Handler) events(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	u, err := h.authProvider.Get(r)
+	if err != nil {
+		h.log.Error("auth provider get failed", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if u == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.log.Error("response writer doesn't support flushing")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := h.app.Music.Subscribe.Execute(r.Context())
+
+	keepAlive := time.NewTicker(eventsKeepAliveEvery)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			b, err := json.Marshal(toEvent(e))
+			if err != nil {
+				h.log.Error("could not marshal the event", "err", err)
+				return
+			}
+
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			// comments keep the connection open through proxies
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
 func (h *Handler) stats(r *http.Request) rest.RestResponse {
 	stats, err := h.app.Queries.Stats.Execute()
 	if err != nil {
@@ -194,6 +629,46 @@ func (h *Handler) stats(r *http.Request) rest.RestResponse {
 	return rest.NewResponse(stats)
 }
 
+func (h *Handler) scanProblems(r *http.Request) rest.RestResponse {
+	u, err := h.authProvider.Get(r)
+	if err != nil {
+		h.log.Error("auth provider get failed", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	if !h.isAdmin(u) {
+		return rest.ErrForbidden.WithMessage("Only an administrator can list scan problems.")
+	}
+
+	problems, err := h.app.Queries.ScanProblems.Execute()
+	if err != nil {
+		h.log.Error("scan problems query error", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	return rest.NewResponse(problems)
+}
+
+func (h *Handler) conversionQueues(r *http.Request) rest.RestResponse {
+	u, err := h.authProvider.Get(r)
+	if err != nil {
+		h.log.Error("auth provider get failed", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	if !h.isAdmin(u) {
+		return rest.ErrForbidden.WithMessage("Only an administrator can list conversion queues.")
+	}
+
+	queues, err := h.app.Queries.ConversionQueues.Execute()
+	if err != nil {
+		h.log.Error("conversion queues query error", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	return rest.NewResponse(queues)
+}
+
 type registerInitialInput struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -420,6 +895,45 @@ func (h *Handler) removeUser(r *http.Request) rest.RestResponse {
 	return rest.NewResponse(nil)
 }
 
+type setNormalizationInput struct {
+	Normalization string `json:"normalization"`
+}
+
+func (h *Handler) setNormalization(r *http.Request) rest.RestResponse {
+	u, err := h.authProvider.Get(r)
+	if err != nil {
+		h.log.Error("auth provider get failed", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	if u == nil {
+		return rest.ErrUnauthorized
+	}
+
+	var t setNormalizationInput
+	if err = json.NewDecoder(r.Body).Decode(&t); err != nil {
+		h.log.Warn("set normalization decoding failed", "err", err)
+		return rest.ErrBadRequest.WithMessage("Malformed input.")
+	}
+
+	normalization, err := music.NewNormalization(t.Normalization)
+	if err != nil {
+		return rest.ErrBadRequest.WithMessage("Invalid normalization.")
+	}
+
+	cmd := auth.SetNormalization{
+		Username:      u.User.Username,
+		Normalization: string(normalization),
+	}
+
+	if err := h.app.Auth.SetNormalization.Execute(cmd); err != nil {
+		h.log.Error("could not set the normalization", "err", err)
+		return rest.ErrInternalServerError
+	}
+
+	return rest.NewResponse(nil)
+}
+
 func (h *Handler) isAdmin(u *AuthenticatedUser) bool {
 	return u != nil && u.User.Administrator
 }
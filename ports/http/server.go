@@ -3,6 +3,7 @@ package http
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/boreq/eggplant/logging"
@@ -22,16 +23,21 @@ func NewServer(handler http.Handler) *Server {
 	}
 }
 
-func (s *Server) Serve(ctx context.Context, address string) error {
+// Handler returns the handler wrapped in the middleware.
+func (s *Server) Handler() http.Handler {
 	// Add CORS middleware
 	handler := cors.AllowAll().Handler(s.handler)
 
 	// Add GZIP middleware
-	handler = gziphandler.GzipHandler(handler)
+	handler = compress(handler)
+
+	return handler
+}
 
+func (s *Server) Serve(ctx context.Context, address string) error {
 	httpServer := &http.Server{
 		Addr:    address,
-		Handler: handler,
+		Handler: s.Handler(),
 	}
 
 	go func() {
@@ -48,3 +54,31 @@ func (s *Server) Serve(ctx context.Context, address string) error {
 
 	return nil
 }
+
+// compress gzips all responses except for the event stream and the tracks.
+// The gzip middleware sends nothing, not even the headers, until enough data
+// is written which would hold back the events and the tracks which are
+// streamed while they are being converted. The tracks are already compressed
+// anyway.
+func compress(handler http.Handler) http.Handler {
+	gzipped := gziphandler.GzipHandler(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStreamed(r.URL.Path) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		gzipped.ServeHTTP(w, r)
+	})
+}
+
+func isStreamed(path string) bool {
+	if path == "/api/events" {
+		return true
+	}
+
+	if id := strings.TrimPrefix(path, "/api/track/"); id != path {
+		return !strings.Contains(id, "/")
+	}
+
+	return false
+}
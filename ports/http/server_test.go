@@ -0,0 +1,268 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boreq/eggplant/application"
+	"github.com/boreq/eggplant/application/auth"
+	"github.com/boreq/eggplant/application/music"
+	httpPort "github.com/boreq/eggplant/ports/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerStreamsEventsWithoutCompressingThem(t *testing.T) {
+	subscriber := newMockEventSubscriber()
+
+	app := &application.Application{
+		Music: application.Music{
+			Subscribe: music.NewSubscribeHandler(subscriber),
+		},
+	}
+
+	srv := newTestServer(t, app)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp := get(t, ctx, srv.URL+"/api/events")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	require.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	subscriber.ch <- music.Event{Type: music.EventTypeLibraryChanged}
+
+	lines := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	select {
+	case line := <-lines:
+		require.Equal(t, "event: libraryChanged", line)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestServerStreamsTracksWithoutCompressingThem(t *testing.T) {
+	content := strings.Repeat("a", 10000)
+
+	testCases := []struct {
+		Name    string
+		Partial bool
+	}{
+		{
+			Name:    "converted",
+			Partial: false,
+		},
+		{
+			Name:    "partial",
+			Partial: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			trackStore := newMockTrackStore(testCase.Partial, content)
+
+			app := &application.Application{
+				Music: application.Music{
+					Track: music.NewTrackHandler(trackStore, nil, 0),
+				},
+			}
+
+			srv := newTestServer(t, app)
+			defer srv.Close()
+			defer trackStore.close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			resp := get(t, ctx, srv.URL+"/api/track/id")
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "audio/mpeg", resp.Header.Get("Content-Type"))
+			require.Empty(t, resp.Header.Get("Content-Encoding"))
+
+			// the partial content has to arrive before the conversion ends
+			buf := make([]byte, 10)
+			_, err := io.ReadFull(resp.Body, buf)
+			require.NoError(t, err)
+			require.Equal(t, content[:10], string(buf))
+
+			trackStore.finish()
+
+			rest, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, content[10:], string(rest))
+		})
+	}
+}
+
+func newTestServer(t *testing.T, app *application.Application) *httptest.Server {
+	h, err := httpPort.NewHandler(app, mockAuthProvider{})
+	require.NoError(t, err)
+
+	return httptest.NewServer(httpPort.NewServer(h).Handler())
+}
+
+// get sends a request which accepts gzipped responses the same way the
+// browsers do. The response must arrive before the handler returns.
+func get(t *testing.T, ctx context.Context, url string) *http.Response {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		ch <- result{resp, err}
+	}()
+
+	select {
+	case r := <-ch:
+		require.NoError(t, r.err)
+		return r.resp
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+type mockAuthProvider struct {
+}
+
+func (mockAuthProvider) Get(r *http.Request) (*httpPort.AuthenticatedUser, error) {
+	return &httpPort.AuthenticatedUser{
+		User: auth.ReadUser{
+			Username: "user",
+		},
+	}, nil
+}
+
+type mockTrackStore struct {
+	partial bool
+	content string
+	w       *io.PipeWriter
+	r       *io.PipeReader
+}
+
+func newMockTrackStore(partial bool, content string) *mockTrackStore {
+	r, w := io.Pipe()
+	return &mockTrackStore{
+		partial: partial,
+		content: content,
+		r:       r,
+		w:       w,
+	}
+}
+
+func (s *mockTrackStore) GetConvertedTrack(ctx context.Context, id string, profile music.TranscodingProfile, gain float64) (music.ConvertedFile, error) {
+	if !s.partial {
+		return music.ConvertedFile{
+			Name:        "track.mp3",
+			ContentType: "audio/mpeg",
+			Content:     nopCloser{strings.NewReader(s.content)},
+		}, nil
+	}
+
+	// the first part of the content is written right away and the rest
+	// once the conversion finishes
+	go func() {
+		s.w.Write([]byte(s.content[:10]))
+	}()
+
+	return music.ConvertedFile{
+		Name:        "track.mp3",
+		ContentType: "audio/mpeg",
+		Content:     partialContent{s.r},
+		Partial:     true,
+	}, nil
+}
+
+func (s *mockTrackStore) finish() {
+	if s.partial {
+		s.w.Write([]byte(s.content[10:]))
+		s.w.Close()
+	}
+}
+
+func (s *mockTrackStore) close() {
+	s.w.Close()
+}
+
+func (s *mockTrackStore) GetOriginalTrack(id string, formats music.PlayableFormats) (music.ConvertedFile, bool, error) {
+	return music.ConvertedFile{}, false, nil
+}
+
+func (s *mockTrackStore) PreTranscodeTrack(id string, profile music.TranscodingProfile, gain float64) error {
+	return nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+type partialContent struct {
+	*io.PipeReader
+}
+
+func (partialContent) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("partial content can't be seeked")
+}
+
+type mockEventSubscriber struct {
+	ch chan music.Event
+}
+
+func newMockEventSubscriber() *mockEventSubscriber {
+	return &mockEventSubscriber{
+		ch: make(chan music.Event, 1),
+	}
+}
+
+func (s *mockEventSubscriber) Subscribe(ctx context.Context) <-chan music.Event {
+	ch := make(chan music.Event)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case e := <-s.ch:
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}